@@ -0,0 +1,337 @@
+// Package backup implements incremental backups of volumes.
+//
+// Blob of volume is append-only, so backup is sequence of segments,
+// where every segment contains records of blob from offset, where
+// previous segment ends, and index delta, that is locations of IDs set
+// or deleted by these records. Full backup is segment that starts at
+// the first record of blob. Restore replays full backup and following
+// incremental backups, so restored blob is byte-to-byte copy of
+// backed-up blob and index is not rebuilt.
+//
+// Segment layout:
+//
+//	header | data frames... | empty frame | delta frames... | empty frame
+//
+// Data frames contain whole records, delta frames contain entries
+// of ID and location, where zero location means deleted ID.
+//
+// Header contains identity of backed-up blob, that is checksum of header
+// of its first record, so incremental backups are not mixed with
+// backups of other blob with the same name.
+package backup
+
+import (
+	"bufio"
+	"hash/crc32"
+	"io"
+	"sort"
+	"sync/atomic"
+	"time"
+
+	"github.com/cydev/stok"
+	"github.com/cydev/stok/binary"
+	"github.com/cydev/stok/index"
+	"github.com/cydev/stok/storage"
+	"github.com/cydev/stok/volume"
+	"github.com/pkg/errors"
+)
+
+// ErrBadSegment means that segment is inconsistent.
+const ErrBadSegment stok.Error = "Bad backup segment"
+
+// ChunkSize is size of data frame, unless single record is larger.
+const ChunkSize = 1024 * 1024
+
+// entrySize = ID + location.
+const entrySize = 8 + volume.LocationSize
+
+// deltaEntries is count of entries in delta frame.
+const deltaEntries = 4096
+
+// Segment describes backup segment.
+type Segment struct {
+	Start   int64     // offset of first record in blob
+	End     int64     // offset of end of last record in blob
+	Time    time.Time // time of backup
+	Blob    uint32    // identity of blob
+	Records int64     // count of records, including tombstones
+	Entries int64     // count of index delta entries
+}
+
+// Full reports whether segment is full backup.
+func (s Segment) Full() bool {
+	return s.Start == storage.BlobHeaderSize
+}
+
+// blobEnd returns offset of end of records in b.
+func blobEnd(b *storage.Blob) int64 {
+	return recordsEnd(atomic.LoadInt64(&b.Size))
+}
+
+// recordsEnd returns offset of end of records in blob of size.
+func recordsEnd(size int64) int64 {
+	if size < storage.BlobHeaderSize {
+		// header of new blob is not synced yet
+		return storage.BlobHeaderSize
+	}
+	return size
+}
+
+// identity returns identity of b, that is checksum of header of its
+// first record, or zero if b has no records.
+func identity(b *storage.Blob) (uint32, error) {
+	if blobEnd(b) < storage.BlobHeaderSize+storage.RecordHeaderSize {
+		return 0, nil
+	}
+	var buf [storage.RecordHeaderSize]byte
+	if _, err := b.ReadAt(buf[:], storage.BlobHeaderSize); err != nil {
+		return 0, errors.Wrap(err, "failed to read first record")
+	}
+	return crc32.ChecksumIEEE(buf[:]), nil
+}
+
+// chunk returns end of last whole record in [start, end) that fits
+// to ChunkSize after start, but at least end of first record.
+func chunk(b *storage.Blob, start, end int64) (int64, error) {
+	offset := start
+	for offset < end {
+		h, err := b.ReadRecordHeader(offset)
+		if err != nil {
+			return 0, errors.Wrapf(err, "failed to read record header at %d", offset)
+		}
+		next := offset + storage.RecordHeaderSize + h.Size
+		if next-start > ChunkSize && offset > start {
+			break
+		}
+		offset = next
+	}
+	if offset > end {
+		return 0, errors.Wrapf(ErrBadSegment, "record at %d ends after %d", start, end)
+	}
+	return offset, nil
+}
+
+// Write writes segment with records of v from start to the end of blob
+// and their index delta to w. Start is end of previous segment or zero
+// for full backup. Records written concurrently are included in the
+// next segment.
+func Write(w io.Writer, v *volume.Volume, start int64) (Segment, error) {
+	b := v.Blob
+	end, err := v.End()
+	if err != nil {
+		return Segment{}, errors.Wrap(err, "failed to get end")
+	}
+	s := Segment{Start: start, End: recordsEnd(end), Time: time.Now()}
+	if s.Start == 0 {
+		s.Start = storage.BlobHeaderSize
+	}
+	if s.Start < storage.BlobHeaderSize || s.Start > s.End {
+		return s, errors.Wrapf(storage.ErrBadOffset, "blob ends at %d, backup starts at %d", s.End, s.Start)
+	}
+	if s.Blob, err = identity(b); err != nil {
+		return s, err
+	}
+	bw := bufio.NewWriter(w)
+	buf := header{
+		Version: FormatVersion,
+		Start:   s.Start,
+		End:     s.End,
+		Time:    s.Time.UnixNano(),
+		Blob:    s.Blob,
+	}.Append(nil)
+	if _, err := bw.Write(buf); err != nil {
+		return s, err
+	}
+	var (
+		data  []byte
+		delta = make(map[int64]volume.Location)
+	)
+	for offset := s.Start; offset < s.End; {
+		next, err := chunk(b, offset, s.End)
+		if err != nil {
+			return s, err
+		}
+		data = append(data[:0], make([]byte, next-offset)...)
+		if _, err = b.ReadAt(data, offset); err != nil {
+			return s, errors.Wrap(err, "failed to read")
+		}
+		err = storage.DecodeRecords(data, func(pos int64, h storage.RecordHeader, _ []byte) error {
+			s.Records++
+			if h.Deleted() {
+				delta[h.ID] = volume.Location{}
+			} else {
+				delta[h.ID] = volume.Location{Offset: offset + pos, Size: h.Size}
+			}
+			return nil
+		})
+		if err != nil {
+			return s, errors.Wrapf(err, "bad records at %d", offset)
+		}
+		if len(data) > binary.MaxFrameSize {
+			return s, errors.Wrapf(ErrBadSegment, "record at %d is too large", offset)
+		}
+		if buf, err = writeFrame(bw, buf, data); err != nil {
+			return s, err
+		}
+		offset = next
+	}
+	// empty frame ends data
+	buf, err = writeFrame(bw, buf, nil)
+	if err != nil {
+		return s, err
+	}
+	ids := make([]int64, 0, len(delta))
+	for id := range delta {
+		ids = append(ids, id)
+	}
+	sort.Slice(ids, func(i, j int) bool { return ids[i] < ids[j] })
+	data = data[:0]
+	for i, id := range ids {
+		data = binary.AppendInt64(data, id)
+		data = append(data, make([]byte, volume.LocationSize)...)
+		delta[id].Put(data[len(data)-volume.LocationSize:])
+		if (i+1)%deltaEntries == 0 || i == len(ids)-1 {
+			if buf, err = writeFrame(bw, buf, data); err != nil {
+				return s, err
+			}
+			data = data[:0]
+		}
+	}
+	s.Entries = int64(len(ids))
+	if _, err = writeFrame(bw, buf, nil); err != nil {
+		return s, err
+	}
+	return s, bw.Flush()
+}
+
+// writeFrame writes payload as frame to w, using buf as buffer.
+func writeFrame(w io.Writer, buf, payload []byte) ([]byte, error) {
+	buf = binary.AppendFrame(buf[:0], payload)
+	_, err := w.Write(buf)
+	return buf, err
+}
+
+// Read reads header of segment from r. Counts of records and entries
+// are not set.
+func Read(r io.Reader) (Segment, error) {
+	var (
+		h   header
+		buf [headerSize]byte
+	)
+	if _, err := io.ReadFull(r, buf[:]); err != nil {
+		return Segment{}, errors.Wrap(err, "failed to read header")
+	}
+	if _, err := h.Decode(buf[:]); err != nil {
+		return Segment{}, errors.Wrap(err, "bad header")
+	}
+	if h.Version != FormatVersion {
+		return Segment{}, errors.Wrapf(ErrBadSegment, "unsupported version %d", h.Version)
+	}
+	return Segment{Start: h.Start, End: h.End, Time: time.Unix(0, h.Time), Blob: h.Blob}, nil
+}
+
+// Restore appends records of segment from r to b and applies index delta
+// to idx. Segment should start at the end of b, so full backup is
+// restored to new blob and incremental ones of the same blob follow it
+// in order. If
+// Restore fails, b can contain part of records, that are not indexed.
+func Restore(b *storage.Blob, idx index.Index, r io.Reader) (Segment, error) {
+	br := bufio.NewReader(r)
+	s, err := Read(br)
+	if err != nil {
+		return s, err
+	}
+	if e := blobEnd(b); e != s.Start {
+		return s, errors.Wrapf(storage.ErrBadOffset, "blob ends at %d, segment starts at %d", e, s.Start)
+	}
+	if !s.Full() {
+		id, err := identity(b)
+		if err != nil {
+			return s, err
+		}
+		if id != s.Blob {
+			return s, errors.Wrap(ErrBadSegment, "segment is backup of other blob")
+		}
+	}
+	var buf []byte
+	offset := s.Start
+	for {
+		data, frame, err := binary.ReadFrame(br, buf)
+		buf = frame
+		if err != nil {
+			return s, errors.Wrap(err, "failed to read data")
+		}
+		if len(data) == 0 {
+			break
+		}
+		err = storage.DecodeRecords(data, func(int64, storage.RecordHeader, []byte) error {
+			s.Records++
+			return nil
+		})
+		if err != nil {
+			return s, errors.Wrapf(err, "bad records at %d", offset)
+		}
+		if offset+int64(len(data)) > s.End {
+			return s, errors.Wrapf(ErrBadSegment, "records at %d end after %d", offset, s.End)
+		}
+		if err = b.WriteTail(offset, data); err != nil {
+			return s, errors.Wrap(err, "failed to write")
+		}
+		offset += int64(len(data))
+	}
+	if offset != s.End {
+		return s, errors.Wrapf(ErrBadSegment, "records end at %d, not %d", offset, s.End)
+	}
+	for {
+		data, frame, err := binary.ReadFrame(br, buf)
+		buf = frame
+		if err != nil {
+			return s, errors.Wrap(err, "failed to read delta")
+		}
+		if len(data) == 0 {
+			break
+		}
+		if len(data)%entrySize != 0 {
+			return s, errors.Wrap(ErrBadSegment, "bad delta")
+		}
+		for ; len(data) > 0; data = data[entrySize:] {
+			if err = apply(idx, s, data[:entrySize]); err != nil {
+				return s, err
+			}
+			s.Entries++
+		}
+	}
+	return s, errors.Wrap(b.Sync(), "failed to sync")
+}
+
+// apply sets or deletes index entry of delta.
+func apply(idx index.Index, s Segment, entry []byte) error {
+	var (
+		id int64
+		l  volume.Location
+	)
+	if _, err := binary.DecodeInt64(entry, &id); err != nil {
+		return err
+	}
+	loc := entry[8:]
+	if err := l.Read(loc); err != nil {
+		return err
+	}
+	if l.Offset == 0 {
+		if n, err := idx.Len(); err == nil && id >= n {
+			// growing index, so deleted IDs are not assigned again
+			if err = idx.Set(id, loc); err != nil {
+				return errors.Wrapf(err, "failed to set %d", id)
+			}
+		}
+		err := idx.Delete(id)
+		if errors.Cause(err) == index.ErrNotFound {
+			return nil
+		}
+		return errors.Wrapf(err, "failed to delete %d", id)
+	}
+	if l.Offset < s.Start || l.Offset+storage.RecordHeaderSize+l.Size > s.End {
+		return errors.Wrapf(ErrBadSegment, "location of %d is out of segment", id)
+	}
+	return errors.Wrapf(idx.Set(id, loc), "failed to set %d", id)
+}
@@ -0,0 +1,358 @@
+package backup
+
+import (
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/cydev/stok/index"
+	"github.com/cydev/stok/stokutils"
+	"github.com/cydev/stok/storage"
+	"github.com/cydev/stok/volume"
+	"github.com/pkg/errors"
+)
+
+func put(t *testing.T, v *volume.Volume, n int) {
+	for i := 0; i < n; i++ {
+		if _, _, err := v.Put(bytes.Repeat([]byte(fmt.Sprint(i)), i*100)); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+// equal checks that restored volume at path has the same records as v.
+func equal(t *testing.T, v *volume.Volume, path string) {
+	r, err := volume.Open(path, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer stokutils.MustClose(t, r)
+	if r.Blob.Size != v.Blob.Size || r.Next() != v.Next() {
+		t.Fatalf("restored size %d, next %d != %d, %d", r.Blob.Size, r.Next(), v.Blob.Size, v.Next())
+	}
+	records := 0
+	err = v.Walk(func(h storage.RecordHeader, l volume.Location) error {
+		records++
+		rl, err := r.Locate(h.ID)
+		if err != nil {
+			return errors.Wrapf(err, "record %d", h.ID)
+		}
+		if rl != l {
+			t.Errorf("%d: location %v != %v", h.ID, rl, l)
+		}
+		expected, _ := v.Get(h.ID, h.Cookie, nil)
+		data, err := r.Get(h.ID, h.Cookie, nil)
+		if err != nil {
+			return errors.Wrapf(err, "record %d", h.ID)
+		}
+		if !bytes.Equal(data, expected) {
+			t.Errorf("%d: data mismatch", h.ID)
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	restored := 0
+	r.Walk(func(storage.RecordHeader, volume.Location) error {
+		restored++
+		return nil
+	})
+	if restored != records {
+		t.Errorf("restored %d records, not %d", restored, records)
+	}
+}
+
+// segment returns incremental backup of new volume at path, that starts
+// at start. Records are put in the same way as to other volumes, so
+// start is offset of record.
+func segment(t *testing.T, path string, start int64) []byte {
+	v, err := volume.Open(path, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer stokutils.MustClose(t, v)
+	for v.Blob.Size <= start {
+		put(t, v, 10)
+	}
+	var buf bytes.Buffer
+	if _, err = Write(&buf, v, start); err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
+}
+
+func TestDir(t *testing.T) {
+	dir, err := ioutil.TempDir("", "backup")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	d := Dir(filepath.Join(dir, "backups"))
+	if err = os.Mkdir(string(d), 0755); err != nil {
+		t.Fatal(err)
+	}
+	v, err := volume.Open(filepath.Join(dir, "volume"), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer stokutils.MustClose(t, v)
+
+	if s, err := d.Backup("volume", v, false); err != nil || s.End != s.Start {
+		t.Fatal("empty backup", s, err)
+	}
+	put(t, v, 20)
+	s, err := d.Backup("volume", v, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !s.Full() || s.Records != 20 || s.Entries != 20 {
+		t.Errorf("bad full backup %+v", s)
+	}
+	// incremental backup with tombstone and overwrite
+	put(t, v, 5)
+	overwritten, overwrittenCookie, err := v.Put([]byte("first"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	id, cookie, err := v.Put([]byte("deleted"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = v.Delete(id, cookie); err != nil {
+		t.Fatal(err)
+	}
+	if err = v.Set(overwritten, overwrittenCookie, []byte("overwritten")); err != nil {
+		t.Fatal(err)
+	}
+	s, err = d.Backup("volume", v, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if s.Full() || s.Records != 9 || s.Entries != 7 {
+		t.Errorf("bad incremental backup %+v", s)
+	}
+	if last, err := d.Last("volume", v); err != nil || last != v.Blob.Size {
+		t.Error("last", last, "!=", v.Blob.Size, err)
+	}
+	if s, err = d.Backup("volume", v, false); err != nil || s.End != s.Start {
+		t.Error("backup without new records", s, err)
+	}
+	if _, err = d.Restore("volume", filepath.Join(dir, "restored")); err != nil {
+		t.Fatal(err)
+	}
+	equal(t, v, filepath.Join(dir, "restored"))
+
+	// full backup overrides previous chain
+	put(t, v, 3)
+	if s, err = d.Backup("volume", v, true); err != nil || !s.Full() {
+		t.Fatal("full backup", s, err)
+	}
+	put(t, v, 3)
+	if _, err = d.Backup("volume", v, false); err != nil {
+		t.Fatal(err)
+	}
+	files, err := d.Files("volume")
+	if err != nil {
+		t.Fatal(err)
+	}
+	chain, err := d.Chain("volume")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(files) != 4 || len(chain) != 2 || chain[0].Path != files[1].Path {
+		t.Errorf("bad chain %v of %v", chain, files)
+	}
+	segments, err := d.Restore("volume", filepath.Join(dir, "restored2"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(segments) != 2 {
+		t.Error("restored", len(segments), "segments")
+	}
+	equal(t, v, filepath.Join(dir, "restored2"))
+
+	if _, err = d.Restore("volume", filepath.Join(dir, "restored2")); err == nil {
+		t.Error("restored to existing volume")
+	}
+	if _, err = d.Restore("missing", filepath.Join(dir, "restored3")); err == nil {
+		t.Error("restored missing volume")
+	}
+}
+
+func TestDirRecreated(t *testing.T) {
+	dir, err := ioutil.TempDir("", "backup")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	d := Dir(dir)
+	// incremental backup, that is full if there are no backups of v
+	backup := func(v *volume.Volume, full bool) Segment {
+		s, err := d.Backup("volume", v, false)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if s.Full() != full || s.End != v.Blob.Size {
+			t.Fatalf("bad backup %+v", s)
+		}
+		return s
+	}
+	old, err := volume.Open(filepath.Join(dir, "old"), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	put(t, old, 20)
+	backup(old, true)
+	put(t, old, 5)
+	backup(old, false)
+	stokutils.MustClose(t, old)
+
+	// volume with the same name is created again and is shorter
+	v, err := volume.Open(filepath.Join(dir, "new"), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer stokutils.MustClose(t, v)
+	put(t, v, 3)
+	if last, err := d.Last("volume", v); err != nil || last != 0 {
+		t.Error("last", last, err)
+	}
+	s := backup(v, true)
+	put(t, v, 2)
+	backup(v, false)
+	chain, err := d.Chain("volume")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(chain) != 2 || chain[0].Blob != s.Blob || chain[0].End != s.End {
+		t.Errorf("bad chain %+v", chain)
+	}
+	if _, err = d.Restore("volume", filepath.Join(dir, "restored")); err != nil {
+		t.Fatal(err)
+	}
+	equal(t, v, filepath.Join(dir, "restored"))
+}
+
+func TestRestore(t *testing.T) {
+	dir, err := ioutil.TempDir("", "backup")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	v, err := volume.Open(filepath.Join(dir, "volume"), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer stokutils.MustClose(t, v)
+	put(t, v, 10)
+	var full bytes.Buffer
+	s, err := Write(&full, v, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	put(t, v, 10)
+	var incremental bytes.Buffer
+	if _, err = Write(&incremental, v, s.End); err != nil {
+		t.Fatal(err)
+	}
+	if _, err = Write(&incremental, v, v.Blob.Size+1); errors.Cause(err) != storage.ErrBadOffset {
+		t.Error("unexpected error", err)
+	}
+
+	b, err := storage.OpenBlob(filepath.Join(dir, "restored"), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	idx, err := index.Open(filepath.Join(dir, "restored"+volume.IndexSuffix), volume.LocationSize)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err = Restore(b, idx, bytes.NewReader(incremental.Bytes())); errors.Cause(err) != storage.ErrBadOffset {
+		t.Error("restored incremental backup before full:", err)
+	}
+	corrupted := append([]byte(nil), full.Bytes()...)
+	corrupted[headerSize+100]++
+	if _, err = Restore(b, idx, bytes.NewReader(corrupted)); err == nil {
+		t.Error("restored corrupted backup")
+	}
+	if _, err = Restore(b, idx, &full); err != nil {
+		t.Fatal(err)
+	}
+	if _, err = Restore(b, idx, &incremental); err != nil {
+		t.Fatal(err)
+	}
+	other := segment(t, filepath.Join(dir, "other"), v.Blob.Size)
+	if _, err = Restore(b, idx, bytes.NewReader(other)); errors.Cause(err) != ErrBadSegment {
+		t.Error("restored backup of other blob:", err)
+	}
+	stokutils.MustClose(t, idx)
+	stokutils.MustClose(t, b)
+	equal(t, v, filepath.Join(dir, "restored"))
+}
+
+func TestWrite_Concurrent(t *testing.T) {
+	dir, err := ioutil.TempDir("", "backup")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	v, err := volume.Open(filepath.Join(dir, "volume"), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer stokutils.MustClose(t, v)
+	done := make(chan error)
+	go func() {
+		for i := 0; i < 200; i++ {
+			if _, _, err := v.Put(bytes.Repeat([]byte{'x'}, i*10)); err != nil {
+				done <- err
+				return
+			}
+		}
+		done <- nil
+	}()
+	var (
+		segments []bytes.Buffer
+		start    int64
+		running  = true
+	)
+	for running {
+		select {
+		case err = <-done:
+			if err != nil {
+				t.Fatal(err)
+			}
+			running = false
+		default:
+		}
+		// segment written after writes are done contains the rest
+		var buf bytes.Buffer
+		s, err := Write(&buf, v, start)
+		if err != nil {
+			t.Fatal(err)
+		}
+		segments = append(segments, buf)
+		start = s.End
+	}
+
+	b, err := storage.OpenBlob(filepath.Join(dir, "restored"), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	idx, err := index.Open(filepath.Join(dir, "restored"+volume.IndexSuffix), volume.LocationSize)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := range segments {
+		if _, err = Restore(b, idx, &segments[i]); err != nil {
+			t.Fatal(i, err)
+		}
+	}
+	stokutils.MustClose(t, idx)
+	stokutils.MustClose(t, b)
+	equal(t, v, filepath.Join(dir, "restored"))
+}
@@ -0,0 +1,226 @@
+package backup
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/cydev/stok/index"
+	"github.com/cydev/stok/storage"
+	"github.com/cydev/stok/volume"
+	"github.com/pkg/errors"
+)
+
+// Suffix is extension of segment files.
+const Suffix = ".backup"
+
+// File is segment file in Dir.
+type File struct {
+	Path  string
+	Start int64
+	End   int64
+	Time  time.Time // time of backup from header
+	Blob  uint32    // identity of blob from header
+}
+
+// Dir is directory with backups of volumes. Segment files are named by
+// volume name and offsets of segment, so end of last backup of every
+// volume is recorded in directory.
+type Dir string
+
+// fileName returns name of segment file.
+func fileName(name string, start, end int64) string {
+	return fmt.Sprintf("%s.%016x-%016x%s", name, start, end, Suffix)
+}
+
+// Files returns segment files of volume with name sorted by offsets.
+// Headers of files are read and checked.
+func (d Dir) Files(name string) ([]File, error) {
+	infos, err := ioutil.ReadDir(string(d))
+	if err != nil {
+		return nil, err
+	}
+	var files []File
+	for _, info := range infos {
+		s := info.Name()
+		if !strings.HasPrefix(s, name+".") || !strings.HasSuffix(s, Suffix) {
+			continue
+		}
+		var f File
+		offsets := strings.TrimSuffix(strings.TrimPrefix(s, name+"."), Suffix)
+		if n, _ := fmt.Sscanf(offsets, "%016x-%016x", &f.Start, &f.End); n != 2 || fileName(name, f.Start, f.End) != s {
+			continue
+		}
+		f.Path = filepath.Join(string(d), s)
+		segment, err := readFile(f.Path)
+		if err != nil {
+			return nil, errors.Wrap(err, f.Path)
+		}
+		if segment.Start != f.Start || segment.End != f.End {
+			return nil, errors.Wrapf(ErrBadSegment, "%s: offsets in header are %d-%d", f.Path, segment.Start, segment.End)
+		}
+		f.Time, f.Blob = segment.Time, segment.Blob
+		files = append(files, f)
+	}
+	sort.Slice(files, func(i, j int) bool {
+		if files[i].Start != files[j].Start {
+			return files[i].Start < files[j].Start
+		}
+		return files[i].End < files[j].End
+	})
+	return files, nil
+}
+
+// Chain returns segment files, that are restored for volume with name:
+// the latest full backup and following incremental ones of the same blob.
+func (d Dir) Chain(name string) ([]File, error) {
+	files, err := d.Files(name)
+	if err != nil {
+		return nil, err
+	}
+	full := -1
+	for i, f := range files {
+		if f.Start == storage.BlobHeaderSize && (full < 0 || f.Time.After(files[full].Time)) {
+			full = i
+		}
+	}
+	if full < 0 {
+		return nil, nil
+	}
+	return lineage(files, files[full].Blob), nil
+}
+
+// lineage returns full backup of blob with identity id from files and
+// following incremental ones.
+func lineage(files []File, id uint32) []File {
+	var (
+		c   []File
+		end = int64(storage.BlobHeaderSize)
+	)
+	for {
+		// the longest segment that starts at end, so full backups
+		// override previous chains
+		next := -1
+		for i, f := range files {
+			if f.Blob == id && f.Start == end && f.End > end {
+				next = i
+			}
+		}
+		if next < 0 {
+			return c
+		}
+		c = append(c, files[next])
+		end = files[next].End
+	}
+}
+
+// Last returns end of last backup of blob of v, that is volume with
+// name, zero if there are no backups of this blob, like when volume is
+// created again.
+func (d Dir) Last(name string, v *volume.Volume) (int64, error) {
+	id, err := identity(v.Blob)
+	if err != nil || id == 0 {
+		return 0, err
+	}
+	files, err := d.Files(name)
+	if err != nil {
+		return 0, err
+	}
+	c := lineage(files, id)
+	if len(c) == 0 {
+		return 0, nil
+	}
+	return c[len(c)-1].End, nil
+}
+
+// Backup writes segment of v with name to d, starting at end of last
+// backup, or full backup if full is true or there are no backups.
+// Segment file is not written if there are no new records.
+func (d Dir) Backup(name string, v *volume.Volume, full bool) (Segment, error) {
+	var start int64
+	if !full {
+		var err error
+		if start, err = d.Last(name, v); err != nil {
+			return Segment{Start: start}, err
+		}
+	}
+	f, err := ioutil.TempFile(string(d), name+".tmp")
+	if err != nil {
+		return Segment{Start: start}, err
+	}
+	s, err := Write(f, v, start)
+	if err == nil {
+		err = f.Sync()
+	}
+	if closeErr := f.Close(); err == nil {
+		err = closeErr
+	}
+	if err == nil && s.End > s.Start {
+		// renaming complete segment, so partial ones are never restored
+		return s, os.Rename(f.Name(), filepath.Join(string(d), fileName(name, s.Start, s.End)))
+	}
+	os.Remove(f.Name())
+	return s, err
+}
+
+// Restore creates volume at path from backups of volume with name and
+// returns restored segments.
+func (d Dir) Restore(name, path string) ([]Segment, error) {
+	chain, err := d.Chain(name)
+	if err != nil {
+		return nil, err
+	}
+	if len(chain) == 0 {
+		return nil, errors.Errorf("no backups of %s", name)
+	}
+	if _, err = os.Stat(path); err == nil {
+		return nil, errors.Errorf("%s exists", path)
+	}
+	b, err := storage.OpenBlob(path, nil)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to open blob")
+	}
+	idx, err := index.Open(path+volume.IndexSuffix, volume.LocationSize)
+	if err != nil {
+		b.Close()
+		return nil, errors.Wrap(err, "failed to open index")
+	}
+	var segments []Segment
+	for _, f := range chain {
+		var s Segment
+		if s, err = restoreFile(b, idx, f.Path); err != nil {
+			err = errors.Wrap(err, f.Path)
+			break
+		}
+		segments = append(segments, s)
+	}
+	if closeErr := idx.Close(); err == nil {
+		err = closeErr
+	}
+	if closeErr := b.Close(); err == nil {
+		err = closeErr
+	}
+	return segments, err
+}
+
+func readFile(path string) (Segment, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return Segment{}, err
+	}
+	defer f.Close()
+	return Read(f)
+}
+
+func restoreFile(b *storage.Blob, idx index.Index, path string) (Segment, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return Segment{}, err
+	}
+	defer f.Close()
+	return Restore(b, idx, f)
+}
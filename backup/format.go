@@ -0,0 +1,46 @@
+package backup
+
+import "github.com/cydev/stok/binary"
+
+// FormatVersion is version of segment format.
+const FormatVersion = 1
+
+//go:generate stok-binarygen -type header
+
+// header is at start of every segment.
+type header struct {
+	_       [8]byte `binary:"magic=magic"`
+	Version uint8
+	Start   int64  // offset of first record in blob
+	End     int64  // offset of end of last record in blob
+	Time    int64  // unix nanoseconds of backup
+	Blob    uint32 // identity of blob
+	_       uint32 `binary:"crc"`
+}
+
+// headerSize = magic + version + start + end + time + blob + crc.
+const headerSize = 8 + 1 + 8 + 8 + 8 + 4 + 4
+
+var magic = [...]byte{
+	0xba,
+	0xc4,
+	0x0b,
+	0x5e,
+	0x67,
+	0x13,
+	0x20,
+	0x17,
+}
+
+func init() {
+	binary.Register(binary.Format{
+		Name:       "backup",
+		Magic:      magic,
+		Version:    FormatVersion,
+		HeaderSize: headerSize,
+		Check: func(buf []byte) error {
+			_, err := new(header).Decode(buf)
+			return err
+		},
+	})
+}
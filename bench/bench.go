@@ -0,0 +1,282 @@
+// Package bench implements load generator for storage layers.
+//
+// Workload is mix of reads, writes and deletes of keys, that are chosen
+// by Distribution, with data sizes chosen by Sizes. Workers run
+// workload against Target concurrently, recording latency of every
+// operation, and Report contains throughput and latency percentiles.
+// Workload is reproducible: every worker uses own random source,
+// seeded from Config.Seed.
+package bench
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"math/rand"
+	"sync"
+	"sync/atomic"
+	"text/tabwriter"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// Config is configuration of benchmark.
+type Config struct {
+	Target      Target
+	Concurrency int           // count of workers, 1 if zero
+	Duration    time.Duration // duration of run, if Ops is zero
+	Ops         int64         // total count of operations
+	Keys        int64         // count of keys
+	Sizes       Sizes
+	// Distribution chooses keys of operations.
+	Distribution Distribution
+	Mix          Mix
+	Seed         int64
+	// Prefill enables writing every key before run, so reads do
+	// not miss. Prefill is not included in report.
+	Prefill bool
+}
+
+// Latency is summary of latencies of operation in nanoseconds.
+type Latency struct {
+	Mean time.Duration `json:"mean"`
+	P50  time.Duration `json:"p50"`
+	P99  time.Duration `json:"p99"`
+	P999 time.Duration `json:"p999"`
+	Max  time.Duration `json:"max"`
+}
+
+// Stats are results of operation.
+type Stats struct {
+	Ops            int64   `json:"ops"`
+	Errors         int64   `json:"errors"`
+	Misses         int64   `json:"misses"` // reads and deletes of keys that are not found
+	Bytes          int64   `json:"bytes"`  // bytes read or written
+	OpsPerSecond   float64 `json:"opsPerSecond"`
+	BytesPerSecond float64 `json:"bytesPerSecond"`
+	Latency        Latency `json:"latency"`
+	Err            string  `json:"error,omitempty"` // first error
+
+	hist Histogram
+}
+
+func (s *Stats) merge(o *Stats) {
+	s.Ops += o.Ops
+	s.Errors += o.Errors
+	s.Misses += o.Misses
+	s.Bytes += o.Bytes
+	if s.Err == "" {
+		s.Err = o.Err
+	}
+	s.hist.Merge(&o.hist)
+}
+
+// finish computes throughput and latencies for elapsed time.
+func (s *Stats) finish(elapsed time.Duration) {
+	if sec := elapsed.Seconds(); sec > 0 {
+		s.OpsPerSecond = float64(s.Ops) / sec
+		s.BytesPerSecond = float64(s.Bytes) / sec
+	}
+	s.Latency = Latency{
+		Mean: s.hist.Mean(),
+		P50:  s.hist.Quantile(.5),
+		P99:  s.hist.Quantile(.99),
+		P999: s.hist.Quantile(.999),
+		Max:  s.hist.Max(),
+	}
+}
+
+// Report is result of Run.
+type Report struct {
+	Elapsed     time.Duration     `json:"elapsed"`
+	Concurrency int               `json:"concurrency"`
+	Operations  map[string]*Stats `json:"operations"`
+	Total       *Stats            `json:"total"`
+}
+
+// WriteText writes report as table.
+func (r *Report) WriteText(w io.Writer) error {
+	tw := tabwriter.NewWriter(w, 0, 8, 2, ' ', tabwriter.AlignRight)
+	fmt.Fprintf(tw, "op\tops\tops/s\tMB/s\terrors\tmisses\tmean\tp50\tp99\tp999\tmax\t\n")
+	row := func(name string, s *Stats) {
+		l := s.Latency
+		fmt.Fprintf(tw, "%s\t%d\t%.0f\t%.2f\t%d\t%d\t%v\t%v\t%v\t%v\t%v\t\n",
+			name, s.Ops, s.OpsPerSecond, s.BytesPerSecond/(1<<20), s.Errors, s.Misses,
+			round(l.Mean), round(l.P50), round(l.P99), round(l.P999), round(l.Max))
+	}
+	for op := Op(0); op < opCount; op++ {
+		if s, ok := r.Operations[op.String()]; ok {
+			row(op.String(), s)
+		}
+	}
+	row("total", r.Total)
+	if err := tw.Flush(); err != nil {
+		return err
+	}
+	for op := Op(0); op < opCount; op++ {
+		if s, ok := r.Operations[op.String()]; ok && s.Err != "" {
+			if _, err := fmt.Fprintf(w, "%s error: %s\n", op, s.Err); err != nil {
+				return err
+			}
+		}
+	}
+	_, err := fmt.Fprintf(w, "elapsed %v, %d workers\n", round(r.Elapsed), r.Concurrency)
+	return err
+}
+
+// round rounds d to 3 significant digits for printing.
+func round(d time.Duration) time.Duration {
+	for m := time.Duration(1); ; m *= 10 {
+		if d < 1000*m {
+			return d.Round(m)
+		}
+	}
+}
+
+// worker runs workload and collects stats of operations.
+type worker struct {
+	cfg   *Config
+	rand  *rand.Rand
+	key   func() int64
+	data  []byte
+	buf   []byte
+	stats [opCount]Stats
+}
+
+// fill returns data of random size.
+func (w *worker) fill() []byte {
+	n := w.cfg.Sizes(w.rand)
+	if start := len(w.data); start < n {
+		w.data = append(w.data, make([]byte, n-start)...)
+		w.rand.Read(w.data[start:])
+	}
+	return w.data[:n]
+}
+
+func (w *worker) do(op Op, key int64) {
+	var (
+		err   error
+		bytes int
+		start = time.Now()
+	)
+	switch op {
+	case OpRead:
+		w.buf, err = w.cfg.Target.Read(key, w.buf[:0])
+		bytes = len(w.buf)
+	case OpWrite:
+		data := w.fill()
+		err = w.cfg.Target.Write(key, data)
+		bytes = len(data)
+	case OpDelete:
+		err = w.cfg.Target.Delete(key)
+	}
+	s := &w.stats[op]
+	s.hist.Record(time.Since(start))
+	s.Ops++
+	switch {
+	case errors.Cause(err) == ErrNotFound:
+		s.Misses++
+	case err != nil:
+		s.Errors++
+		if s.Err == "" {
+			s.Err = err.Error()
+		}
+	default:
+		s.Bytes += int64(bytes)
+	}
+}
+
+// parallel runs n workers with f and returns them.
+func (c *Config) parallel(n int, f func(w *worker, i int)) []*worker {
+	workers := make([]*worker, n)
+	var wg sync.WaitGroup
+	for i := range workers {
+		r := rand.New(rand.NewSource(c.Seed + int64(i)))
+		w := &worker{cfg: c, rand: r, key: c.Distribution(r, c.Keys)}
+		workers[i] = w
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			f(w, i)
+		}(i)
+	}
+	wg.Wait()
+	return workers
+}
+
+// prefill writes every key, returning first error.
+func (c *Config) prefill(workers int) error {
+	var failed atomic.Value
+	c.parallel(workers, func(w *worker, i int) {
+		for key := int64(i); key < c.Keys; key += int64(workers) {
+			if err := c.Target.Write(key, w.fill()); err != nil {
+				failed.Store(errors.Wrapf(err, "failed to prefill key %d", key))
+				return
+			}
+		}
+	})
+	if err, ok := failed.Load().(error); ok {
+		return err
+	}
+	return nil
+}
+
+// Run runs benchmark until Ops operations are done, Duration elapses
+// or ctx is done. Errors of operations are counted in report.
+func Run(ctx context.Context, c Config) (*Report, error) {
+	if c.Keys < 1 || c.Sizes == nil || c.Distribution == nil || c.Mix.total() == 0 {
+		return nil, ErrBadSpec
+	}
+	n := c.Concurrency
+	if n < 1 {
+		n = 1
+	}
+	if c.Prefill {
+		if err := c.prefill(n); err != nil {
+			return nil, err
+		}
+	}
+	if c.Ops == 0 && c.Duration > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, c.Duration)
+		defer cancel()
+	}
+	start := time.Now()
+	workers := c.parallel(n, func(w *worker, i int) {
+		// every worker does its own share of operations, so the same
+		// operations are done in every run with the same seed
+		quota := c.Ops / int64(n)
+		if int64(i) < c.Ops%int64(n) {
+			quota++
+		}
+		for j := int64(0); c.Ops == 0 || j < quota; j++ {
+			select {
+			case <-ctx.Done():
+				return
+			default:
+			}
+			w.do(c.Mix.op(w.rand), w.key())
+		}
+	})
+	r := &Report{
+		Elapsed:     time.Since(start),
+		Concurrency: n,
+		Operations:  make(map[string]*Stats),
+		Total:       new(Stats),
+	}
+	for op := Op(0); op < opCount; op++ {
+		if c.Mix[op] == 0 {
+			continue
+		}
+		s := new(Stats)
+		for _, w := range workers {
+			s.merge(&w.stats[op])
+		}
+		s.finish(r.Elapsed)
+		r.Total.merge(s)
+		r.Operations[op.String()] = s
+	}
+	r.Total.finish(r.Elapsed)
+	return r, nil
+}
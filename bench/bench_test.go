@@ -0,0 +1,112 @@
+package bench
+
+import (
+	"context"
+	"io/ioutil"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/cydev/stok/file"
+	"github.com/cydev/stok/server"
+	"github.com/cydev/stok/stokutils"
+	"github.com/cydev/stok/storage"
+	"github.com/cydev/stok/volume"
+)
+
+func TestParse(t *testing.T) {
+	for _, s := range []string{"4k", "1k-64k", "exp:16k", "100"} {
+		if _, err := ParseSizes(s); err != nil {
+			t.Error(s, err)
+		}
+	}
+	for _, s := range []string{"", "k", "64k-1k", "exp:0", "-1"} {
+		if _, err := ParseSizes(s); err == nil {
+			t.Error(s, "expected error")
+		}
+	}
+	for _, s := range []string{"uniform", "sequential", "zipf:1.1"} {
+		if _, err := ParseDistribution(s); err != nil {
+			t.Error(s, err)
+		}
+	}
+	for _, s := range []string{"", "zipf:1", "zipf"} {
+		if _, err := ParseDistribution(s); err == nil {
+			t.Error(s, "expected error")
+		}
+	}
+	m, err := ParseMix("read=80,write=15,delete=5")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if m != (Mix{80, 15, 5}) {
+		t.Error("bad mix", m)
+	}
+	for _, s := range []string{"", "read", "read=0", "scan=1"} {
+		if _, err := ParseMix(s); err == nil {
+			t.Error(s, "expected error")
+		}
+	}
+}
+
+func TestRun(t *testing.T) {
+	dir, err := ioutil.TempDir("", "bench")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	v, err := volume.Open(filepath.Join(dir, "volume"), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer stokutils.MustClose(t, v)
+	b, err := storage.OpenBlob(filepath.Join(dir, "blob"), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer stokutils.MustClose(t, b)
+	f, err := os.Create(filepath.Join(dir, "file"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	ff, err := file.New(f)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer stokutils.MustClose(t, ff)
+	s := httptest.NewServer(&server.Server{Volume: v, VolumeID: 1})
+	defer s.Close()
+
+	targets := map[string]Target{
+		"volume": Volume{Volume: v},
+		"blob":   &Blob{Blob: b},
+		"file":   &File{File: ff},
+		"http":   HTTP{URL: s.URL, VolumeID: 1},
+	}
+	for name, target := range targets {
+		r, err := Run(context.Background(), Config{
+			Target:       target,
+			Concurrency:  4,
+			Ops:          1000,
+			Keys:         100,
+			Sizes:        Uniform(0, 1024),
+			Distribution: Zipf(1.1),
+			Mix:          Mix{70, 25, 5},
+			Seed:         1,
+			Prefill:      true,
+		})
+		if err != nil {
+			t.Fatal(name, err)
+		}
+		if r.Total.Ops != 1000 || r.Total.Errors != 0 {
+			t.Errorf("%s: ops=%d errors=%d %s", name, r.Total.Ops, r.Total.Errors, r.Total.Err)
+		}
+		if r.Operations["read"].Latency.P99 == 0 || r.Operations["write"].Bytes == 0 {
+			t.Errorf("%s: bad stats", name)
+		}
+		if err = r.WriteText(ioutil.Discard); err != nil {
+			t.Error(err)
+		}
+	}
+}
@@ -0,0 +1,110 @@
+package bench
+
+import (
+	"math/bits"
+	"time"
+)
+
+const (
+	// subBuckets is count of buckets for every power of two, so
+	// relative error of recorded latency is under 1/subBuckets.
+	subBuckets = 64
+	// linear is count of exact buckets for smallest values.
+	linear = 2 * subBuckets
+	// buckets is total count of buckets for all positive int64.
+	buckets = linear + (63-7)*subBuckets
+)
+
+// Histogram records latencies with relative error under 2%, using
+// constant memory. It is not goroutine-safe.
+type Histogram struct {
+	counts [buckets]uint64
+	count  uint64
+	sum    time.Duration
+	max    time.Duration
+}
+
+// bucket returns index of bucket for v.
+func bucket(v uint64) int {
+	if v < linear {
+		return int(v)
+	}
+	shift := bits.Len64(v) - 7 // v>>shift is in [64, 128)
+	return linear + (shift-1)*subBuckets + int(v>>uint(shift)) - subBuckets
+}
+
+// value returns middle of bucket i.
+func value(i int) time.Duration {
+	if i < linear {
+		return time.Duration(i)
+	}
+	shift := uint((i-linear)/subBuckets + 1)
+	m := uint64((i-linear)%subBuckets + subBuckets)
+	return time.Duration(m<<shift + 1<<shift/2)
+}
+
+// Record adds d to histogram.
+func (h *Histogram) Record(d time.Duration) {
+	if d < 0 {
+		d = 0
+	}
+	h.counts[bucket(uint64(d))]++
+	h.count++
+	h.sum += d
+	if d > h.max {
+		h.max = d
+	}
+}
+
+// Merge adds all values of o to h.
+func (h *Histogram) Merge(o *Histogram) {
+	for i, c := range o.counts {
+		h.counts[i] += c
+	}
+	h.count += o.count
+	h.sum += o.sum
+	if o.max > h.max {
+		h.max = o.max
+	}
+}
+
+// Count returns count of recorded values.
+func (h *Histogram) Count() uint64 {
+	return h.count
+}
+
+// Mean returns mean of recorded values.
+func (h *Histogram) Mean() time.Duration {
+	if h.count == 0 {
+		return 0
+	}
+	return h.sum / time.Duration(h.count)
+}
+
+// Max returns maximum of recorded values.
+func (h *Histogram) Max() time.Duration {
+	return h.max
+}
+
+// Quantile returns value, that is greater than or equal to q of
+// recorded values, where q is in [0, 1].
+func (h *Histogram) Quantile(q float64) time.Duration {
+	if h.count == 0 {
+		return 0
+	}
+	rank := uint64(q*float64(h.count) + 0.5)
+	if rank < 1 {
+		rank = 1
+	}
+	var n uint64
+	for i, c := range h.counts {
+		n += c
+		if n >= rank {
+			if v := value(i); v < h.max {
+				return v
+			}
+			return h.max
+		}
+	}
+	return h.max
+}
@@ -0,0 +1,52 @@
+package bench
+
+import (
+	"math/rand"
+	"sort"
+	"testing"
+	"time"
+)
+
+func TestHistogram(t *testing.T) {
+	var (
+		h      Histogram
+		values []time.Duration
+		r      = rand.New(rand.NewSource(1))
+	)
+	for i := 0; i < 10000; i++ {
+		v := time.Duration(r.ExpFloat64() * float64(time.Millisecond))
+		values = append(values, v)
+		h.Record(v)
+	}
+	sort.Slice(values, func(i, j int) bool {
+		return values[i] < values[j]
+	})
+	for _, q := range []float64{.5, .99, .999} {
+		expected := values[int(q*float64(len(values)))-1]
+		got := h.Quantile(q)
+		if diff := float64(got-expected) / float64(expected); diff > .02 || diff < -.02 {
+			t.Errorf("q%v: %v != %v", q, got, expected)
+		}
+	}
+	if h.Max() != values[len(values)-1] {
+		t.Error("max", h.Max(), "!=", values[len(values)-1])
+	}
+	var merged Histogram
+	merged.Merge(&h)
+	merged.Merge(&h)
+	if merged.Count() != 2*h.Count() || merged.Quantile(.5) != h.Quantile(.5) {
+		t.Error("bad merge")
+	}
+}
+
+func TestBucket(t *testing.T) {
+	for _, v := range []uint64{0, 1, 127, 128, 1000, 1 << 40, 1<<63 - 1} {
+		i := bucket(v)
+		if i < 0 || i >= buckets {
+			t.Fatal("bucket", i, "of", v, "is out of range")
+		}
+		if d := float64(value(i)) - float64(v); d > float64(v)/subBuckets || -d > float64(v)/subBuckets {
+			t.Error("value", value(i), "of bucket", i, "is far from", v)
+		}
+	}
+}
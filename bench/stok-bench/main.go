@@ -0,0 +1,154 @@
+// Command stok-bench runs workload against storage and reports
+// throughput and latency percentiles.
+//
+//	$ stok-bench -target volume -c 16 -n 100000 -size 1k-64k -keys 10000 -dist zipf:1.1 -mix read=80,write=15,delete=5
+//	$ stok-bench -target http -url http://localhost:9080 -vid 1 -d 30s -json
+//
+// Local targets are created in temporary directory, if path is not set.
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/cydev/stok/bench"
+	"github.com/cydev/stok/file"
+	"github.com/cydev/stok/storage"
+	"github.com/cydev/stok/volume"
+)
+
+var (
+	target      = flag.String("target", "volume", "target: blob, file, volume or http")
+	path        = flag.String("path", "", "path of blob, file or volume (default is in temporary directory)")
+	url         = flag.String("url", "http://localhost:9080", "url of volume server for http target")
+	vid         = flag.Uint("vid", 1, "volume id of volume server for http target")
+	concurrency = flag.Int("c", 8, "concurrent workers")
+	ops         = flag.Int64("n", 0, "total count of operations (default is run for duration)")
+	duration    = flag.Duration("d", 10*time.Second, "duration of run, if count of operations is not set")
+	keys        = flag.Int64("keys", 10000, "count of keys")
+	sizes       = flag.String("size", "4k", "data sizes: fixed like 4k, uniform like 1k-64k or exponential like exp:16k")
+	dist        = flag.String("dist", "uniform", "key distribution: uniform, sequential or zipf:S like zipf:1.1")
+	mix         = flag.String("mix", "read=80,write=20", "weights of read, write and delete operations")
+	seed        = flag.Int64("seed", 1, "seed of random sources")
+	prefill     = flag.Bool("prefill", true, "write every key before run")
+	asJSON      = flag.Bool("json", false, "print report as JSON")
+)
+
+func fatal(args ...interface{}) {
+	fmt.Fprintln(os.Stderr, args...)
+	os.Exit(1)
+}
+
+// result is report with workload, so runs can be compared.
+type result struct {
+	Target       string `json:"target"`
+	Keys         int64  `json:"keys"`
+	Sizes        string `json:"sizes"`
+	Distribution string `json:"distribution"`
+	Mix          string `json:"mix"`
+	Seed         int64  `json:"seed"`
+	*bench.Report
+}
+
+// open returns target and function that closes it.
+func open(dir string) (bench.Target, func() error) {
+	name := *path
+	if name == "" {
+		name = filepath.Join(dir, *target)
+	}
+	switch *target {
+	case "blob":
+		b, err := storage.OpenBlob(name, nil)
+		if err != nil {
+			fatal("blob:", err)
+		}
+		return &bench.Blob{Blob: b}, b.Close
+	case "file":
+		f, err := os.OpenFile(name, os.O_RDWR|os.O_CREATE, 0644)
+		if err != nil {
+			fatal("file:", err)
+		}
+		ff, err := file.New(f)
+		if err != nil {
+			fatal("file:", err)
+		}
+		return &bench.File{File: ff}, ff.Close
+	case "volume":
+		v, err := volume.Open(name, nil)
+		if err != nil {
+			fatal("volume:", err)
+		}
+		return bench.Volume{Volume: v}, v.Close
+	case "http":
+		return bench.HTTP{URL: *url, VolumeID: uint32(*vid)}, func() error { return nil }
+	}
+	fatal("unknown target", *target)
+	return nil, nil
+}
+
+func main() {
+	flag.Parse()
+	s, err := bench.ParseSizes(*sizes)
+	if err != nil {
+		fatal(err)
+	}
+	d, err := bench.ParseDistribution(*dist)
+	if err != nil {
+		fatal(err)
+	}
+	m, err := bench.ParseMix(*mix)
+	if err != nil {
+		fatal(err)
+	}
+	dir, err := ioutil.TempDir("", "stok-bench")
+	if err != nil {
+		fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	t, closeTarget := open(dir)
+	r, err := bench.Run(context.Background(), bench.Config{
+		Target:       t,
+		Concurrency:  *concurrency,
+		Duration:     *duration,
+		Ops:          *ops,
+		Keys:         *keys,
+		Sizes:        s,
+		Distribution: d,
+		Mix:          m,
+		Seed:         *seed,
+		Prefill:      *prefill,
+	})
+	if closeErr := closeTarget(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		os.RemoveAll(dir)
+		fatal(err)
+	}
+	if *asJSON {
+		e := json.NewEncoder(os.Stdout)
+		e.SetIndent("", "  ")
+		err = e.Encode(result{
+			Target:       *target,
+			Keys:         *keys,
+			Sizes:        *sizes,
+			Distribution: *dist,
+			Mix:          *mix,
+			Seed:         *seed,
+			Report:       r,
+		})
+	} else {
+		fmt.Printf("target %s, %d keys, sizes %s, distribution %s, mix %s, seed %d\n",
+			*target, *keys, *sizes, *dist, *mix, *seed)
+		err = r.WriteText(os.Stdout)
+	}
+	if err != nil {
+		fatal(err)
+	}
+}
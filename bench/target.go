@@ -0,0 +1,226 @@
+package bench
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"strings"
+	"sync"
+
+	"github.com/cydev/stok"
+	"github.com/cydev/stok/file"
+	"github.com/cydev/stok/master"
+	"github.com/cydev/stok/storage"
+	"github.com/cydev/stok/volume"
+	"github.com/pkg/errors"
+)
+
+// ErrNotFound means that key is not written or deleted. Such reads and
+// deletes are counted as misses.
+const ErrNotFound stok.Error = "Key not found"
+
+// Target is storage under benchmark. It should be goroutine-safe.
+type Target interface {
+	// Write writes data with key, replacing previous data.
+	Write(key int64, data []byte) error
+	// Read appends data with key to buf and returns it.
+	Read(key int64, buf []byte) ([]byte, error)
+	// Delete deletes data with key.
+	Delete(key int64) error
+}
+
+// offsets maps keys to locations of data in append-only storage.
+type offsets struct {
+	mux sync.RWMutex
+	m   map[int64]volume.Location
+}
+
+func (o *offsets) get(key int64) (volume.Location, error) {
+	o.mux.RLock()
+	l, ok := o.m[key]
+	o.mux.RUnlock()
+	if !ok {
+		return l, ErrNotFound
+	}
+	return l, nil
+}
+
+func (o *offsets) set(key int64, l volume.Location) {
+	o.mux.Lock()
+	if o.m == nil {
+		o.m = make(map[int64]volume.Location)
+	}
+	o.m[key] = l
+	o.mux.Unlock()
+}
+
+// remove deletes key and reports whether it existed.
+func (o *offsets) remove(key int64) bool {
+	o.mux.Lock()
+	_, ok := o.m[key]
+	delete(o.m, key)
+	o.mux.Unlock()
+	return ok
+}
+
+// Blob is Target that writes records to blob and keeps their offsets
+// in memory. Delete writes tombstone.
+type Blob struct {
+	Blob    *storage.Blob
+	offsets offsets
+}
+
+// Write implements Target.
+func (b *Blob) Write(key int64, data []byte) error {
+	offset, err := b.Blob.WriteRecord(storage.RecordHeader{ID: key}, data)
+	if err != nil {
+		return err
+	}
+	b.offsets.set(key, volume.Location{Offset: offset, Size: int64(len(data))})
+	return nil
+}
+
+// Read implements Target.
+func (b *Blob) Read(key int64, buf []byte) ([]byte, error) {
+	l, err := b.offsets.get(key)
+	if err != nil {
+		return buf, err
+	}
+	_, buf, err = b.Blob.ReadRecord(l.Offset, buf)
+	return buf, err
+}
+
+// Delete implements Target.
+func (b *Blob) Delete(key int64) error {
+	if !b.offsets.remove(key) {
+		return ErrNotFound
+	}
+	_, err := b.Blob.WriteRecord(storage.RecordHeader{ID: key, Flags: storage.FlagDeleted}, nil)
+	return err
+}
+
+// File is Target that appends data to file and keeps offsets in memory.
+// File has no deletion, so Delete only forgets key.
+type File struct {
+	File    *file.File
+	offsets offsets
+}
+
+// Write implements Target.
+func (f *File) Write(key int64, data []byte) error {
+	offset, err := f.File.Append(data)
+	if err != nil {
+		return err
+	}
+	f.offsets.set(key, volume.Location{Offset: offset, Size: int64(len(data))})
+	return nil
+}
+
+// Read implements Target.
+func (f *File) Read(key int64, buf []byte) ([]byte, error) {
+	l, err := f.offsets.get(key)
+	if err != nil {
+		return buf, err
+	}
+	start := len(buf)
+	buf = append(buf, make([]byte, l.Size)...)
+	_, err = f.File.ReadAt(buf[start:], l.Offset)
+	return buf, err
+}
+
+// Delete implements Target.
+func (f *File) Delete(key int64) error {
+	if !f.offsets.remove(key) {
+		return ErrNotFound
+	}
+	return nil
+}
+
+// Cookie is cookie of records written by Volume and HTTP targets.
+const Cookie uint32 = 0x57011ce
+
+// Volume is Target that uses keys as record IDs.
+type Volume struct {
+	Volume *volume.Volume
+}
+
+func volumeError(err error) error {
+	if errors.Cause(err) == volume.ErrNotFound {
+		return ErrNotFound
+	}
+	return err
+}
+
+// Write implements Target.
+func (v Volume) Write(key int64, data []byte) error {
+	return v.Volume.Set(key, Cookie, data)
+}
+
+// Read implements Target.
+func (v Volume) Read(key int64, buf []byte) ([]byte, error) {
+	buf, err := v.Volume.Get(key, Cookie, buf)
+	return buf, volumeError(err)
+}
+
+// Delete implements Target.
+func (v Volume) Delete(key int64) error {
+	return volumeError(v.Volume.Delete(key, Cookie))
+}
+
+// HTTP is Target that sends requests to volume server at URL, using
+// keys as keys of file ids.
+type HTTP struct {
+	URL      string
+	VolumeID uint32
+	Client   *http.Client // http.DefaultClient if nil
+}
+
+func (h HTTP) do(method string, key int64, body []byte, buf []byte) ([]byte, error) {
+	fid := master.FileID{Volume: h.VolumeID, Key: key, Cookie: Cookie}
+	req, err := http.NewRequest(method, strings.TrimSuffix(h.URL, "/")+"/"+fid.String(), bytes.NewReader(body))
+	if err != nil {
+		return buf, err
+	}
+	c := h.Client
+	if c == nil {
+		c = http.DefaultClient
+	}
+	res, err := c.Do(req)
+	if err != nil {
+		return buf, err
+	}
+	defer res.Body.Close()
+	switch {
+	case res.StatusCode == http.StatusNotFound:
+		io.Copy(ioutil.Discard, res.Body)
+		return buf, ErrNotFound
+	case res.StatusCode >= 300:
+		io.Copy(ioutil.Discard, res.Body)
+		return buf, fmt.Errorf("%s %s: %s", method, req.URL, res.Status)
+	case method != http.MethodGet:
+		_, err = io.Copy(ioutil.Discard, res.Body)
+		return buf, err
+	}
+	b := bytes.NewBuffer(buf)
+	_, err = b.ReadFrom(res.Body)
+	return b.Bytes(), err
+}
+
+// Write implements Target.
+func (h HTTP) Write(key int64, data []byte) error {
+	_, err := h.do(http.MethodPut, key, data, nil)
+	return err
+}
+
+// Read implements Target.
+func (h HTTP) Read(key int64, buf []byte) ([]byte, error) {
+	return h.do(http.MethodGet, key, nil, buf)
+}
+
+// Delete implements Target.
+func (h HTTP) Delete(key int64) error {
+	_, err := h.do(http.MethodDelete, key, nil, nil)
+	return err
+}
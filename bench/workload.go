@@ -0,0 +1,211 @@
+package bench
+
+import (
+	"math"
+	"math/rand"
+	"strconv"
+	"strings"
+
+	"github.com/cydev/stok"
+	"github.com/pkg/errors"
+)
+
+// ErrBadSpec means that workload specification can't be parsed.
+const ErrBadSpec stok.Error = "Bad workload specification"
+
+// Sizes returns size of written data using r.
+type Sizes func(r *rand.Rand) int
+
+// Fixed returns Sizes that always returns n.
+func Fixed(n int) Sizes {
+	return func(*rand.Rand) int { return n }
+}
+
+// Uniform returns Sizes that are uniformly distributed in [min, max].
+func Uniform(min, max int) Sizes {
+	return func(r *rand.Rand) int { return min + r.Intn(max-min+1) }
+}
+
+// Exponential returns Sizes that are exponentially distributed with
+// mean, so most values are small with a long tail of large ones. Sizes
+// are limited by 64 * mean.
+func Exponential(mean int) Sizes {
+	return func(r *rand.Rand) int {
+		n := int(r.ExpFloat64() * float64(mean))
+		if n > 64*mean {
+			n = 64 * mean
+		}
+		return n
+	}
+}
+
+// parseBytes parses size with optional k, m or g suffix, like 64k.
+func parseBytes(s string) (int, error) {
+	s = strings.TrimSuffix(strings.ToLower(s), "b")
+	mul := 1
+	switch {
+	case strings.HasSuffix(s, "k"):
+		mul = 1 << 10
+	case strings.HasSuffix(s, "m"):
+		mul = 1 << 20
+	case strings.HasSuffix(s, "g"):
+		mul = 1 << 30
+	}
+	if mul > 1 {
+		s = s[:len(s)-1]
+	}
+	n, err := strconv.Atoi(s)
+	if err != nil || n < 0 {
+		return 0, ErrBadSpec
+	}
+	return n * mul, nil
+}
+
+// ParseSizes parses size distribution, that is one of
+//
+//	4k        fixed size
+//	1k-64k    uniform in range
+//	exp:16k   exponential with mean
+func ParseSizes(s string) (Sizes, error) {
+	if mean := strings.TrimPrefix(s, "exp:"); mean != s {
+		n, err := parseBytes(mean)
+		if err != nil || n == 0 {
+			return nil, errors.Wrapf(ErrBadSpec, "sizes %q", s)
+		}
+		return Exponential(n), nil
+	}
+	if i := strings.IndexByte(s, '-'); i > 0 {
+		min, err := parseBytes(s[:i])
+		if err != nil {
+			return nil, errors.Wrapf(err, "sizes %q", s)
+		}
+		max, err := parseBytes(s[i+1:])
+		if err != nil || max < min {
+			return nil, errors.Wrapf(ErrBadSpec, "sizes %q", s)
+		}
+		return Uniform(min, max), nil
+	}
+	n, err := parseBytes(s)
+	if err != nil {
+		return nil, errors.Wrapf(err, "sizes %q", s)
+	}
+	return Fixed(n), nil
+}
+
+// Distribution returns generator of keys in [0, n), that uses r.
+type Distribution func(r *rand.Rand, n int64) func() int64
+
+// UniformKeys chooses every key with the same probability.
+func UniformKeys(r *rand.Rand, n int64) func() int64 {
+	return func() int64 { return r.Int63n(n) }
+}
+
+// SequentialKeys iterates over keys in order, starting from random key.
+func SequentialKeys(r *rand.Rand, n int64) func() int64 {
+	k := r.Int63n(n)
+	return func() int64 {
+		k = (k + 1) % n
+		return k
+	}
+}
+
+// Zipf returns Distribution, where probability of key k is proportional
+// to 1/(k+1)^s, so small keys are hot. Parameter s should be > 1.
+func Zipf(s float64) Distribution {
+	return func(r *rand.Rand, n int64) func() int64 {
+		if n == 1 {
+			return func() int64 { return 0 }
+		}
+		z := rand.NewZipf(r, s, 1, uint64(n-1))
+		return func() int64 { return int64(z.Uint64()) }
+	}
+}
+
+// ParseDistribution parses key distribution, that is uniform,
+// sequential or zipf:S, like zipf:1.1.
+func ParseDistribution(s string) (Distribution, error) {
+	switch s {
+	case "uniform":
+		return UniformKeys, nil
+	case "sequential":
+		return SequentialKeys, nil
+	}
+	if v := strings.TrimPrefix(s, "zipf:"); v != s {
+		f, err := strconv.ParseFloat(v, 64)
+		if err != nil || f <= 1 || math.IsInf(f, 0) {
+			return nil, errors.Wrapf(ErrBadSpec, "distribution %q", s)
+		}
+		return Zipf(f), nil
+	}
+	return nil, errors.Wrapf(ErrBadSpec, "distribution %q", s)
+}
+
+// Op is benchmarked operation.
+type Op int
+
+// Operations of workload.
+const (
+	OpRead Op = iota
+	OpWrite
+	OpDelete
+	opCount
+)
+
+var opNames = [...]string{"read", "write", "delete"}
+
+func (o Op) String() string {
+	return opNames[o]
+}
+
+// Mix is relative weights of operations in workload.
+type Mix [opCount]int
+
+// ParseMix parses weights of operations, like read=80,write=15,delete=5.
+// Missing operations have zero weight.
+func ParseMix(s string) (Mix, error) {
+	var m Mix
+	for _, part := range strings.Split(s, ",") {
+		i := strings.IndexByte(part, '=')
+		if i < 0 {
+			return m, errors.Wrapf(ErrBadSpec, "mix %q", s)
+		}
+		w, err := strconv.Atoi(part[i+1:])
+		if err != nil || w < 0 {
+			return m, errors.Wrapf(ErrBadSpec, "mix %q", s)
+		}
+		found := false
+		for op, name := range opNames {
+			if name == part[:i] {
+				m[op] = w
+				found = true
+			}
+		}
+		if !found {
+			return m, errors.Wrapf(ErrBadSpec, "mix %q: unknown operation %q", s, part[:i])
+		}
+	}
+	if m.total() == 0 {
+		return m, errors.Wrapf(ErrBadSpec, "mix %q", s)
+	}
+	return m, nil
+}
+
+func (m Mix) total() int {
+	n := 0
+	for _, w := range m {
+		n += w
+	}
+	return n
+}
+
+// op returns random operation with probability proportional to its weight.
+func (m Mix) op(r *rand.Rand) Op {
+	n := r.Intn(m.total())
+	for op, w := range m {
+		if n < w {
+			return Op(op)
+		}
+		n -= w
+	}
+	panic("unreachable")
+}
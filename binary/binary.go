@@ -1,3 +1,11 @@
+// Package binary implements allocation-free encoding of primitives
+// for on-disk structures.
+//
+// Append* functions append encoded value to buffer and return it.
+// Decode* functions decode value from start of buffer and return the rest
+// of buffer, returning ErrShortBuffer instead of panic if buffer is too short.
+// Fixed-width integers are big endian, byte slices and strings are
+// prefixed with uint32 length.
 package binary
 
 import (
@@ -25,14 +33,13 @@ var (
 
 const (
 	ErrBadMagic stok.Error = "Bad magic header"
+	ErrBadCRC   stok.Error = "CRC mismatch"
+	// ErrShortBuffer means that buffer is too short to decode value.
+	ErrShortBuffer stok.Error = "Buffer is too short"
+	// ErrBadVarint means that varint overflows 64-bit integer.
+	ErrBadVarint stok.Error = "Bad varint"
 )
 
-func AppendInt64(buf []byte, i int64) []byte {
-	inn := make([]byte, 8)
-	e.PutUint64(inn, uint64(i))
-	return append(buf, inn...)
-}
-
 func AppendMagic(buf []byte, magic [8]byte) []byte {
 	return append(buf, magic[:]...)
 }
@@ -49,11 +56,215 @@ func CheckMagic(buf []byte, magic [8]byte) error {
 	return nil
 }
 
-func DecodeInt64(buf []byte, d *int64) []byte {
-	*d = int64(e.Uint64(buf[:8]))
-	return buf[8:]
+func DecodeMagic(buf []byte, magic [8]byte) ([]byte, error) {
+	if err := CheckMagic(buf, magic); err != nil {
+		return buf, err
+	}
+	return buf[len(magic):], nil
 }
 
-func DecodeMagic(buf []byte, magic [8]byte) ([]byte, error) {
-	return buf[8:], CheckMagic(buf, magic)
+func AppendUint8(buf []byte, v uint8) []byte {
+	return append(buf, v)
+}
+
+func AppendUint16(buf []byte, v uint16) []byte {
+	return append(buf, byte(v>>8), byte(v))
+}
+
+func AppendUint32(buf []byte, v uint32) []byte {
+	return append(buf, byte(v>>24), byte(v>>16), byte(v>>8), byte(v))
+}
+
+func AppendUint64(buf []byte, v uint64) []byte {
+	return append(buf,
+		byte(v>>56), byte(v>>48), byte(v>>40), byte(v>>32),
+		byte(v>>24), byte(v>>16), byte(v>>8), byte(v),
+	)
+}
+
+func AppendInt8(buf []byte, v int8) []byte {
+	return AppendUint8(buf, uint8(v))
+}
+
+func AppendInt16(buf []byte, v int16) []byte {
+	return AppendUint16(buf, uint16(v))
+}
+
+func AppendInt32(buf []byte, v int32) []byte {
+	return AppendUint32(buf, uint32(v))
+}
+
+func AppendInt64(buf []byte, v int64) []byte {
+	return AppendUint64(buf, uint64(v))
+}
+
+// AppendUvarint appends v in varint encoding of encoding/binary.
+func AppendUvarint(buf []byte, v uint64) []byte {
+	for v >= 0x80 {
+		buf = append(buf, byte(v)|0x80)
+		v >>= 7
+	}
+	return append(buf, byte(v))
+}
+
+// AppendVarint appends v in zig-zag varint encoding of encoding/binary.
+func AppendVarint(buf []byte, v int64) []byte {
+	u := uint64(v) << 1
+	if v < 0 {
+		u = ^u
+	}
+	return AppendUvarint(buf, u)
+}
+
+// AppendBool appends v as single byte, 1 for true and 0 for false.
+func AppendBool(buf []byte, v bool) []byte {
+	if v {
+		return append(buf, 1)
+	}
+	return append(buf, 0)
+}
+
+// AppendBytes appends uint32 length of v and v.
+func AppendBytes(buf []byte, v []byte) []byte {
+	buf = AppendUint32(buf, uint32(len(v)))
+	return append(buf, v...)
+}
+
+// AppendString appends uint32 length of v and v.
+func AppendString(buf []byte, v string) []byte {
+	buf = AppendUint32(buf, uint32(len(v)))
+	return append(buf, v...)
+}
+
+func DecodeUint8(buf []byte, d *uint8) ([]byte, error) {
+	if len(buf) < 1 {
+		return buf, ErrShortBuffer
+	}
+	*d = buf[0]
+	return buf[1:], nil
+}
+
+func DecodeUint16(buf []byte, d *uint16) ([]byte, error) {
+	if len(buf) < 2 {
+		return buf, ErrShortBuffer
+	}
+	*d = e.Uint16(buf)
+	return buf[2:], nil
+}
+
+func DecodeUint32(buf []byte, d *uint32) ([]byte, error) {
+	if len(buf) < 4 {
+		return buf, ErrShortBuffer
+	}
+	*d = e.Uint32(buf)
+	return buf[4:], nil
+}
+
+func DecodeUint64(buf []byte, d *uint64) ([]byte, error) {
+	if len(buf) < 8 {
+		return buf, ErrShortBuffer
+	}
+	*d = e.Uint64(buf)
+	return buf[8:], nil
+}
+
+func DecodeInt8(buf []byte, d *int8) ([]byte, error) {
+	if len(buf) < 1 {
+		return buf, ErrShortBuffer
+	}
+	*d = int8(buf[0])
+	return buf[1:], nil
+}
+
+func DecodeInt16(buf []byte, d *int16) ([]byte, error) {
+	if len(buf) < 2 {
+		return buf, ErrShortBuffer
+	}
+	*d = int16(e.Uint16(buf))
+	return buf[2:], nil
+}
+
+func DecodeInt32(buf []byte, d *int32) ([]byte, error) {
+	if len(buf) < 4 {
+		return buf, ErrShortBuffer
+	}
+	*d = int32(e.Uint32(buf))
+	return buf[4:], nil
+}
+
+func DecodeInt64(buf []byte, d *int64) ([]byte, error) {
+	if len(buf) < 8 {
+		return buf, ErrShortBuffer
+	}
+	*d = int64(e.Uint64(buf))
+	return buf[8:], nil
+}
+
+// DecodeUvarint decodes varint, returning ErrShortBuffer if buf ends
+// before last byte of varint and ErrBadVarint on overflow.
+func DecodeUvarint(buf []byte, d *uint64) ([]byte, error) {
+	v, n := b.Uvarint(buf)
+	if n == 0 {
+		return buf, ErrShortBuffer
+	}
+	if n < 0 {
+		return buf, ErrBadVarint
+	}
+	*d = v
+	return buf[n:], nil
+}
+
+// DecodeVarint decodes zig-zag varint, returning ErrShortBuffer if buf ends
+// before last byte of varint and ErrBadVarint on overflow.
+func DecodeVarint(buf []byte, d *int64) ([]byte, error) {
+	v, n := b.Varint(buf)
+	if n == 0 {
+		return buf, ErrShortBuffer
+	}
+	if n < 0 {
+		return buf, ErrBadVarint
+	}
+	*d = v
+	return buf[n:], nil
+}
+
+// DecodeBool decodes single byte, any non-zero value is true.
+func DecodeBool(buf []byte, d *bool) ([]byte, error) {
+	if len(buf) < 1 {
+		return buf, ErrShortBuffer
+	}
+	*d = buf[0] != 0
+	return buf[1:], nil
+}
+
+// DecodeBytes decodes length-prefixed byte slice, reusing capacity of *d.
+func DecodeBytes(buf []byte, d *[]byte) ([]byte, error) {
+	v, rest, err := decodeBytes(buf)
+	if err != nil {
+		return buf, err
+	}
+	*d = append((*d)[:0], v...)
+	return rest, nil
+}
+
+// DecodeString decodes length-prefixed string.
+func DecodeString(buf []byte, d *string) ([]byte, error) {
+	v, rest, err := decodeBytes(buf)
+	if err != nil {
+		return buf, err
+	}
+	*d = string(v)
+	return rest, nil
+}
+
+func decodeBytes(buf []byte) ([]byte, []byte, error) {
+	var n uint32
+	rest, err := DecodeUint32(buf, &n)
+	if err != nil {
+		return nil, buf, err
+	}
+	if uint32(len(rest)) < n {
+		return nil, buf, ErrShortBuffer
+	}
+	return rest[:n], rest[n:], nil
 }
@@ -0,0 +1,160 @@
+package binary
+
+import (
+	"bytes"
+	"math"
+	"testing"
+)
+
+func TestIntegers(t *testing.T) {
+	var buf []byte
+	buf = AppendUint8(buf, 0xfe)
+	buf = AppendUint16(buf, 0xfedc)
+	buf = AppendUint32(buf, 0xfedcba98)
+	buf = AppendUint64(buf, math.MaxUint64-1)
+	buf = AppendInt8(buf, -2)
+	buf = AppendInt16(buf, -300)
+	buf = AppendInt32(buf, -70000)
+	buf = AppendInt64(buf, math.MinInt64)
+	if len(buf) != 2*(1+2+4+8) {
+		t.Fatal("bad length", len(buf))
+	}
+	var (
+		u8  uint8
+		u16 uint16
+		u32 uint32
+		u64 uint64
+		i8  int8
+		i16 int16
+		i32 int32
+		i64 int64
+		err error
+	)
+	rest := buf
+	for _, decode := range []func() error{
+		func() error { rest, err = DecodeUint8(rest, &u8); return err },
+		func() error { rest, err = DecodeUint16(rest, &u16); return err },
+		func() error { rest, err = DecodeUint32(rest, &u32); return err },
+		func() error { rest, err = DecodeUint64(rest, &u64); return err },
+		func() error { rest, err = DecodeInt8(rest, &i8); return err },
+		func() error { rest, err = DecodeInt16(rest, &i16); return err },
+		func() error { rest, err = DecodeInt32(rest, &i32); return err },
+		func() error { rest, err = DecodeInt64(rest, &i64); return err },
+	} {
+		if err := decode(); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if u8 != 0xfe || u16 != 0xfedc || u32 != 0xfedcba98 || u64 != math.MaxUint64-1 {
+		t.Error("unsigned mismatch", u8, u16, u32, u64)
+	}
+	if i8 != -2 || i16 != -300 || i32 != -70000 || i64 != math.MinInt64 {
+		t.Error("signed mismatch", i8, i16, i32, i64)
+	}
+	if len(rest) != 0 {
+		t.Error("rest", rest)
+	}
+}
+
+func TestShortBuffer(t *testing.T) {
+	var (
+		u16 uint16
+		u32 uint32
+		u64 uint64
+		i64 int64
+		v   bool
+		s   string
+		b   []byte
+	)
+	short := []byte{1}
+	for name, err := range map[string]error{
+		"uint16":  second(DecodeUint16(short, &u16)),
+		"uint32":  second(DecodeUint32(short, &u32)),
+		"uint64":  second(DecodeUint64(short, &u64)),
+		"int64":   second(DecodeInt64(short, &i64)),
+		"bool":    second(DecodeBool(nil, &v)),
+		"varint":  second(DecodeVarint([]byte{0x80}, &i64)),
+		"uvarint": second(DecodeUvarint(nil, &u64)),
+		"string":  second(DecodeString(AppendUint32(nil, 10), &s)),
+		"bytes":   second(DecodeBytes([]byte{0, 0}, &b)),
+	} {
+		if err != ErrShortBuffer {
+			t.Error(name, err, "!=", ErrShortBuffer)
+		}
+	}
+	overflow := bytes.Repeat([]byte{0xff}, 11)
+	if _, err := DecodeUvarint(overflow, &u64); err != ErrBadVarint {
+		t.Error(err, "!=", ErrBadVarint)
+	}
+	if _, err := DecodeMagic(short, [8]byte{}); err != ErrBadMagic {
+		t.Error(err, "!=", ErrBadMagic)
+	}
+}
+
+func second(_ []byte, err error) error {
+	return err
+}
+
+func TestVarintBoolBytes(t *testing.T) {
+	var buf []byte
+	buf = AppendVarint(buf, -1234567)
+	buf = AppendUvarint(buf, 1<<63)
+	buf = AppendBool(buf, true)
+	buf = AppendBytes(buf, []byte("bytes"))
+	buf = AppendString(buf, "string")
+	buf = AppendMagic(buf, [8]byte{1, 2, 3, 4, 5, 6, 7, 8})
+	var (
+		i   int64
+		u   uint64
+		v   bool
+		b   = make([]byte, 0, 16)
+		s   string
+		err error
+	)
+	if buf, err = DecodeVarint(buf, &i); err != nil || i != -1234567 {
+		t.Fatal(i, err)
+	}
+	if buf, err = DecodeUvarint(buf, &u); err != nil || u != 1<<63 {
+		t.Fatal(u, err)
+	}
+	if buf, err = DecodeBool(buf, &v); err != nil || !v {
+		t.Fatal(v, err)
+	}
+	if buf, err = DecodeBytes(buf, &b); err != nil || string(b) != "bytes" {
+		t.Fatal(b, err)
+	}
+	if buf, err = DecodeString(buf, &s); err != nil || s != "string" {
+		t.Fatal(s, err)
+	}
+	if buf, err = DecodeMagic(buf, [8]byte{1, 2, 3, 4, 5, 6, 7, 8}); err != nil || len(buf) != 0 {
+		t.Fatal(buf, err)
+	}
+}
+
+func TestZeroAllocations(t *testing.T) {
+	var (
+		buf = make([]byte, 0, 1024)
+		d   = make([]byte, 0, 64)
+		i   int64
+	)
+	data := []byte("data")
+	allocs := testing.AllocsPerRun(100, func() {
+		b := AppendInt64(buf[:0], 1234)
+		b = AppendVarint(b, -1234)
+		b = AppendBytes(b, data)
+		b, _ = DecodeInt64(b, &i)
+		b, _ = DecodeVarint(b, &i)
+		DecodeBytes(b, &d)
+	})
+	if allocs != 0 {
+		t.Error("allocations:", allocs)
+	}
+}
+
+func BenchmarkAppendInt64(b *testing.B) {
+	b.ReportAllocs()
+	buf := make([]byte, 0, 8)
+	for i := 0; i < b.N; i++ {
+		buf = AppendInt64(buf[:0], int64(i))
+	}
+}
@@ -0,0 +1,93 @@
+package binary
+
+import (
+	"io"
+	"sort"
+	"sync"
+
+	"github.com/cydev/stok"
+)
+
+// ErrUnknownFormat means that magic of file is not registered.
+const ErrUnknownFormat stok.Error = "Unknown file format"
+
+// Format describes on-disk format of stok file, that starts with
+// 8 magic bytes.
+type Format struct {
+	Name    string
+	Magic   [8]byte
+	Version int
+	// HeaderSize is size of header, including magic, that is passed to Check.
+	HeaderSize int
+	// Check validates header. Can be nil.
+	Check func(header []byte) error
+}
+
+var (
+	formatsMux sync.RWMutex
+	formats    = make(map[[8]byte]Format)
+)
+
+// Register adds format to registry, so it can be identified.
+// It panics if format with the same magic is already registered.
+func Register(f Format) {
+	formatsMux.Lock()
+	defer formatsMux.Unlock()
+	if registered, ok := formats[f.Magic]; ok {
+		panic("binary: magic of " + f.Name + " is already registered by " + registered.Name)
+	}
+	if f.HeaderSize < len(f.Magic) {
+		f.HeaderSize = len(f.Magic)
+	}
+	formats[f.Magic] = f
+}
+
+// Formats returns all registered formats sorted by name.
+func Formats() []Format {
+	formatsMux.RLock()
+	list := make([]Format, 0, len(formats))
+	for _, f := range formats {
+		list = append(list, f)
+	}
+	formatsMux.RUnlock()
+	sort.Slice(list, func(i, j int) bool {
+		return list[i].Name < list[j].Name
+	})
+	return list
+}
+
+// Lookup returns format registered with magic.
+func Lookup(magic [8]byte) (Format, bool) {
+	formatsMux.RLock()
+	f, ok := formats[magic]
+	formatsMux.RUnlock()
+	return f, ok
+}
+
+// Identify reads magic from start of r and returns registered format
+// and result of header check. Returns ErrUnknownFormat if magic is not
+// registered and ErrShortBuffer if header is truncated.
+func Identify(r io.ReaderAt) (Format, error) {
+	var magic [8]byte
+	if n, err := r.ReadAt(magic[:], 0); n < len(magic) {
+		if err == nil || err == io.EOF {
+			err = ErrUnknownFormat
+		}
+		return Format{}, err
+	}
+	f, ok := Lookup(magic)
+	if !ok {
+		return f, ErrUnknownFormat
+	}
+	header := make([]byte, f.HeaderSize)
+	if n, err := r.ReadAt(header, 0); n < len(header) {
+		if err == nil || err == io.EOF {
+			err = ErrShortBuffer
+		}
+		return f, err
+	}
+	if f.Check == nil {
+		return f, nil
+	}
+	return f, f.Check(header)
+}
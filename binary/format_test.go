@@ -0,0 +1,61 @@
+package binary
+
+import (
+	"bytes"
+	"testing"
+)
+
+func TestIdentify(t *testing.T) {
+	var (
+		magic = [8]byte{'t', 'e', 's', 't', 0x13, 0x37, 0x20, 0x16}
+		f     = Format{
+			Name:       "test",
+			Magic:      magic,
+			Version:    1,
+			HeaderSize: 8 + 8,
+			Check: func(header []byte) error {
+				var size int64
+				_, err := DecodeInt64(header[8:], &size)
+				if size < 0 {
+					return ErrBadMagic
+				}
+				return err
+			},
+		}
+	)
+	Register(f)
+	func() {
+		defer func() {
+			if recover() == nil {
+				t.Error("duplicate Register should panic")
+			}
+		}()
+		Register(f)
+	}()
+	found := false
+	for _, registered := range Formats() {
+		found = found || registered.Name == "test"
+	}
+	if !found {
+		t.Error("format not found")
+	}
+
+	valid := AppendInt64(AppendMagic(nil, magic), 1024)
+	got, err := Identify(bytes.NewReader(valid))
+	if err != nil || got.Name != "test" || got.Version != 1 {
+		t.Error(got, err)
+	}
+	invalid := AppendInt64(AppendMagic(nil, magic), -1)
+	if _, err := Identify(bytes.NewReader(invalid)); err != ErrBadMagic {
+		t.Error(err, "!=", ErrBadMagic)
+	}
+	if _, err := Identify(bytes.NewReader(valid[:10])); err != ErrShortBuffer {
+		t.Error(err, "!=", ErrShortBuffer)
+	}
+	if _, err := Identify(bytes.NewReader([]byte("unknown format"))); err != ErrUnknownFormat {
+		t.Error(err, "!=", ErrUnknownFormat)
+	}
+	if _, err := Identify(bytes.NewReader(nil)); err != ErrUnknownFormat {
+		t.Error(err, "!=", ErrUnknownFormat)
+	}
+}
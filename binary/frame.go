@@ -0,0 +1,259 @@
+package binary
+
+import (
+	"hash/crc32"
+	"hash/crc64"
+	"io"
+
+	"github.com/cydev/stok"
+)
+
+// Checksum is algorithm of frame checksum.
+type Checksum uint8
+
+// Supported checksum algorithms.
+const (
+	CRC32IEEE Checksum = iota + 1
+	CRC32Castagnoli
+	CRC64ISO
+	CRC64ECMA
+)
+
+// DefaultChecksum is used by AppendFrame.
+const DefaultChecksum = CRC32IEEE
+
+const (
+	// ErrTruncatedFrame means that frame is not complete.
+	ErrTruncatedFrame stok.Error = "Frame is truncated"
+	// ErrCorruptedFrame means that frame checksum mismatch or length is invalid.
+	ErrCorruptedFrame stok.Error = "Frame is corrupted"
+	// ErrUnknownChecksum means that checksum algorithm is not supported.
+	ErrUnknownChecksum stok.Error = "Unknown checksum algorithm"
+	// ErrFrameTooLarge means that frame payload is longer than limit.
+	ErrFrameTooLarge stok.Error = "Frame is too large"
+)
+
+// FrameHeaderSize = checksum algorithm + payload length.
+const FrameHeaderSize = 1 + 4
+
+// MaxFrameSize is max length of frame payload.
+const MaxFrameSize = 1 << 30
+
+var (
+	castagnoliTable = crc32.MakeTable(crc32.Castagnoli)
+	isoTable        = crc64.MakeTable(crc64.ISO)
+	ecmaTable       = crc64.MakeTable(crc64.ECMA)
+)
+
+// Size returns size of checksum in bytes or 0 if c is unknown.
+func (c Checksum) Size() int {
+	switch c {
+	case CRC32IEEE, CRC32Castagnoli:
+		return 4
+	case CRC64ISO, CRC64ECMA:
+		return 8
+	}
+	return 0
+}
+
+// Sum returns checksum of b.
+func (c Checksum) Sum(b []byte) uint64 {
+	switch c {
+	case CRC32IEEE:
+		return uint64(crc32.ChecksumIEEE(b))
+	case CRC32Castagnoli:
+		return uint64(crc32.Checksum(b, castagnoliTable))
+	case CRC64ISO:
+		return crc64.Checksum(b, isoTable)
+	case CRC64ECMA:
+		return crc64.Checksum(b, ecmaTable)
+	}
+	return 0
+}
+
+func (c Checksum) append(buf []byte, sum uint64) []byte {
+	if c.Size() == 4 {
+		return AppendUint32(buf, uint32(sum))
+	}
+	return AppendUint64(buf, sum)
+}
+
+func (c Checksum) decode(buf []byte) uint64 {
+	if c.Size() == 4 {
+		return uint64(e.Uint32(buf))
+	}
+	return e.Uint64(buf)
+}
+
+// FrameSize returns size of frame with payload of n bytes.
+func (c Checksum) FrameSize(n int) int {
+	return FrameHeaderSize + n + c.Size()
+}
+
+// AppendFrame appends payload wrapped into frame with DefaultChecksum.
+func AppendFrame(buf, payload []byte) []byte {
+	return AppendFrameChecksum(buf, payload, DefaultChecksum)
+}
+
+// AppendFrameChecksum appends frame with payload length, payload and
+// its checksum computed with c.
+//
+// Frame layout:
+//
+//	algorithm (1 byte) | len(payload) (4 bytes) | payload | checksum (4 or 8 bytes)
+//
+// Checksum covers algorithm, length and payload.
+// If c is unknown, AppendFrameChecksum will panic.
+func AppendFrameChecksum(buf, payload []byte, c Checksum) []byte {
+	if c.Size() == 0 {
+		panic(ErrUnknownChecksum)
+	}
+	start := len(buf)
+	buf = AppendUint8(buf, uint8(c))
+	buf = AppendBytes(buf, payload)
+	return c.append(buf, c.Sum(buf[start:]))
+}
+
+// frameHeader decodes frame header and returns checksum algorithm and
+// length of payload.
+func frameHeader(buf []byte) (Checksum, int, error) {
+	if len(buf) < FrameHeaderSize {
+		return 0, 0, ErrTruncatedFrame
+	}
+	c := Checksum(buf[0])
+	if c.Size() == 0 {
+		return c, 0, ErrUnknownChecksum
+	}
+	n := e.Uint32(buf[1:])
+	if n > MaxFrameSize {
+		return c, 0, ErrCorruptedFrame
+	}
+	return c, int(n), nil
+}
+
+// DecodeFrame decodes frame from buf and returns its payload and
+// rest of buf. Payload references buf.
+//
+// Returns ErrTruncatedFrame if buf is shorter than frame,
+// ErrCorruptedFrame on checksum mismatch and ErrUnknownChecksum if
+// checksum algorithm is not supported.
+func DecodeFrame(buf []byte) (payload, rest []byte, err error) {
+	c, n, err := frameHeader(buf)
+	if err != nil {
+		return nil, buf, err
+	}
+	size := c.FrameSize(n)
+	if len(buf) < size {
+		return nil, buf, ErrTruncatedFrame
+	}
+	end := FrameHeaderSize + n
+	if c.Sum(buf[:end]) != c.decode(buf[end:]) {
+		return nil, buf, ErrCorruptedFrame
+	}
+	return buf[FrameHeaderSize:end], buf[size:], nil
+}
+
+// FrameReader reads sequence of frames from io.ReaderAt.
+type FrameReader struct {
+	r   io.ReaderAt
+	off int64
+	buf []byte
+}
+
+// NewFrameReader returns FrameReader that reads frames from r starting at off.
+func NewFrameReader(r io.ReaderAt, off int64) *FrameReader {
+	return &FrameReader{r: r, off: off}
+}
+
+// Offset returns offset of next frame.
+func (f *FrameReader) Offset() int64 {
+	return f.off
+}
+
+// read reads n bytes at offset f.off+start to f.buf[start:].
+func (f *FrameReader) read(start, n int) error {
+	if cap(f.buf) < start+n {
+		buf := make([]byte, start+n)
+		copy(buf, f.buf[:start])
+		f.buf = buf
+	}
+	f.buf = f.buf[:start+n]
+	read, err := f.r.ReadAt(f.buf[start:], f.off+int64(start))
+	if read == n {
+		return nil
+	}
+	if err == io.EOF && read == 0 && start == 0 {
+		return io.EOF
+	}
+	if err == nil || err == io.EOF {
+		return ErrTruncatedFrame
+	}
+	return err
+}
+
+// Next reads next frame and returns its payload, that is valid until
+// next call. Returns io.EOF if there are no more frames, and errors of
+// DecodeFrame if frame can't be decoded. Offset is advanced only
+// after successfully decoded frame.
+func (f *FrameReader) Next() ([]byte, error) {
+	if err := f.read(0, FrameHeaderSize); err != nil {
+		return nil, err
+	}
+	c, n, err := frameHeader(f.buf)
+	if err != nil {
+		return nil, err
+	}
+	if err = f.read(FrameHeaderSize, n+c.Size()); err != nil {
+		return nil, err
+	}
+	payload, _, err := DecodeFrame(f.buf)
+	if err != nil {
+		return nil, err
+	}
+	f.off += int64(len(f.buf))
+	return payload, nil
+}
+
+// ReadFrame reads single frame from r to buf, reusing its capacity, and
+// returns payload, that references returned frame buffer. Returns io.EOF
+// if r is at the end and ErrTruncatedFrame if frame is truncated.
+func ReadFrame(r io.Reader, buf []byte) (payload, frame []byte, err error) {
+	return ReadFrameLimit(r, buf, MaxFrameSize)
+}
+
+// ReadFrameLimit is ReadFrame, that returns ErrFrameTooLarge without
+// reading payload if it is longer than limit.
+func ReadFrameLimit(r io.Reader, buf []byte, limit int) (payload, frame []byte, err error) {
+	if cap(buf) < FrameHeaderSize {
+		buf = make([]byte, FrameHeaderSize, 512)
+	}
+	buf = buf[:FrameHeaderSize]
+	if _, err = io.ReadFull(r, buf); err != nil {
+		if err == io.ErrUnexpectedEOF {
+			err = ErrTruncatedFrame
+		}
+		return nil, buf, err
+	}
+	c, n, err := frameHeader(buf)
+	if err != nil {
+		return nil, buf, err
+	}
+	if n > limit {
+		return nil, buf, ErrFrameTooLarge
+	}
+	size := c.FrameSize(n)
+	if cap(buf) < size {
+		grown := make([]byte, size)
+		copy(grown, buf)
+		buf = grown
+	}
+	buf = buf[:size]
+	if _, err = io.ReadFull(r, buf[FrameHeaderSize:]); err != nil {
+		if err == io.EOF || err == io.ErrUnexpectedEOF {
+			err = ErrTruncatedFrame
+		}
+		return nil, buf, err
+	}
+	payload, _, err = DecodeFrame(buf)
+	return payload, buf, err
+}
@@ -0,0 +1,122 @@
+package binary
+
+import (
+	"bytes"
+	"io"
+	"testing"
+)
+
+func TestFrame(t *testing.T) {
+	payload := []byte("payload")
+	for _, c := range []Checksum{CRC32IEEE, CRC32Castagnoli, CRC64ISO, CRC64ECMA} {
+		buf := AppendFrameChecksum([]byte("prefix"), payload, c)
+		buf = append(buf, "rest"...)
+		frame := buf[len("prefix"):]
+		if len(frame) != c.FrameSize(len(payload))+len("rest") {
+			t.Error(c, "bad frame size", len(frame))
+		}
+		got, rest, err := DecodeFrame(frame)
+		if err != nil {
+			t.Fatal(c, err)
+		}
+		if !bytes.Equal(got, payload) || string(rest) != "rest" {
+			t.Error(c, "mismatch", got, rest)
+		}
+		if _, _, err := DecodeFrame(frame[:c.FrameSize(len(payload))-1]); err != ErrTruncatedFrame {
+			t.Error(c, err, "!=", ErrTruncatedFrame)
+		}
+		frame[FrameHeaderSize+1]++
+		if _, _, err := DecodeFrame(frame); err != ErrCorruptedFrame {
+			t.Error(c, err, "!=", ErrCorruptedFrame)
+		}
+	}
+	if _, _, err := DecodeFrame([]byte{0xff, 0, 0, 0, 0, 0}); err != ErrUnknownChecksum {
+		t.Error(err, "!=", ErrUnknownChecksum)
+	}
+	if _, _, err := DecodeFrame([]byte{1, 0xff, 0, 0, 0}); err != ErrCorruptedFrame {
+		t.Error(err, "!=", ErrCorruptedFrame)
+	}
+}
+
+func TestFrameReader(t *testing.T) {
+	var buf []byte
+	payloads := []string{"first", "", "third frame is longer"}
+	for _, p := range payloads {
+		buf = AppendFrameChecksum(buf, []byte(p), CRC64ECMA)
+	}
+	complete := int64(len(buf))
+	buf = AppendFrame(buf, []byte("truncated"))
+	buf = buf[:len(buf)-2]
+
+	r := NewFrameReader(bytes.NewReader(buf), 0)
+	for _, p := range payloads {
+		got, err := r.Next()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if string(got) != p {
+			t.Error(string(got), "!=", p)
+		}
+	}
+	if _, err := r.Next(); err != ErrTruncatedFrame {
+		t.Error(err, "!=", ErrTruncatedFrame)
+	}
+	if r.Offset() != complete {
+		t.Error(r.Offset(), "!=", complete)
+	}
+	r = NewFrameReader(bytes.NewReader(buf[:complete]), 0)
+	for range payloads {
+		if _, err := r.Next(); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if _, err := r.Next(); err != io.EOF {
+		t.Error(err, "!=", io.EOF)
+	}
+}
+
+func TestReadFrame(t *testing.T) {
+	var buf []byte
+	payloads := []string{"first", "", "third frame is longer than first"}
+	for _, p := range payloads {
+		buf = AppendFrame(buf, []byte(p))
+	}
+	var (
+		r     = bytes.NewReader(buf)
+		frame []byte
+	)
+	for _, p := range payloads {
+		got, f, err := ReadFrame(r, frame)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if string(got) != p {
+			t.Error(string(got), "!=", p)
+		}
+		frame = f
+	}
+	if _, _, err := ReadFrame(r, frame); err != io.EOF {
+		t.Error(err, "!=", io.EOF)
+	}
+	for _, n := range []int{2, FrameHeaderSize + 1} {
+		if _, _, err := ReadFrame(bytes.NewReader(buf[:n]), nil); err != ErrTruncatedFrame {
+			t.Error(n, err, "!=", ErrTruncatedFrame)
+		}
+	}
+	if _, _, err := ReadFrameLimit(bytes.NewReader(buf), nil, 4); err != ErrFrameTooLarge {
+		t.Error(err, "!=", ErrFrameTooLarge)
+	}
+	if got, _, err := ReadFrameLimit(bytes.NewReader(buf), nil, 5); err != nil || string(got) != "first" {
+		t.Error(string(got), err)
+	}
+}
+
+func BenchmarkAppendFrame(b *testing.B) {
+	b.ReportAllocs()
+	payload := make([]byte, 2048)
+	buf := make([]byte, 0, 4096)
+	b.SetBytes(int64(len(payload)))
+	for i := 0; i < b.N; i++ {
+		buf = AppendFrame(buf[:0], payload)
+	}
+}
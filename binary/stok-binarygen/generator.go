@@ -0,0 +1,294 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"go/ast"
+	"go/format"
+	"go/token"
+	"reflect"
+	"strconv"
+	"strings"
+	"unicode"
+)
+
+// TagName is name of struct tag that is read by generator.
+//
+// Supported tag values:
+//
+//	binary:"-"           field is skipped
+//	binary:"magic=name"  blank [8]byte field, encoded as package-level var name
+//	binary:"crc"         blank uint32 field, crc32 of all previous fields
+const TagName = "binary"
+
+// kinds of fields
+const (
+	kindInt   = "int"
+	kindBool  = "bool"
+	kindBytes = "bytes"
+	kindStr   = "string"
+	kindArray = "array"
+	kindMagic = "magic"
+	kindCRC   = "crc"
+)
+
+var intSizes = map[string]int{
+	"int8":   1,
+	"uint8":  1,
+	"byte":   1,
+	"int16":  2,
+	"uint16": 2,
+	"int32":  4,
+	"uint32": 4,
+	"int64":  8,
+	"uint64": 8,
+}
+
+type field struct {
+	Name  string
+	Type  string // go type of int field
+	Kind  string
+	Size  int    // size in bytes for int and array fields
+	Magic string // name of magic variable
+}
+
+type structType struct {
+	Name   string
+	Fields []field
+}
+
+func (s structType) hasKind(kind string) bool {
+	for _, f := range s.Fields {
+		if f.Kind == kind {
+			return true
+		}
+	}
+	return false
+}
+
+// parseStruct returns structType for struct with given name.
+func parseStruct(name string, st *ast.StructType) (structType, error) {
+	s := structType{Name: name}
+	for _, f := range st.Fields.List {
+		var tag string
+		if f.Tag != nil {
+			v, err := strconv.Unquote(f.Tag.Value)
+			if err != nil {
+				return s, err
+			}
+			tag = reflect.StructTag(v).Get(TagName)
+		}
+		if tag == "-" {
+			continue
+		}
+		if len(f.Names) == 0 {
+			return s, fmt.Errorf("%s: embedded fields are not supported", name)
+		}
+		for _, n := range f.Names {
+			fd, err := parseField(n.Name, f.Type, tag)
+			if err != nil {
+				return s, fmt.Errorf("%s.%s: %v", name, n.Name, err)
+			}
+			s.Fields = append(s.Fields, fd)
+		}
+	}
+	crc := -1
+	for i, f := range s.Fields {
+		if f.Kind == kindCRC {
+			crc = i
+		}
+	}
+	if crc >= 0 && crc != len(s.Fields)-1 {
+		return s, fmt.Errorf("%s: crc should be the last field", name)
+	}
+	return s, nil
+}
+
+func parseField(name string, expr ast.Expr, tag string) (field, error) {
+	f := field{Name: name}
+	switch {
+	case strings.HasPrefix(tag, "magic="):
+		f.Kind = kindMagic
+		f.Magic = strings.TrimPrefix(tag, "magic=")
+		f.Size = 8
+		if name != "_" {
+			return f, fmt.Errorf("magic field should be blank")
+		}
+		if !isMagicType(expr) {
+			return f, fmt.Errorf("magic field should be [8]byte")
+		}
+		return f, nil
+	case tag == "crc":
+		f.Kind = kindCRC
+		f.Size = 4
+		if name != "_" {
+			return f, fmt.Errorf("crc field should be blank")
+		}
+		if t, ok := expr.(*ast.Ident); !ok || t.Name != "uint32" {
+			return f, fmt.Errorf("crc field should be uint32")
+		}
+		return f, nil
+	case tag != "":
+		return f, fmt.Errorf("unknown tag %q", tag)
+	case name == "_":
+		return f, fmt.Errorf("blank field without tag")
+	}
+	switch t := expr.(type) {
+	case *ast.Ident:
+		if size, ok := intSizes[t.Name]; ok {
+			f.Kind, f.Type, f.Size = kindInt, t.Name, size
+			return f, nil
+		}
+		switch t.Name {
+		case "bool":
+			f.Kind = kindBool
+			return f, nil
+		case "string":
+			f.Kind = kindStr
+			return f, nil
+		}
+	case *ast.ArrayType:
+		elt, ok := t.Elt.(*ast.Ident)
+		if !ok || (elt.Name != "byte" && elt.Name != "uint8") {
+			break
+		}
+		if t.Len == nil {
+			f.Kind = kindBytes
+			return f, nil
+		}
+		lit, ok := t.Len.(*ast.BasicLit)
+		if !ok || lit.Kind != token.INT {
+			return f, fmt.Errorf("array length should be literal")
+		}
+		size, err := strconv.Atoi(lit.Value)
+		if err != nil {
+			return f, err
+		}
+		f.Kind, f.Size = kindArray, size
+		return f, nil
+	}
+	return f, fmt.Errorf("unsupported type")
+}
+
+// isMagicType reports whether expr is [8]byte, that is type of magic field.
+func isMagicType(expr ast.Expr) bool {
+	t, ok := expr.(*ast.ArrayType)
+	if !ok {
+		return false
+	}
+	elt, ok := t.Elt.(*ast.Ident)
+	lit, isLit := t.Len.(*ast.BasicLit)
+	return ok && (elt.Name == "byte" || elt.Name == "uint8") && isLit && lit.Value == "8"
+}
+
+func receiver(name string) string {
+	return string(unicode.ToLower([]rune(name)[0]))
+}
+
+// funcSuffixes are suffixes of binary.Append* and binary.Decode* functions
+// for int types.
+var funcSuffixes = map[string]string{
+	"int8":   "Int8",
+	"uint8":  "Uint8",
+	"byte":   "Uint8",
+	"int16":  "Int16",
+	"uint16": "Uint16",
+	"int32":  "Int32",
+	"uint32": "Uint32",
+	"int64":  "Int64",
+	"uint64": "Uint64",
+}
+
+// suffix returns suffix of binary.Append* and binary.Decode* functions for f.
+func (f field) suffix() string {
+	switch f.Kind {
+	case kindInt:
+		return funcSuffixes[f.Type]
+	case kindBool:
+		return "Bool"
+	case kindBytes:
+		return "Bytes"
+	case kindStr:
+		return "String"
+	}
+	return ""
+}
+
+func (s structType) writeAppend(w *bytes.Buffer) {
+	r := receiver(s.Name)
+	fmt.Fprintf(w, "// Append encodes %s to buf and returns it, implementing binary.Appender.\n", s.Name)
+	fmt.Fprintf(w, "func (%s %s) Append(buf []byte) []byte {\n", r, s.Name)
+	if s.hasKind(kindCRC) {
+		fmt.Fprintf(w, "start := len(buf)\n")
+	}
+	for _, f := range s.Fields {
+		v := r + "." + f.Name
+		switch f.Kind {
+		case kindMagic:
+			fmt.Fprintf(w, "buf = binary.AppendMagic(buf, %s)\n", f.Magic)
+		case kindArray:
+			fmt.Fprintf(w, "buf = append(buf, %s[:]...)\n", v)
+		case kindCRC:
+			fmt.Fprintf(w, "buf = binary.AppendUint32(buf, crc32.ChecksumIEEE(buf[start:]))\n")
+		default:
+			fmt.Fprintf(w, "buf = binary.Append%s(buf, %s)\n", f.suffix(), v)
+		}
+	}
+	fmt.Fprintf(w, "return buf\n}\n\n")
+}
+
+// check writes code that returns err if it is not nil.
+func check(w *bytes.Buffer, format string, args ...interface{}) {
+	fmt.Fprintf(w, "if buf, err = "+format+"; err != nil {\nreturn buf, err\n}\n", args...)
+}
+
+func (s structType) writeDecode(w *bytes.Buffer) {
+	r := receiver(s.Name)
+	fmt.Fprintf(w, "// Decode decodes %s from buf and returns rest of buf, implementing binary.Decoder.\n", s.Name)
+	fmt.Fprintf(w, "func (%s *%s) Decode(buf []byte) ([]byte, error) {\n", r, s.Name)
+	fmt.Fprintf(w, "var err error\n")
+	if s.hasKind(kindCRC) {
+		fmt.Fprintf(w, "start := buf\n")
+	}
+	for _, f := range s.Fields {
+		v := r + "." + f.Name
+		switch f.Kind {
+		case kindMagic:
+			check(w, "binary.DecodeMagic(buf, %s)", f.Magic)
+		case kindArray:
+			fmt.Fprintf(w, "if len(buf) < %d {\nreturn buf, binary.ErrShortBuffer\n}\n", f.Size)
+			fmt.Fprintf(w, "copy(%s[:], buf)\nbuf = buf[%d:]\n", v, f.Size)
+		case kindCRC:
+			fmt.Fprintf(w, "var crc uint32\nn := len(start) - len(buf)\n")
+			check(w, "binary.DecodeUint32(buf, &crc)")
+			fmt.Fprintf(w, "if crc32.ChecksumIEEE(start[:n]) != crc {\nreturn buf, binary.ErrBadCRC\n}\n")
+		default:
+			check(w, "binary.Decode%s(buf, &%s)", f.suffix(), v)
+		}
+	}
+	fmt.Fprintf(w, "return buf, nil\n}\n\n")
+}
+
+// generate returns formatted source with Append and Decode methods
+// for given structs.
+func generate(pkg string, args []string, structs []structType) ([]byte, error) {
+	var (
+		w       = new(bytes.Buffer)
+		imports []string
+	)
+	fmt.Fprintf(w, "// Code generated by \"stok-binarygen %s\"; DO NOT EDIT.\n\n", strings.Join(args, " "))
+	fmt.Fprintf(w, "package %s\n\n", pkg)
+	for _, s := range structs {
+		if s.hasKind(kindCRC) {
+			imports = append(imports, `"hash/crc32"`, "")
+			break
+		}
+	}
+	imports = append(imports, `"github.com/cydev/stok/binary"`)
+	fmt.Fprintf(w, "import (\n%s\n)\n\n", strings.Join(imports, "\n"))
+	for _, s := range structs {
+		s.writeAppend(w)
+		s.writeDecode(w)
+	}
+	return format.Source(w.Bytes())
+}
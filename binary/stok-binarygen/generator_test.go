@@ -0,0 +1,95 @@
+package main
+
+import (
+	"go/ast"
+	"go/parser"
+	"go/token"
+	"strings"
+	"testing"
+)
+
+func parseSource(t *testing.T, src string) []structType {
+	fset := token.NewFileSet()
+	f, err := parser.ParseFile(fset, "source.go", src, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var structs []structType
+	for _, decl := range f.Decls {
+		gen, ok := decl.(*ast.GenDecl)
+		if !ok {
+			continue
+		}
+		for _, spec := range gen.Specs {
+			ts := spec.(*ast.TypeSpec)
+			s, err := parseStruct(ts.Name.Name, ts.Type.(*ast.StructType))
+			if err != nil {
+				t.Fatal(err)
+			}
+			structs = append(structs, s)
+		}
+	}
+	return structs
+}
+
+func TestGenerate(t *testing.T) {
+	structs := parseSource(t, `package p
+type record struct {
+	_       [8]byte `+"`binary:\"magic=recordMagic\"`"+`
+	ID      int64
+	Flags   uint8
+	Kind    uint16
+	Deleted bool
+	Hash    [20]byte
+	Data    []byte
+	Name    string
+	Cache   map[string]int `+"`binary:\"-\"`"+`
+	_       uint32 `+"`binary:\"crc\"`"+`
+}`)
+	if len(structs[0].Fields) != 9 {
+		t.Fatal("bad fields", structs[0].Fields)
+	}
+	src, err := generate("p", []string{"-type", "record"}, structs)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := parser.ParseFile(token.NewFileSet(), "", src, 0); err != nil {
+		t.Fatal(err)
+	}
+	for _, s := range []string{
+		"func (r record) Append(buf []byte) []byte",
+		"func (r *record) Decode(buf []byte) ([]byte, error)",
+		"binary.DecodeMagic(buf, recordMagic)",
+		"crc32.ChecksumIEEE(buf[start:])",
+		"binary.DecodeBytes(buf, &r.Data)",
+		"binary.AppendUint16(buf, r.Kind)",
+		`"hash/crc32"`,
+	} {
+		if !strings.Contains(string(src), s) {
+			t.Errorf("%q not found in generated code", s)
+		}
+	}
+}
+
+func TestParseStructErrors(t *testing.T) {
+	for _, src := range []string{
+		"struct { Size int }",
+		"struct { Values []int64 }",
+		"struct { _ uint32 `binary:\"crc\"`; Size int64 }",
+		"struct { Magic [8]byte `binary:\"magic=m\"` }",
+		"struct { Size int64 `binary:\"varint\"` }",
+		"struct { _ int64 }",
+		"struct { _ int64 `binary:\"crc\"` }",
+		"struct { _ [4]byte `binary:\"crc\"` }",
+		"struct { _ [4]byte `binary:\"magic=m\"` }",
+		"struct { _ uint64 `binary:\"magic=m\"` }",
+	} {
+		expr, err := parser.ParseExpr(src)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err := parseStruct("s", expr.(*ast.StructType)); err == nil {
+			t.Error(src, "should fail")
+		}
+	}
+}
@@ -0,0 +1,108 @@
+// Command stok-binarygen generates Append and Decode methods, that
+// implement binary.Appender and binary.Decoder, for structs with
+// fixed-width integers, bools, byte arrays, length-prefixed byte slices
+// and strings, magic bytes and trailing crc32.
+//
+// Integers are encoded in big endian, byte slices and strings are
+// prefixed with uint32 length. Usage:
+//
+//	//go:generate stok-binarygen -type header
+//	type header struct {
+//	    _    [8]byte `binary:"magic=magic"`
+//	    Size int64
+//	    Name string
+//	    _    uint32 `binary:"crc"`
+//	}
+//
+// Generated code is written to <type>_binary.go in package directory.
+package main
+
+import (
+	"flag"
+	"fmt"
+	"go/ast"
+	"go/parser"
+	"go/token"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+var (
+	typeNames = flag.String("type", "", "comma-separated list of type names, required")
+	output    = flag.String("output", "", "output file name, default is <type>_binary.go")
+)
+
+func fatal(args ...interface{}) {
+	fmt.Fprintln(os.Stderr, append([]interface{}{"stok-binarygen:"}, args...)...)
+	os.Exit(1)
+}
+
+// findStructs parses package in dir and returns package name and
+// structs with given names.
+func findStructs(dir string, names []string) (string, []structType, error) {
+	fset := token.NewFileSet()
+	pkgs, err := parser.ParseDir(fset, dir, func(info os.FileInfo) bool {
+		return !strings.HasSuffix(info.Name(), "_test.go")
+	}, 0)
+	if err != nil {
+		return "", nil, err
+	}
+	found := make(map[string]*ast.StructType)
+	var pkgName string
+	for name, pkg := range pkgs {
+		pkgName = name
+		ast.Inspect(pkg, func(n ast.Node) bool {
+			spec, ok := n.(*ast.TypeSpec)
+			if !ok {
+				return true
+			}
+			if st, ok := spec.Type.(*ast.StructType); ok {
+				found[spec.Name.Name] = st
+			}
+			return false
+		})
+	}
+	var structs []structType
+	for _, name := range names {
+		st, ok := found[name]
+		if !ok {
+			return "", nil, fmt.Errorf("struct %s not found", name)
+		}
+		s, err := parseStruct(name, st)
+		if err != nil {
+			return "", nil, err
+		}
+		structs = append(structs, s)
+	}
+	return pkgName, structs, nil
+}
+
+func main() {
+	flag.Parse()
+	if *typeNames == "" {
+		flag.Usage()
+		os.Exit(2)
+	}
+	dir := "."
+	if flag.NArg() > 0 {
+		dir = flag.Arg(0)
+	}
+	names := strings.Split(*typeNames, ",")
+	pkg, structs, err := findStructs(dir, names)
+	if err != nil {
+		fatal(err)
+	}
+	src, err := generate(pkg, os.Args[1:], structs)
+	if err != nil {
+		fatal("format:", err)
+	}
+	name := *output
+	if name == "" {
+		name = filepath.Join(dir, strings.ToLower(names[0])+"_binary.go")
+	}
+	if err = ioutil.WriteFile(name, src, 0644); err != nil {
+		fatal(err)
+	}
+}
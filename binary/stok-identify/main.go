@@ -0,0 +1,57 @@
+// Command stok-identify prints format of stok files and whether
+// their headers are valid.
+//
+//	$ stok-identify volume.blob volume.btree data.bin
+//	volume.blob: blob v1, header ok
+//	volume.btree: btree v1, header invalid: Bad meta page
+//	data.bin: Unknown file format
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+
+	"github.com/cydev/stok/binary"
+
+	// registering formats
+	_ "github.com/cydev/stok/backup"
+	_ "github.com/cydev/stok/erasure"
+	_ "github.com/cydev/stok/file"
+	_ "github.com/cydev/stok/index/btree"
+	_ "github.com/cydev/stok/storage"
+)
+
+// identify returns description of file format.
+func identify(name string) (string, bool) {
+	f, err := os.Open(name)
+	if err != nil {
+		return err.Error(), false
+	}
+	defer f.Close()
+	format, err := binary.Identify(f)
+	if format.Name == "" {
+		return err.Error(), false
+	}
+	if err != nil {
+		return fmt.Sprintf("%s v%d, header invalid: %v", format.Name, format.Version, err), false
+	}
+	return fmt.Sprintf("%s v%d, header ok", format.Name, format.Version), true
+}
+
+func main() {
+	flag.Parse()
+	if flag.NArg() == 0 {
+		fmt.Fprintln(os.Stderr, "usage: stok-identify file...")
+		os.Exit(2)
+	}
+	code := 0
+	for _, name := range flag.Args() {
+		s, ok := identify(name)
+		if !ok {
+			code = 1
+		}
+		fmt.Printf("%s: %s\n", name, s)
+	}
+	os.Exit(code)
+}
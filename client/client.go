@@ -0,0 +1,347 @@
+// Package client implements client of stok cluster.
+//
+// Client assigns file ids and resolves locations of volumes through
+// master, and transfers files to volume servers over pooled HTTP
+// connections. Writes go only to primary server of volume. Idempotent
+// operations are retried with exponential backoff, and reads fail over
+// to other replicas of volume, resuming interrupted download from last
+// received byte.
+package client
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"math/rand"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/cydev/stok"
+	"github.com/cydev/stok/master"
+	"github.com/pkg/errors"
+)
+
+// ErrNotFound means that file does not exist.
+const ErrNotFound stok.Error = "File not found"
+
+const (
+	// DefaultRetries is default count of retries of failed operation.
+	DefaultRetries = 3
+	// DefaultBackoff is default delay before first retry.
+	DefaultBackoff = 100 * time.Millisecond
+	// DefaultCacheTTL is default time during which volume locations
+	// are cached.
+	DefaultCacheTTL = time.Minute
+	// DefaultMaxIdleConns is default count of idle connections kept
+	// to every volume server.
+	DefaultMaxIdleConns = 32
+)
+
+// Client is client of stok cluster. It is goroutine-safe.
+type Client struct {
+	Master master.Client
+	// HTTP is used for requests to volume servers.
+	HTTP *http.Client
+	// Retries is count of retries of failed operation,
+	// DefaultRetries if zero and no retries if negative.
+	Retries int
+	// Backoff is delay before first retry, that is doubled after every
+	// retry, DefaultBackoff if zero.
+	Backoff time.Duration
+	// CacheTTL is time during which volume locations are cached,
+	// DefaultCacheTTL if zero.
+	CacheTTL time.Duration
+
+	mux       sync.Mutex
+	locations map[uint32]locations
+}
+
+// locations are cached locations of volume.
+type locations struct {
+	list    []master.Location
+	expires time.Time
+}
+
+// New returns Client of cluster with master at url, that keeps
+// DefaultMaxIdleConns idle connections to every volume server.
+func New(url string) *Client {
+	transport := &http.Transport{
+		Proxy: http.ProxyFromEnvironment,
+		DialContext: (&net.Dialer{
+			Timeout:   10 * time.Second,
+			KeepAlive: 30 * time.Second,
+		}).DialContext,
+		MaxIdleConns:        DefaultMaxIdleConns * 4,
+		MaxIdleConnsPerHost: DefaultMaxIdleConns,
+		IdleConnTimeout:     90 * time.Second,
+	}
+	h := &http.Client{Transport: transport}
+	return &Client{
+		Master: master.Client{URL: url, HTTP: h},
+		HTTP:   h,
+	}
+}
+
+func (c *Client) http() *http.Client {
+	if c.HTTP == nil {
+		return http.DefaultClient
+	}
+	return c.HTTP
+}
+
+func (c *Client) retries() int {
+	switch {
+	case c.Retries == 0:
+		return DefaultRetries
+	case c.Retries < 0:
+		return 0
+	}
+	return c.Retries
+}
+
+func (c *Client) backoff() time.Duration {
+	if c.Backoff == 0 {
+		return DefaultBackoff
+	}
+	return c.Backoff
+}
+
+func (c *Client) cacheTTL() time.Duration {
+	if c.CacheTTL == 0 {
+		return DefaultCacheTTL
+	}
+	return c.CacheTTL
+}
+
+// temporary marks error of operation that can be retried.
+type temporary struct {
+	error
+}
+
+// retry calls f until it succeeds, returns error that is not temporary
+// or retries are exhausted. Delay between calls is doubled after every
+// retry and randomized to avoid synchronized retries of many clients.
+func (c *Client) retry(ctx context.Context, f func(attempt int) error) error {
+	backoff := c.backoff()
+	for attempt := 0; ; attempt++ {
+		err := f(attempt)
+		t, ok := err.(temporary)
+		if !ok {
+			return err
+		}
+		if attempt >= c.retries() {
+			return t.error
+		}
+		delay := backoff/2 + time.Duration(rand.Int63n(int64(backoff/2)+1))
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(delay):
+		}
+		backoff *= 2
+	}
+}
+
+// lookup returns locations of volume, requesting them from master if
+// they are not cached or refresh is true.
+func (c *Client) lookup(ctx context.Context, id uint32, refresh bool) ([]master.Location, error) {
+	c.mux.Lock()
+	cached, ok := c.locations[id]
+	c.mux.Unlock()
+	if ok && !refresh && time.Now().Before(cached.expires) {
+		return cached.list, nil
+	}
+	l, err := c.Master.Lookup(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+	if len(l.Locations) == 0 {
+		return nil, master.ErrVolumeNotFound
+	}
+	c.mux.Lock()
+	if c.locations == nil {
+		c.locations = make(map[uint32]locations)
+	}
+	c.locations[id] = locations{list: l.Locations, expires: time.Now().Add(c.cacheTTL())}
+	c.mux.Unlock()
+	return l.Locations, nil
+}
+
+// primary returns location of primary server of volume, that accepts
+// writes, requesting locations from master if refresh is true.
+func (c *Client) primary(ctx context.Context, id uint32, refresh bool) (master.Location, error) {
+	list, err := c.lookup(ctx, id, refresh)
+	if err != nil {
+		return master.Location{}, masterError(ctx, err)
+	}
+	for _, l := range list {
+		if l.Primary {
+			return l, nil
+		}
+	}
+	return master.Location{}, temporary{errors.Errorf("volume %d has no primary", id)}
+}
+
+// masterError returns error of master request, that is temporary
+// unless ctx is done.
+func masterError(ctx context.Context, err error) error {
+	if err == nil || ctx.Err() != nil {
+		return err
+	}
+	return temporary{errors.Wrap(err, "master")}
+}
+
+// do sends request and returns response with successful status.
+// Network errors and 5xx statuses are temporary.
+func (c *Client) do(ctx context.Context, req *http.Request) (*http.Response, error) {
+	res, err := c.http().Do(req.WithContext(ctx))
+	if err != nil {
+		if ctx.Err() != nil {
+			return nil, ctx.Err()
+		}
+		return nil, temporary{err}
+	}
+	if res.StatusCode < 300 {
+		return res, nil
+	}
+	io.Copy(ioutil.Discard, io.LimitReader(res.Body, 4096))
+	res.Body.Close()
+	err = fmt.Errorf("%s %s: %s", req.Method, req.URL, res.Status)
+	switch {
+	case res.StatusCode == http.StatusNotFound:
+		return nil, ErrNotFound
+	case res.StatusCode >= 500:
+		return nil, temporary{err}
+	}
+	return nil, err
+}
+
+func fileURL(location master.Location, fid master.FileID) string {
+	return strings.TrimSuffix(location.URL, "/") + "/" + fid.String()
+}
+
+// Put uploads content of r as new file and returns its id.
+func (c *Client) Put(ctx context.Context, r io.Reader) (master.FileID, error) {
+	data, err := ioutil.ReadAll(r)
+	if err != nil {
+		return master.FileID{}, errors.Wrap(err, "failed to read")
+	}
+	var (
+		a   master.Assignment
+		fid master.FileID
+	)
+	err = c.retry(ctx, func(int) error {
+		a, err = c.Master.Assign(ctx)
+		return masterError(ctx, err)
+	})
+	if err != nil {
+		return fid, err
+	}
+	if fid, err = master.ParseFileID(a.FileID); err != nil {
+		return fid, err
+	}
+	// writing same data to same file id is idempotent
+	location := master.Location{URL: a.URL, Primary: true}
+	return fid, c.retry(ctx, func(attempt int) error {
+		if attempt > 0 {
+			// primary can be changed
+			if location, err = c.primary(ctx, fid.Volume, true); err != nil {
+				return err
+			}
+		}
+		req, err := http.NewRequest(http.MethodPut, fileURL(location, fid), bytes.NewReader(data))
+		if err != nil {
+			return err
+		}
+		res, err := c.do(ctx, req)
+		if err != nil {
+			return err
+		}
+		res.Body.Close()
+		return nil
+	})
+}
+
+// countingWriter counts bytes written to w.
+type countingWriter struct {
+	w   io.Writer
+	n   int64
+	err error // error of w
+}
+
+func (c *countingWriter) Write(p []byte) (int, error) {
+	n, err := c.w.Write(p)
+	c.n += int64(n)
+	c.err = err
+	return n, err
+}
+
+// Get writes content of file to w. Every attempt uses next location of
+// volume, and continues download from last byte written to w.
+func (c *Client) Get(ctx context.Context, fid master.FileID, w io.Writer) error {
+	cw := &countingWriter{w: w}
+	var etag string
+	return c.retry(ctx, func(attempt int) error {
+		list, err := c.lookup(ctx, fid.Volume, attempt > 0)
+		if err != nil {
+			return masterError(ctx, err)
+		}
+		req, err := http.NewRequest(http.MethodGet, fileURL(list[attempt%len(list)], fid), nil)
+		if err != nil {
+			return err
+		}
+		if cw.n > 0 {
+			req.Header.Set("Range", "bytes="+strconv.FormatInt(cw.n, 10)+"-")
+			req.Header.Set("If-Range", etag)
+		}
+		res, err := c.do(ctx, req)
+		if err != nil {
+			return err
+		}
+		defer res.Body.Close()
+		if cw.n > 0 && res.StatusCode != http.StatusPartialContent {
+			return errors.New("file is changed during download")
+		}
+		etag = res.Header.Get("ETag")
+		if _, err = io.Copy(cw, res.Body); err != nil {
+			if cw.err != nil {
+				return cw.err
+			}
+			return temporary{err}
+		}
+		return nil
+	})
+}
+
+// Delete deletes file on primary server of volume.
+func (c *Client) Delete(ctx context.Context, fid master.FileID) error {
+	sent := false // request is sent by previous attempt
+	return c.retry(ctx, func(attempt int) error {
+		location, err := c.primary(ctx, fid.Volume, attempt > 0)
+		if err != nil {
+			return err
+		}
+		req, err := http.NewRequest(http.MethodDelete, fileURL(location, fid), nil)
+		if err != nil {
+			return err
+		}
+		deleted := sent
+		sent = true
+		res, err := c.do(ctx, req)
+		if err == ErrNotFound && deleted {
+			// file can be deleted by previous attempt
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		res.Body.Close()
+		return nil
+	})
+}
@@ -0,0 +1,196 @@
+package client
+
+import (
+	"bytes"
+	"context"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/cydev/stok/master"
+	"github.com/cydev/stok/server"
+	"github.com/cydev/stok/stokutils"
+	"github.com/cydev/stok/volume"
+)
+
+// flaky fails requests while fail is positive, decrementing it.
+type flaky struct {
+	http.Handler
+	fail  int32
+	abort bool // abort response after half of body instead of 500
+}
+
+type halfWriter struct {
+	http.ResponseWriter
+}
+
+func (w halfWriter) Write(p []byte) (int, error) {
+	w.ResponseWriter.Write(p[:len(p)/2])
+	panic(http.ErrAbortHandler)
+}
+
+func (f *flaky) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if atomic.AddInt32(&f.fail, -1) < 0 {
+		f.Handler.ServeHTTP(w, r)
+		return
+	}
+	if f.abort {
+		f.Handler.ServeHTTP(halfWriter{w}, r)
+		return
+	}
+	http.Error(w, "unavailable", http.StatusServiceUnavailable)
+}
+
+func newCluster(t testing.TB) (*Client, *flaky, func()) {
+	dir, err := ioutil.TempDir("", "client")
+	if err != nil {
+		t.Fatal(err)
+	}
+	v, err := volume.Open(filepath.Join(dir, "volume"), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	s := &server.Server{Volume: v, VolumeID: 1}
+	f := &flaky{Handler: s}
+	ts := httptest.NewServer(f)
+	m := &master.Master{}
+	m.Join(master.Heartbeat{URL: ts.URL, Volumes: []master.VolumeInfo{s.Info()}})
+	ms := httptest.NewServer(m)
+	c := New(ms.URL)
+	c.Backoff = time.Millisecond
+	return c, f, func() {
+		ms.Close()
+		ts.Close()
+		stokutils.MustClose(t, v)
+		os.RemoveAll(dir)
+	}
+}
+
+func TestClient(t *testing.T) {
+	c, f, clear := newCluster(t)
+	defer clear()
+	ctx := context.Background()
+	data := bytes.Repeat([]byte("stok"), 1024)
+
+	// put is retried after failures
+	atomic.StoreInt32(&f.fail, 2)
+	fid, err := c.Put(ctx, bytes.NewReader(data))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var buf bytes.Buffer
+	if err = c.Get(ctx, fid, &buf); err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(buf.Bytes(), data) {
+		t.Error("data mismatch")
+	}
+
+	// interrupted download is resumed
+	buf.Reset()
+	f.abort = true
+	atomic.StoreInt32(&f.fail, 2)
+	if err = c.Get(ctx, fid, &buf); err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(buf.Bytes(), data) {
+		t.Error("data mismatch after resume", buf.Len())
+	}
+	f.abort = false
+
+	// retries are exhausted
+	atomic.StoreInt32(&f.fail, 10)
+	if err = c.Get(ctx, fid, ioutil.Discard); err == nil {
+		t.Error("expected error")
+	}
+	atomic.StoreInt32(&f.fail, 0)
+
+	if err = c.Delete(ctx, fid); err != nil {
+		t.Fatal(err)
+	}
+	if err = c.Get(ctx, fid, ioutil.Discard); err != ErrNotFound {
+		t.Error(err, "!=", ErrNotFound)
+	}
+	if err = c.Delete(ctx, fid); err != ErrNotFound {
+		t.Error(err, "!=", ErrNotFound)
+	}
+}
+
+func TestClient_Failover(t *testing.T) {
+	c, _, clear := newCluster(t)
+	defer clear()
+	ctx := context.Background()
+	fid, err := c.Put(ctx, bytes.NewReader([]byte("data")))
+	if err != nil {
+		t.Fatal(err)
+	}
+	list, err := c.lookup(ctx, fid.Volume, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// dead replica is listed first
+	dead := httptest.NewServer(http.NotFoundHandler())
+	dead.Close()
+	c.locations[fid.Volume] = locations{
+		list:    []master.Location{{URL: dead.URL}, list[0]},
+		expires: time.Now().Add(time.Hour),
+	}
+	var buf bytes.Buffer
+	if err = c.Get(ctx, fid, &buf); err != nil {
+		t.Fatal(err)
+	}
+	if buf.String() != "data" {
+		t.Errorf("%q != %q", buf.String(), "data")
+	}
+}
+
+func TestClient_Primary(t *testing.T) {
+	c, _, clear := newCluster(t)
+	defer clear()
+	ctx := context.Background()
+	fid, err := c.Put(ctx, bytes.NewReader([]byte("data")))
+	if err != nil {
+		t.Fatal(err)
+	}
+	list, err := c.lookup(ctx, fid.Volume, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(list) != 1 || !list[0].Primary {
+		t.Fatal("bad locations", list)
+	}
+	var writes int32
+	replica := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet {
+			atomic.AddInt32(&writes, 1)
+		}
+	}))
+	defer replica.Close()
+	cache := func(list ...master.Location) {
+		c.locations[fid.Volume] = locations{list: list, expires: time.Now().Add(time.Hour)}
+	}
+
+	// replica is listed first
+	cache(master.Location{URL: replica.URL}, list[0])
+	if err = c.Delete(ctx, fid); err != nil {
+		t.Fatal(err)
+	}
+	cache(list...)
+	if err = c.Get(ctx, fid, ioutil.Discard); err != ErrNotFound {
+		t.Error(err, "!=", ErrNotFound)
+	}
+
+	// first attempt fails before request, so file is not deleted by it
+	cache(master.Location{URL: replica.URL})
+	if err = c.Delete(ctx, fid); err != ErrNotFound {
+		t.Error(err, "!=", ErrNotFound)
+	}
+	if n := atomic.LoadInt32(&writes); n != 0 {
+		t.Error(n, "writes to replica")
+	}
+}
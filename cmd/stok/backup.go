@@ -0,0 +1,48 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/cydev/stok/backup"
+)
+
+func backupVolumes(fs *flag.FlagSet, args []string) {
+	full := fs.Bool("full", false, "write full backup instead of incremental one")
+	parse(fs, args, 2)
+	d := backup.Dir(fs.Arg(0))
+	if err := os.MkdirAll(string(d), 0755); err != nil {
+		fatal(err)
+	}
+	for _, path := range fs.Args()[1:] {
+		v := open(path)
+		s, err := d.Backup(filepath.Base(path), v, *full)
+		if err != nil {
+			abort(v, path+":", err)
+		}
+		closeVolume(v)
+		kind := "incremental"
+		switch {
+		case s.End == s.Start:
+			fmt.Printf("%s: up to date at %d\n", path, s.End)
+			continue
+		case s.Full():
+			kind = "full"
+		}
+		fmt.Printf("%s: %s backup %d-%d, %d records\n", path, kind, s.Start, s.End, s.Records)
+	}
+}
+
+func restore(fs *flag.FlagSet, args []string) {
+	parse(fs, args, 3)
+	segments, err := backup.Dir(fs.Arg(0)).Restore(fs.Arg(1), fs.Arg(2))
+	for _, s := range segments {
+		fmt.Printf("%d-%d\t%d records\t%s\n", s.Start, s.End, s.Records, s.Time.UTC().Format(time.RFC3339))
+	}
+	if err != nil {
+		fatal(err)
+	}
+}
@@ -0,0 +1,75 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"os"
+
+	"github.com/cydev/stok/volume"
+)
+
+func fsck(fs *flag.FlagSet, args []string) {
+	repair := fs.Bool("repair", false, "truncate torn tails, rewrite headers and fix index entries")
+	asJSON := fs.Bool("json", false, "print report of every volume as JSON line")
+	parse(fs, args, 1)
+	code := 0
+	e := json.NewEncoder(os.Stdout)
+	for _, name := range fs.Args() {
+		r, err := volume.Fsck(name, *repair)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "%s: %v\n", name, err)
+			code = 1
+			continue
+		}
+		if !r.OK && !r.Repaired {
+			code = 1
+		}
+		if *asJSON {
+			e.Encode(r)
+			continue
+		}
+		printReport(r)
+	}
+	os.Exit(code)
+}
+
+func printReport(r *volume.FsckReport) {
+	status := "ok"
+	switch {
+	case r.Repaired:
+		status = "repaired"
+	case !r.OK:
+		status = "damaged"
+	}
+	fmt.Printf("%s: %s, records=%d deleted=%d size=%d capacity=%d\n",
+		r.Blob, status, r.Records, r.Deleted, r.Size, r.Capacity)
+	if r.HeaderErr != "" {
+		fmt.Printf("  header: %s\n", r.HeaderErr)
+	}
+	if r.Unsynced > 0 {
+		fmt.Printf("  unsynced records=%d\n", r.Unsynced)
+	}
+	for _, c := range r.Corrupted {
+		fmt.Printf("  corrupted start=%d end=%d err=%q\n", c.Start, c.End, c.Err)
+	}
+	if t := r.TornTail; t != nil {
+		fmt.Printf("  torn tail start=%d end=%d err=%q\n", t.Start, t.End, t.Err)
+	}
+	if r.NoIndex {
+		fmt.Printf("  index %s does not exist\n", r.Index)
+	}
+	if r.IndexErr != "" {
+		fmt.Printf("  index: %s\n", r.IndexErr)
+	}
+	for _, m := range r.Mismatches {
+		kind := "stale"
+		switch {
+		case m.Dangling():
+			kind = "dangling"
+		case m.Offset == 0:
+			kind = "missing"
+		}
+		fmt.Printf("  %s index entry id=%d offset=%d record=%d\n", kind, m.ID, m.Offset, m.Record)
+	}
+}
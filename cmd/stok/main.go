@@ -0,0 +1,85 @@
+// Command stok administers local volume files.
+//
+//	$ stok create volume.blob
+//	$ stok put volume.blob photo.jpg
+//	0,0,3b9ac9ff	photo.jpg
+//	$ stok get -o copy.jpg volume.blob 0,0,3b9ac9ff
+//	$ stok ls volume.blob
+//	0,0,3b9ac9ff	5	2017-01-02T15:04:05Z
+//	$ stok stat volume.blob
+//	$ stok fsck -json volume.blob
+//	$ stok export volume.blob > volume.records
+//	$ stok import other.blob < volume.records
+//	$ stok export -format tar volume.blob > volume.tar
+//	$ stok import -format tar other.blob volume.tar
+//	$ stok import -p 8 other.blob photos/
+//	$ stok backup backups volume.blob
+//	$ stok restore backups volume.blob restored.blob
+//	$ stok delete volume.blob 0,0,3b9ac9ff
+//
+// Files are addressed by file ids, where volume id is ignored.
+// Backups of volume are named by base name of its path.
+// Volume must not be opened by other process, e.g. by server.
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+)
+
+// command is subcommand of stok.
+type command struct {
+	name  string
+	usage string // arguments, printed after name
+	help  string
+	run   func(fs *flag.FlagSet, args []string)
+}
+
+var commands = []command{
+	{"create", "[-size bytes] volume", "create empty volume", create},
+	{"put", "[-vid id] volume [file...]", "write files or stdin as new records and print their ids", put},
+	{"get", "[-o file] volume fid", "write record to stdout or file", get},
+	{"delete", "volume fid...", "delete records", remove},
+	{"ls", "[-vid id] volume", "list live records with size and time", ls},
+	{"stat", "file...", "print format and header values of blobs, files and indexes", stat},
+	{"fsck", "[-repair] [-json] volume...", "verify and repair volumes", fsck},
+	{"export", "[-o file] [-format records|tar] volume", "write live records as record stream or tar", export},
+	{"import", "[-format records|tar] [-p workers] [-vid id] volume [file|dir...]", "write records from record streams, tars, directories or stdin", importRecords},
+	{"backup", "[-full] dir volume...", "write new records of volumes to backup directory", backupVolumes},
+	{"restore", "dir name volume", "create volume from full and incremental backups of volume with name", restore},
+}
+
+func usage() {
+	fmt.Fprintln(os.Stderr, "usage: stok command [arguments]\n\ncommands:")
+	for _, c := range commands {
+		fmt.Fprintf(os.Stderr, "  %-8s %s\n", c.name, c.help)
+	}
+	os.Exit(2)
+}
+
+func fatal(args ...interface{}) {
+	fmt.Fprintln(os.Stderr, args...)
+	os.Exit(1)
+}
+
+func main() {
+	if len(os.Args) < 2 {
+		usage()
+	}
+	for _, c := range commands {
+		if c.name != os.Args[1] {
+			continue
+		}
+		c := c
+		fs := flag.NewFlagSet(c.name, flag.ExitOnError)
+		fs.Usage = func() {
+			fmt.Fprintf(os.Stderr, "usage: stok %s %s\n\n%s\n", c.name, c.usage, c.help)
+			fs.PrintDefaults()
+			os.Exit(2)
+		}
+		c.run(fs, os.Args[2:])
+		return
+	}
+	usage()
+}
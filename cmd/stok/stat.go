@@ -0,0 +1,109 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/cydev/stok/backup"
+	"github.com/cydev/stok/binary"
+	"github.com/cydev/stok/file"
+	"github.com/cydev/stok/index"
+	"github.com/cydev/stok/storage"
+	"github.com/cydev/stok/volume"
+
+	// registering formats
+	_ "github.com/cydev/stok/erasure"
+	_ "github.com/cydev/stok/index/btree"
+)
+
+func stat(fs *flag.FlagSet, args []string) {
+	parse(fs, args, 1)
+	code := 0
+	for _, name := range fs.Args() {
+		if err := statFile(name); err != nil {
+			fmt.Printf("%s: %v\n", name, err)
+			code = 1
+		}
+	}
+	os.Exit(code)
+}
+
+// statFile prints format and header values of file.
+func statFile(name string) error {
+	f, err := os.Open(name)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	info, err := f.Stat()
+	if err != nil {
+		return err
+	}
+	format, err := binary.Identify(f)
+	if err == binary.ErrUnknownFormat && strings.HasSuffix(name, volume.IndexSuffix) {
+		// volume index has no header
+		fmt.Printf("%s: volume index\n  file size  %d\n", name, info.Size())
+		return statIndex(name)
+	}
+	if format.Name == "" {
+		return err
+	}
+	fmt.Printf("%s: %s v%d\n", name, format.Name, format.Version)
+	if err != nil {
+		return fmt.Errorf("header invalid: %v", err)
+	}
+	fmt.Printf("  file size  %d\n", info.Size())
+	switch format.Name {
+	case "blob":
+		header := make([]byte, format.HeaderSize)
+		if _, err = f.ReadAt(header, 0); err != nil {
+			return err
+		}
+		var h storage.BlobHeader
+		if err = h.Read(header); err != nil {
+			return err
+		}
+		fmt.Printf("  size       %d\n  capacity   %d\n", h.Size, h.Capacity)
+		if h.Capacity > info.Size() {
+			return storage.ErrBadHeaderCapacity
+		}
+		return statIndex(name + volume.IndexSuffix)
+	case "file":
+		ff, err := file.New(f)
+		if err != nil {
+			return err
+		}
+		fmt.Printf("  size       %d\n", ff.Size())
+	case "backup":
+		s, err := backup.Read(f)
+		if err != nil {
+			return err
+		}
+		fmt.Printf("  start      %d\n  end        %d\n  time       %s\n  blob       %08x\n",
+			s.Start, s.End, s.Time.UTC().Format(time.RFC3339), s.Blob)
+	}
+	return nil
+}
+
+// statIndex prints length and count of live entries of volume index
+// at name if it exists.
+func statIndex(name string) error {
+	for _, n := range []string{name, name + index.BitmapSuffix} {
+		if _, err := os.Stat(n); os.IsNotExist(err) {
+			return nil
+		}
+	}
+	idx, err := index.Open(name, volume.LocationSize)
+	if err != nil {
+		return err
+	}
+	defer idx.Close()
+	length, _ := idx.Len()
+	count, _ := idx.Count()
+	fmt.Printf("  index      %s\n  ids        %d\n  live       %d\n", filepath.Base(name), length, count)
+	return nil
+}
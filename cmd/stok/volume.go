@@ -0,0 +1,248 @@
+package main
+
+import (
+	"bufio"
+	"flag"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/cydev/stok/master"
+	"github.com/cydev/stok/storage"
+	"github.com/cydev/stok/volume"
+)
+
+// parse parses flags and exits with usage if there are less than n
+// arguments.
+func parse(fs *flag.FlagSet, args []string, n int) {
+	fs.Parse(args)
+	if fs.NArg() < n {
+		fs.Usage()
+	}
+}
+
+// open opens existing volume at path.
+func open(path string) *volume.Volume {
+	if _, err := os.Stat(path); err != nil {
+		fatal("volume:", err)
+	}
+	v, err := volume.Open(path, nil)
+	if err != nil {
+		fatal("volume:", err)
+	}
+	return v
+}
+
+func closeVolume(v *volume.Volume) {
+	if err := v.Close(); err != nil {
+		fatal("volume:", err)
+	}
+}
+
+// abort closes v, so records written before failure are kept, and exits.
+func abort(v *volume.Volume, args ...interface{}) {
+	v.Close()
+	fatal(args...)
+}
+
+func parseFileID(s string) master.FileID {
+	fid, err := master.ParseFileID(s)
+	if err != nil {
+		fatal(s+":", err)
+	}
+	return fid
+}
+
+func create(fs *flag.FlagSet, args []string) {
+	size := fs.Int64("size", storage.DefaultBlobSize, "initial capacity of blob in bytes")
+	parse(fs, args, 1)
+	path := fs.Arg(0)
+	if _, err := os.Stat(path); err == nil {
+		fatal("volume", path, "exists")
+	}
+	v, err := volume.Open(path, &volume.Config{
+		Blob: &storage.BlobConfig{InitialSize: *size},
+	})
+	if err != nil {
+		fatal("volume:", err)
+	}
+	closeVolume(v)
+}
+
+func put(fs *flag.FlagSet, args []string) {
+	vid := fs.Uint("vid", 0, "volume id of printed file ids")
+	parse(fs, args, 1)
+	v := open(fs.Arg(0))
+	defer closeVolume(v)
+	names := fs.Args()[1:]
+	if len(names) == 0 {
+		names = []string{"-"}
+	}
+	for _, name := range names {
+		var (
+			data []byte
+			err  error
+		)
+		if name == "-" {
+			data, err = ioutil.ReadAll(os.Stdin)
+		} else {
+			data, err = ioutil.ReadFile(name)
+		}
+		if err != nil {
+			abort(v, err)
+		}
+		id, cookie, err := v.Put(data)
+		if err != nil {
+			abort(v, name+":", err)
+		}
+		fid := master.FileID{Volume: uint32(*vid), Key: id, Cookie: cookie}
+		fmt.Printf("%s\t%s\n", fid, name)
+	}
+}
+
+func get(fs *flag.FlagSet, args []string) {
+	output := fs.String("o", "", "output file (default is stdout)")
+	parse(fs, args, 2)
+	v := open(fs.Arg(0))
+	defer closeVolume(v)
+	fid := parseFileID(fs.Arg(1))
+	_, r, err := v.Reader(fid.Key, fid.Cookie)
+	if err != nil {
+		fatal(fid, err)
+	}
+	w := os.Stdout
+	if *output != "" {
+		if w, err = os.Create(*output); err != nil {
+			fatal(err)
+		}
+	}
+	if _, err = io.Copy(w, r); err != nil {
+		fatal(err)
+	}
+	if err = w.Close(); err != nil {
+		fatal(err)
+	}
+}
+
+func remove(fs *flag.FlagSet, args []string) {
+	parse(fs, args, 2)
+	v := open(fs.Arg(0))
+	defer closeVolume(v)
+	for _, s := range fs.Args()[1:] {
+		fid, err := master.ParseFileID(s)
+		if err != nil {
+			abort(v, s+":", err)
+		}
+		if err = v.Delete(fid.Key, fid.Cookie); err != nil {
+			abort(v, fid, err)
+		}
+	}
+}
+
+func ls(fs *flag.FlagSet, args []string) {
+	vid := fs.Uint("vid", 0, "volume id of printed file ids")
+	parse(fs, args, 1)
+	v := open(fs.Arg(0))
+	defer closeVolume(v)
+	w := bufio.NewWriter(os.Stdout)
+	err := v.Walk(func(h storage.RecordHeader, l volume.Location) error {
+		fid := master.FileID{Volume: uint32(*vid), Key: h.ID, Cookie: h.Cookie}
+		_, err := fmt.Fprintf(w, "%s\t%d\t%s\n", fid, h.Size, h.Time().UTC().Format(time.RFC3339))
+		return err
+	})
+	if err != nil {
+		fatal(err)
+	}
+	if err = w.Flush(); err != nil {
+		fatal(err)
+	}
+}
+
+func export(fs *flag.FlagSet, args []string) {
+	output := fs.String("o", "", "output file (default is stdout)")
+	format := fs.String("format", "records", "format of output: records or tar")
+	parse(fs, args, 1)
+	v := open(fs.Arg(0))
+	defer closeVolume(v)
+	w := os.Stdout
+	if *output != "" {
+		var err error
+		if w, err = os.Create(*output); err != nil {
+			fatal(err)
+		}
+	}
+	var (
+		n   int
+		err error
+	)
+	switch *format {
+	case "records":
+		n, err = v.Export(w)
+	case "tar":
+		n, err = v.ExportTar(w)
+	default:
+		fatal("unknown format", *format)
+	}
+	if err != nil {
+		fatal(err)
+	}
+	if err = w.Close(); err != nil {
+		fatal(err)
+	}
+	fmt.Fprintln(os.Stderr, "exported", n, "records")
+}
+
+func importRecords(fs *flag.FlagSet, args []string) {
+	format := fs.String("format", "records", "format of input: records or tar")
+	workers := fs.Int("p", 0, "concurrent writes of tar and directory imports (default is count of CPUs)")
+	vid := fs.Uint("vid", 0, "volume id of printed file ids")
+	maxSize := fs.Int64("max-size", volume.DefaultMaxImportSize, "max size of imported file of tar or directory")
+	parse(fs, args, 1)
+	if *format != "records" && *format != "tar" {
+		fatal("unknown format", *format)
+	}
+	v := open(fs.Arg(0))
+	defer closeVolume(v)
+	var mux sync.Mutex
+	i := &volume.Importer{Volume: v, Workers: *workers, MaxSize: *maxSize, Imported: func(name string, id int64, cookie uint32) {
+		fid := master.FileID{Volume: uint32(*vid), Key: id, Cookie: cookie}
+		mux.Lock()
+		fmt.Printf("%s\t%s\n", fid, name)
+		mux.Unlock()
+	}}
+	names := fs.Args()[1:]
+	if len(names) == 0 {
+		names = []string{"-"}
+	}
+	total := 0
+	for _, name := range names {
+		var (
+			n   int
+			err error
+		)
+		if info, statErr := os.Stat(name); statErr == nil && info.IsDir() {
+			n, err = i.Dir(name)
+		} else {
+			r := os.Stdin
+			if name != "-" {
+				if r, err = os.Open(name); err != nil {
+					abort(v, err)
+				}
+			}
+			if *format == "tar" {
+				n, err = i.Tar(r)
+			} else {
+				n, err = v.Import(r)
+			}
+			r.Close()
+		}
+		total += n
+		if err != nil {
+			abort(v, name+":", err)
+		}
+	}
+	fmt.Fprintln(os.Stderr, "imported", total, "records")
+}
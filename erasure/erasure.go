@@ -0,0 +1,516 @@
+// Package erasure implements Reed-Solomon erasure coding of sealed blobs.
+//
+// Blob is split into blocks of BlockSize, and block i is stored in data
+// shard i % Data, so every row of Data blocks forms stripe, that is
+// protected by Parity blocks of parity shards. Every shard is separate
+// file, that starts with header, so shards can be placed in different
+// directories. Every block in shard is followed by its CRC32, so
+// corrupted blocks are reconstructed like missing ones. Blob can be read
+// while at most Parity shards are lost, and lost shards can be
+// reconstructed.
+package erasure
+
+import (
+	"hash/crc32"
+	"io"
+	"io/ioutil"
+	"math"
+	"os"
+	"path/filepath"
+	"sync"
+
+	"github.com/cydev/stok"
+	"github.com/cydev/stok/binary"
+	"github.com/cydev/stok/storage"
+	"github.com/cydev/stok/volume"
+	"github.com/klauspost/reedsolomon"
+	"github.com/pkg/errors"
+)
+
+const (
+	// ErrTooFewShards means that count of available shards is less
+	// than count of data shards.
+	ErrTooFewShards stok.Error = "Too few shards"
+	// ErrBadConfig means that count of shards or block size is invalid.
+	ErrBadConfig stok.Error = "Bad config"
+	// ErrBadBlock means that block checksum mismatch.
+	ErrBadBlock stok.Error = "Bad block checksum"
+)
+
+const (
+	// DefaultData is default count of data shards.
+	DefaultData = 10
+	// DefaultParity is default count of parity shards.
+	DefaultParity = 4
+	// DefaultBlockSize is default size of block.
+	DefaultBlockSize = 64 * 1024
+	// MaxShards is max count of data and parity shards.
+	MaxShards = 256
+)
+
+// crcSize is size of checksum, that follows every block in shard.
+const crcSize = 4
+
+// Config is configuration of encoding.
+type Config struct {
+	Data      int // count of data shards, DefaultData if zero
+	Parity    int // count of parity shards, DefaultParity if zero
+	BlockSize int // DefaultBlockSize if zero
+}
+
+func (c *Config) header() (header, error) {
+	h := header{
+		Version:   FormatVersion,
+		Data:      DefaultData,
+		Parity:    DefaultParity,
+		BlockSize: DefaultBlockSize,
+	}
+	if c == nil {
+		return h, nil
+	}
+	data, parity, bs := int(h.Data), int(h.Parity), int(h.BlockSize)
+	if c.Data != 0 {
+		data = c.Data
+	}
+	if c.Parity != 0 {
+		parity = c.Parity
+	}
+	if c.BlockSize != 0 {
+		bs = c.BlockSize
+	}
+	// validating before conversion to header fields, that can overflow
+	if data < 1 || parity < 1 || data+parity > MaxShards || bs < 1 || int64(bs) > math.MaxUint32 {
+		return h, ErrBadConfig
+	}
+	h.Data, h.Parity, h.BlockSize = uint8(data), uint8(parity), uint32(bs)
+	return h, nil
+}
+
+func (h header) shards() int {
+	return int(h.Data) + int(h.Parity)
+}
+
+// rows returns count of stripes.
+func (h header) rows() int64 {
+	stripe := int64(h.BlockSize) * int64(h.Data)
+	return (h.Size + stripe - 1) / stripe
+}
+
+// offset returns offset of block of row in shard.
+func (h header) offset(row int64) int64 {
+	return headerSize + row*(int64(h.BlockSize)+crcSize)
+}
+
+// writeBlock writes block of row with its checksum to f, using buf as
+// buffer, and returns buf.
+func (h header) writeBlock(f *os.File, row int64, block, buf []byte) ([]byte, error) {
+	buf = append(buf[:0], block...)
+	buf = binary.AppendUint32(buf, crc32.ChecksumIEEE(block))
+	_, err := f.WriteAt(buf, h.offset(row))
+	return buf, err
+}
+
+// readBlock reads block of row with its checksum from f to buf, that
+// has length of block and checksum, and verifies it.
+func (h header) readBlock(f *os.File, row int64, buf []byte) error {
+	if _, err := f.ReadAt(buf, h.offset(row)); err != nil {
+		return err
+	}
+	var crc uint32
+	if _, err := binary.DecodeUint32(buf[h.BlockSize:], &crc); err != nil {
+		return err
+	}
+	if crc32.ChecksumIEEE(buf[:h.BlockSize]) != crc {
+		return ErrBadBlock
+	}
+	return nil
+}
+
+// Encode syncs and encodes blob to shards at paths, where first paths
+// are data shards and last are parity shards.
+func Encode(b *storage.Blob, paths []string, cfg *Config) error {
+	h, err := cfg.header()
+	if err != nil || len(paths) != h.shards() {
+		return ErrBadConfig
+	}
+	enc, err := reedsolomon.New(int(h.Data), int(h.Parity))
+	if err != nil {
+		return errors.Wrap(err, "failed to create encoder")
+	}
+	// persisting size of blob in its header, that is encoded too
+	if err = b.Sync(); err != nil {
+		return errors.Wrap(err, "failed to sync blob")
+	}
+	h.Size = b.Size
+	files := make([]*os.File, len(paths))
+	defer func() {
+		for _, f := range files {
+			if f != nil {
+				f.Close()
+			}
+		}
+	}()
+	for i, path := range paths {
+		if files[i], err = os.Create(path); err != nil {
+			return errors.Wrap(err, "failed to create shard")
+		}
+		h.Index = uint8(i)
+		if _, err = files[i].Write(h.Append(nil)); err != nil {
+			return errors.Wrap(err, "failed to write header")
+		}
+	}
+	var (
+		bs     = int64(h.BlockSize)
+		stripe = make([]byte, bs*int64(h.shards()))
+		shards = split(stripe, h)
+		buf    []byte
+	)
+	for row := int64(0); row < h.rows(); row++ {
+		for i := range stripe {
+			stripe[i] = 0
+		}
+		data := stripe[:bs*int64(h.Data)]
+		n, err := b.ReadAt(data, row*int64(len(data)))
+		if err == io.EOF && n > 0 {
+			err = nil
+		}
+		if err != nil {
+			return errors.Wrap(err, "failed to read blob")
+		}
+		if err = enc.Encode(shards); err != nil {
+			return errors.Wrap(err, "failed to encode")
+		}
+		for i, f := range files {
+			if buf, err = h.writeBlock(f, row, shards[i], buf); err != nil {
+				return errors.Wrap(err, "failed to write shard")
+			}
+		}
+	}
+	for i, f := range files {
+		if err = f.Sync(); err != nil {
+			return errors.Wrap(err, "failed to sync shard")
+		}
+		err = f.Close()
+		files[i] = nil
+		if err != nil {
+			return errors.Wrap(err, "failed to close shard")
+		}
+	}
+	return nil
+}
+
+// split splits stripe to blocks of shards.
+func split(stripe []byte, h header) [][]byte {
+	bs := int(h.BlockSize)
+	shards := make([][]byte, h.shards())
+	for i := range shards {
+		shards[i] = stripe[i*bs : (i+1)*bs : (i+1)*bs]
+	}
+	return shards
+}
+
+// Shards is erasure-coded blob. It implements storage.BlobBackend,
+// so it can be opened with storage.OpenReadOnlyBlob. It is goroutine-safe.
+type Shards struct {
+	h     header
+	enc   reedsolomon.Encoder
+	paths []string
+
+	blocks sync.Pool // buffers for block and checksum
+
+	mux   sync.RWMutex
+	files []*os.File // nil for missing shards
+	row   int64      // row of last reconstructed stripe, -1 if none
+	cache [][]byte   // last reconstructed stripe
+}
+
+// Open opens shards at paths, that should be in the same order as in
+// Encode. Missing or corrupted shards are skipped and reconstructed on read.
+func Open(paths []string) (*Shards, error) {
+	s := &Shards{
+		paths: paths,
+		files: make([]*os.File, len(paths)),
+		row:   -1,
+	}
+	var (
+		found bool
+		buf   = make([]byte, headerSize)
+	)
+	for i, path := range paths {
+		f, err := os.OpenFile(path, os.O_RDWR, 0)
+		if os.IsNotExist(err) {
+			continue
+		}
+		if err != nil {
+			s.Close()
+			return nil, errors.Wrap(err, "failed to open shard")
+		}
+		var h header
+		if _, err = f.ReadAt(buf, 0); err == nil {
+			_, err = h.Decode(buf)
+		}
+		if err != nil || int(h.Index) != i || (found && !s.h.compatible(h)) {
+			// corrupted shard is same as missing one
+			f.Close()
+			continue
+		}
+		s.h, s.files[i], found = h, f, true
+	}
+	available := 0
+	for _, f := range s.files {
+		if f != nil {
+			available++
+		}
+	}
+	if !found || available < int(s.h.Data) || s.h.shards() != len(paths) {
+		s.Close()
+		return nil, ErrTooFewShards
+	}
+	enc, err := reedsolomon.New(int(s.h.Data), int(s.h.Parity))
+	if err != nil {
+		s.Close()
+		return nil, errors.Wrap(err, "failed to create encoder")
+	}
+	s.enc = enc
+	s.blocks.New = func() interface{} {
+		return make([]byte, s.h.BlockSize+crcSize)
+	}
+	return s, nil
+}
+
+// compatible reports whether shards with headers h and o are
+// parts of the same encoded blob.
+func (h header) compatible(o header) bool {
+	h.Index = o.Index
+	return h == o
+}
+
+// Size returns size of encoded blob.
+func (s *Shards) Size() int64 {
+	return s.h.Size
+}
+
+// Missing returns indexes of missing shards.
+func (s *Shards) Missing() []int {
+	s.mux.RLock()
+	defer s.mux.RUnlock()
+	var missing []int
+	for i, f := range s.files {
+		if f == nil {
+			missing = append(missing, i)
+		}
+	}
+	return missing
+}
+
+// ReadAt implements io.ReaderAt, reading blob from data shards.
+// Stripes with missing data blocks are reconstructed from other shards.
+func (s *Shards) ReadAt(p []byte, off int64) (int, error) {
+	var (
+		bs   = int64(s.h.BlockSize)
+		data = int64(s.h.Data)
+		n    int
+	)
+	for n < len(p) {
+		if off >= s.h.Size {
+			return n, io.EOF
+		}
+		var (
+			block = off / bs
+			row   = block / data
+			in    = off % bs
+			size  = bs - in
+		)
+		if rest := int64(len(p) - n); rest < size {
+			size = rest
+		}
+		if rest := s.h.Size - off; rest < size {
+			size = rest
+		}
+		if err := s.read(p[n:n+int(size)], row, int(block%data), in); err != nil {
+			return n, err
+		}
+		n += int(size)
+		off += size
+	}
+	return n, nil
+}
+
+// read reads part of block of shard i in row, starting at in.
+func (s *Shards) read(p []byte, row int64, i int, in int64) error {
+	s.mux.RLock()
+	f := s.files[i]
+	if f == nil && s.row == row {
+		copy(p, s.cache[i][in:])
+		s.mux.RUnlock()
+		return nil
+	}
+	s.mux.RUnlock()
+	if f != nil {
+		// whole block is read to verify checksum
+		buf := s.blocks.Get().([]byte)
+		err := s.h.readBlock(f, row, buf)
+		if err == nil {
+			copy(p, buf[in:])
+		}
+		s.blocks.Put(buf)
+		if err == nil {
+			return nil
+		}
+	}
+	s.mux.Lock()
+	defer s.mux.Unlock()
+	if s.row != row {
+		shards, err := s.stripe(row, false)
+		if err != nil {
+			return err
+		}
+		s.row, s.cache = row, shards
+	}
+	copy(p, s.cache[i][in:])
+	return nil
+}
+
+// stripe reads row from available shards and reconstructs missing and
+// corrupted data blocks, or all of them if parity is true. Should be
+// called with s.mux held.
+func (s *Shards) stripe(row int64, parity bool) ([][]byte, error) {
+	var (
+		bs        = int64(s.h.BlockSize)
+		shards    = make([][]byte, len(s.files))
+		available = 0
+	)
+	for i, f := range s.files {
+		if f == nil || available == int(s.h.Data) {
+			continue
+		}
+		buf := make([]byte, bs+crcSize)
+		if err := s.h.readBlock(f, row, buf); err != nil {
+			continue
+		}
+		shards[i] = buf[:bs]
+		available++
+	}
+	var err error
+	if parity {
+		err = s.enc.Reconstruct(shards)
+	} else {
+		err = s.enc.ReconstructData(shards)
+	}
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to reconstruct")
+	}
+	return shards, nil
+}
+
+// Reconstruct recreates missing shards from available ones. Shards are
+// written to temporary files, that are renamed after sync, so partially
+// written shards are never opened.
+func (s *Shards) Reconstruct() (err error) {
+	s.mux.Lock()
+	defer s.mux.Unlock()
+	created := make(map[int]*os.File)
+	defer func() {
+		if err == nil {
+			return
+		}
+		for _, f := range created {
+			f.Close()
+			os.Remove(f.Name())
+		}
+	}()
+	for i, f := range s.files {
+		if f != nil {
+			continue
+		}
+		path := s.paths[i]
+		if f, err = ioutil.TempFile(filepath.Dir(path), filepath.Base(path)+".tmp"); err != nil {
+			return errors.Wrap(err, "failed to create shard")
+		}
+		created[i] = f
+		h := s.h
+		h.Index = uint8(i)
+		if _, err = f.Write(h.Append(nil)); err != nil {
+			return errors.Wrap(err, "failed to write header")
+		}
+	}
+	var buf []byte
+	for row := int64(0); row < s.h.rows() && len(created) > 0; row++ {
+		shards, err := s.stripe(row, true)
+		if err != nil {
+			return err
+		}
+		for i, f := range created {
+			if buf, err = s.h.writeBlock(f, row, shards[i], buf); err != nil {
+				return errors.Wrap(err, "failed to write shard")
+			}
+		}
+	}
+	for _, f := range created {
+		if err = f.Sync(); err != nil {
+			return errors.Wrap(err, "failed to sync shard")
+		}
+	}
+	for i, f := range created {
+		if err = os.Rename(f.Name(), s.paths[i]); err != nil {
+			return errors.Wrap(err, "failed to rename shard")
+		}
+		s.files[i] = f
+		delete(created, i)
+	}
+	return nil
+}
+
+// WriteAt implements io.WriterAt, returning storage.ErrReadOnly.
+func (s *Shards) WriteAt(p []byte, off int64) (int, error) {
+	return 0, storage.ErrReadOnly
+}
+
+// Truncate returns storage.ErrReadOnly.
+func (s *Shards) Truncate(size int64) error {
+	return storage.ErrReadOnly
+}
+
+// Sync does nothing, because shards are read-only.
+func (s *Shards) Sync() error {
+	return nil
+}
+
+// Close closes shard files.
+func (s *Shards) Close() error {
+	s.mux.Lock()
+	defer s.mux.Unlock()
+	var err error
+	for i, f := range s.files {
+		if f == nil {
+			continue
+		}
+		if closeErr := f.Close(); closeErr != nil && err == nil {
+			err = closeErr
+		}
+		s.files[i] = nil
+	}
+	return err
+}
+
+// OpenVolume opens read-only volume on top of shards at paths with
+// index at indexPath, that is rebuilt if it does not exist.
+func OpenVolume(paths []string, indexPath string) (*volume.Volume, error) {
+	s, err := Open(paths)
+	if err != nil {
+		return nil, err
+	}
+	b, err := storage.OpenReadOnlyBlob(s)
+	if err != nil {
+		s.Close()
+		return nil, errors.Wrap(err, "failed to open blob")
+	}
+	v, err := volume.Load(b, indexPath)
+	if err != nil {
+		s.Close()
+		return nil, err
+	}
+	return v, nil
+}
+
+var _ storage.BlobBackend = &Shards{}
@@ -0,0 +1,171 @@
+package erasure
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/cydev/stok/binary"
+	"github.com/cydev/stok/stokutils"
+	"github.com/cydev/stok/storage"
+	"github.com/cydev/stok/volume"
+	"github.com/pkg/errors"
+)
+
+func TestErasure(t *testing.T) {
+	dir, err := ioutil.TempDir("", "erasure")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	v, err := volume.Open(filepath.Join(dir, "volume"), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var (
+		ids     []int64
+		cookies []uint32
+		records [][]byte
+	)
+	for i := 0; i < 50; i++ {
+		data := bytes.Repeat([]byte(fmt.Sprint(i)), i*7)
+		id, cookie, err := v.Put(data)
+		if err != nil {
+			t.Fatal(err)
+		}
+		ids, cookies, records = append(ids, id), append(cookies, cookie), append(records, data)
+	}
+	for _, bad := range []Config{{Data: 256 + 4}, {Data: 200, Parity: 100}, {Parity: -1}, {BlockSize: -1}} {
+		if err = Encode(v.Blob, nil, &bad); err != ErrBadConfig {
+			t.Errorf("%+v: %v != %v", bad, err, ErrBadConfig)
+		}
+	}
+	cfg := &Config{Data: 4, Parity: 2, BlockSize: 100}
+	var paths []string
+	for i := 0; i < cfg.Data+cfg.Parity; i++ {
+		// shards can be in different directories
+		shardDir := filepath.Join(dir, fmt.Sprint("disk", i))
+		if err = os.Mkdir(shardDir, 0755); err != nil {
+			t.Fatal(err)
+		}
+		paths = append(paths, filepath.Join(shardDir, "volume.shard"))
+	}
+	if err = Encode(v.Blob, paths, cfg); err != nil {
+		t.Fatal(err)
+	}
+	blob := make([]byte, v.Blob.Size)
+	if _, err = v.Blob.ReadAt(blob, 0); err != nil {
+		t.Fatal(err)
+	}
+	stokutils.MustClose(t, v)
+
+	f, err := os.Open(paths[1])
+	if err != nil {
+		t.Fatal(err)
+	}
+	format, err := binary.Identify(f)
+	f.Close()
+	if err != nil || format.Name != "shard" {
+		t.Error("identify", format.Name, err)
+	}
+
+	check := func() {
+		s, err := Open(paths)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer stokutils.MustClose(t, s)
+		if s.Size() != int64(len(blob)) {
+			t.Fatal("size", s.Size(), "!=", len(blob))
+		}
+		got := make([]byte, len(blob)+10)
+		n, err := s.ReadAt(got, 0)
+		if err != io.EOF || n != len(blob) || !bytes.Equal(got[:n], blob) {
+			t.Fatal("read", n, err)
+		}
+		// unaligned reads
+		for _, off := range []int64{1, 99, 100, 399, 401, int64(len(blob)) - 3} {
+			got = make([]byte, 250)
+			n, _ = s.ReadAt(got, off)
+			if !bytes.Equal(got[:n], blob[off:off+int64(n)]) {
+				t.Error("read at", off)
+			}
+		}
+	}
+	check()
+
+	// corrupting block of data shard, that is lost later
+	f, err = os.OpenFile(paths[1], os.O_RDWR, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err = f.WriteAt([]byte("corrupted"), headerSize+10); err != nil {
+		t.Fatal(err)
+	}
+	stokutils.MustClose(t, f)
+	check()
+
+	// losing data shard and corrupting parity shard
+	if err = os.Remove(paths[1]); err != nil {
+		t.Fatal(err)
+	}
+	if err = ioutil.WriteFile(paths[4], []byte("garbage"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	check()
+	if err = os.Rename(filepath.Join(dir, "volume.idx"), filepath.Join(dir, "old.idx")); err != nil {
+		t.Fatal(err)
+	}
+
+	// recovering all shards
+	for _, lost := range [][]int{{1, 2}, {4, 2}, {0, 5}, nil} {
+		s, err := Open(paths)
+		if err == nil {
+			err = s.Reconstruct()
+		}
+		if err != nil {
+			t.Fatal(lost, err)
+		}
+		if missing := s.Missing(); len(missing) != 0 {
+			t.Error("missing", missing)
+		}
+		if tmp, _ := filepath.Glob(filepath.Join(dir, "*", "*.tmp*")); len(tmp) != 0 {
+			t.Error("temporary files", tmp)
+		}
+		stokutils.MustClose(t, s)
+		check()
+		for _, i := range lost {
+			os.Remove(paths[i])
+		}
+	}
+
+	// serving reads from shards with rebuilt index
+	sealed, err := OpenVolume(paths, filepath.Join(dir, "volume.idx"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer stokutils.MustClose(t, sealed)
+	for i, id := range ids {
+		data, err := sealed.Get(id, cookies[i], nil)
+		if err != nil || !bytes.Equal(data, records[i]) {
+			t.Error("get", id, err)
+		}
+	}
+	if _, _, err = sealed.Put([]byte("new")); err == nil {
+		t.Error("sealed volume accepted write")
+	} else if errors.Cause(err) != storage.ErrReadOnly {
+		t.Error(err)
+	}
+
+	for _, i := range []int{0, 1, 2} {
+		os.Remove(paths[i])
+	}
+	if _, err = Open(paths); err != ErrTooFewShards {
+		t.Error(err, "!=", ErrTooFewShards)
+	}
+}
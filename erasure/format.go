@@ -0,0 +1,47 @@
+package erasure
+
+import "github.com/cydev/stok/binary"
+
+// FormatVersion is version of shard format.
+const FormatVersion = 1
+
+//go:generate stok-binarygen -type header
+
+// header is at start of every shard file.
+type header struct {
+	_         [8]byte `binary:"magic=magic"`
+	Version   uint8
+	Data      uint8 // count of data shards
+	Parity    uint8 // count of parity shards
+	Index     uint8 // index of shard, parity shards follow data shards
+	BlockSize uint32
+	Size      int64  // size of encoded blob
+	_         uint32 `binary:"crc"`
+}
+
+// headerSize = magic + version + data + parity + index + block size + size + crc.
+const headerSize = 8 + 1 + 1 + 1 + 1 + 4 + 8 + 4
+
+var magic = [...]byte{
+	0xec,
+	0x5a,
+	0x4d,
+	0xd0,
+	0x13,
+	0x37,
+	0x20,
+	0x16,
+}
+
+func init() {
+	binary.Register(binary.Format{
+		Name:       "shard",
+		Magic:      magic,
+		Version:    FormatVersion,
+		HeaderSize: headerSize,
+		Check: func(buf []byte) error {
+			_, err := new(header).Decode(buf)
+			return err
+		},
+	})
+}
@@ -0,0 +1,59 @@
+// Code generated by "stok-binarygen -type header"; DO NOT EDIT.
+
+package erasure
+
+import (
+	"hash/crc32"
+
+	"github.com/cydev/stok/binary"
+)
+
+// Append encodes header to buf and returns it, implementing binary.Appender.
+func (h header) Append(buf []byte) []byte {
+	start := len(buf)
+	buf = binary.AppendMagic(buf, magic)
+	buf = binary.AppendUint8(buf, h.Version)
+	buf = binary.AppendUint8(buf, h.Data)
+	buf = binary.AppendUint8(buf, h.Parity)
+	buf = binary.AppendUint8(buf, h.Index)
+	buf = binary.AppendUint32(buf, h.BlockSize)
+	buf = binary.AppendInt64(buf, h.Size)
+	buf = binary.AppendUint32(buf, crc32.ChecksumIEEE(buf[start:]))
+	return buf
+}
+
+// Decode decodes header from buf and returns rest of buf, implementing binary.Decoder.
+func (h *header) Decode(buf []byte) ([]byte, error) {
+	var err error
+	start := buf
+	if buf, err = binary.DecodeMagic(buf, magic); err != nil {
+		return buf, err
+	}
+	if buf, err = binary.DecodeUint8(buf, &h.Version); err != nil {
+		return buf, err
+	}
+	if buf, err = binary.DecodeUint8(buf, &h.Data); err != nil {
+		return buf, err
+	}
+	if buf, err = binary.DecodeUint8(buf, &h.Parity); err != nil {
+		return buf, err
+	}
+	if buf, err = binary.DecodeUint8(buf, &h.Index); err != nil {
+		return buf, err
+	}
+	if buf, err = binary.DecodeUint32(buf, &h.BlockSize); err != nil {
+		return buf, err
+	}
+	if buf, err = binary.DecodeInt64(buf, &h.Size); err != nil {
+		return buf, err
+	}
+	var crc uint32
+	n := len(start) - len(buf)
+	if buf, err = binary.DecodeUint32(buf, &crc); err != nil {
+		return buf, err
+	}
+	if crc32.ChecksumIEEE(start[:n]) != crc {
+		return buf, binary.ErrBadCRC
+	}
+	return buf, nil
+}
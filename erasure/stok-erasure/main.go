@@ -0,0 +1,61 @@
+// Command stok-erasure encodes sealed volume blob to Reed-Solomon shards
+// and reconstructs lost shards.
+package main
+
+import (
+	"flag"
+	"log"
+	"strings"
+
+	"github.com/cydev/stok/erasure"
+	"github.com/cydev/stok/storage"
+)
+
+var (
+	blobPath    = flag.String("blob", "", "path to blob to encode")
+	shards      = flag.String("shards", "", "comma-separated paths of data and parity shards, required")
+	data        = flag.Int("data", erasure.DefaultData, "count of data shards")
+	parity      = flag.Int("parity", erasure.DefaultParity, "count of parity shards")
+	blockSize   = flag.Int("block", erasure.DefaultBlockSize, "size of block")
+	reconstruct = flag.Bool("reconstruct", false, "reconstruct missing shards instead of encoding")
+)
+
+func main() {
+	flag.Parse()
+	if *shards == "" {
+		log.Fatalln("no shards provided")
+	}
+	paths := strings.Split(*shards, ",")
+	if *reconstruct {
+		s, err := erasure.Open(paths)
+		if err != nil {
+			log.Fatalln("failed to open shards:", err)
+		}
+		missing := s.Missing()
+		if err = s.Reconstruct(); err != nil {
+			log.Fatalln("failed to reconstruct:", err)
+		}
+		if err = s.Close(); err != nil {
+			log.Fatalln("failed to close shards:", err)
+		}
+		log.Println("reconstructed shards", missing)
+		return
+	}
+	if *blobPath == "" {
+		log.Fatalln("no blob provided")
+	}
+	b, err := storage.OpenBlob(*blobPath, nil)
+	if err != nil {
+		log.Fatalln("failed to open blob:", err)
+	}
+	defer b.Close()
+	cfg := &erasure.Config{
+		Data:      *data,
+		Parity:    *parity,
+		BlockSize: *blockSize,
+	}
+	if err = erasure.Encode(b, paths, cfg); err != nil {
+		log.Fatalln("failed to encode:", err)
+	}
+	log.Println("encoded", *blobPath, "to", len(paths), "shards")
+}
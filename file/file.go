@@ -6,7 +6,9 @@ import (
 	"os"
 	"sync"
 	"sync/atomic"
+	"time"
 
+	"github.com/cydev/stok"
 	"github.com/cydev/stok/binary"
 	"github.com/pkg/errors"
 )
@@ -31,6 +33,7 @@ func New(f *os.File) (*File, error) {
 	ff.f = f
 	ff.capacity = cap_
 	ff.size = ff.h.Size
+	ff.opened()
 	return ff, nil
 }
 
@@ -44,27 +47,34 @@ func create(f *os.File) (*File, error) {
 		size:     0,
 		capacity: 0,
 	}
+	ff.opened()
 	if err := ff.alloc(initialCap); err != nil {
 		return nil, err
 	}
 	return ff, nil
 }
 
+//go:generate stok-binarygen -type header
+
 type header struct {
+	_    [8]byte `binary:"magic=magic"`
 	Size int64
 }
 
-func (h header) Append(buf []byte) []byte {
-	buf = binary.AppendMagic(buf, magic)
-	return binary.AppendInt64(buf, h.Size)
-}
+// FormatVersion is version of file format.
+const FormatVersion = 1
 
-func (h *header) Decode(buf []byte) ([]byte, error) {
-	var err error
-	if buf, err = binary.DecodeMagic(buf, magic); err != nil {
-		return buf, err
-	}
-	return binary.DecodeInt64(buf, &h.Size), nil
+func init() {
+	binary.Register(binary.Format{
+		Name:       "file",
+		Magic:      magic,
+		Version:    FormatVersion,
+		HeaderSize: headerSize,
+		Check: func(buf []byte) error {
+			_, err := new(header).Decode(buf)
+			return err
+		},
+	})
 }
 
 var magic = [...]byte{
@@ -94,12 +104,16 @@ type File struct {
 	size     int64
 	h        header
 	buf      []byte // buffer for header write
+	observer stok.Observer
+	open     int32 // file is reported to observer as open
 }
 
 type Options struct {
 	Backend  Backend
 	Capacity int64
 	Size     int64
+	// Observer receives events of file operations, Metrics if nil.
+	Observer stok.Observer
 }
 
 func NewFile(o Options) *File {
@@ -107,11 +121,35 @@ func NewFile(o Options) *File {
 		capacity: o.Capacity,
 		f:        o.Backend,
 		size:     o.Size,
+		observer: o.Observer,
 	}
 	f.buf = f.h.Append(f.buf)
+	f.opened()
 	return f
 }
 
+func (f *File) observe() stok.Observer {
+	if f.observer == nil {
+		return Metrics
+	}
+	return f.observer
+}
+
+// opened reports f to observer as open, so it is reported as closed
+// on Close.
+func (f *File) opened() {
+	atomic.StoreInt32(&f.open, 1)
+	f.observe().OnOpen(atomic.LoadInt64(&f.size), atomic.LoadInt64(&f.capacity))
+}
+
+// failed reports err of op to observer if it is not nil and returns it.
+func (f *File) failed(op stok.Op, err error) error {
+	if err != nil {
+		f.observe().OnError(op, err)
+	}
+	return err
+}
+
 // Close implements io.Closer.
 func (f *File) Close() error {
 	if f == nil {
@@ -120,6 +158,9 @@ func (f *File) Close() error {
 	if f.f == nil {
 		return errors.New("backend is nil")
 	}
+	if atomic.CompareAndSwapInt32(&f.open, 1, 0) {
+		f.observe().OnClose(atomic.LoadInt64(&f.size), atomic.LoadInt64(&f.capacity))
+	}
 	return f.f.Close()
 }
 
@@ -149,11 +190,17 @@ func (f *File) Size() int64 {
 
 // ReadAt implements io.ReaderAt.
 func (f *File) ReadAt(b []byte, off int64) (int, error) {
-	// checking that len(b) + off is < f.size
-	if atomic.LoadInt64(&f.size)-(f.off(off)+int64(len(b))) < 0 {
+	// checking that len(b) + off is <= f.size
+	if atomic.LoadInt64(&f.size) < off+int64(len(b)) {
 		return 0, io.ErrUnexpectedEOF
 	}
-	return f.f.ReadAt(b, f.off(off))
+	start := time.Now()
+	n, err := f.f.ReadAt(b, f.off(off))
+	if err != nil && err != io.EOF {
+		return n, f.failed(stok.OpRead, err)
+	}
+	f.observe().OnRead(n, time.Since(start))
+	return n, err
 }
 
 const (
@@ -201,24 +248,38 @@ func (f *File) alloc(size int64) error {
 	if !atomic.CompareAndSwapInt64(&f.capacity, oldCap, newCap) {
 		return f.alloc(size)
 	}
-	return f.f.Truncate(newCap)
+	start := time.Now()
+	if err := f.f.Truncate(newCap); err != nil {
+		atomic.CompareAndSwapInt64(&f.capacity, newCap, oldCap)
+		return f.failed(stok.OpTruncate, err)
+	}
+	f.observe().OnTruncate(oldCap, newCap, time.Since(start))
+	return nil
 }
 
 // Append writes b and returns it offset, implementing Appender.
 func (f *File) Append(b []byte) (int64, error) {
+	start := time.Now()
 	// [f.size ... f.size+len(b)]
 	// soft allocate of len(b) in the end of file
 	size := atomic.AddInt64(&f.size, int64(len(b)))
-	if err := f.alloc(size); err != nil {
+	if err := f.alloc(f.off(size)); err != nil {
+		atomic.AddInt64(&f.size, -int64(len(b)))
 		return 0, err
 	}
 	offset := size - int64(len(b))
-	_, err := f.f.WriteAt(b, offset)
+	_, err := f.f.WriteAt(b, f.off(offset))
 	if err != nil {
 		atomic.AddInt64(&f.size, -int64(len(b)))
-		return 0, err
+		return 0, f.failed(stok.OpWrite, err)
+	}
+	// reported after write, because size is restored on failure
+	f.observe().OnAllocate(offset, int64(len(b)))
+	if err = f.writeHeader(); err != nil {
+		return offset, f.failed(stok.OpWrite, err)
 	}
-	return offset, f.writeHeader()
+	f.observe().OnWrite(len(b), time.Since(start))
+	return offset, nil
 }
 
 // WriteAt implements io.WriterAt.
@@ -1,6 +1,8 @@
 package file
 
 import (
+	"bytes"
+	"io"
 	"math/rand"
 	"sync"
 	"testing"
@@ -48,7 +50,7 @@ func BenchmarkHeader_Append(b *testing.B) {
 func BenchmarkFile_WriteHeader(b *testing.B) {
 	b.ReportAllocs()
 	f := File{
-		h: header{1234},
+		h: header{Size: 1234},
 		f: stokutils.Zeroes,
 	}
 	for i := 0; i < b.N; i++ {
@@ -100,7 +102,7 @@ func TestFile_AppendParallel(t *testing.T) {
 	wg.Wait()
 
 	if f.capacity < int64(sum) {
-		t.Errorf("capacity %s is < %d", f.capacity, sum)
+		t.Errorf("capacity %d is < %d", f.capacity, sum)
 	}
 
 	if f.size != int64(sum) {
@@ -142,6 +144,59 @@ func TestNew(t *testing.T) {
 	}
 }
 
+func TestFile_AppendReadAt(t *testing.T) {
+	f := stokutils.TempFile(t)
+	n := f.Name()
+	defer os.Remove(n)
+	ff, err := New(f)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// second record is larger than initial capacity
+	records := [][]byte{bytes.Repeat([]byte{1}, 100), bytes.Repeat([]byte{2}, 2000)}
+	var offsets []int64
+	for _, r := range records {
+		offset, err := ff.Append(r)
+		if err != nil {
+			t.Fatal(err)
+		}
+		offsets = append(offsets, offset)
+	}
+	if offsets[0] != 0 || offsets[1] != 100 {
+		t.Error("offsets", offsets)
+	}
+	read := func(ff *File) {
+		for i, r := range records {
+			buf := make([]byte, len(r))
+			if _, err := ff.ReadAt(buf, offsets[i]); err != nil {
+				t.Fatal(err)
+			}
+			if !bytes.Equal(buf, r) {
+				t.Errorf("record %d mismatch", i)
+			}
+		}
+		if _, err := ff.ReadAt(make([]byte, 1), ff.Size()); err != io.ErrUnexpectedEOF {
+			t.Error("read after end:", err)
+		}
+	}
+	read(ff)
+	if err = ff.Close(); err != nil {
+		t.Error(err)
+	}
+	// header is not overwritten by data
+	if f, err = os.Open(n); err != nil {
+		t.Fatal(err)
+	}
+	if ff, err = New(f); err != nil {
+		t.Fatal(err)
+	}
+	defer stokutils.MustClose(t, ff)
+	if s := ff.Size(); s != 2100 {
+		t.Error("size", s)
+	}
+	read(ff)
+}
+
 func TestStartAlloc(t *testing.T) {
 	f := &File{
 		f: stokutils.Zeroes,
@@ -0,0 +1,7 @@
+package file
+
+import "github.com/cydev/stok/metrics"
+
+// Metrics is observer of files without Observer, that exposes metrics
+// with stok_file prefix in metrics.Default.
+var Metrics = metrics.NewObserver(metrics.Default, "stok_file", "files")
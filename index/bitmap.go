@@ -0,0 +1,131 @@
+package index
+
+import (
+	"io"
+	"sync"
+
+	"github.com/pkg/errors"
+)
+
+// Bitmap is set of live index entries, where entry k is
+// represented by single bit. Every change is persisted to Backend
+// by rewriting the byte that contains changed bit.
+type Bitmap struct {
+	mux     sync.RWMutex
+	backend Backend
+	bits    []byte
+	count   int64
+	buf     [1]byte // write buffer
+}
+
+// bitmapChunk is size of chunk used while loading bitmap from backend.
+const bitmapChunk = 4096
+
+// NewBitmap loads Bitmap from b. Empty backend is empty bitmap.
+func NewBitmap(b Backend) (*Bitmap, error) {
+	m := &Bitmap{backend: b}
+	chunk := make([]byte, bitmapChunk)
+	for off := int64(0); ; off += bitmapChunk {
+		n, err := b.ReadAt(chunk, off)
+		m.bits = append(m.bits, chunk[:n]...)
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, errors.Wrap(err, "failed to read")
+		}
+	}
+	for _, c := range m.bits {
+		m.count += int64(popCount(c))
+	}
+	return m, nil
+}
+
+func popCount(c byte) (n int) {
+	for ; c != 0; c &= c - 1 {
+		n++
+	}
+	return n
+}
+
+// Get reports whether k is live.
+func (m *Bitmap) Get(k int64) bool {
+	m.mux.RLock()
+	live := m.get(k)
+	m.mux.RUnlock()
+	return live
+}
+
+func (m *Bitmap) get(k int64) bool {
+	if k < 0 || k/8 >= int64(len(m.bits)) {
+		return false
+	}
+	return m.bits[k/8]&(1<<uint(k%8)) != 0
+}
+
+// Set marks k as live.
+func (m *Bitmap) Set(k int64) error {
+	return m.put(k, true)
+}
+
+// Clear marks k as deleted.
+func (m *Bitmap) Clear(k int64) error {
+	return m.put(k, false)
+}
+
+func (m *Bitmap) put(k int64, live bool) error {
+	if k < 0 {
+		return ErrBadKey
+	}
+	m.mux.Lock()
+	defer m.mux.Unlock()
+	if m.get(k) == live {
+		return nil
+	}
+	var (
+		i     = k / 8
+		c     byte
+		delta int64 = 1
+	)
+	if i < int64(len(m.bits)) {
+		c = m.bits[i]
+	}
+	if live {
+		c |= 1 << uint(k%8)
+	} else {
+		c &^= 1 << uint(k%8)
+		delta = -1
+	}
+	// changing memory only after write, so it matches backend
+	m.buf[0] = c
+	if _, err := m.backend.WriteAt(m.buf[:], i); err != nil {
+		return errors.Wrap(err, "failed to write")
+	}
+	for int64(len(m.bits)) <= i {
+		m.bits = append(m.bits, 0)
+	}
+	m.bits[i] = c
+	m.count += delta
+	return nil
+}
+
+// Len returns count of entries, that are covered by bitmap.
+func (m *Bitmap) Len() int64 {
+	m.mux.RLock()
+	n := int64(len(m.bits)) * 8
+	m.mux.RUnlock()
+	return n
+}
+
+// Count returns count of live entries.
+func (m *Bitmap) Count() int64 {
+	m.mux.RLock()
+	n := m.count
+	m.mux.RUnlock()
+	return n
+}
+
+// Close implements io.Closer.
+func (m *Bitmap) Close() error {
+	return m.backend.Close()
+}
@@ -0,0 +1,70 @@
+package index
+
+import (
+	"testing"
+
+	"github.com/cydev/stok/stokutils"
+)
+
+func TestBitmap(t *testing.T) {
+	f := stokutils.TempFile(t)
+	defer stokutils.ClearTempFile(f, t)
+	m, err := NewBitmap(f)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, k := range []int64{0, 7, 8, 100} {
+		if err := m.Set(k); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := m.Set(7); err != nil {
+		t.Fatal(err)
+	}
+	if err := m.Clear(8); err != nil {
+		t.Fatal(err)
+	}
+	if err := m.Set(-1); err != ErrBadKey {
+		t.Error(err, "!=", ErrBadKey)
+	}
+	if m.Count() != 3 {
+		t.Error(m.Count(), "!=", 3)
+	}
+	loaded, err := NewBitmap(f)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for k := int64(0); k < 128; k++ {
+		expected := k == 0 || k == 7 || k == 100
+		if loaded.Get(k) != expected {
+			t.Error(k, "should be", expected)
+		}
+	}
+	if loaded.Count() != 3 {
+		t.Error(loaded.Count(), "!=", 3)
+	}
+}
+
+func TestBitmap_FailedWrite(t *testing.T) {
+	f := stokutils.TempFile(t)
+	defer stokutils.ClearTempFile(f, t)
+	backend := &failingBackend{Backend: f}
+	m, err := NewBitmap(backend)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = m.Set(3); err != nil {
+		t.Fatal(err)
+	}
+	backend.fail = true
+	if err = m.Set(20); err == nil {
+		t.Error("set with failed write")
+	}
+	if err = m.Clear(3); err == nil {
+		t.Error("cleared with failed write")
+	}
+	// memory is not changed
+	if m.Get(20) || !m.Get(3) || m.Count() != 1 || m.Len() != 8 {
+		t.Error("bitmap is changed by failed write")
+	}
+}
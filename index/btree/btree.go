@@ -0,0 +1,354 @@
+// Package btree implements persistent B+tree index for byte string keys
+// on top of storage.BlobBackend.
+//
+// Tree is stored as sequence of fixed-size pages, where page 0 is meta page
+// with root page id and count of pages, and other pages are nodes.
+// Leaf nodes contain (key, value) pairs in key order and are linked
+// to their right siblings, so ordered ranges are read sequentially.
+//
+// Deletes do not rebalance tree and freed space of pages is not reused
+// until next write to the same page.
+//
+// Tree is not crash-safe. Pages are overwritten in place without
+// copy-on-write or log, and writes are not synced until Sync, so crash
+// during Set or Delete, especially one that splits nodes, can lose keys
+// or leave pointers to partially written pages. Tree should be rebuilt
+// or restored from copy after crash.
+package btree
+
+import (
+	"bytes"
+	"encoding/binary"
+	"hash/crc32"
+	"io"
+	"sort"
+	"sync"
+
+	"github.com/cydev/stok"
+	"github.com/cydev/stok/storage"
+	"github.com/pkg/errors"
+)
+
+const (
+	// PageSize is size of single tree page.
+	PageSize = 4096
+	// MaxKeySize is max length of key.
+	MaxKeySize = 512
+	// MaxValueSize is max length of value.
+	MaxValueSize = 512
+)
+
+const (
+	// ErrNotFound means that key is not found in tree.
+	ErrNotFound stok.Error = "Key not found"
+	// ErrKeySize means that key is empty or larger than MaxKeySize.
+	ErrKeySize stok.Error = "Bad key size"
+	// ErrValueSize means that value is larger than MaxValueSize.
+	ErrValueSize stok.Error = "Bad value size"
+	// ErrBadMeta means that meta page is corrupted or in wrong format.
+	ErrBadMeta stok.Error = "Bad meta page"
+	// ErrBadPage means that node page can't be decoded.
+	ErrBadPage stok.Error = "Bad page"
+)
+
+// Magic are magic bytes at start of meta page.
+var Magic = [...]byte{
+	0xbb,
+	0x7e,
+	0xe0,
+	0x1d,
+	0x13,
+	0x37,
+	0x20,
+	0x16,
+}
+
+// metaSize = magic + root + pages + crc.
+const metaSize = 8 + 8 + 8 + 4
+
+// Walker is function that is used as callback while iterating over tree.
+// Key and value are valid only until walker returns.
+type Walker func(k, v []byte) error
+
+// Tree is B+tree on top of storage.BlobBackend. It is goroutine-safe.
+type Tree struct {
+	mux     sync.RWMutex
+	backend storage.BlobBackend
+	root    uint64
+	pages   uint64
+	page    [PageSize]byte // buffer for page write, guarded by mux
+}
+
+// Open loads tree from backend, initializing empty backend.
+func Open(backend storage.BlobBackend) (*Tree, error) {
+	t := &Tree{backend: backend}
+	buf := make([]byte, metaSize)
+	n, err := backend.ReadAt(buf, 0)
+	if n == 0 && err == io.EOF {
+		return t, t.init()
+	}
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to read meta")
+	}
+	if t.root, t.pages, err = readMeta(buf); err != nil {
+		return nil, err
+	}
+	return t, nil
+}
+
+// readMeta decodes meta page and returns root page id and count of pages.
+func readMeta(buf []byte) (root, pages uint64, err error) {
+	if len(buf) < metaSize || !bytes.HasPrefix(buf, Magic[:]) {
+		return 0, 0, ErrBadMeta
+	}
+	if crc32.ChecksumIEEE(buf[:metaSize-4]) != binary.BigEndian.Uint32(buf[metaSize-4:]) {
+		return 0, 0, ErrBadMeta
+	}
+	root = binary.BigEndian.Uint64(buf[8:])
+	pages = binary.BigEndian.Uint64(buf[16:])
+	if root == 0 || root >= pages {
+		return 0, 0, ErrBadMeta
+	}
+	return root, pages, nil
+}
+
+// init writes empty root leaf and meta page.
+func (t *Tree) init() error {
+	t.pages = 1
+	root := &node{id: t.alloc(), leaf: true}
+	t.root = root.id
+	if err := t.write(root); err != nil {
+		return err
+	}
+	return t.writeMeta()
+}
+
+func (t *Tree) writeMeta() error {
+	buf := t.page[:metaSize]
+	copy(buf, Magic[:])
+	binary.BigEndian.PutUint64(buf[8:], t.root)
+	binary.BigEndian.PutUint64(buf[16:], t.pages)
+	binary.BigEndian.PutUint32(buf[24:], crc32.ChecksumIEEE(buf[:metaSize-4]))
+	_, err := t.backend.WriteAt(buf, 0)
+	return errors.Wrap(err, "failed to write meta")
+}
+
+// alloc returns id of new page.
+func (t *Tree) alloc() uint64 {
+	id := t.pages
+	t.pages++
+	return id
+}
+
+func (t *Tree) read(id uint64) (*node, error) {
+	buf := make([]byte, PageSize)
+	if _, err := t.backend.ReadAt(buf, int64(id)*PageSize); err != nil {
+		return nil, errors.Wrap(err, "failed to read page")
+	}
+	n := &node{id: id}
+	return n, n.decode(buf)
+}
+
+func (t *Tree) write(n *node) error {
+	buf := n.encode(t.page[:0])
+	for i := len(buf); i < PageSize; i++ {
+		buf = append(buf, 0)
+	}
+	_, err := t.backend.WriteAt(buf, int64(n.id)*PageSize)
+	return errors.Wrap(err, "failed to write page")
+}
+
+func checkKey(key []byte) error {
+	if len(key) == 0 || len(key) > MaxKeySize {
+		return ErrKeySize
+	}
+	return nil
+}
+
+// leaf returns leaf node that can contain key.
+func (t *Tree) leaf(key []byte) (*node, error) {
+	n, err := t.read(t.root)
+	for err == nil && !n.leaf {
+		n, err = t.read(n.children[n.child(key)])
+	}
+	return n, err
+}
+
+// Get appends value of key to buf and returns it.
+func (t *Tree) Get(key, buf []byte) ([]byte, error) {
+	if err := checkKey(key); err != nil {
+		return buf, err
+	}
+	t.mux.RLock()
+	defer t.mux.RUnlock()
+	n, err := t.leaf(key)
+	if err != nil {
+		return buf, err
+	}
+	i, ok := n.search(key)
+	if !ok {
+		return buf, ErrNotFound
+	}
+	return append(buf, n.vals[i]...), nil
+}
+
+// Set sets value of key.
+func (t *Tree) Set(key, value []byte) error {
+	if err := checkKey(key); err != nil {
+		return err
+	}
+	if len(value) > MaxValueSize {
+		return ErrValueSize
+	}
+	t.mux.Lock()
+	defer t.mux.Unlock()
+	pages := t.pages
+	sep, right, err := t.insert(t.root, key, value)
+	if err != nil {
+		return err
+	}
+	if right != 0 {
+		root := &node{
+			id:       t.alloc(),
+			keys:     [][]byte{sep},
+			children: []uint64{t.root, right},
+		}
+		if err = t.write(root); err != nil {
+			return err
+		}
+		t.root = root.id
+	}
+	if t.pages == pages {
+		// root is changed only by split, that allocates pages
+		return nil
+	}
+	return t.writeMeta()
+}
+
+// insert inserts key to subtree with root id and returns separator key
+// and id of right node if root of subtree is split.
+func (t *Tree) insert(id uint64, key, value []byte) ([]byte, uint64, error) {
+	n, err := t.read(id)
+	if err != nil {
+		return nil, 0, err
+	}
+	if n.leaf {
+		i, ok := n.search(key)
+		if ok {
+			n.vals[i] = value
+		} else {
+			n.keys = insertBytes(n.keys, i, key)
+			n.vals = insertBytes(n.vals, i, value)
+		}
+	} else {
+		i := n.child(key)
+		sep, right, err := t.insert(n.children[i], key, value)
+		if err != nil {
+			return nil, 0, err
+		}
+		if right == 0 {
+			return nil, 0, nil
+		}
+		n.keys = insertBytes(n.keys, i, sep)
+		n.children = append(n.children, 0)
+		copy(n.children[i+2:], n.children[i+1:])
+		n.children[i+1] = right
+	}
+	if n.size() <= PageSize {
+		return nil, 0, t.write(n)
+	}
+	sep, r := n.split(t.alloc())
+	if err = t.write(r); err != nil {
+		return nil, 0, err
+	}
+	return sep, r.id, t.write(n)
+}
+
+// Delete removes key from tree.
+func (t *Tree) Delete(key []byte) error {
+	if err := checkKey(key); err != nil {
+		return err
+	}
+	t.mux.Lock()
+	defer t.mux.Unlock()
+	n, err := t.leaf(key)
+	if err != nil {
+		return err
+	}
+	i, ok := n.search(key)
+	if !ok {
+		return ErrNotFound
+	}
+	n.keys = append(n.keys[:i], n.keys[i+1:]...)
+	n.vals = append(n.vals[:i], n.vals[i+1:]...)
+	return t.write(n)
+}
+
+// Range calls w for every key in [start, end) in key order.
+// Nil end means no upper bound.
+func (t *Tree) Range(start, end []byte, w Walker) error {
+	t.mux.RLock()
+	defer t.mux.RUnlock()
+	n, err := t.leaf(start)
+	if err != nil {
+		return err
+	}
+	i := sort.Search(len(n.keys), func(i int) bool {
+		return bytes.Compare(n.keys[i], start) >= 0
+	})
+	for {
+		for ; i < len(n.keys); i++ {
+			if end != nil && bytes.Compare(n.keys[i], end) >= 0 {
+				return nil
+			}
+			if err = w(n.keys[i], n.vals[i]); err != nil {
+				return err
+			}
+		}
+		if n.next == 0 {
+			return nil
+		}
+		if n, err = t.read(n.next); err != nil {
+			return err
+		}
+		i = 0
+	}
+}
+
+// Prefix calls w for every key that starts with prefix in key order.
+func (t *Tree) Prefix(prefix []byte, w Walker) error {
+	return t.Range(prefix, PrefixEnd(prefix), w)
+}
+
+// PrefixEnd returns smallest key that is larger than all keys with
+// given prefix, or nil if there is no such key.
+func PrefixEnd(prefix []byte) []byte {
+	end := append([]byte(nil), prefix...)
+	for i := len(end) - 1; i >= 0; i-- {
+		if end[i] < 0xff {
+			end[i]++
+			return end[:i+1]
+		}
+	}
+	return nil
+}
+
+// Sync commits the current contents of tree to stable storage.
+func (t *Tree) Sync() error {
+	return t.backend.Sync()
+}
+
+// Close syncs and closes underlying backend.
+func (t *Tree) Close() error {
+	if err := t.Sync(); err != nil {
+		return err
+	}
+	return t.backend.Close()
+}
+
+func insertBytes(s [][]byte, i int, b []byte) [][]byte {
+	s = append(s, nil)
+	copy(s[i+1:], s[i:])
+	s[i] = append([]byte(nil), b...)
+	return s
+}
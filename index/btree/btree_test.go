@@ -0,0 +1,212 @@
+package btree
+
+import (
+	"bytes"
+	"fmt"
+	"math/rand"
+	"os"
+	"sort"
+	"testing"
+
+	"github.com/cydev/stok/stokutils"
+)
+
+func openTree(t testing.TB, name string) *Tree {
+	f, err := os.OpenFile(name, os.O_RDWR|os.O_CREATE, 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tree, err := Open(f)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return tree
+}
+
+func TestTree(t *testing.T) {
+	f := stokutils.TempFile(t)
+	name := f.Name()
+	stokutils.ClearTempFile(f, t)
+	defer os.Remove(name)
+
+	var (
+		tree  = openTree(t, name)
+		rnd   = rand.New(rand.NewSource(666))
+		count = 5000
+		added = make(map[string]string)
+	)
+	for i := 0; i < count; i++ {
+		k := fmt.Sprintf("dir%d/file%d", rnd.Intn(10), rnd.Intn(count*4))
+		v := bytes.Repeat([]byte{byte(i)}, rnd.Intn(64))
+		if i%100 == 0 {
+			// some large keys and values to force splits
+			k += string(bytes.Repeat([]byte{'k'}, MaxKeySize-len(k)))
+			v = bytes.Repeat([]byte{'v'}, MaxValueSize)
+		}
+		if err := tree.Set([]byte(k), v); err != nil {
+			t.Fatal(err)
+		}
+		added[k] = string(v)
+	}
+	var deleted int
+	for k := range added {
+		if deleted > count/4 {
+			break
+		}
+		if err := tree.Delete([]byte(k)); err != nil {
+			t.Fatal(err)
+		}
+		delete(added, k)
+		deleted++
+	}
+	if err := tree.Delete([]byte("not exists")); err != ErrNotFound {
+		t.Error(err, "!=", ErrNotFound)
+	}
+	stokutils.MustClose(t, tree)
+
+	tree = openTree(t, name)
+	defer stokutils.MustClose(t, tree)
+	var keys []string
+	for k, v := range added {
+		keys = append(keys, k)
+		got, err := tree.Get([]byte(k), nil)
+		if err != nil {
+			t.Fatal(k, err)
+		}
+		if string(got) != v {
+			t.Error(k, "value mismatch")
+		}
+	}
+	sort.Strings(keys)
+
+	var scanned []string
+	if err := tree.Range(nil, nil, func(k, v []byte) error {
+		scanned = append(scanned, string(k))
+		return nil
+	}); err != nil {
+		t.Fatal(err)
+	}
+	if fmt.Sprint(scanned) != fmt.Sprint(keys) {
+		t.Error("range mismatch")
+	}
+
+	var prefixed []string
+	if err := tree.Prefix([]byte("dir3/"), func(k, v []byte) error {
+		prefixed = append(prefixed, string(k))
+		return nil
+	}); err != nil {
+		t.Fatal(err)
+	}
+	var expected []string
+	for _, k := range keys {
+		if len(k) > 5 && k[:5] == "dir3/" {
+			expected = append(expected, k)
+		}
+	}
+	if len(expected) == 0 || fmt.Sprint(prefixed) != fmt.Sprint(expected) {
+		t.Error("prefix mismatch", len(prefixed), len(expected))
+	}
+}
+
+// metaWrites counts writes of meta page.
+type metaWrites struct {
+	*os.File
+	n int
+}
+
+func (m *metaWrites) WriteAt(p []byte, off int64) (int, error) {
+	if off == 0 {
+		m.n++
+	}
+	return m.File.WriteAt(p, off)
+}
+
+func TestTree_MetaWrites(t *testing.T) {
+	f := stokutils.TempFile(t)
+	defer stokutils.ClearTempFile(f, t)
+	backend := &metaWrites{File: f}
+	tree, err := Open(backend)
+	if err != nil {
+		t.Fatal(err)
+	}
+	backend.n = 0
+	for i := 0; i < 3; i++ {
+		if err = tree.Set([]byte(fmt.Sprint("key", i)), []byte("value")); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if backend.n != 0 {
+		t.Error("meta is written", backend.n, "times without split")
+	}
+	v := bytes.Repeat([]byte{'v'}, MaxValueSize)
+	for i := 0; backend.n == 0 && i < 100; i++ {
+		if err = tree.Set([]byte(fmt.Sprint("large", i)), v); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if backend.n != 1 {
+		t.Error("meta is written", backend.n, "times after split")
+	}
+	reopened, err := Open(f)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if reopened.root != tree.root || reopened.pages != tree.pages {
+		t.Error("meta is not updated after split")
+	}
+}
+
+func TestTree_BadSize(t *testing.T) {
+	f := stokutils.TempFile(t)
+	defer stokutils.ClearTempFile(f, t)
+	tree, err := Open(f)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := tree.Set(nil, nil); err != ErrKeySize {
+		t.Error(err, "!=", ErrKeySize)
+	}
+	if err := tree.Set([]byte("k"), make([]byte, MaxValueSize+1)); err != ErrValueSize {
+		t.Error(err, "!=", ErrValueSize)
+	}
+	if _, err := tree.Get([]byte("k"), nil); err != ErrNotFound {
+		t.Error(err, "!=", ErrNotFound)
+	}
+}
+
+func TestPrefixEnd(t *testing.T) {
+	for _, tt := range []struct {
+		prefix, end []byte
+	}{
+		{[]byte("a"), []byte("b")},
+		{[]byte{'a', 0xff}, []byte("b")},
+		{[]byte{0xff, 0xff}, nil},
+	} {
+		if got := PrefixEnd(tt.prefix); !bytes.Equal(got, tt.end) {
+			t.Error(tt.prefix, got, "!=", tt.end)
+		}
+	}
+}
+
+func BenchmarkTree_Get(b *testing.B) {
+	f := stokutils.TempFile(b)
+	defer stokutils.ClearTempFile(f, b)
+	tree, err := Open(f)
+	if err != nil {
+		b.Fatal(err)
+	}
+	for i := 0; i < 1000; i++ {
+		if err := tree.Set([]byte(fmt.Sprintf("key%d", i)), []byte("value")); err != nil {
+			b.Fatal(err)
+		}
+	}
+	key := []byte("key500")
+	buf := make([]byte, 0, MaxValueSize)
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		if _, err := tree.Get(key, buf[:0]); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
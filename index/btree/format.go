@@ -0,0 +1,19 @@
+package btree
+
+import "github.com/cydev/stok/binary"
+
+// FormatVersion is version of tree format.
+const FormatVersion = 1
+
+func init() {
+	binary.Register(binary.Format{
+		Name:       "btree",
+		Magic:      Magic,
+		Version:    FormatVersion,
+		HeaderSize: metaSize,
+		Check: func(header []byte) error {
+			_, _, err := readMeta(header)
+			return err
+		},
+	})
+}
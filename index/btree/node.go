@@ -0,0 +1,194 @@
+package btree
+
+import (
+	"bytes"
+	"encoding/binary"
+	"sort"
+)
+
+// node is decoded tree page.
+//
+// Page layout:
+//
+//	type (1 byte) | count (2 bytes) | next (8 bytes) | entries
+//
+// Leaf entry is
+//
+//	len(key) (2 bytes) | key | len(value) (2 bytes) | value
+//
+// Internal node entries are
+//
+//	child0 (8 bytes) | {len(key) (2 bytes) | key | child (8 bytes)}
+//
+// where all keys in child(i) are less than key(i).
+type node struct {
+	id       uint64
+	leaf     bool
+	next     uint64 // right sibling of leaf, 0 if none
+	keys     [][]byte
+	vals     [][]byte // values of leaf
+	children []uint64 // children of internal node, len(keys) + 1
+}
+
+const (
+	nodeHeaderSize = 1 + 2 + 8
+	typeInternal   = 1
+	typeLeaf       = 2
+)
+
+// search returns position of key in leaf and whether key is found.
+func (n *node) search(key []byte) (int, bool) {
+	i := sort.Search(len(n.keys), func(i int) bool {
+		return bytes.Compare(n.keys[i], key) >= 0
+	})
+	return i, i < len(n.keys) && bytes.Equal(n.keys[i], key)
+}
+
+// child returns position of child of internal node that can contain key.
+func (n *node) child(key []byte) int {
+	return sort.Search(len(n.keys), func(i int) bool {
+		return bytes.Compare(key, n.keys[i]) < 0
+	})
+}
+
+func (n *node) entrySize(i int) int {
+	if n.leaf {
+		return 2 + len(n.keys[i]) + 2 + len(n.vals[i])
+	}
+	return 2 + len(n.keys[i]) + 8
+}
+
+// size returns size of encoded node.
+func (n *node) size() int {
+	s := nodeHeaderSize
+	if !n.leaf {
+		s += 8
+	}
+	for i := range n.keys {
+		s += n.entrySize(i)
+	}
+	return s
+}
+
+// split moves right half of entries to new node with given id and
+// returns separator key and new node.
+func (n *node) split(id uint64) ([]byte, *node) {
+	var (
+		half = n.size() / 2
+		size = nodeHeaderSize
+		mid  = 0
+	)
+	for mid < len(n.keys)-1 && size < half {
+		size += n.entrySize(mid)
+		mid++
+	}
+	r := &node{id: id, leaf: n.leaf}
+	if n.leaf {
+		r.keys = append(r.keys, n.keys[mid:]...)
+		r.vals = append(r.vals, n.vals[mid:]...)
+		r.next, n.next = n.next, id
+		n.keys, n.vals = n.keys[:mid], n.vals[:mid]
+		return r.keys[0], r
+	}
+	sep := n.keys[mid]
+	r.keys = append(r.keys, n.keys[mid+1:]...)
+	r.children = append(r.children, n.children[mid+1:]...)
+	n.keys, n.children = n.keys[:mid], n.children[:mid+1]
+	return sep, r
+}
+
+func appendUint16(buf []byte, v int) []byte {
+	return append(buf, byte(v>>8), byte(v))
+}
+
+func appendUint64(buf []byte, v uint64) []byte {
+	var b [8]byte
+	binary.BigEndian.PutUint64(b[:], v)
+	return append(buf, b[:]...)
+}
+
+// encode appends encoded node to buf.
+func (n *node) encode(buf []byte) []byte {
+	t := byte(typeInternal)
+	if n.leaf {
+		t = typeLeaf
+	}
+	buf = append(buf, t)
+	buf = appendUint16(buf, len(n.keys))
+	buf = appendUint64(buf, n.next)
+	if !n.leaf {
+		buf = appendUint64(buf, n.children[0])
+	}
+	for i, k := range n.keys {
+		buf = appendUint16(buf, len(k))
+		buf = append(buf, k...)
+		if n.leaf {
+			buf = appendUint16(buf, len(n.vals[i]))
+			buf = append(buf, n.vals[i]...)
+		} else {
+			buf = appendUint64(buf, n.children[i+1])
+		}
+	}
+	return buf
+}
+
+// decoder reads values from buf, remembering if buf is too short.
+type decoder struct {
+	buf []byte
+	bad bool
+}
+
+func (d *decoder) next(n int) []byte {
+	if d.bad || len(d.buf) < n {
+		d.bad = true
+		return make([]byte, n)
+	}
+	b := d.buf[:n]
+	d.buf = d.buf[n:]
+	return b
+}
+
+func (d *decoder) uint16() int {
+	return int(binary.BigEndian.Uint16(d.next(2)))
+}
+
+func (d *decoder) uint64() uint64 {
+	return binary.BigEndian.Uint64(d.next(8))
+}
+
+func (d *decoder) bytes() []byte {
+	return append([]byte(nil), d.next(d.uint16())...)
+}
+
+// decode decodes node from page buf.
+func (n *node) decode(buf []byte) error {
+	d := &decoder{buf: buf}
+	switch d.next(1)[0] {
+	case typeLeaf:
+		n.leaf = true
+	case typeInternal:
+		n.leaf = false
+	default:
+		return ErrBadPage
+	}
+	count := d.uint16()
+	n.next = d.uint64()
+	n.keys = make([][]byte, 0, count)
+	if n.leaf {
+		n.vals = make([][]byte, 0, count)
+	} else {
+		n.children = append(make([]uint64, 0, count+1), d.uint64())
+	}
+	for i := 0; i < count && !d.bad; i++ {
+		n.keys = append(n.keys, d.bytes())
+		if n.leaf {
+			n.vals = append(n.vals, d.bytes())
+		} else {
+			n.children = append(n.children, d.uint64())
+		}
+	}
+	if d.bad {
+		return ErrBadPage
+	}
+	return nil
+}
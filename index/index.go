@@ -11,17 +11,38 @@
 //   Blob - {v0, v1, ..., vi, ... vN}
 //
 // Vi is ReadAt(buf[:Vlen], Vlen*i) on Blob.
+//
+// Entry with all-zero value is empty. Deleted entries are zeroed and,
+// if index has live-entry Bitmap, are also cleared in it, so Iterator
+// skips both deleted and never written entries.
 package index
 
 import (
+	"context"
 	"io"
+	"math"
+	"os"
+	"sync"
+	"sync/atomic"
+	"time"
 
+	"github.com/cydev/stok"
 	"github.com/pkg/errors"
 	"github.com/valyala/bytebufferpool"
 )
 
 const StartID int64 = 0
 
+const (
+	// ErrNotFound means that entry is deleted or was never written.
+	ErrNotFound stok.Error = "Entry not found"
+	// ErrBadKey means that key is out of {0..n}.
+	ErrBadKey stok.Error = "Bad key"
+	// ErrBadBitmap means that live-entry bitmap is missing or shorter
+	// than index, so index should be rebuilt.
+	ErrBadBitmap stok.Error = "Bitmap does not match index"
+)
+
 // Walker is function that is used as callback while iterating over index.
 type Walker func(k int64, v []byte) error
 
@@ -31,7 +52,11 @@ type Index interface {
 	io.Closer
 	Get(k int64, b []byte) error
 	Set(k int64, v []byte) error
+	Delete(k int64) error
+	// Len returns capacity of index, i.e. max(k) + 1.
 	Len() (int64, error)
+	// Count returns count of live entries.
+	Count() (int64, error)
 }
 
 // Ranger is Index that supports iteration.
@@ -40,44 +65,256 @@ type Ranger interface {
 	Range(start, end int64, w Walker) error
 }
 
+// Liveness is Index, that tracks live entries in bitmap, so Get returns
+// ErrNotFound for entries that are not live and zero values can be live.
+// Wrappers of index should implement it to keep iteration by bitmap.
+type Liveness interface {
+	// Bitmap returns bitmap of live entries or nil if there is none.
+	Bitmap() *Bitmap
+}
+
 type Backend interface {
 	io.ReaderAt
 	io.WriterAt
 	io.Closer
 }
 
+// RWAtIndex is Index on top of Backend.
 type RWAtIndex struct {
 	Backend Backend
-	Size    int
-	Length  int
+	// Live is optional bitmap of live entries. If nil, Count
+	// requires full scan of index.
+	Live   *Bitmap
+	Size   int
+	Length int64
+	// Observer receives events of index operations, Metrics if nil.
+	Observer stok.Observer
+
+	mux       sync.RWMutex // held for writing while taking snapshot
+	snapshots []*Snapshot
+	open      int32 // index is reported to observer as open
+}
+
+// BitmapSuffix is appended to index file name to get
+// name of live-entry bitmap file.
+const BitmapSuffix = ".live"
+
+// Open opens or creates index file with value size of size bytes
+// and live-entry bitmap alongside it. Bitmap is created only with new
+// index, so ErrBadBitmap is returned if index is not empty and bitmap
+// is missing or does not cover all entries.
+func Open(name string, size int) (*RWAtIndex, error) {
+	f, err := os.OpenFile(name, os.O_RDWR|os.O_CREATE, 0644)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to open")
+	}
+	info, err := f.Stat()
+	if err != nil {
+		f.Close()
+		return nil, errors.Wrap(err, "failed to stat")
+	}
+	length := info.Size() / int64(size)
+	flag := os.O_RDWR
+	if length == 0 {
+		flag |= os.O_CREATE
+	}
+	bf, err := os.OpenFile(name+BitmapSuffix, flag, 0644)
+	if os.IsNotExist(err) {
+		f.Close()
+		return nil, errors.Wrap(ErrBadBitmap, "bitmap is missing")
+	}
+	if err != nil {
+		f.Close()
+		return nil, errors.Wrap(err, "failed to open bitmap")
+	}
+	live, err := NewBitmap(bf)
+	if err == nil && live.Len() < length {
+		// bit of the last entry is written when entry is set
+		err = errors.Wrapf(ErrBadBitmap, "bitmap of %d entries, index of %d", live.Len(), length)
+	}
+	if err != nil {
+		f.Close()
+		bf.Close()
+		return nil, errors.Wrap(err, "failed to load bitmap")
+	}
+	i := &RWAtIndex{
+		Backend: f,
+		Live:    live,
+		Size:    size,
+		Length:  length,
+	}
+	atomic.StoreInt32(&i.open, 1)
+	i.observer().OnOpen(i.size(length), i.size(length))
+	return i, nil
 }
 
-func (i RWAtIndex) Len() (int64, error) {
-	return int64(i.Length), nil
+// Bitmap implements Liveness.
+func (i *RWAtIndex) Bitmap() *Bitmap {
+	return i.Live
 }
 
-func (i RWAtIndex) Close() error {
+// Len returns capacity of index.
+func (i *RWAtIndex) Len() (int64, error) {
+	return atomic.LoadInt64(&i.Length), nil
+}
+
+// Count returns count of live entries.
+func (i *RWAtIndex) Count() (int64, error) {
+	if i.Live != nil {
+		return i.Live.Count(), nil
+	}
+	var n int64
+	err := Iterator{Index: i, Size: i.Size}.All(func(int64, []byte) error {
+		n++
+		return nil
+	})
+	return n, err
+}
+
+// Close closes bitmap and backend of index.
+func (i *RWAtIndex) Close() error {
+	if atomic.CompareAndSwapInt32(&i.open, 1, 0) {
+		size := i.size(atomic.LoadInt64(&i.Length))
+		i.observer().OnClose(size, size)
+	}
+	if i.Live != nil {
+		if err := i.Live.Close(); err != nil {
+			return errors.Wrap(err, "failed to close bitmap")
+		}
+	}
 	return i.Backend.Close()
 }
 
-func (i RWAtIndex) offset(k int64) int64 {
+func (i *RWAtIndex) observer() stok.Observer {
+	if i.Observer == nil {
+		return Metrics
+	}
+	return i.Observer
+}
+
+// observe reports result of op, that read or wrote n bytes since start,
+// to observer and returns err. Missing keys are not reported as errors.
+func (i *RWAtIndex) observe(op stok.Op, n int, start time.Time, err error) error {
+	o := i.observer()
+	switch {
+	case err == nil && op == stok.OpRead:
+		o.OnRead(n, time.Since(start))
+	case err == nil:
+		o.OnWrite(n, time.Since(start))
+	case errors.Cause(err) != ErrNotFound:
+		o.OnError(op, err)
+	}
+	return err
+}
+
+func (i *RWAtIndex) offset(k int64) int64 {
 	return int64(i.Size) * k
 }
 
-func (i RWAtIndex) Get(k int64, b []byte) error {
+// size returns size of n entries in bytes.
+func (i *RWAtIndex) size(n int64) int64 {
+	return i.offset(n)
+}
+
+// valid reports whether offset of value of k does not overflow.
+func (i *RWAtIndex) valid(k int64) bool {
+	return k >= 0 && k <= math.MaxInt64/int64(i.Size)-1
+}
+
+// Get reads value of k to b, returning ErrNotFound if
+// k is not live in bitmap.
+func (i *RWAtIndex) Get(k int64, b []byte) error {
+	start := time.Now()
 	var (
 		err error
 	)
+	if i.Live != nil && !i.Live.Get(k) {
+		return ErrNotFound
+	}
 	_, err = i.Backend.ReadAt(b, i.offset(k))
-	return errors.Wrap(err, "failed to read")
+	return i.observe(stok.OpRead, len(b), start, errors.Wrap(err, "failed to read"))
 }
 
-func (i RWAtIndex) Set(k int64, b []byte) error {
+func (i *RWAtIndex) Set(k int64, b []byte) error {
+	start := time.Now()
+	return i.observe(stok.OpWrite, len(b), start, i.set(k, b, start))
+}
+
+func (i *RWAtIndex) set(k int64, b []byte, start time.Time) error {
 	var (
 		err error
 	)
-	_, err = i.Backend.WriteAt(b, i.offset(k))
-	return errors.Wrap(err, "failed to write")
+	if !i.valid(k) {
+		return ErrBadKey
+	}
+	i.mux.RLock()
+	defer i.mux.RUnlock()
+	if err = i.preserve(k); err != nil {
+		return errors.Wrap(err, "failed to preserve")
+	}
+	if _, err = i.Backend.WriteAt(b, i.offset(k)); err != nil {
+		return errors.Wrap(err, "failed to write")
+	}
+	if i.Live != nil {
+		if err = i.Live.Set(k); err != nil {
+			return errors.Wrap(err, "failed to mark live")
+		}
+	}
+	i.grow(k+1, start)
+	return nil
+}
+
+// grow sets Length to n if it is bigger and reports growth of backend,
+// that was extended by write started at start.
+func (i *RWAtIndex) grow(n int64, start time.Time) {
+	for {
+		length := atomic.LoadInt64(&i.Length)
+		if length >= n {
+			return
+		}
+		if atomic.CompareAndSwapInt64(&i.Length, length, n) {
+			o := i.observer()
+			o.OnAllocate(i.size(length), i.size(n-length))
+			o.OnTruncate(i.size(length), i.size(n), time.Since(start))
+			return
+		}
+	}
+}
+
+// Delete zeroes value of k and clears it in bitmap.
+func (i *RWAtIndex) Delete(k int64) error {
+	start := time.Now()
+	return i.observe(stok.OpWrite, i.Size, start, i.delete(k))
+}
+
+func (i *RWAtIndex) delete(k int64) error {
+	if !i.valid(k) {
+		return ErrBadKey
+	}
+	if n, _ := i.Len(); k >= n {
+		return ErrNotFound
+	}
+	i.mux.RLock()
+	defer i.mux.RUnlock()
+	if err := i.preserve(k); err != nil {
+		return errors.Wrap(err, "failed to preserve")
+	}
+	if i.Live != nil && !i.Live.Get(k) {
+		return ErrNotFound
+	}
+	b := extend(pool.Get(), i.Size)
+	defer pool.Put(b)
+	for j := range b.B {
+		b.B[j] = 0
+	}
+	// clearing after write, so failed write leaves entry live
+	if _, err := i.Backend.WriteAt(b.B, i.offset(k)); err != nil {
+		return errors.Wrap(err, "failed to write")
+	}
+	if i.Live != nil {
+		return errors.Wrap(i.Live.Clear(k), "failed to clear")
+	}
+	return nil
 }
 
 type Iterator struct {
@@ -96,21 +333,65 @@ func extend(b *bytebufferpool.ByteBuffer, n int) *bytebufferpool.ByteBuffer {
 	return b
 }
 
+// isZero reports whether all bytes of b are zero.
+func isZero(b []byte) bool {
+	for _, c := range b {
+		if c != 0 {
+			return false
+		}
+	}
+	return true
+}
+
+// All calls w for every live entry of index.
 func (i Iterator) All(w Walker) error {
 	n, err := i.Index.Len()
 	if err != nil {
 		return errors.Wrap(err, "failed get Len")
 	}
+	return i.Range(StartID, n, w)
+}
+
+// Range calls w for every live entry in [start, end). If index has
+// bitmap of live entries, entries are live by bitmap, so zero values
+// can be live. Otherwise entries with zero values are skipped.
+func (i Iterator) Range(start, end int64, w Walker) error {
+	return i.walk(context.Background(), start, end, w)
+}
+
+// cancelCheckInterval is count of entries between context checks.
+const cancelCheckInterval = 1024
+
+// hasBitmap reports whether idx has bitmap of live entries.
+func hasBitmap(idx Index) bool {
+	l, ok := idx.(Liveness)
+	return ok && l.Bitmap() != nil
+}
+
+// walk is Range that stops when ctx is done.
+func (i Iterator) walk(ctx context.Context, start, end int64, w Walker) error {
 	b := extend(pool.Get(), i.Size)
 	defer pool.Put(b)
-	for id := StartID; id < n; id++ {
-		if err = i.Index.Get(id, b.B); err != nil {
+	live := hasBitmap(i.Index)
+	for id := start; id < end; id++ {
+		if (id-start)%cancelCheckInterval == 0 {
+			if err := ctx.Err(); err != nil {
+				return err
+			}
+		}
+		err := i.Index.Get(id, b.B)
+		if errors.Cause(err) == ErrNotFound {
+			continue
+		}
+		if err != nil {
 			return errors.Wrap(err, "failed to Get")
 		}
+		if !live && isZero(b.B) {
+			continue
+		}
 		if err := w(id, b.B); err != nil {
 			return errors.Wrap(err, "callback error")
 		}
-		b.Reset()
 	}
 	return nil
 }
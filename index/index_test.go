@@ -1,7 +1,15 @@
 package index
 
-import "testing"
-import "github.com/cydev/stok/stokutils"
+import (
+	"io/ioutil"
+	"math"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/cydev/stok/stokutils"
+	"github.com/pkg/errors"
+)
 
 func BenchmarkReaderAtIndex_Get(b *testing.B) {
 	b.ReportAllocs()
@@ -37,6 +45,7 @@ func TestIterator_All(t *testing.T) {
 		Index: index,
 	}
 	buf := make([]byte, size)
+	buf[0] = 1
 	for i := StartID; i < int64(count); i++ {
 		if err := index.Set(i, buf); err != nil {
 			t.Error(err)
@@ -57,3 +66,246 @@ func TestIterator_All(t *testing.T) {
 		t.Error(count, "!=", countRead)
 	}
 }
+
+func TestIterator_AllZeroLive(t *testing.T) {
+	size := 8
+	index, _, clear := tempIndex(t, size)
+	defer clear()
+	for _, k := range []int64{1, 3} {
+		if err := index.Set(k, make([]byte, size)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := index.Delete(3); err != nil {
+		t.Fatal(err)
+	}
+	var keys []int64
+	walk := func(k int64, v []byte) error {
+		keys = append(keys, k)
+		return nil
+	}
+	if err := (Iterator{Index: index, Size: size}).All(walk); err != nil {
+		t.Fatal(err)
+	}
+	s := index.Snapshot()
+	defer stokutils.MustClose(t, s)
+	if err := s.All(walk); err != nil {
+		t.Fatal(err)
+	}
+	// and of wrapper of index
+	wrapped := struct{ *RWAtIndex }{index}
+	if err := (Iterator{Index: wrapped, Size: size}).All(walk); err != nil {
+		t.Fatal(err)
+	}
+	// zero value is live by bitmap
+	if len(keys) != 3 || keys[0] != 1 || keys[1] != 1 || keys[2] != 1 {
+		t.Error("bad keys", keys)
+	}
+}
+
+// failingBackend fails writes if fail is set.
+type failingBackend struct {
+	Backend
+	fail bool
+}
+
+func (b *failingBackend) WriteAt(p []byte, off int64) (int, error) {
+	if b.fail {
+		return 0, errors.New("write failed")
+	}
+	return b.Backend.WriteAt(p, off)
+}
+
+func TestRWAtIndex_DeleteFailed(t *testing.T) {
+	size := 8
+	index, _, clear := tempIndex(t, size)
+	defer clear()
+	if err := index.Set(1, []byte("12345678")); err != nil {
+		t.Fatal(err)
+	}
+	index.Backend = &failingBackend{Backend: index.Backend, fail: true}
+	if err := index.Delete(1); err == nil {
+		t.Fatal("deleted with failed write")
+	}
+	buf := make([]byte, size)
+	if err := index.Get(1, buf); err != nil || string(buf) != "12345678" {
+		t.Error("entry is not live after failed delete", err)
+	}
+}
+
+func tempIndex(t testing.TB, size int) (*RWAtIndex, string, func()) {
+	dir, err := ioutil.TempDir("", "index")
+	if err != nil {
+		t.Fatal(err)
+	}
+	name := filepath.Join(dir, "index")
+	index, err := Open(name, size)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return index, name, func() {
+		stokutils.MustClose(t, index)
+		os.RemoveAll(dir)
+	}
+}
+
+func TestRWAtIndex_Delete(t *testing.T) {
+	var (
+		size = 16
+		buf  = make([]byte, size)
+	)
+	index, name, clear := tempIndex(t, size)
+	defer clear()
+	buf[0] = 1
+	for _, k := range []int64{0, 1, 2, 5} {
+		if err := index.Set(k, buf); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := index.Delete(1); err != nil {
+		t.Fatal(err)
+	}
+	if err := index.Delete(1); err != ErrNotFound {
+		t.Error(err, "!=", ErrNotFound)
+	}
+	if err := index.Delete(10); err != ErrNotFound {
+		t.Error(err, "!=", ErrNotFound)
+	}
+	// offset of value overflows
+	if err := index.Set(math.MaxInt64/int64(size), buf); err != ErrBadKey {
+		t.Error(err, "!=", ErrBadKey)
+	}
+	if err := index.Get(1, buf); err != ErrNotFound {
+		t.Error(err, "!=", ErrNotFound)
+	}
+	if n, _ := index.Len(); n != 6 {
+		t.Error("len", n, "!=", 6)
+	}
+	if n, _ := index.Count(); n != 3 {
+		t.Error("count", n, "!=", 3)
+	}
+
+	var keys []int64
+	iterator := Iterator{Index: index, Size: size}
+	if err := iterator.All(func(k int64, v []byte) error {
+		keys = append(keys, k)
+		return nil
+	}); err != nil {
+		t.Error(err)
+	}
+	if len(keys) != 3 || keys[0] != 0 || keys[1] != 2 || keys[2] != 5 {
+		t.Error("bad keys", keys)
+	}
+
+	// bitmap should be persisted
+	reopened, err := Open(name, size)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer stokutils.MustClose(t, reopened)
+	if n, _ := reopened.Count(); n != 3 {
+		t.Error("count", n, "!=", 3)
+	}
+	if n, _ := reopened.Len(); n != 6 {
+		t.Error("len", n, "!=", 6)
+	}
+}
+
+func TestRWAtIndex_CountNoBitmap(t *testing.T) {
+	var (
+		size = 8
+		buf  = make([]byte, size)
+	)
+	f := stokutils.TempFile(t)
+	defer stokutils.ClearTempFile(f, t)
+	index := &RWAtIndex{Backend: f, Size: size}
+	buf[0] = 1
+	for _, k := range []int64{1, 3, 4} {
+		if err := index.Set(k, buf); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := index.Delete(3); err != nil {
+		t.Fatal(err)
+	}
+	if n, _ := index.Count(); n != 2 {
+		t.Error("count", n, "!=", 2)
+	}
+	if n, _ := index.Len(); n != 5 {
+		t.Error("len", n, "!=", 5)
+	}
+}
+
+func TestOpen_BadBitmap(t *testing.T) {
+	dir, err := ioutil.TempDir("", "index")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	name := filepath.Join(dir, "index")
+	idx, err := Open(name, 8)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = idx.Set(20, []byte("12345678")); err != nil {
+		t.Fatal(err)
+	}
+	stokutils.MustClose(t, idx)
+	// bitmap is written after value, so crash can leave it short
+	if err = os.Truncate(name+BitmapSuffix, 1); err != nil {
+		t.Fatal(err)
+	}
+	if _, err = Open(name, 8); errors.Cause(err) != ErrBadBitmap {
+		t.Error("short bitmap:", err)
+	}
+	if err = os.Remove(name + BitmapSuffix); err != nil {
+		t.Fatal(err)
+	}
+	if _, err = Open(name, 8); errors.Cause(err) != ErrBadBitmap {
+		t.Error("missing bitmap:", err)
+	}
+	if _, err = os.Stat(name + BitmapSuffix); !os.IsNotExist(err) {
+		t.Error("empty bitmap is created:", err)
+	}
+}
+
+func TestRWAtIndex_Metrics(t *testing.T) {
+	dir, err := ioutil.TempDir("", "index")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	var (
+		name     = filepath.Join(dir, "index")
+		size     = Metrics.Size.Value()
+		capacity = Metrics.Capacity.Value()
+	)
+	check := func(delta int64) {
+		t.Helper()
+		if s, c := Metrics.Size.Value()-size, Metrics.Capacity.Value()-capacity; s != delta || c != delta {
+			t.Errorf("size %d, capacity %d != %d", s, c, delta)
+		}
+	}
+	idx, err := Open(name, 8)
+	if err != nil {
+		t.Fatal(err)
+	}
+	check(0)
+	if err = idx.Set(20, []byte("12345678")); err != nil {
+		t.Fatal(err)
+	}
+	if err = idx.Set(3, []byte("12345678")); err != nil {
+		t.Fatal(err)
+	}
+	check(21 * 8)
+	stokutils.MustClose(t, idx)
+	check(0)
+	idx.Close()
+	check(0)
+	if idx, err = Open(name, 8); err != nil {
+		t.Fatal(err)
+	}
+	check(21 * 8)
+	stokutils.MustClose(t, idx)
+	check(0)
+}
@@ -0,0 +1,7 @@
+package index
+
+import "github.com/cydev/stok/metrics"
+
+// Metrics is observer of indexes without Observer, that exposes metrics
+// with stok_index prefix in metrics.Default.
+var Metrics = metrics.NewObserver(metrics.Default, "stok_index", "indexes")
@@ -0,0 +1,101 @@
+package index
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"sync"
+
+	"github.com/pkg/errors"
+)
+
+// Errors is list of errors from parallel iteration.
+type Errors []error
+
+func (e Errors) Error() string {
+	if len(e) == 1 {
+		return e[0].Error()
+	}
+	s := make([]string, len(e))
+	for i, err := range e {
+		s[i] = err.Error()
+	}
+	return fmt.Sprintf("%d errors: %s", len(e), strings.Join(s, "; "))
+}
+
+// MinShardSize is minimum count of entries in shard of parallel iteration.
+const MinShardSize = 4096
+
+// shardsPerWorker is count of shards per worker, so faster workers
+// can take more shards.
+const shardsPerWorker = 4
+
+// ParallelAll is All that walks index with workers goroutines.
+func (i Iterator) ParallelAll(ctx context.Context, workers int, w Walker) error {
+	n, err := i.Index.Len()
+	if err != nil {
+		return errors.Wrap(err, "failed get Len")
+	}
+	return i.ParallelRange(ctx, StartID, n, workers, w)
+}
+
+// ParallelRange is Range that splits [start, end) into shards and walks
+// them with workers goroutines, each using its own buffer, so w is called
+// concurrently and order of keys is not defined.
+//
+// First error cancels iteration, and all errors from shards that are not
+// caused by cancellation are returned as Errors. If ctx is done before
+// iteration is complete, ctx.Err() is returned.
+func (i Iterator) ParallelRange(ctx context.Context, start, end int64, workers int, w Walker) error {
+	if workers < 1 {
+		workers = 1
+	}
+	size := (end - start) / int64(workers*shardsPerWorker)
+	if size < MinShardSize {
+		size = MinShardSize
+	}
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	var (
+		wg     sync.WaitGroup
+		mux    sync.Mutex
+		errs   Errors
+		shards = make(chan int64)
+	)
+	wg.Add(workers)
+	for j := 0; j < workers; j++ {
+		go func() {
+			defer wg.Done()
+			for s := range shards {
+				e := s + size
+				if e > end {
+					e = end
+				}
+				err := i.walk(ctx, s, e, w)
+				switch errors.Cause(err) {
+				case nil, context.Canceled, context.DeadlineExceeded:
+					continue
+				}
+				mux.Lock()
+				errs = append(errs, errors.Wrapf(err, "shard [%d, %d)", s, e))
+				mux.Unlock()
+				cancel()
+			}
+		}()
+	}
+	func() {
+		defer close(shards)
+		for s := start; s < end; s += size {
+			select {
+			case shards <- s:
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+	wg.Wait()
+	if len(errs) > 0 {
+		return errs
+	}
+	return ctx.Err()
+}
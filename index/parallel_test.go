@@ -0,0 +1,90 @@
+package index
+
+import (
+	"context"
+	"encoding/binary"
+	"errors"
+	"sync/atomic"
+	"testing"
+
+	"github.com/cydev/stok/stokutils"
+)
+
+func parallelIndex(t testing.TB, count int64) (*RWAtIndex, func()) {
+	var (
+		size = 8
+		buf  = make([]byte, size)
+	)
+	f := stokutils.TempFile(t)
+	index := &RWAtIndex{Backend: f, Size: size}
+	for k := StartID; k < count; k++ {
+		if k%10 == 0 {
+			continue
+		}
+		binary.BigEndian.PutUint64(buf, uint64(k))
+		if err := index.Set(k, buf); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return index, func() {
+		stokutils.ClearTempFile(f, t)
+	}
+}
+
+func TestIterator_ParallelAll(t *testing.T) {
+	count := int64(MinShardSize*3 + 100)
+	index, clear := parallelIndex(t, count)
+	defer clear()
+	var (
+		read int64
+		sum  int64
+	)
+	iterator := Iterator{Index: index, Size: index.Size}
+	if err := iterator.ParallelAll(context.Background(), 4, func(k int64, v []byte) error {
+		if int64(binary.BigEndian.Uint64(v)) != k {
+			t.Error("bad value of", k)
+		}
+		atomic.AddInt64(&read, 1)
+		atomic.AddInt64(&sum, k)
+		return nil
+	}); err != nil {
+		t.Fatal(err)
+	}
+	var expectedRead, expectedSum int64
+	for k := StartID; k < count; k++ {
+		if k%10 != 0 {
+			expectedRead++
+			expectedSum += k
+		}
+	}
+	if read != expectedRead || sum != expectedSum {
+		t.Error(read, sum, "!=", expectedRead, expectedSum)
+	}
+}
+
+func TestIterator_ParallelAllError(t *testing.T) {
+	index, clear := parallelIndex(t, MinShardSize*8)
+	defer clear()
+	var (
+		iterator = Iterator{Index: index, Size: index.Size}
+		failure  = errors.New("failure")
+	)
+	err := iterator.ParallelAll(context.Background(), 2, func(k int64, v []byte) error {
+		if k == MinShardSize+1 {
+			return failure
+		}
+		return nil
+	})
+	errs, ok := err.(Errors)
+	if !ok || len(errs) != 1 {
+		t.Fatal("unexpected error", err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if err := iterator.ParallelAll(ctx, 2, func(int64, []byte) error {
+		return nil
+	}); err != context.Canceled {
+		t.Error(err, "!=", context.Canceled)
+	}
+}
@@ -0,0 +1,201 @@
+package index
+
+import (
+	"io"
+	"sync"
+	"sync/atomic"
+
+	"github.com/cydev/stok"
+	"github.com/pkg/errors"
+)
+
+// ErrReadOnly means that write operation is called on Snapshot.
+const ErrReadOnly stok.Error = "Snapshot is read-only"
+
+// Snapshot is read-only point-in-time view of RWAtIndex.
+//
+// Snapshot reads unchanged entries directly from index, and
+// old values of entries that are changed after snapshot are
+// copied to in-memory overlay by writer before change,
+// so memory consumption of Snapshot grows with count of
+// entries changed while it is open. Snapshot must be closed after use.
+type Snapshot struct {
+	mux     sync.RWMutex // guards overlay
+	index   *RWAtIndex
+	length  int64
+	count   int64
+	overlay map[int64][]byte // nil value means not live entry
+}
+
+// Snapshot returns point-in-time view of index, waiting for
+// in-flight Set and Delete calls to complete.
+func (i *RWAtIndex) Snapshot() *Snapshot {
+	i.mux.Lock()
+	s := &Snapshot{
+		index:   i,
+		length:  atomic.LoadInt64(&i.Length),
+		count:   -1,
+		overlay: make(map[int64][]byte),
+	}
+	if i.Live != nil {
+		s.count = i.Live.Count()
+	}
+	i.snapshots = append(i.snapshots, s)
+	i.mux.Unlock()
+	return s
+}
+
+// preserve copies current value of k to all open snapshots.
+// Should be called with i.mux held for reading.
+func (i *RWAtIndex) preserve(k int64) error {
+	for _, s := range i.snapshots {
+		if err := s.preserve(k); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (s *Snapshot) preserve(k int64) error {
+	if k >= s.length {
+		return nil
+	}
+	s.mux.Lock()
+	defer s.mux.Unlock()
+	if _, ok := s.overlay[k]; ok {
+		return nil
+	}
+	v := make([]byte, s.index.Size)
+	err := s.index.Get(k, v)
+	if errors.Cause(err) == ErrNotFound {
+		v, err = nil, nil
+	}
+	if err != nil {
+		return err
+	}
+	s.overlay[k] = v
+	return nil
+}
+
+// Get reads value of k at the moment of snapshot to b.
+func (s *Snapshot) Get(k int64, b []byte) error {
+	if k < 0 || k >= s.length {
+		return ErrNotFound
+	}
+	if ok, err := s.preserved(k, b); ok {
+		return err
+	}
+	err := s.index.Get(k, b)
+	// value is preserved before it is changed, so if it is changed
+	// while being read, it is already in overlay
+	if ok, overlayErr := s.preserved(k, b); ok {
+		return overlayErr
+	}
+	return err
+}
+
+// preserved reads value of k from overlay to b and reports whether it
+// is preserved.
+func (s *Snapshot) preserved(k int64, b []byte) (bool, error) {
+	s.mux.RLock()
+	defer s.mux.RUnlock()
+	v, ok := s.overlay[k]
+	if !ok {
+		return false, nil
+	}
+	if v == nil {
+		return true, ErrNotFound
+	}
+	copy(b, v)
+	return true, nil
+}
+
+// Bitmap implements Liveness, returning bitmap of index. Get of
+// snapshot reports liveness at the moment of snapshot.
+func (s *Snapshot) Bitmap() *Bitmap {
+	return s.index.Live
+}
+
+// Set always returns ErrReadOnly.
+func (s *Snapshot) Set(k int64, v []byte) error {
+	return ErrReadOnly
+}
+
+// Delete always returns ErrReadOnly.
+func (s *Snapshot) Delete(k int64) error {
+	return ErrReadOnly
+}
+
+// Len returns capacity of index at the moment of snapshot.
+func (s *Snapshot) Len() (int64, error) {
+	return s.length, nil
+}
+
+// Count returns count of live entries at the moment of snapshot.
+func (s *Snapshot) Count() (int64, error) {
+	if s.count >= 0 {
+		return s.count, nil
+	}
+	var n int64
+	err := s.All(func(int64, []byte) error {
+		n++
+		return nil
+	})
+	return n, err
+}
+
+// All implements Ranger.
+func (s *Snapshot) All(w Walker) error {
+	return Iterator{Index: s, Size: s.index.Size}.All(w)
+}
+
+// Range implements Ranger.
+func (s *Snapshot) Range(start, end int64, w Walker) error {
+	return Iterator{Index: s, Size: s.index.Size}.Range(start, end, w)
+}
+
+// WriteTo writes values of all entries to w, so it can be used
+// as Backend of the copy of index. Not live entries are written
+// as zeroes.
+func (s *Snapshot) WriteTo(w io.Writer) (int64, error) {
+	var (
+		total int64
+		buf   = make([]byte, s.index.Size)
+	)
+	for k := StartID; k < s.length; k++ {
+		err := s.Get(k, buf)
+		if errors.Cause(err) == ErrNotFound {
+			for j := range buf {
+				buf[j] = 0
+			}
+			err = nil
+		}
+		if err != nil {
+			return total, errors.Wrap(err, "failed to Get")
+		}
+		n, err := w.Write(buf)
+		total += int64(n)
+		if err != nil {
+			return total, errors.Wrap(err, "failed to write")
+		}
+	}
+	return total, nil
+}
+
+// Close releases snapshot, so writers stop copying values to it.
+// Snapshot must not be used after Close.
+func (s *Snapshot) Close() error {
+	i := s.index
+	i.mux.Lock()
+	for j, snapshot := range i.snapshots {
+		if snapshot == s {
+			i.snapshots = append(i.snapshots[:j], i.snapshots[j+1:]...)
+			break
+		}
+	}
+	i.mux.Unlock()
+	s.mux.Lock()
+	s.overlay = nil
+	s.mux.Unlock()
+	return nil
+}
@@ -0,0 +1,94 @@
+package index
+
+import (
+	"bytes"
+	"encoding/binary"
+	"sync"
+	"testing"
+
+	"github.com/cydev/stok/stokutils"
+)
+
+func TestSnapshot(t *testing.T) {
+	var (
+		size  = 8
+		count = int64(64)
+		buf   = make([]byte, size)
+	)
+	index, _, clear := tempIndex(t, size)
+	defer clear()
+	for k := StartID; k < count; k++ {
+		binary.BigEndian.PutUint64(buf, uint64(k+1))
+		if err := index.Set(k, buf); err != nil {
+			t.Fatal(err)
+		}
+	}
+	snapshot := index.Snapshot()
+	defer stokutils.MustClose(t, snapshot)
+
+	// concurrently rewriting, deleting and appending entries
+	var wg sync.WaitGroup
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		v := make([]byte, size)
+		for k := StartID; k < count*2; k++ {
+			binary.BigEndian.PutUint64(v, uint64(k+1000))
+			if err := index.Set(k, v); err != nil {
+				t.Error(err)
+			}
+			if k%3 == 0 {
+				if err := index.Delete(k); err != nil {
+					t.Error(err)
+				}
+			}
+		}
+	}()
+	// readers of snapshot do not block each other
+	read := make([]int64, 4)
+	for i := range read {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			if err := snapshot.All(func(k int64, v []byte) error {
+				if got := binary.BigEndian.Uint64(v); got != uint64(k+1) {
+					t.Error(k, "value", got, "!=", k+1)
+				}
+				read[i]++
+				return nil
+			}); err != nil {
+				t.Error(err)
+			}
+		}(i)
+	}
+	wg.Wait()
+	for _, n := range read {
+		if n != count {
+			t.Error(n, "!=", count)
+		}
+	}
+	if n, _ := snapshot.Count(); n != count {
+		t.Error("count", n, "!=", count)
+	}
+	if err := snapshot.Set(0, buf); err != ErrReadOnly {
+		t.Error(err, "!=", ErrReadOnly)
+	}
+
+	// snapshot taken after deletes should not see deleted entries
+	after := index.Snapshot()
+	defer stokutils.MustClose(t, after)
+	if n, _ := after.Count(); n != count*2-(count*2+2)/3 {
+		t.Error("count", n)
+	}
+	if err := after.Get(3, buf); err != ErrNotFound {
+		t.Error(err, "!=", ErrNotFound)
+	}
+
+	out := new(bytes.Buffer)
+	if _, err := snapshot.WriteTo(out); err != nil {
+		t.Fatal(err)
+	}
+	if int64(out.Len()) != count*int64(size) {
+		t.Error(out.Len(), "!=", count*int64(size))
+	}
+}
@@ -0,0 +1,74 @@
+package master
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+// Client is client of master.
+type Client struct {
+	URL    string // base url of master
+	Secret string // secret of master, that is required in heartbeats
+	HTTP   *http.Client
+}
+
+func (c Client) http() *http.Client {
+	if c.HTTP == nil {
+		return http.DefaultClient
+	}
+	return c.HTTP
+}
+
+func (c Client) do(ctx context.Context, method, path string, body, result interface{}) error {
+	var buf bytes.Buffer
+	if body != nil {
+		if err := json.NewEncoder(&buf).Encode(body); err != nil {
+			return err
+		}
+	}
+	req, err := http.NewRequest(method, strings.TrimSuffix(c.URL, "/")+path, &buf)
+	if err != nil {
+		return err
+	}
+	if c.Secret != "" {
+		req.Header.Set(SecretHeader, c.Secret)
+	}
+	res, err := c.http().Do(req.WithContext(ctx))
+	if err != nil {
+		return err
+	}
+	defer res.Body.Close()
+	if res.StatusCode >= 300 {
+		return fmt.Errorf("master: %s %s: %s", method, path, res.Status)
+	}
+	if result == nil {
+		return nil
+	}
+	return errors.Wrap(json.NewDecoder(res.Body).Decode(result), "failed to decode")
+}
+
+// Heartbeat sends heartbeat to master.
+func (c Client) Heartbeat(ctx context.Context, h Heartbeat) error {
+	return c.do(ctx, http.MethodPost, "/dir/heartbeat", h, nil)
+}
+
+// Assign requests new file id.
+func (c Client) Assign(ctx context.Context) (Assignment, error) {
+	var a Assignment
+	return a, c.do(ctx, http.MethodGet, "/dir/assign", nil, &a)
+}
+
+// Lookup requests locations of volume.
+func (c Client) Lookup(ctx context.Context, id uint32) (Lookup, error) {
+	var l Lookup
+	q := url.Values{"volumeId": {strconv.FormatUint(uint64(id), 10)}}
+	return l, c.do(ctx, http.MethodGet, "/dir/lookup?"+q.Encode(), nil, &l)
+}
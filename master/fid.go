@@ -0,0 +1,47 @@
+package master
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/cydev/stok"
+)
+
+// ErrBadFileID means that file id can't be parsed.
+const ErrBadFileID stok.Error = "Bad file id"
+
+// FileID is cluster-wide file identifier, formatted as
+// "volumeID,key,cookie", where key and cookie are hex-encoded.
+type FileID struct {
+	Volume uint32
+	Key    int64
+	Cookie uint32
+}
+
+func (f FileID) String() string {
+	return fmt.Sprintf("%d,%x,%08x", f.Volume, f.Key, f.Cookie)
+}
+
+// ParseFileID parses FileID from string.
+func ParseFileID(s string) (FileID, error) {
+	var f FileID
+	parts := strings.Split(s, ",")
+	if len(parts) != 3 {
+		return f, ErrBadFileID
+	}
+	volume, err := strconv.ParseUint(parts[0], 10, 32)
+	if err != nil {
+		return f, ErrBadFileID
+	}
+	key, err := strconv.ParseInt(parts[1], 16, 64)
+	if err != nil || key < 0 {
+		return f, ErrBadFileID
+	}
+	cookie, err := strconv.ParseUint(parts[2], 16, 32)
+	if err != nil {
+		return f, ErrBadFileID
+	}
+	f.Volume, f.Key, f.Cookie = uint32(volume), key, uint32(cookie)
+	return f, nil
+}
@@ -0,0 +1,279 @@
+// Package master implements directory service that tracks volumes,
+// assigns file ids and resolves volume locations.
+//
+//	POST /dir/heartbeat           volume server reports its volumes (Heartbeat), requires secret
+//	GET  /dir/assign              returns Assignment with new file id on writable volume
+//	GET  /dir/lookup?volumeId=1   returns Lookup with locations of volume
+package master
+
+import (
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/binary"
+	"encoding/json"
+	"net/http"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/cydev/stok"
+)
+
+const (
+	// ErrNoWritableVolumes means that there are no alive volumes
+	// that accept writes.
+	ErrNoWritableVolumes stok.Error = "No writable volumes"
+	// ErrVolumeNotFound means that volume is not registered or not alive.
+	ErrVolumeNotFound stok.Error = "Volume not found"
+)
+
+// SecretHeader is header with secret, that is shared by master and
+// volume servers.
+const SecretHeader = "X-Stok-Secret"
+
+// DefaultTimeout is default time after which volume without heartbeat
+// is considered dead.
+const DefaultTimeout = 30 * time.Second
+
+// KeyWindow is count of keys after the next key reported by volume, that
+// can be assigned. Volume servers reject keys beyond window, so index of
+// volume can't be grown by arbitrary keys.
+const KeyWindow = 1 << 20
+
+// VolumeInfo is state of volume reported by volume server.
+type VolumeInfo struct {
+	ID       uint32 `json:"id"`
+	Free     int64  `json:"free"`     // free space in bytes
+	ReadOnly bool   `json:"readOnly"` // volume does not accept writes
+	NextKey  int64  `json:"nextKey"`  // next key that is not used by volume
+	Replica  bool   `json:"replica"`  // server is replica, that does not accept writes
+}
+
+// Heartbeat is periodic report of volume server.
+type Heartbeat struct {
+	URL     string       `json:"url"`
+	Volumes []VolumeInfo `json:"volumes"`
+}
+
+// Location is address of volume server.
+type Location struct {
+	URL     string `json:"url"`
+	Primary bool   `json:"primary,omitempty"` // server accepts writes
+}
+
+// Assignment is response of /dir/assign.
+type Assignment struct {
+	FileID string `json:"fid"`
+	URL    string `json:"url"`
+}
+
+// Lookup is response of /dir/lookup. Primary is the first location.
+type Lookup struct {
+	VolumeID  uint32     `json:"volumeId"`
+	Locations []Location `json:"locations"`
+}
+
+// server is state of volume reported by volume server.
+type server struct {
+	info VolumeInfo
+	seen time.Time // time of last heartbeat
+}
+
+type volume struct {
+	nextKey int64
+	servers map[string]server // by url
+}
+
+// Master tracks volumes and assigns file ids. It is goroutine-safe.
+type Master struct {
+	// Timeout is time after which volume server without heartbeat is
+	// considered dead, DefaultTimeout if zero.
+	Timeout time.Duration
+	// Secret is shared by master and volume servers, that is required
+	// in heartbeats, so only volume servers can register volumes.
+	// Heartbeats are rejected if it is empty.
+	Secret string
+
+	mux     sync.Mutex
+	volumes map[uint32]*volume
+	now     func() time.Time
+}
+
+func (m *Master) timeout() time.Duration {
+	if m.Timeout == 0 {
+		return DefaultTimeout
+	}
+	return m.Timeout
+}
+
+func (m *Master) init() {
+	if m.volumes == nil {
+		m.volumes = make(map[uint32]*volume)
+	}
+	if m.now == nil {
+		m.now = time.Now
+	}
+}
+
+// Join registers heartbeat of volume server.
+func (m *Master) Join(h Heartbeat) {
+	m.mux.Lock()
+	defer m.mux.Unlock()
+	m.init()
+	now := m.now()
+	for _, info := range h.Volumes {
+		v, ok := m.volumes[info.ID]
+		if !ok {
+			v = &volume{servers: make(map[string]server)}
+			m.volumes[info.ID] = v
+		}
+		if info.NextKey > v.nextKey {
+			v.nextKey = info.NextKey
+		}
+		v.servers[h.URL] = server{info: info, seen: now}
+	}
+}
+
+// alive returns locations of volume servers with recent heartbeats,
+// primary first and others sorted by url, and state of primary, that
+// is zero if primary is not alive. Should be called with m.mux held.
+func (m *Master) alive(v *volume) ([]Location, VolumeInfo) {
+	var (
+		deadline  = m.now().Add(-m.timeout())
+		locations []Location
+		primary   VolumeInfo
+	)
+	for url, s := range v.servers {
+		if s.seen.Before(deadline) {
+			delete(v.servers, url)
+			continue
+		}
+		locations = append(locations, Location{URL: url, Primary: !s.info.Replica})
+	}
+	sort.Slice(locations, func(i, j int) bool {
+		if locations[i].Primary != locations[j].Primary {
+			return locations[i].Primary
+		}
+		return locations[i].URL < locations[j].URL
+	})
+	if len(locations) > 0 && locations[0].Primary {
+		primary = v.servers[locations[0].URL].info
+	}
+	// only the first primary accepts writes
+	for i := 1; i < len(locations); i++ {
+		locations[i].Primary = false
+	}
+	return locations, primary
+}
+
+func cookie() uint32 {
+	var b [4]byte
+	if _, err := rand.Read(b[:]); err != nil {
+		panic(err)
+	}
+	return binary.BigEndian.Uint32(b[:])
+}
+
+// Assign returns new file id on writable volume with max free space
+// and location of its primary. Master is the only allocator of keys of
+// volumes, so volume servers should reject uploads without file id.
+func (m *Master) Assign() (FileID, Location, error) {
+	m.mux.Lock()
+	defer m.mux.Unlock()
+	m.init()
+	var (
+		best     *volume
+		info     VolumeInfo
+		location Location
+	)
+	for _, v := range m.volumes {
+		locations, primary := m.alive(v)
+		if len(locations) == 0 || !locations[0].Primary {
+			continue
+		}
+		if primary.ReadOnly || primary.Free <= 0 || v.nextKey >= primary.NextKey+KeyWindow {
+			continue
+		}
+		if best == nil || primary.Free > info.Free {
+			best, info, location = v, primary, locations[0]
+		}
+	}
+	if best == nil {
+		return FileID{}, Location{}, ErrNoWritableVolumes
+	}
+	fid := FileID{
+		Volume: info.ID,
+		Key:    best.nextKey,
+		Cookie: cookie(),
+	}
+	best.nextKey++
+	return fid, location, nil
+}
+
+// Lookup returns locations of alive volume servers with volume, primary
+// first.
+func (m *Master) Lookup(id uint32) ([]Location, error) {
+	m.mux.Lock()
+	defer m.mux.Unlock()
+	m.init()
+	v, ok := m.volumes[id]
+	if !ok {
+		return nil, ErrVolumeNotFound
+	}
+	locations, _ := m.alive(v)
+	if len(locations) == 0 {
+		return nil, ErrVolumeNotFound
+	}
+	return locations, nil
+}
+
+func writeJSON(w http.ResponseWriter, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(v)
+}
+
+// ServeHTTP implements http.Handler.
+func (m *Master) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	switch r.URL.Path {
+	case "/dir/heartbeat":
+		if r.Method != http.MethodPost {
+			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		// empty secrets are equal, so master without secret is not joined
+		secret := r.Header.Get(SecretHeader)
+		if m.Secret == "" || subtle.ConstantTimeCompare([]byte(secret), []byte(m.Secret)) != 1 {
+			http.Error(w, "bad secret", http.StatusForbidden)
+			return
+		}
+		var h Heartbeat
+		if err := json.NewDecoder(r.Body).Decode(&h); err != nil || h.URL == "" {
+			http.Error(w, "bad heartbeat", http.StatusBadRequest)
+			return
+		}
+		m.Join(h)
+		w.WriteHeader(http.StatusNoContent)
+	case "/dir/assign":
+		fid, location, err := m.Assign()
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusServiceUnavailable)
+			return
+		}
+		writeJSON(w, Assignment{FileID: fid.String(), URL: location.URL})
+	case "/dir/lookup":
+		id, err := strconv.ParseUint(r.URL.Query().Get("volumeId"), 10, 32)
+		if err != nil {
+			http.Error(w, "bad volumeId", http.StatusBadRequest)
+			return
+		}
+		locations, err := m.Lookup(uint32(id))
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusNotFound)
+			return
+		}
+		writeJSON(w, Lookup{VolumeID: uint32(id), Locations: locations})
+	default:
+		http.NotFound(w, r)
+	}
+}
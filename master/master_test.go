@@ -0,0 +1,115 @@
+package master
+
+import (
+	"context"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestFileID(t *testing.T) {
+	f := FileID{Volume: 3, Key: 0x1a2b, Cookie: 0xdeadbeef}
+	s := f.String()
+	if s != "3,1a2b,deadbeef" {
+		t.Fatal("unexpected", s)
+	}
+	parsed, err := ParseFileID(s)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if parsed != f {
+		t.Fatal(parsed, "!=", f)
+	}
+	for _, bad := range []string{
+		"", "1", "1,2", "x,1,1", "1,-1,1", "1,1,1,1", "1,1,zz", "4294967296,1,1",
+	} {
+		if _, err := ParseFileID(bad); err != ErrBadFileID {
+			t.Error(bad, err)
+		}
+	}
+}
+
+func TestMaster(t *testing.T) {
+	now := time.Unix(100, 0)
+	m := &Master{Timeout: time.Second}
+	m.now = func() time.Time { return now }
+	if _, _, err := m.Assign(); err != ErrNoWritableVolumes {
+		t.Fatal(err)
+	}
+	m.Join(Heartbeat{URL: "a", Volumes: []VolumeInfo{
+		{ID: 1, Free: 10, NextKey: 5},
+		{ID: 2, Free: 100, ReadOnly: true},
+	}})
+	// state of replica does not change state of primary
+	m.Join(Heartbeat{URL: "b", Volumes: []VolumeInfo{
+		{ID: 1, Free: 0, NextKey: 3, ReadOnly: true, Replica: true},
+	}})
+	for i := int64(5); i < 8; i++ {
+		fid, location, err := m.Assign()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if fid.Volume != 1 || fid.Key != i {
+			t.Fatal("unexpected", fid)
+		}
+		if location.URL != "a" || !location.Primary {
+			t.Fatal("assigned on", location)
+		}
+	}
+	// keys are not assigned beyond window of volume
+	m.volumes[1].nextKey = 5 + KeyWindow
+	if _, _, err := m.Assign(); err != ErrNoWritableVolumes {
+		t.Fatal(err)
+	}
+	m.volumes[1].nextKey = 8
+	locations, err := m.Lookup(1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(locations) != 2 || locations[0] != (Location{URL: "a", Primary: true}) || locations[1].Primary {
+		t.Fatal("unexpected", locations)
+	}
+	if _, err = m.Lookup(3); err != ErrVolumeNotFound {
+		t.Fatal(err)
+	}
+	now = now.Add(2 * time.Second)
+	if _, err = m.Lookup(1); err != ErrVolumeNotFound {
+		t.Fatal(err)
+	}
+	if _, _, err = m.Assign(); err != ErrNoWritableVolumes {
+		t.Fatal(err)
+	}
+}
+
+func TestMaster_HeartbeatSecret(t *testing.T) {
+	h := Heartbeat{URL: "a", Volumes: []VolumeInfo{{ID: 1, Free: 10}}}
+	for _, tt := range []struct {
+		master, client string
+	}{
+		{"", ""},
+		{"", "secret"},
+		{"secret", ""},
+		{"secret", "bad"},
+	} {
+		m := &Master{Secret: tt.master}
+		s := httptest.NewServer(m)
+		c := Client{URL: s.URL, Secret: tt.client}
+		if err := c.Heartbeat(context.Background(), h); err == nil {
+			t.Error("heartbeat accepted", tt)
+		}
+		s.Close()
+		if _, _, err := m.Assign(); err != ErrNoWritableVolumes {
+			t.Error("volume joined", tt, err)
+		}
+	}
+	m := &Master{Secret: "secret"}
+	s := httptest.NewServer(m)
+	defer s.Close()
+	c := Client{URL: s.URL, Secret: "secret"}
+	if err := c.Heartbeat(context.Background(), h); err != nil {
+		t.Fatal(err)
+	}
+	if _, _, err := m.Assign(); err != nil {
+		t.Fatal(err)
+	}
+}
@@ -0,0 +1,40 @@
+// Command stok-master runs directory service of volume servers.
+package main
+
+import (
+	"flag"
+	"log"
+	"net/http"
+	"os"
+	"os/signal"
+
+	"github.com/cydev/stok/master"
+)
+
+var (
+	addr    = flag.String("addr", ":9333", "address to listen")
+	timeout = flag.Duration("timeout", master.DefaultTimeout, "time after which volume server without heartbeat is dead")
+	secret  = flag.String("secret", "", "secret, that is shared by master and volume servers, required")
+)
+
+func main() {
+	flag.Parse()
+	if *secret == "" {
+		// anyone could register volumes and receive uploads
+		log.Fatalln("secret is required")
+	}
+	s := &http.Server{
+		Addr:    *addr,
+		Handler: &master.Master{Timeout: *timeout, Secret: *secret},
+	}
+	go func() {
+		c := make(chan os.Signal, 1)
+		signal.Notify(c, os.Interrupt)
+		<-c
+		s.Close()
+	}()
+	log.Println("serving master on", *addr)
+	if err := s.ListenAndServe(); err != http.ErrServerClosed {
+		log.Fatalln("failed to serve:", err)
+	}
+}
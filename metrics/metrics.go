@@ -0,0 +1,251 @@
+// Package metrics implements counters, gauges and histograms, that are
+// grouped in registry and exposed in Prometheus text format.
+//
+// Observer collects metrics of storage layer. Blobs, files and indexes
+// without own stok.Observer report to observers registered in Default
+// registry, that is exposed by server on /metrics. Embedders can serve
+// Default registry with their own handlers or register own metrics in it.
+package metrics
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"math"
+	"net/http"
+	"sort"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// Metric is value that can be written in Prometheus text format.
+type Metric interface {
+	// Type returns Prometheus type of metric.
+	Type() string
+	// Write writes samples of metric with name.
+	Write(w io.Writer, name string) error
+}
+
+// Counter is monotonically increasing value. It is goroutine-safe.
+type Counter struct {
+	v uint64
+}
+
+// Add increases counter by n.
+func (c *Counter) Add(n uint64) {
+	atomic.AddUint64(&c.v, n)
+}
+
+// Inc increments counter.
+func (c *Counter) Inc() {
+	c.Add(1)
+}
+
+// Value returns current value of counter.
+func (c *Counter) Value() uint64 {
+	return atomic.LoadUint64(&c.v)
+}
+
+// Type implements Metric.
+func (c *Counter) Type() string {
+	return "counter"
+}
+
+// Write implements Metric.
+func (c *Counter) Write(w io.Writer, name string) error {
+	_, err := fmt.Fprintf(w, "%s %d\n", name, c.Value())
+	return err
+}
+
+// Gauge is value that can go up and down. It is goroutine-safe.
+type Gauge struct {
+	v int64
+}
+
+// Set sets value of gauge.
+func (g *Gauge) Set(v int64) {
+	atomic.StoreInt64(&g.v, v)
+}
+
+// Add adds d to gauge.
+func (g *Gauge) Add(d int64) {
+	atomic.AddInt64(&g.v, d)
+}
+
+// Value returns current value of gauge.
+func (g *Gauge) Value() int64 {
+	return atomic.LoadInt64(&g.v)
+}
+
+// Type implements Metric.
+func (g *Gauge) Type() string {
+	return "gauge"
+}
+
+// Write implements Metric.
+func (g *Gauge) Write(w io.Writer, name string) error {
+	_, err := fmt.Fprintf(w, "%s %d\n", name, g.Value())
+	return err
+}
+
+// DefaultBuckets are upper bounds of histogram buckets for latencies
+// of storage operations in seconds.
+var DefaultBuckets = []float64{
+	.00001, .00005, .0001, .0005, .001, .005, .01, .05, .1, .5, 1,
+}
+
+// Histogram counts observed values in buckets. It is goroutine-safe.
+type Histogram struct {
+	buckets []float64 // upper bounds
+	counts  []uint64  // count of values in bucket, last is +Inf
+	count   uint64
+	sum     uint64 // bits of float64
+}
+
+// NewHistogram returns histogram with upper bounds of buckets, that
+// should be sorted. DefaultBuckets are used if buckets is nil.
+func NewHistogram(buckets []float64) *Histogram {
+	if buckets == nil {
+		buckets = DefaultBuckets
+	}
+	return &Histogram{
+		buckets: buckets,
+		counts:  make([]uint64, len(buckets)+1),
+	}
+}
+
+// Observe adds v to histogram.
+func (h *Histogram) Observe(v float64) {
+	i := sort.SearchFloat64s(h.buckets, v)
+	atomic.AddUint64(&h.counts[i], 1)
+	atomic.AddUint64(&h.count, 1)
+	for {
+		old := atomic.LoadUint64(&h.sum)
+		sum := math.Float64bits(math.Float64frombits(old) + v)
+		if atomic.CompareAndSwapUint64(&h.sum, old, sum) {
+			return
+		}
+	}
+}
+
+// Since observes seconds elapsed since start.
+func (h *Histogram) Since(start time.Time) {
+	h.Observe(time.Since(start).Seconds())
+}
+
+// Count returns count of observed values.
+func (h *Histogram) Count() uint64 {
+	return atomic.LoadUint64(&h.count)
+}
+
+// Sum returns sum of observed values.
+func (h *Histogram) Sum() float64 {
+	return math.Float64frombits(atomic.LoadUint64(&h.sum))
+}
+
+// Type implements Metric.
+func (h *Histogram) Type() string {
+	return "histogram"
+}
+
+func formatFloat(v float64) string {
+	return strconv.FormatFloat(v, 'g', -1, 64)
+}
+
+// Write implements Metric.
+func (h *Histogram) Write(w io.Writer, name string) error {
+	var cumulative uint64
+	for i := range h.counts {
+		cumulative += atomic.LoadUint64(&h.counts[i])
+		le := "+Inf"
+		if i < len(h.buckets) {
+			le = formatFloat(h.buckets[i])
+		}
+		if _, err := fmt.Fprintf(w, "%s_bucket{le=%q} %d\n", name, le, cumulative); err != nil {
+			return err
+		}
+	}
+	_, err := fmt.Fprintf(w, "%s_sum %s\n%s_count %d\n", name, formatFloat(h.Sum()), name, cumulative)
+	return err
+}
+
+type entry struct {
+	name string
+	help string
+	m    Metric
+}
+
+// Registry is named set of metrics. It is goroutine-safe.
+type Registry struct {
+	mux     sync.RWMutex
+	metrics map[string]entry
+}
+
+// NewRegistry returns empty registry.
+func NewRegistry() *Registry {
+	return &Registry{metrics: make(map[string]entry)}
+}
+
+// Default is registry of stok metrics.
+var Default = NewRegistry()
+
+// Register adds metric with name and help text to registry.
+// Register panics if metric with the same name is already registered.
+func (r *Registry) Register(name, help string, m Metric) {
+	r.mux.Lock()
+	defer r.mux.Unlock()
+	if _, ok := r.metrics[name]; ok {
+		panic("metrics: duplicate metric " + name)
+	}
+	r.metrics[name] = entry{name: name, help: help, m: m}
+}
+
+// Get returns metric with name or nil if it is not registered.
+func (r *Registry) Get(name string) Metric {
+	r.mux.RLock()
+	defer r.mux.RUnlock()
+	return r.metrics[name].m
+}
+
+// WriteTo writes metrics in Prometheus text format, sorted by name.
+func (r *Registry) WriteTo(w io.Writer) (int64, error) {
+	r.mux.RLock()
+	entries := make([]entry, 0, len(r.metrics))
+	for _, e := range r.metrics {
+		entries = append(entries, e)
+	}
+	r.mux.RUnlock()
+	sort.Slice(entries, func(i, j int) bool {
+		return entries[i].name < entries[j].name
+	})
+	cw := &countingWriter{w: bufio.NewWriter(w)}
+	for _, e := range entries {
+		fmt.Fprintf(cw, "# HELP %s %s\n# TYPE %s %s\n", e.name, e.help, e.name, e.m.Type())
+		if err := e.m.Write(cw, e.name); err != nil {
+			return cw.n, err
+		}
+	}
+	return cw.n, cw.w.Flush()
+}
+
+// ContentType is content type of Prometheus text format.
+const ContentType = "text/plain; version=0.0.4; charset=utf-8"
+
+// ServeHTTP implements http.Handler, writing metrics of registry.
+func (r *Registry) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	w.Header().Set("Content-Type", ContentType)
+	r.WriteTo(w)
+}
+
+type countingWriter struct {
+	w *bufio.Writer
+	n int64
+}
+
+func (c *countingWriter) Write(p []byte) (int, error) {
+	n, err := c.w.Write(p)
+	c.n += int64(n)
+	return n, err
+}
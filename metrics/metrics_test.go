@@ -0,0 +1,55 @@
+package metrics
+
+import (
+	"bytes"
+	"testing"
+)
+
+func TestRegistry(t *testing.T) {
+	var (
+		r = NewRegistry()
+		c = new(Counter)
+		g = new(Gauge)
+		h = NewHistogram([]float64{1, 10})
+	)
+	r.Register("requests_total", "Count of requests.", c)
+	r.Register("size_bytes", "Size.", g)
+	r.Register("latency_seconds", "Latency.", h)
+	c.Add(2)
+	c.Inc()
+	g.Set(10)
+	g.Add(-3)
+	for _, v := range []float64{0.5, 1, 5, 100} {
+		h.Observe(v)
+	}
+	var buf bytes.Buffer
+	if _, err := r.WriteTo(&buf); err != nil {
+		t.Fatal(err)
+	}
+	expected := `# HELP latency_seconds Latency.
+# TYPE latency_seconds histogram
+latency_seconds_bucket{le="1"} 2
+latency_seconds_bucket{le="10"} 3
+latency_seconds_bucket{le="+Inf"} 4
+latency_seconds_sum 106.5
+latency_seconds_count 4
+# HELP requests_total Count of requests.
+# TYPE requests_total counter
+requests_total 3
+# HELP size_bytes Size.
+# TYPE size_bytes gauge
+size_bytes 7
+`
+	if buf.String() != expected {
+		t.Errorf("unexpected output:\n%s", buf.String())
+	}
+	if r.Get("requests_total") != c || r.Get("missing") != nil {
+		t.Error("bad Get")
+	}
+	defer func() {
+		if recover() == nil {
+			t.Error("expected panic on duplicate")
+		}
+	}()
+	r.Register("size_bytes", "", g)
+}
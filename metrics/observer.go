@@ -0,0 +1,104 @@
+package metrics
+
+import (
+	"time"
+
+	"github.com/cydev/stok"
+)
+
+// Observer is stok.Observer, that collects metrics of storage layer.
+// Size and Capacity are totals over open instances, that the observer
+// is attached to.
+type Observer struct {
+	Allocations     *Counter
+	AllocatedBytes  *Counter
+	WrittenBytes    *Counter
+	ReadBytes       *Counter
+	Errors          *Counter
+	Size            *Gauge
+	Capacity        *Gauge
+	WriteSeconds    *Histogram
+	ReadSeconds     *Histogram
+	TruncateSeconds *Histogram
+	SyncSeconds     *Histogram
+}
+
+// NewObserver returns Observer with metrics, that are registered in r
+// with names starting with prefix, like "stok_blob". Layer is used in
+// help texts.
+func NewObserver(r *Registry, prefix, layer string) *Observer {
+	o := &Observer{
+		Allocations:     new(Counter),
+		AllocatedBytes:  new(Counter),
+		WrittenBytes:    new(Counter),
+		ReadBytes:       new(Counter),
+		Errors:          new(Counter),
+		Size:            new(Gauge),
+		Capacity:        new(Gauge),
+		WriteSeconds:    NewHistogram(nil),
+		ReadSeconds:     NewHistogram(nil),
+		TruncateSeconds: NewHistogram(nil),
+		SyncSeconds:     NewHistogram(nil),
+	}
+	r.Register(prefix+"_allocations_total", "Count of "+layer+" allocations.", o.Allocations)
+	r.Register(prefix+"_allocated_bytes_total", "Bytes allocated in "+layer+".", o.AllocatedBytes)
+	r.Register(prefix+"_written_bytes_total", "Bytes written to "+layer+".", o.WrittenBytes)
+	r.Register(prefix+"_read_bytes_total", "Bytes read from "+layer+".", o.ReadBytes)
+	r.Register(prefix+"_errors_total", "Count of failed "+layer+" operations.", o.Errors)
+	r.Register(prefix+"_size_bytes", "Total size of open "+layer+".", o.Size)
+	r.Register(prefix+"_capacity_bytes", "Total capacity of open "+layer+".", o.Capacity)
+	r.Register(prefix+"_write_seconds", "Latency of "+layer+" writes.", o.WriteSeconds)
+	r.Register(prefix+"_read_seconds", "Latency of "+layer+" reads.", o.ReadSeconds)
+	r.Register(prefix+"_truncate_seconds", "Latency of "+layer+" truncation.", o.TruncateSeconds)
+	r.Register(prefix+"_sync_seconds", "Latency of "+layer+" sync.", o.SyncSeconds)
+	return o
+}
+
+// OnAllocate implements stok.Observer.
+func (o *Observer) OnAllocate(offset, size int64) {
+	o.Allocations.Inc()
+	o.AllocatedBytes.Add(uint64(size))
+	o.Size.Add(size)
+}
+
+// OnWrite implements stok.Observer.
+func (o *Observer) OnWrite(n int, d time.Duration) {
+	o.WrittenBytes.Add(uint64(n))
+	o.WriteSeconds.Observe(d.Seconds())
+}
+
+// OnRead implements stok.Observer.
+func (o *Observer) OnRead(n int, d time.Duration) {
+	o.ReadBytes.Add(uint64(n))
+	o.ReadSeconds.Observe(d.Seconds())
+}
+
+// OnTruncate implements stok.Observer.
+func (o *Observer) OnTruncate(old, size int64, d time.Duration) {
+	o.Capacity.Add(size - old)
+	o.TruncateSeconds.Observe(d.Seconds())
+}
+
+// OnSync implements stok.Observer.
+func (o *Observer) OnSync(d time.Duration) {
+	o.SyncSeconds.Observe(d.Seconds())
+}
+
+// OnError implements stok.Observer.
+func (o *Observer) OnError(op stok.Op, err error) {
+	o.Errors.Inc()
+}
+
+// OnOpen implements stok.Observer.
+func (o *Observer) OnOpen(size, capacity int64) {
+	o.Size.Add(size)
+	o.Capacity.Add(capacity)
+}
+
+// OnClose implements stok.Observer.
+func (o *Observer) OnClose(size, capacity int64) {
+	o.Size.Add(-size)
+	o.Capacity.Add(-capacity)
+}
+
+var _ stok.Observer = &Observer{}
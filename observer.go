@@ -0,0 +1,125 @@
+package stok
+
+import "time"
+
+// Op is storage operation, that is reported to Observer.
+type Op string
+
+// Operations of storage layers.
+const (
+	OpAllocate Op = "allocate"
+	OpWrite    Op = "write"
+	OpRead     Op = "read"
+	OpTruncate Op = "truncate"
+	OpSync     Op = "sync"
+)
+
+// Observer receives events of storage operations, so metrics, tracing,
+// audit logs or debugging tools can be attached to storage.Blob,
+// file.File and index.RWAtIndex. Methods are called synchronously after
+// operation completes, so they should be fast and goroutine-safe.
+type Observer interface {
+	// OnAllocate is called after size bytes are allocated at offset.
+	OnAllocate(offset, size int64)
+	// OnWrite is called after n bytes are written in d.
+	OnWrite(n int, d time.Duration)
+	// OnRead is called after n bytes are read in d.
+	OnRead(n int, d time.Duration)
+	// OnTruncate is called after capacity is changed from old to size in d.
+	OnTruncate(old, size int64, d time.Duration)
+	// OnSync is called after sync, that took d.
+	OnSync(d time.Duration)
+	// OnError is called after op failed with err.
+	OnError(op Op, err error)
+	// OnOpen is called after instance with size and capacity is opened.
+	OnOpen(size, capacity int64)
+	// OnClose is called when instance with size and capacity is closed.
+	OnClose(size, capacity int64)
+}
+
+// NopObserver is Observer that ignores all events. It can be used to
+// disable default observer of storage layer.
+type NopObserver struct{}
+
+// OnAllocate implements Observer.
+func (NopObserver) OnAllocate(offset, size int64) {}
+
+// OnWrite implements Observer.
+func (NopObserver) OnWrite(n int, d time.Duration) {}
+
+// OnRead implements Observer.
+func (NopObserver) OnRead(n int, d time.Duration) {}
+
+// OnTruncate implements Observer.
+func (NopObserver) OnTruncate(old, size int64, d time.Duration) {}
+
+// OnSync implements Observer.
+func (NopObserver) OnSync(d time.Duration) {}
+
+// OnError implements Observer.
+func (NopObserver) OnError(op Op, err error) {}
+
+// OnOpen implements Observer.
+func (NopObserver) OnOpen(size, capacity int64) {}
+
+// OnClose implements Observer.
+func (NopObserver) OnClose(size, capacity int64) {}
+
+// Observers is Observer that passes events to every observer in order.
+type Observers []Observer
+
+// OnAllocate implements Observer.
+func (o Observers) OnAllocate(offset, size int64) {
+	for _, v := range o {
+		v.OnAllocate(offset, size)
+	}
+}
+
+// OnWrite implements Observer.
+func (o Observers) OnWrite(n int, d time.Duration) {
+	for _, v := range o {
+		v.OnWrite(n, d)
+	}
+}
+
+// OnRead implements Observer.
+func (o Observers) OnRead(n int, d time.Duration) {
+	for _, v := range o {
+		v.OnRead(n, d)
+	}
+}
+
+// OnTruncate implements Observer.
+func (o Observers) OnTruncate(old, size int64, d time.Duration) {
+	for _, v := range o {
+		v.OnTruncate(old, size, d)
+	}
+}
+
+// OnSync implements Observer.
+func (o Observers) OnSync(d time.Duration) {
+	for _, v := range o {
+		v.OnSync(d)
+	}
+}
+
+// OnError implements Observer.
+func (o Observers) OnError(op Op, err error) {
+	for _, v := range o {
+		v.OnError(op, err)
+	}
+}
+
+// OnOpen implements Observer.
+func (o Observers) OnOpen(size, capacity int64) {
+	for _, v := range o {
+		v.OnOpen(size, capacity)
+	}
+}
+
+// OnClose implements Observer.
+func (o Observers) OnClose(size, capacity int64) {
+	for _, v := range o {
+		v.OnClose(size, capacity)
+	}
+}
@@ -0,0 +1,327 @@
+package s3
+
+import (
+	"bufio"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"hash"
+	"net/http"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// Credentials maps access key ids to secret access keys.
+type Credentials map[string]string
+
+// LoadCredentials reads credentials from file in format of AWS shared
+// credentials file, where every section has aws_access_key_id and
+// aws_secret_access_key.
+func LoadCredentials(path string) (Credentials, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	var (
+		c       = make(Credentials)
+		key     string
+		secret  string
+		s       = bufio.NewScanner(f)
+		line    int
+		section = func() {
+			if key != "" && secret != "" {
+				c[key] = secret
+			}
+			key, secret = "", ""
+		}
+	)
+	for s.Scan() {
+		line++
+		text := strings.TrimSpace(s.Text())
+		switch {
+		case text == "" || text[0] == '#' || text[0] == ';':
+			continue
+		case text[0] == '[':
+			section()
+			continue
+		}
+		i := strings.IndexByte(text, '=')
+		if i < 0 {
+			return nil, errors.Errorf("%s:%d: bad line", path, line)
+		}
+		switch strings.TrimSpace(text[:i]) {
+		case "aws_access_key_id":
+			key = strings.TrimSpace(text[i+1:])
+		case "aws_secret_access_key":
+			secret = strings.TrimSpace(text[i+1:])
+		}
+	}
+	section()
+	return c, errors.Wrap(s.Err(), "failed to read")
+}
+
+const (
+	algorithm  = "AWS4-HMAC-SHA256"
+	amzDate    = "20060102T150405Z"
+	scopeDate  = "20060102"
+	terminator = "aws4_request"
+
+	// UnsignedPayload is value of X-Amz-Content-Sha256 for requests with
+	// payload that is not signed.
+	UnsignedPayload = "UNSIGNED-PAYLOAD"
+	// EmptySHA256 is hex-encoded sha256 of empty payload.
+	EmptySHA256 = "e3b0c44298fc1c149afbf4c8996fb92427ae41e4649b934ca495991b7852b855"
+)
+
+// MaxSkew is max difference between time of request and server time.
+const MaxSkew = 15 * time.Minute
+
+// authorization is parsed Authorization header.
+type authorization struct {
+	key       string
+	date      string // date of scope
+	region    string
+	signed    []string // names of signed headers
+	signature string
+}
+
+func parseAuthorization(h string) (authorization, error) {
+	var a authorization
+	if !strings.HasPrefix(h, algorithm+" ") {
+		return a, errors.New("unsupported algorithm")
+	}
+	for _, part := range strings.Split(h[len(algorithm)+1:], ",") {
+		part = strings.TrimSpace(part)
+		i := strings.IndexByte(part, '=')
+		if i < 0 {
+			return a, errors.New("bad authorization")
+		}
+		v := part[i+1:]
+		switch part[:i] {
+		case "Credential":
+			scope := strings.Split(v, "/")
+			if len(scope) != 5 || scope[3] != "s3" || scope[4] != terminator {
+				return a, errors.New("bad credential scope")
+			}
+			a.key, a.date, a.region = scope[0], scope[1], scope[2]
+		case "SignedHeaders":
+			a.signed = strings.Split(v, ";")
+		case "Signature":
+			a.signature = v
+		}
+	}
+	if a.key == "" || len(a.signed) == 0 || a.signature == "" {
+		return a, errors.New("bad authorization")
+	}
+	// date and payload hash are always required, so they must be signed,
+	// otherwise request could be replayed with other time or body
+	for _, name := range requiredSigned {
+		if !a.signs(name) {
+			return a, errors.Errorf("%s is not signed", name)
+		}
+	}
+	return a, nil
+}
+
+// requiredSigned is names of headers, that must be signed.
+var requiredSigned = []string{"host", "x-amz-content-sha256", "x-amz-date"}
+
+// signs reports whether header is signed.
+func (a authorization) signs(name string) bool {
+	for _, s := range a.signed {
+		if s == name {
+			return true
+		}
+	}
+	return false
+}
+
+// uriEncode encodes s as required by SigV4, escaping every byte except
+// unreserved characters and, if path is true, slashes.
+func uriEncode(s string, path bool) string {
+	const hex = "0123456789ABCDEF"
+	var b strings.Builder
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+		switch {
+		case 'A' <= c && c <= 'Z', 'a' <= c && c <= 'z', '0' <= c && c <= '9',
+			c == '-', c == '_', c == '.', c == '~', c == '/' && path:
+			b.WriteByte(c)
+		default:
+			b.WriteByte('%')
+			b.WriteByte(hex[c>>4])
+			b.WriteByte(hex[c&15])
+		}
+	}
+	return b.String()
+}
+
+func canonicalQuery(r *http.Request) string {
+	q := r.URL.Query()
+	pairs := make([]string, 0, len(q))
+	for k, values := range q {
+		for _, v := range values {
+			pairs = append(pairs, uriEncode(k, false)+"="+uriEncode(v, false))
+		}
+	}
+	sort.Strings(pairs)
+	return strings.Join(pairs, "&")
+}
+
+func headerValue(r *http.Request, name string) string {
+	switch name {
+	case "host":
+		return r.Host
+	case "content-length":
+		return strconv.FormatInt(r.ContentLength, 10)
+	}
+	values := r.Header[http.CanonicalHeaderKey(name)]
+	trimmed := make([]string, len(values))
+	for i, v := range values {
+		trimmed[i] = strings.Join(strings.Fields(v), " ")
+	}
+	return strings.Join(trimmed, ",")
+}
+
+// canonicalRequest returns canonical request of SigV4.
+func canonicalRequest(r *http.Request, signed []string, payloadHash string) string {
+	var b strings.Builder
+	b.WriteString(r.Method)
+	b.WriteByte('\n')
+	b.WriteString(uriEncode(r.URL.Path, true))
+	b.WriteByte('\n')
+	b.WriteString(canonicalQuery(r))
+	b.WriteByte('\n')
+	for _, name := range signed {
+		b.WriteString(name)
+		b.WriteByte(':')
+		b.WriteString(headerValue(r, name))
+		b.WriteByte('\n')
+	}
+	b.WriteByte('\n')
+	b.WriteString(strings.Join(signed, ";"))
+	b.WriteByte('\n')
+	b.WriteString(payloadHash)
+	return b.String()
+}
+
+func hmacSHA256(key []byte, data string) []byte {
+	h := hmac.New(sha256.New, key)
+	h.Write([]byte(data))
+	return h.Sum(nil)
+}
+
+// signature returns hex-encoded SigV4 signature of request.
+func signature(r *http.Request, a authorization, secret, date, payloadHash string) string {
+	scope := a.date + "/" + a.region + "/s3/" + terminator
+	sum := sha256.Sum256([]byte(canonicalRequest(r, a.signed, payloadHash)))
+	toSign := algorithm + "\n" + date + "\n" + scope + "\n" + hex.EncodeToString(sum[:])
+	key := hmacSHA256([]byte("AWS4"+secret), a.date)
+	key = hmacSHA256(key, a.region)
+	key = hmacSHA256(key, "s3")
+	key = hmacSHA256(key, terminator)
+	return hex.EncodeToString(hmacSHA256(key, toSign))
+}
+
+// verify checks SigV4 signature of request against credentials before
+// body is read and returns payload hash from X-Amz-Content-Sha256, that
+// is signed, so body is checked against it while it is read.
+func (c Credentials) verify(r *http.Request, region string, now time.Time) (string, error) {
+	h := r.Header.Get("Authorization")
+	if h == "" {
+		return "", errAccessDenied
+	}
+	a, err := parseAuthorization(h)
+	if err != nil || a.region != region {
+		return "", errAuthorizationHeaderMalformed
+	}
+	secret, ok := c[a.key]
+	if !ok {
+		return "", errInvalidAccessKeyID
+	}
+	date := r.Header.Get("X-Amz-Date")
+	t, err := time.Parse(amzDate, date)
+	if err != nil || t.Format(scopeDate) != a.date {
+		return "", errAuthorizationHeaderMalformed
+	}
+	if d := now.Sub(t); d > MaxSkew || d < -MaxSkew {
+		return "", errRequestTimeTooSkewed
+	}
+	payloadHash := r.Header.Get("X-Amz-Content-Sha256")
+	switch {
+	case payloadHash == "":
+		return "", errMissingContentSHA256
+	case strings.HasPrefix(payloadHash, "STREAMING-"):
+		return "", errNotImplemented
+	}
+	expected := signature(r, a, secret, date, payloadHash)
+	if !hmac.Equal([]byte(expected), []byte(a.signature)) {
+		return "", errSignatureDoesNotMatch
+	}
+	return payloadHash, nil
+}
+
+// payloadHasher checks body against payload hash while it is read.
+type payloadHasher struct {
+	hash.Hash
+	expected string
+}
+
+// newPayloadHasher returns hasher of body with payload hash, or nil if
+// payload is not signed.
+func newPayloadHasher(payloadHash string) *payloadHasher {
+	if payloadHash == "" || payloadHash == UnsignedPayload {
+		return nil
+	}
+	return &payloadHasher{Hash: sha256.New(), expected: payloadHash}
+}
+
+// check returns errContentSHA256Mismatch if read body does not match
+// payload hash.
+func (p *payloadHasher) check() error {
+	if hex.EncodeToString(p.Sum(nil)) != p.expected {
+		return errContentSHA256Mismatch
+	}
+	return nil
+}
+
+// Sign signs request with SigV4 using payload hash from
+// X-Amz-Content-Sha256 header, that is set to UnsignedPayload if empty.
+// Host, X-Amz-Date and X-Amz-* headers are signed.
+func Sign(r *http.Request, key, secret, region string, now time.Time) {
+	date := now.UTC().Format(amzDate)
+	r.Header.Set("X-Amz-Date", date)
+	payloadHash := r.Header.Get("X-Amz-Content-Sha256")
+	if payloadHash == "" {
+		payloadHash = UnsignedPayload
+		r.Header.Set("X-Amz-Content-Sha256", payloadHash)
+	}
+	if r.Host == "" {
+		r.Host = r.URL.Host
+	}
+	signed := []string{"host"}
+	for name := range r.Header {
+		name = strings.ToLower(name)
+		if strings.HasPrefix(name, "x-amz-") || name == "content-md5" || name == "content-type" {
+			signed = append(signed, name)
+		}
+	}
+	sort.Strings(signed)
+	a := authorization{
+		key:    key,
+		date:   now.UTC().Format(scopeDate),
+		region: region,
+		signed: signed,
+	}
+	sig := signature(r, a, secret, date, payloadHash)
+	r.Header.Set("Authorization", algorithm+
+		" Credential="+key+"/"+a.date+"/"+region+"/s3/"+terminator+
+		", SignedHeaders="+strings.Join(signed, ";")+
+		", Signature="+sig)
+}
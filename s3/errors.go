@@ -0,0 +1,79 @@
+package s3
+
+import (
+	"encoding/xml"
+	"net/http"
+
+	"github.com/pkg/errors"
+)
+
+// apiError is error of S3 API, that is written to response as XML.
+type apiError struct {
+	status  int
+	code    string
+	message string
+}
+
+func (e *apiError) Error() string {
+	return e.code + ": " + e.message
+}
+
+var (
+	errAccessDenied                 = &apiError{http.StatusForbidden, "AccessDenied", "Access Denied"}
+	errAuthorizationHeaderMalformed = &apiError{http.StatusBadRequest, "AuthorizationHeaderMalformed", "The authorization header is malformed"}
+	errBadDigest                    = &apiError{http.StatusBadRequest, "BadDigest", "The Content-MD5 you specified did not match what was received"}
+	errBucketAlreadyOwnedByYou      = &apiError{http.StatusConflict, "BucketAlreadyOwnedByYou", "Your previous request to create the named bucket succeeded and you already own it"}
+	errBucketNotEmpty               = &apiError{http.StatusConflict, "BucketNotEmpty", "The bucket you tried to delete is not empty"}
+	errContentSHA256Mismatch        = &apiError{http.StatusBadRequest, "XAmzContentSHA256Mismatch", "The provided 'x-amz-content-sha256' header does not match what was computed"}
+	errEntityTooLarge               = &apiError{http.StatusBadRequest, "EntityTooLarge", "Your proposed upload exceeds the maximum allowed object size"}
+	errInternalError                = &apiError{http.StatusInternalServerError, "InternalError", "We encountered an internal error. Please try again"}
+	errInvalidAccessKeyID           = &apiError{http.StatusForbidden, "InvalidAccessKeyId", "The access key Id you provided does not exist in our records"}
+	errInvalidArgument              = &apiError{http.StatusBadRequest, "InvalidArgument", "Invalid Argument"}
+	errInvalidBucketName            = &apiError{http.StatusBadRequest, "InvalidBucketName", "The specified bucket is not valid"}
+	errInvalidDigest                = &apiError{http.StatusBadRequest, "InvalidDigest", "The Content-MD5 you specified is not valid"}
+	errInvalidPart                  = &apiError{http.StatusBadRequest, "InvalidPart", "One or more of the specified parts could not be found"}
+	errInvalidPartOrder             = &apiError{http.StatusBadRequest, "InvalidPartOrder", "The list of parts was not in ascending order"}
+	errKeyTooLong                   = &apiError{http.StatusBadRequest, "KeyTooLongError", "Your key is too long"}
+	errMalformedXML                 = &apiError{http.StatusBadRequest, "MalformedXML", "The XML you provided was not well-formed"}
+	errMethodNotAllowed             = &apiError{http.StatusMethodNotAllowed, "MethodNotAllowed", "The specified method is not allowed against this resource"}
+	errMissingContentSHA256         = &apiError{http.StatusBadRequest, "InvalidRequest", "Missing required header for this request: x-amz-content-sha256"}
+	errNoSuchBucket                 = &apiError{http.StatusNotFound, "NoSuchBucket", "The specified bucket does not exist"}
+	errNoSuchKey                    = &apiError{http.StatusNotFound, "NoSuchKey", "The specified key does not exist"}
+	errNoSuchUpload                 = &apiError{http.StatusNotFound, "NoSuchUpload", "The specified multipart upload does not exist"}
+	errNotImplemented               = &apiError{http.StatusNotImplemented, "NotImplemented", "A header or query you provided implies functionality that is not implemented"}
+	errRequestTimeTooSkewed         = &apiError{http.StatusForbidden, "RequestTimeTooSkewed", "The difference between the request time and the server's time is too large"}
+	errSignatureDoesNotMatch        = &apiError{http.StatusForbidden, "SignatureDoesNotMatch", "The request signature we calculated does not match the signature you provided"}
+)
+
+// errorResponse is body of error response.
+type errorResponse struct {
+	XMLName  xml.Name `xml:"Error"`
+	Code     string
+	Message  string
+	Resource string
+}
+
+// writeError writes err as S3 error response. Errors that are not
+// *apiError are reported as InternalError.
+func writeError(w http.ResponseWriter, r *http.Request, err error) {
+	e, ok := errors.Cause(err).(*apiError)
+	if !ok {
+		e = &apiError{errInternalError.status, errInternalError.code, err.Error()}
+	}
+	if r.Method == http.MethodHead {
+		w.WriteHeader(e.status)
+		return
+	}
+	writeXML(w, e.status, errorResponse{
+		Code:     e.code,
+		Message:  e.message,
+		Resource: r.URL.Path,
+	})
+}
+
+func writeXML(w http.ResponseWriter, status int, v interface{}) {
+	w.Header().Set("Content-Type", "application/xml")
+	w.WriteHeader(status)
+	w.Write([]byte(xml.Header))
+	xml.NewEncoder(w).Encode(v)
+}
@@ -0,0 +1,165 @@
+package s3
+
+import (
+	"encoding/base64"
+	"encoding/xml"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+
+	"github.com/cydev/stok/index/btree"
+	"github.com/pkg/errors"
+)
+
+// MaxKeys is max count of keys in ListObjectsV2 response.
+const MaxKeys = 1000
+
+// ObjectInfo is object in ListObjectsV2 response.
+type ObjectInfo struct {
+	Key          string
+	LastModified string
+	ETag         string
+	Size         int64
+	StorageClass string
+}
+
+// CommonPrefix is common prefix in ListObjectsV2 response.
+type CommonPrefix struct {
+	Prefix string
+}
+
+// ListObjectsResult is response of ListObjectsV2.
+type ListObjectsResult struct {
+	XMLName               xml.Name `xml:"http://s3.amazonaws.com/doc/2006-03-01/ ListBucketResult"`
+	Name                  string
+	Prefix                string
+	Delimiter             string `xml:",omitempty"`
+	StartAfter            string `xml:",omitempty"`
+	ContinuationToken     string `xml:",omitempty"`
+	NextContinuationToken string `xml:",omitempty"`
+	EncodingType          string `xml:",omitempty"`
+	KeyCount              int
+	MaxKeys               int
+	IsTruncated           bool
+	Contents              []ObjectInfo
+	CommonPrefixes        []CommonPrefix
+}
+
+var (
+	// errStop stops walking of tree.
+	errStop = errors.New("stop")
+	// errSkip stops walking of tree, that is continued from next start.
+	errSkip = errors.New("skip")
+)
+
+// listObjects implements ListObjectsV2. Continuation token is encoded
+// name of first key that is not listed.
+func (g *Gateway) listObjects(w http.ResponseWriter, bucket string, query url.Values) error {
+	if err := g.bucket(bucket); err != nil {
+		return err
+	}
+	result := ListObjectsResult{
+		Name:              bucket,
+		Prefix:            query.Get("prefix"),
+		Delimiter:         query.Get("delimiter"),
+		StartAfter:        query.Get("start-after"),
+		ContinuationToken: query.Get("continuation-token"),
+		EncodingType:      query.Get("encoding-type"),
+		MaxKeys:           MaxKeys,
+	}
+	if result.EncodingType != "" && result.EncodingType != "url" {
+		return errInvalidArgument
+	}
+	if s := query.Get("max-keys"); s != "" {
+		n, err := strconv.Atoi(s)
+		if err != nil || n < 0 {
+			return errInvalidArgument
+		}
+		if n < MaxKeys {
+			result.MaxKeys = n
+		}
+	}
+	var (
+		prefix = result.Prefix
+		base   = len(objectKey(bucket, ""))
+		start  = objectKey(bucket, prefix)
+		end    = btree.PrefixEnd(start)
+	)
+	if after := objectKey(bucket, result.StartAfter+"\x00"); result.StartAfter != "" && string(after) > string(start) {
+		start = after
+	}
+	if result.ContinuationToken != "" {
+		token, err := base64.RawURLEncoding.DecodeString(result.ContinuationToken)
+		if err != nil {
+			return errInvalidArgument
+		}
+		if next := objectKey(bucket, string(token)); string(next) > string(start) {
+			start = next
+		}
+	}
+	walk := func(k, v []byte) error {
+		name := string(k[base:])
+		if result.Delimiter != "" {
+			if i := strings.Index(name[len(prefix):], result.Delimiter); i >= 0 {
+				p := name[:len(prefix)+i+len(result.Delimiter)]
+				if result.KeyCount == result.MaxKeys {
+					result.IsTruncated = true
+					result.NextContinuationToken = base64.RawURLEncoding.EncodeToString([]byte(name))
+					return errStop
+				}
+				result.CommonPrefixes = append(result.CommonPrefixes, CommonPrefix{Prefix: p})
+				result.KeyCount++
+				// keys under common prefix are not listed, so they are skipped
+				start = btree.PrefixEnd(objectKey(bucket, p))
+				return errSkip
+			}
+		}
+		if result.KeyCount == result.MaxKeys {
+			result.IsTruncated = true
+			result.NextContinuationToken = base64.RawURLEncoding.EncodeToString([]byte(name))
+			return errStop
+		}
+		var o object
+		if _, err := o.Decode(v); err != nil {
+			return errors.Wrap(err, "failed to decode object")
+		}
+		result.Contents = append(result.Contents, ObjectInfo{
+			Key:          name,
+			LastModified: formatTime(o.Modified),
+			ETag:         `"` + o.ETag + `"`,
+			Size:         o.Size,
+			StorageClass: "STANDARD",
+		})
+		result.KeyCount++
+		return nil
+	}
+	for result.MaxKeys > 0 && start != nil && (end == nil || string(start) < string(end)) {
+		err := g.Tree.Range(start, end, walk)
+		if err == errSkip {
+			continue
+		}
+		if err != nil && err != errStop {
+			return err
+		}
+		break
+	}
+	if result.EncodingType == "url" {
+		result.encode()
+	}
+	writeXML(w, http.StatusOK, result)
+	return nil
+}
+
+// encode url-encodes keys and prefixes of result.
+func (result *ListObjectsResult) encode() {
+	result.Prefix = uriEncode(result.Prefix, true)
+	result.Delimiter = uriEncode(result.Delimiter, true)
+	result.StartAfter = uriEncode(result.StartAfter, true)
+	for i := range result.Contents {
+		result.Contents[i].Key = uriEncode(result.Contents[i].Key, true)
+	}
+	for i := range result.CommonPrefixes {
+		result.CommonPrefixes[i].Prefix = uriEncode(result.CommonPrefixes[i].Prefix, true)
+	}
+}
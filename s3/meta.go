@@ -0,0 +1,59 @@
+package s3
+
+//go:generate stok-binarygen -type object,upload,bucket -output meta_binary.go
+
+// object is metadata of object or uploaded part, that is stored in tree.
+type object struct {
+	Key         int64 // key of record in volume
+	Cookie      uint32
+	Size        int64
+	Modified    int64  // unix time in nanoseconds
+	ETag        string // hex-encoded md5 without quotes
+	ContentType string
+	Upload      string // id of completed multipart upload, whose parts are data of object
+}
+
+// upload is metadata of multipart upload.
+type upload struct {
+	Bucket      string
+	Key         string
+	ContentType string
+	Initiated   int64
+}
+
+// bucket is metadata of bucket.
+type bucket struct {
+	Created int64
+}
+
+// Prefixes of tree keys.
+const (
+	prefixBucket = 'b' // b<bucket>
+	prefixObject = 'o' // o<bucket>/<key>
+	prefixUpload = 'u' // u<upload id>
+	prefixPart   = 'p' // p<upload id><part number, 4 bytes>
+)
+
+func bucketKey(name string) []byte {
+	return append([]byte{prefixBucket}, name...)
+}
+
+func objectKey(bucket, key string) []byte {
+	k := make([]byte, 0, len(bucket)+len(key)+2)
+	k = append(k, prefixObject)
+	k = append(k, bucket...)
+	k = append(k, '/')
+	return append(k, key...)
+}
+
+func uploadKey(id string) []byte {
+	return append([]byte{prefixUpload}, id...)
+}
+
+func partPrefix(id string) []byte {
+	return append([]byte{prefixPart}, id...)
+}
+
+func partKey(id string, n int) []byte {
+	return append(partPrefix(id), byte(n>>24), byte(n>>16), byte(n>>8), byte(n))
+}
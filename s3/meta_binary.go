@@ -0,0 +1,88 @@
+// Code generated by "stok-binarygen -type object,upload,bucket -output meta_binary.go"; DO NOT EDIT.
+
+package s3
+
+import (
+	"github.com/cydev/stok/binary"
+)
+
+// Append encodes object to buf and returns it, implementing binary.Appender.
+func (o object) Append(buf []byte) []byte {
+	buf = binary.AppendInt64(buf, o.Key)
+	buf = binary.AppendUint32(buf, o.Cookie)
+	buf = binary.AppendInt64(buf, o.Size)
+	buf = binary.AppendInt64(buf, o.Modified)
+	buf = binary.AppendString(buf, o.ETag)
+	buf = binary.AppendString(buf, o.ContentType)
+	buf = binary.AppendString(buf, o.Upload)
+	return buf
+}
+
+// Decode decodes object from buf and returns rest of buf, implementing binary.Decoder.
+func (o *object) Decode(buf []byte) ([]byte, error) {
+	var err error
+	if buf, err = binary.DecodeInt64(buf, &o.Key); err != nil {
+		return buf, err
+	}
+	if buf, err = binary.DecodeUint32(buf, &o.Cookie); err != nil {
+		return buf, err
+	}
+	if buf, err = binary.DecodeInt64(buf, &o.Size); err != nil {
+		return buf, err
+	}
+	if buf, err = binary.DecodeInt64(buf, &o.Modified); err != nil {
+		return buf, err
+	}
+	if buf, err = binary.DecodeString(buf, &o.ETag); err != nil {
+		return buf, err
+	}
+	if buf, err = binary.DecodeString(buf, &o.ContentType); err != nil {
+		return buf, err
+	}
+	if buf, err = binary.DecodeString(buf, &o.Upload); err != nil {
+		return buf, err
+	}
+	return buf, nil
+}
+
+// Append encodes upload to buf and returns it, implementing binary.Appender.
+func (u upload) Append(buf []byte) []byte {
+	buf = binary.AppendString(buf, u.Bucket)
+	buf = binary.AppendString(buf, u.Key)
+	buf = binary.AppendString(buf, u.ContentType)
+	buf = binary.AppendInt64(buf, u.Initiated)
+	return buf
+}
+
+// Decode decodes upload from buf and returns rest of buf, implementing binary.Decoder.
+func (u *upload) Decode(buf []byte) ([]byte, error) {
+	var err error
+	if buf, err = binary.DecodeString(buf, &u.Bucket); err != nil {
+		return buf, err
+	}
+	if buf, err = binary.DecodeString(buf, &u.Key); err != nil {
+		return buf, err
+	}
+	if buf, err = binary.DecodeString(buf, &u.ContentType); err != nil {
+		return buf, err
+	}
+	if buf, err = binary.DecodeInt64(buf, &u.Initiated); err != nil {
+		return buf, err
+	}
+	return buf, nil
+}
+
+// Append encodes bucket to buf and returns it, implementing binary.Appender.
+func (b bucket) Append(buf []byte) []byte {
+	buf = binary.AppendInt64(buf, b.Created)
+	return buf
+}
+
+// Decode decodes bucket from buf and returns rest of buf, implementing binary.Decoder.
+func (b *bucket) Decode(buf []byte) ([]byte, error) {
+	var err error
+	if buf, err = binary.DecodeInt64(buf, &b.Created); err != nil {
+		return buf, err
+	}
+	return buf, nil
+}
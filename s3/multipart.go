@@ -0,0 +1,221 @@
+package s3
+
+import (
+	"crypto/md5"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/xml"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/cydev/stok/index/btree"
+	"github.com/pkg/errors"
+)
+
+// MaxParts is max count of parts in multipart upload.
+const MaxParts = 10000
+
+// InitiateMultipartUploadResult is response of CreateMultipartUpload.
+type InitiateMultipartUploadResult struct {
+	XMLName  xml.Name `xml:"http://s3.amazonaws.com/doc/2006-03-01/ InitiateMultipartUploadResult"`
+	Bucket   string
+	Key      string
+	UploadID string `xml:"UploadId"`
+}
+
+// CompletedPart is part in CompleteMultipartUpload request.
+type CompletedPart struct {
+	PartNumber int
+	ETag       string
+}
+
+// CompleteMultipartUpload is request of CompleteMultipartUpload.
+type CompleteMultipartUpload struct {
+	XMLName xml.Name        `xml:"CompleteMultipartUpload"`
+	Parts   []CompletedPart `xml:"Part"`
+}
+
+// CompleteMultipartUploadResult is response of CompleteMultipartUpload.
+type CompleteMultipartUploadResult struct {
+	XMLName  xml.Name `xml:"http://s3.amazonaws.com/doc/2006-03-01/ CompleteMultipartUploadResult"`
+	Location string
+	Bucket   string
+	Key      string
+	ETag     string
+}
+
+// upload returns upload with id, that is started for key in bucket.
+func (g *Gateway) upload(id, bucket, key string) (upload, error) {
+	var u upload
+	if _, err := hex.DecodeString(id); err != nil || len(id) != 32 {
+		return u, errNoSuchUpload
+	}
+	v, err := g.Tree.Get(uploadKey(id), nil)
+	if err == btree.ErrNotFound {
+		return u, errNoSuchUpload
+	}
+	if err != nil {
+		return u, err
+	}
+	if _, err = u.Decode(v); err != nil {
+		return u, errors.Wrap(err, "failed to decode upload")
+	}
+	if u.Bucket != bucket || u.Key != key {
+		return u, errNoSuchUpload
+	}
+	return u, nil
+}
+
+func (g *Gateway) createUpload(w http.ResponseWriter, r *http.Request, bucket, key string) error {
+	if err := g.bucket(bucket); err != nil {
+		return err
+	}
+	var raw [16]byte
+	if _, err := rand.Read(raw[:]); err != nil {
+		return errors.Wrap(err, "failed to generate upload id")
+	}
+	id := hex.EncodeToString(raw[:])
+	u := upload{
+		Bucket:      bucket,
+		Key:         key,
+		ContentType: r.Header.Get("Content-Type"),
+		Initiated:   time.Now().UnixNano(),
+	}
+	switch err := g.Tree.Set(uploadKey(id), u.Append(nil)); errors.Cause(err) {
+	case nil:
+	case btree.ErrValueSize:
+		return errInvalidArgument
+	default:
+		return err
+	}
+	writeXML(w, http.StatusOK, InitiateMultipartUploadResult{
+		Bucket:   bucket,
+		Key:      key,
+		UploadID: id,
+	})
+	return nil
+}
+
+func (g *Gateway) uploadPart(w http.ResponseWriter, r *http.Request, id, bucket, key string, data []byte) error {
+	n, err := strconv.Atoi(r.URL.Query().Get("partNumber"))
+	if err != nil || n < 1 || n > MaxParts {
+		return errInvalidArgument
+	}
+	check := func() error {
+		_, err := g.upload(id, bucket, key)
+		return err
+	}
+	if err = check(); err != nil {
+		return err
+	}
+	o, err := g.store(r, data)
+	if err != nil {
+		return err
+	}
+	if err = g.replace(partKey(id, n), o, check); err != nil {
+		return err
+	}
+	w.Header().Set("ETag", `"`+o.ETag+`"`)
+	w.WriteHeader(http.StatusOK)
+	return nil
+}
+
+// completeUpload creates object from listed parts, that are kept as data
+// of object, and removes other parts. ETag of object is md5 of md5 sums
+// of parts with count of parts, as in S3.
+func (g *Gateway) completeUpload(w http.ResponseWriter, id, bucket, key string, body []byte) error {
+	var req CompleteMultipartUpload
+	if err := xml.Unmarshal(body, &req); err != nil || len(req.Parts) == 0 {
+		return errMalformedXML
+	}
+	g.mux.Lock()
+	defer g.mux.Unlock()
+	if err := g.bucket(bucket); err != nil {
+		return err
+	}
+	u, err := g.upload(id, bucket, key)
+	if err != nil {
+		return err
+	}
+	parts, err := g.parts(id)
+	if err != nil {
+		return err
+	}
+	uploaded := make(map[int]part, len(parts))
+	for _, p := range parts {
+		uploaded[p.number] = p
+	}
+	var (
+		hash   = md5.New()
+		size   int64
+		prev   int
+		listed = make(map[int]bool, len(req.Parts))
+	)
+	for _, c := range req.Parts {
+		if c.PartNumber <= prev {
+			return errInvalidPartOrder
+		}
+		prev = c.PartNumber
+		p, ok := uploaded[c.PartNumber]
+		if !ok || strings.Trim(c.ETag, `"`) != p.ETag {
+			return errInvalidPart
+		}
+		sum, err := hex.DecodeString(p.ETag)
+		if err != nil {
+			return errors.Wrap(err, "failed to decode etag")
+		}
+		hash.Write(sum)
+		size += p.Size
+		listed[p.number] = true
+	}
+	for _, p := range parts {
+		if listed[p.number] {
+			continue
+		}
+		if err = g.Tree.Delete(partKey(id, p.number)); err != nil {
+			return err
+		}
+		if err = g.remove(p.object); err != nil {
+			return err
+		}
+	}
+	o := object{
+		Size:        size,
+		Modified:    time.Now().UnixNano(),
+		ETag:        hex.EncodeToString(hash.Sum(nil)) + "-" + strconv.Itoa(len(req.Parts)),
+		ContentType: u.ContentType,
+		Upload:      id,
+	}
+	if err = g.set(objectKey(bucket, key), o); err != nil {
+		return err
+	}
+	if err = g.Tree.Delete(uploadKey(id)); err != nil {
+		return err
+	}
+	writeXML(w, http.StatusOK, CompleteMultipartUploadResult{
+		Location: "/" + bucket + "/" + key,
+		Bucket:   bucket,
+		Key:      key,
+		ETag:     `"` + o.ETag + `"`,
+	})
+	return nil
+}
+
+func (g *Gateway) abortUpload(w http.ResponseWriter, id, bucket, key string) error {
+	g.mux.Lock()
+	defer g.mux.Unlock()
+	if _, err := g.upload(id, bucket, key); err != nil {
+		return err
+	}
+	// object with upload id owns all parts of upload
+	if err := g.remove(object{Upload: id}); err != nil {
+		return err
+	}
+	if err := g.Tree.Delete(uploadKey(id)); err != nil {
+		return err
+	}
+	w.WriteHeader(http.StatusNoContent)
+	return nil
+}
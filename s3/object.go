@@ -0,0 +1,257 @@
+package s3
+
+import (
+	"bytes"
+	"crypto/md5"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/hex"
+	"io"
+	"net/http"
+	"time"
+
+	"github.com/cydev/stok/index/btree"
+	"github.com/cydev/stok/volume"
+	"github.com/pkg/errors"
+)
+
+// object returns metadata of object or part with tree key.
+func (g *Gateway) object(key []byte) (object, error) {
+	var o object
+	v, err := g.Tree.Get(key, nil)
+	if err != nil {
+		return o, err
+	}
+	_, err = o.Decode(v)
+	return o, errors.Wrap(err, "failed to decode object")
+}
+
+// store writes data to volume, checking Content-MD5 of request,
+// and returns metadata of written record.
+func (g *Gateway) store(r *http.Request, data []byte) (object, error) {
+	sum := md5.Sum(data)
+	if h := r.Header.Get("Content-Md5"); h != "" {
+		expected, err := base64.StdEncoding.DecodeString(h)
+		if err != nil || len(expected) != md5.Size {
+			return object{}, errInvalidDigest
+		}
+		if !bytes.Equal(expected, sum[:]) {
+			return object{}, errBadDigest
+		}
+	}
+	id, cookie, err := g.Volume.Put(data)
+	if err != nil {
+		return object{}, errors.Wrap(err, "failed to write")
+	}
+	return object{
+		Key:      id,
+		Cookie:   cookie,
+		Size:     int64(len(data)),
+		Modified: time.Now().UnixNano(),
+		ETag:     hex.EncodeToString(sum[:]),
+	}, nil
+}
+
+// replace sets metadata of tree key to o if check succeeds, and removes
+// data of previous value of key. Data of o is removed if it fails.
+func (g *Gateway) replace(key []byte, o object, check func() error) error {
+	g.mux.Lock()
+	defer g.mux.Unlock()
+	err := check()
+	if err == nil {
+		err = g.set(key, o)
+	}
+	if err != nil {
+		g.remove(o)
+		return err
+	}
+	return nil
+}
+
+// set sets metadata of tree key to o and removes data of previous value
+// of key. Should be called with g.mux held.
+func (g *Gateway) set(key []byte, o object) error {
+	old, err := g.object(key)
+	exists := err == nil
+	if err != nil && err != btree.ErrNotFound {
+		return err
+	}
+	switch err = g.Tree.Set(key, o.Append(nil)); errors.Cause(err) {
+	case nil:
+	case btree.ErrValueSize:
+		return errInvalidArgument
+	default:
+		return err
+	}
+	if exists {
+		return g.remove(old)
+	}
+	return nil
+}
+
+// remove deletes data of object from volume. Should be called with
+// g.mux held.
+func (g *Gateway) remove(o object) error {
+	if o.Upload == "" {
+		err := g.Volume.Delete(o.Key, o.Cookie)
+		if errors.Cause(err) == volume.ErrNotFound {
+			return nil
+		}
+		return err
+	}
+	parts, err := g.parts(o.Upload)
+	if err != nil {
+		return err
+	}
+	for _, p := range parts {
+		if err = g.Tree.Delete(partKey(o.Upload, p.number)); err != nil {
+			return err
+		}
+		if err = g.remove(p.object); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// part is uploaded part of multipart upload.
+type part struct {
+	number int
+	object
+}
+
+// parts returns parts of upload in order of their numbers.
+func (g *Gateway) parts(id string) ([]part, error) {
+	var parts []part
+	err := g.Tree.Prefix(partPrefix(id), func(k, v []byte) error {
+		p := part{number: int(binary.BigEndian.Uint32(k[len(k)-4:]))}
+		if _, err := p.Decode(v); err != nil {
+			return errors.Wrap(err, "failed to decode part")
+		}
+		parts = append(parts, p)
+		return nil
+	})
+	return parts, err
+}
+
+func (g *Gateway) putObject(w http.ResponseWriter, r *http.Request, bucket, key string, data []byte) error {
+	if err := g.bucket(bucket); err != nil {
+		return err
+	}
+	o, err := g.store(r, data)
+	if err != nil {
+		return err
+	}
+	o.ContentType = r.Header.Get("Content-Type")
+	err = g.replace(objectKey(bucket, key), o, func() error {
+		return g.bucket(bucket)
+	})
+	if err != nil {
+		return err
+	}
+	w.Header().Set("ETag", `"`+o.ETag+`"`)
+	w.WriteHeader(http.StatusOK)
+	return nil
+}
+
+func (g *Gateway) getObject(w http.ResponseWriter, r *http.Request, bucket, key string) error {
+	if err := g.bucket(bucket); err != nil {
+		return err
+	}
+	o, err := g.object(objectKey(bucket, key))
+	if err == btree.ErrNotFound {
+		return errNoSuchKey
+	}
+	if err != nil {
+		return err
+	}
+	content, err := g.open(o)
+	if errors.Cause(err) == volume.ErrNotFound {
+		// object is replaced or deleted concurrently
+		return errNoSuchKey
+	}
+	if err != nil {
+		return err
+	}
+	contentType := o.ContentType
+	if contentType == "" {
+		contentType = "binary/octet-stream"
+	}
+	h := w.Header()
+	h.Set("Content-Type", contentType)
+	h.Set("ETag", `"`+o.ETag+`"`)
+	http.ServeContent(w, r, "", time.Unix(0, o.Modified), content)
+	return nil
+}
+
+// open returns reader of object data.
+func (g *Gateway) open(o object) (io.ReadSeeker, error) {
+	if o.Upload == "" {
+		_, r, err := g.Volume.Reader(o.Key, o.Cookie)
+		return r, err
+	}
+	parts, err := g.parts(o.Upload)
+	if err != nil {
+		return nil, err
+	}
+	c := make(concat, 0, len(parts))
+	for _, p := range parts {
+		_, r, err := g.Volume.Reader(p.Key, p.Cookie)
+		if err != nil {
+			return nil, err
+		}
+		c = append(c, r)
+	}
+	return io.NewSectionReader(c, 0, o.Size), nil
+}
+
+// concat is io.ReaderAt that reads sections in sequence.
+type concat []*io.SectionReader
+
+func (c concat) ReadAt(p []byte, off int64) (int, error) {
+	n := 0
+	for _, s := range c {
+		if n == len(p) {
+			return n, nil
+		}
+		if off >= s.Size() {
+			off -= s.Size()
+			continue
+		}
+		m, err := s.ReadAt(p[n:], off)
+		n += m
+		if err != nil && err != io.EOF {
+			return n, err
+		}
+		off = 0
+	}
+	if n < len(p) {
+		return n, io.EOF
+	}
+	return n, nil
+}
+
+func (g *Gateway) deleteObject(w http.ResponseWriter, bucket, key string) error {
+	g.mux.Lock()
+	defer g.mux.Unlock()
+	if err := g.bucket(bucket); err != nil {
+		return err
+	}
+	k := objectKey(bucket, key)
+	o, err := g.object(k)
+	switch err {
+	case nil:
+		if err = g.Tree.Delete(k); err != nil {
+			return err
+		}
+		if err = g.remove(o); err != nil {
+			return err
+		}
+	case btree.ErrNotFound:
+		// deleting missing object succeeds
+	default:
+		return err
+	}
+	w.WriteHeader(http.StatusNoContent)
+	return nil
+}
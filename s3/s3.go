@@ -0,0 +1,293 @@
+// Package s3 implements subset of Amazon S3 API on top of volume, so
+// existing S3 tools can be used with stok.
+//
+//	GET /                                   ListBuckets
+//	PUT, HEAD, DELETE /{bucket}             CreateBucket, HeadBucket, DeleteBucket
+//	GET /{bucket}?list-type=2               ListObjectsV2 with prefix, delimiter and pagination
+//	PUT, GET, HEAD, DELETE /{bucket}/{key}  PutObject, GetObject, HeadObject, DeleteObject
+//	POST /{bucket}/{key}?uploads            CreateMultipartUpload
+//	PUT /{bucket}/{key}?partNumber&uploadId UploadPart
+//	POST /{bucket}/{key}?uploadId           CompleteMultipartUpload
+//	DELETE /{bucket}/{key}?uploadId         AbortMultipartUpload
+//
+// Only path-style addressing is supported. Data of objects and parts is
+// stored as volume records, and metadata is stored in B+tree. Parts of
+// completed multipart upload are kept as separate records, and GetObject
+// reads them in sequence, so size of object is not limited by MaxSize.
+//
+// Requests are authenticated with AWS Signature Version 4 in Authorization
+// header, if Credentials are set. Signature is checked before body is
+// read, and body is checked against signed payload hash while it is read.
+// Presigned urls and chunked payload signing are not supported.
+package s3
+
+import (
+	"encoding/xml"
+	"io"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/cydev/stok/index/btree"
+	"github.com/cydev/stok/storage"
+	"github.com/cydev/stok/volume"
+	"github.com/pkg/errors"
+	"github.com/valyala/bytebufferpool"
+)
+
+// DefaultMaxSize is default max size of request body.
+const DefaultMaxSize = 64 * 1024 * 1024
+
+// DefaultRegion is default region of gateway.
+const DefaultRegion = "us-east-1"
+
+// Gateway serves S3 API over HTTP.
+type Gateway struct {
+	Volume *volume.Volume
+	// Tree stores metadata of buckets, objects and uploads.
+	Tree *btree.Tree
+	// Credentials are used to authenticate requests.
+	// Authentication is disabled if nil.
+	Credentials Credentials
+	// Region is region in credential scope of requests, DefaultRegion
+	// if empty.
+	Region string
+	// MaxSize is max size of request body, that limits size of object
+	// uploaded with PutObject and size of part. DefaultMaxSize if zero.
+	MaxSize int64
+
+	mux sync.Mutex // guards metadata updates
+}
+
+func (g *Gateway) region() string {
+	if g.Region == "" {
+		return DefaultRegion
+	}
+	return g.Region
+}
+
+func (g *Gateway) maxSize() int64 {
+	if g.MaxSize == 0 {
+		return DefaultMaxSize
+	}
+	return g.MaxSize
+}
+
+// ServeHTTP implements http.Handler.
+func (g *Gateway) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	b := storage.AcquireByteBuffer()
+	defer storage.ReleaseByteBuffer(b)
+	if err := g.serve(w, r, b); err != nil {
+		writeError(w, r, err)
+	}
+}
+
+func (g *Gateway) serve(w http.ResponseWriter, r *http.Request, b *bytebufferpool.ByteBuffer) error {
+	var payloadHash string
+	if g.Credentials != nil {
+		var err error
+		if payloadHash, err = g.Credentials.verify(r, g.region(), time.Now()); err != nil {
+			return err
+		}
+	}
+	if err := g.body(r, b, payloadHash); err != nil {
+		return err
+	}
+	path := strings.TrimPrefix(r.URL.Path, "/")
+	if path == "" {
+		if r.Method != http.MethodGet {
+			return errMethodNotAllowed
+		}
+		return g.listBuckets(w)
+	}
+	name, key := path, ""
+	if i := strings.IndexByte(path, '/'); i >= 0 {
+		name, key = path[:i], path[i+1:]
+	}
+	if !validBucket(name) {
+		return errInvalidBucketName
+	}
+	query := r.URL.Query()
+	if key == "" {
+		switch r.Method {
+		case http.MethodPut:
+			return g.createBucket(w, name)
+		case http.MethodHead:
+			if err := g.bucket(name); err != nil {
+				return err
+			}
+			w.WriteHeader(http.StatusOK)
+			return nil
+		case http.MethodDelete:
+			return g.deleteBucket(w, name)
+		case http.MethodGet:
+			if query.Get("list-type") != "2" {
+				return errNotImplemented
+			}
+			return g.listObjects(w, name, query)
+		}
+		return errMethodNotAllowed
+	}
+	if len(objectKey(name, key)) > btree.MaxKeySize {
+		return errKeyTooLong
+	}
+	_, uploads := query["uploads"]
+	uploadID := query.Get("uploadId")
+	switch {
+	case r.Method == http.MethodPut && uploadID != "":
+		return g.uploadPart(w, r, uploadID, name, key, b.B)
+	case r.Method == http.MethodPut && r.Header.Get("X-Amz-Copy-Source") != "":
+		return errNotImplemented
+	case r.Method == http.MethodPut:
+		return g.putObject(w, r, name, key, b.B)
+	case r.Method == http.MethodGet || r.Method == http.MethodHead:
+		return g.getObject(w, r, name, key)
+	case r.Method == http.MethodDelete && uploadID != "":
+		return g.abortUpload(w, uploadID, name, key)
+	case r.Method == http.MethodDelete:
+		return g.deleteObject(w, name, key)
+	case r.Method == http.MethodPost && uploads:
+		return g.createUpload(w, r, name, key)
+	case r.Method == http.MethodPost && uploadID != "":
+		return g.completeUpload(w, uploadID, name, key, b.B)
+	}
+	return errMethodNotAllowed
+}
+
+// body reads request body to b and checks it against signed payload
+// hash, if any.
+func (g *Gateway) body(r *http.Request, b *bytebufferpool.ByteBuffer, payloadHash string) error {
+	max := g.maxSize()
+	if r.ContentLength > max {
+		return errEntityTooLarge
+	}
+	var (
+		body = io.LimitReader(r.Body, max+1)
+		h    = newPayloadHasher(payloadHash)
+	)
+	if h != nil {
+		body = io.TeeReader(body, h)
+	}
+	n, err := b.ReadFrom(body)
+	if err != nil {
+		return errors.Wrap(err, "failed to read body")
+	}
+	if n > max {
+		return errEntityTooLarge
+	}
+	if h != nil {
+		return h.check()
+	}
+	return nil
+}
+
+// validBucket reports whether name is valid bucket name.
+func validBucket(name string) bool {
+	if len(name) < 3 || len(name) > 63 {
+		return false
+	}
+	for i := 0; i < len(name); i++ {
+		c := name[i]
+		alnum := 'a' <= c && c <= 'z' || '0' <= c && c <= '9'
+		edge := i == 0 || i == len(name)-1
+		if !alnum && (edge || c != '-' && c != '.') {
+			return false
+		}
+	}
+	return true
+}
+
+// bucket returns errNoSuchBucket if bucket does not exist.
+func (g *Gateway) bucket(name string) error {
+	_, err := g.Tree.Get(bucketKey(name), nil)
+	if err == btree.ErrNotFound {
+		return errNoSuchBucket
+	}
+	return err
+}
+
+func (g *Gateway) createBucket(w http.ResponseWriter, name string) error {
+	g.mux.Lock()
+	defer g.mux.Unlock()
+	if err := g.bucket(name); err != errNoSuchBucket {
+		if err == nil {
+			err = errBucketAlreadyOwnedByYou
+		}
+		return err
+	}
+	b := bucket{Created: time.Now().UnixNano()}
+	if err := g.Tree.Set(bucketKey(name), b.Append(nil)); err != nil {
+		return err
+	}
+	w.Header().Set("Location", "/"+name)
+	w.WriteHeader(http.StatusOK)
+	return nil
+}
+
+func (g *Gateway) deleteBucket(w http.ResponseWriter, name string) error {
+	g.mux.Lock()
+	defer g.mux.Unlock()
+	if err := g.bucket(name); err != nil {
+		return err
+	}
+	prefix := objectKey(name, "")
+	err := g.Tree.Range(prefix, btree.PrefixEnd(prefix), func(k, v []byte) error {
+		return errBucketNotEmpty
+	})
+	if err != nil {
+		return err
+	}
+	if err = g.Tree.Delete(bucketKey(name)); err != nil {
+		return err
+	}
+	w.WriteHeader(http.StatusNoContent)
+	return nil
+}
+
+// owner is owner of all buckets.
+var owner = Owner{ID: "stok", DisplayName: "stok"}
+
+// Owner is owner of bucket.
+type Owner struct {
+	ID          string
+	DisplayName string
+}
+
+// BucketInfo is bucket in ListBuckets response.
+type BucketInfo struct {
+	Name         string
+	CreationDate string
+}
+
+// ListBucketsResult is response of ListBuckets.
+type ListBucketsResult struct {
+	XMLName xml.Name `xml:"http://s3.amazonaws.com/doc/2006-03-01/ ListAllMyBucketsResult"`
+	Owner   Owner
+	Buckets []BucketInfo `xml:"Buckets>Bucket"`
+}
+
+func (g *Gateway) listBuckets(w http.ResponseWriter) error {
+	result := ListBucketsResult{Owner: owner}
+	err := g.Tree.Prefix([]byte{prefixBucket}, func(k, v []byte) error {
+		var b bucket
+		if _, err := b.Decode(v); err != nil {
+			return err
+		}
+		result.Buckets = append(result.Buckets, BucketInfo{
+			Name:         string(k[1:]),
+			CreationDate: formatTime(b.Created),
+		})
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	writeXML(w, http.StatusOK, result)
+	return nil
+}
+
+// formatTime formats unix time in nanoseconds as in S3 responses.
+func formatTime(t int64) string {
+	return time.Unix(0, t).UTC().Format("2006-01-02T15:04:05.000Z")
+}
@@ -0,0 +1,399 @@
+package s3
+
+import (
+	"bytes"
+	"crypto/md5"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/xml"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/cydev/stok/index/btree"
+	"github.com/cydev/stok/stokutils"
+	"github.com/cydev/stok/volume"
+)
+
+const (
+	testKey    = "key"
+	testSecret = "secret"
+)
+
+func newGateway(t testing.TB) (*httptest.Server, func()) {
+	dir, err := ioutil.TempDir("", "s3")
+	if err != nil {
+		t.Fatal(err)
+	}
+	v, err := volume.Open(filepath.Join(dir, "volume"), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	f, err := os.OpenFile(filepath.Join(dir, "meta"), os.O_RDWR|os.O_CREATE, 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tree, err := btree.Open(f)
+	if err != nil {
+		t.Fatal(err)
+	}
+	g := &Gateway{
+		Volume:      v,
+		Tree:        tree,
+		Credentials: Credentials{testKey: testSecret},
+		MaxSize:     1024,
+	}
+	ts := httptest.NewServer(g)
+	return ts, func() {
+		ts.Close()
+		stokutils.MustClose(t, tree)
+		stokutils.MustClose(t, v)
+		os.RemoveAll(dir)
+	}
+}
+
+// do sends signed request with body and returns response and its body.
+func do(t testing.TB, method, u string, body []byte, header ...string) (*http.Response, []byte) {
+	req, err := http.NewRequest(method, u, bytes.NewReader(body))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < len(header); i += 2 {
+		req.Header.Set(header[i], header[i+1])
+	}
+	sum := sha256.Sum256(body)
+	req.Header.Set("X-Amz-Content-Sha256", hex.EncodeToString(sum[:]))
+	Sign(req, testKey, testSecret, DefaultRegion, time.Now())
+	res, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer res.Body.Close()
+	data, err := ioutil.ReadAll(res.Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return res, data
+}
+
+// expect checks status of response and code of error in body.
+func expect(t testing.TB, res *http.Response, body []byte, status int, code string) {
+	t.Helper()
+	if res.StatusCode != status {
+		t.Fatal("status", res.Status, string(body))
+	}
+	if code == "" {
+		return
+	}
+	var e errorResponse
+	if err := xml.Unmarshal(body, &e); err != nil {
+		t.Fatal(err)
+	}
+	if e.Code != code {
+		t.Fatal(e.Code, "!=", code)
+	}
+}
+
+func TestGateway(t *testing.T) {
+	ts, clear := newGateway(t)
+	defer clear()
+	var (
+		bucket = ts.URL + "/bucket"
+		object = bucket + "/dir/hello world.txt"
+		data   = []byte("hello, stok")
+		sum    = md5.Sum(data)
+		etag   = `"` + hex.EncodeToString(sum[:]) + `"`
+	)
+	res, body := do(t, http.MethodPut, object, data)
+	expect(t, res, body, http.StatusNotFound, "NoSuchBucket")
+	res, body = do(t, http.MethodPut, bucket, nil)
+	expect(t, res, body, http.StatusOK, "")
+	res, body = do(t, http.MethodPut, bucket, nil)
+	expect(t, res, body, http.StatusConflict, "BucketAlreadyOwnedByYou")
+	res, body = do(t, http.MethodPut, ts.URL+"/Bad_Name", nil)
+	expect(t, res, body, http.StatusBadRequest, "InvalidBucketName")
+
+	res, body = do(t, http.MethodPut, object, data, "Content-Md5", base64.StdEncoding.EncodeToString(sum[:]))
+	expect(t, res, body, http.StatusOK, "")
+	if res.Header.Get("ETag") != etag {
+		t.Error("etag", res.Header.Get("ETag"))
+	}
+	res, body = do(t, http.MethodPut, object, []byte("other"), "Content-Md5", base64.StdEncoding.EncodeToString(sum[:]))
+	expect(t, res, body, http.StatusBadRequest, "BadDigest")
+	res, body = do(t, http.MethodPut, object, make([]byte, 2048))
+	expect(t, res, body, http.StatusBadRequest, "EntityTooLarge")
+
+	res, body = do(t, http.MethodGet, object, nil)
+	expect(t, res, body, http.StatusOK, "")
+	if !bytes.Equal(body, data) {
+		t.Errorf("%q != %q", body, data)
+	}
+	if res.Header.Get("ETag") != etag || res.Header.Get("Last-Modified") == "" {
+		t.Error("bad headers", res.Header)
+	}
+	res, body = do(t, http.MethodGet, object, nil, "Range", "bytes=7-")
+	expect(t, res, body, http.StatusPartialContent, "")
+	if string(body) != "stok" {
+		t.Errorf("%q != %q", body, "stok")
+	}
+	res, body = do(t, http.MethodHead, object, nil)
+	expect(t, res, body, http.StatusOK, "")
+	if res.ContentLength != int64(len(data)) {
+		t.Error("content length", res.ContentLength)
+	}
+
+	// overwriting object removes previous record
+	res, body = do(t, http.MethodPut, object, []byte("new data"), "Content-Type", "text/plain")
+	expect(t, res, body, http.StatusOK, "")
+	res, body = do(t, http.MethodGet, object, nil)
+	if string(body) != "new data" || res.Header.Get("Content-Type") != "text/plain" {
+		t.Errorf("bad object %q %s", body, res.Header.Get("Content-Type"))
+	}
+
+	res, body = do(t, http.MethodGet, ts.URL+"/", nil)
+	expect(t, res, body, http.StatusOK, "")
+	var buckets ListBucketsResult
+	if err := xml.Unmarshal(body, &buckets); err != nil {
+		t.Fatal(err)
+	}
+	if len(buckets.Buckets) != 1 || buckets.Buckets[0].Name != "bucket" {
+		t.Error("bad buckets", buckets.Buckets)
+	}
+
+	res, body = do(t, http.MethodDelete, bucket, nil)
+	expect(t, res, body, http.StatusConflict, "BucketNotEmpty")
+	res, body = do(t, http.MethodDelete, object, nil)
+	expect(t, res, body, http.StatusNoContent, "")
+	res, body = do(t, http.MethodGet, object, nil)
+	expect(t, res, body, http.StatusNotFound, "NoSuchKey")
+	res, body = do(t, http.MethodDelete, object, nil)
+	expect(t, res, body, http.StatusNoContent, "")
+	res, body = do(t, http.MethodDelete, bucket, nil)
+	expect(t, res, body, http.StatusNoContent, "")
+	res, body = do(t, http.MethodHead, bucket, nil)
+	expect(t, res, body, http.StatusNotFound, "")
+}
+
+func TestGateway_Auth(t *testing.T) {
+	ts, clear := newGateway(t)
+	defer clear()
+	res, err := http.Get(ts.URL + "/")
+	if err != nil {
+		t.Fatal(err)
+	}
+	body, _ := ioutil.ReadAll(res.Body)
+	res.Body.Close()
+	expect(t, res, body, http.StatusForbidden, "AccessDenied")
+
+	req, _ := http.NewRequest(http.MethodPut, ts.URL+"/bucket", nil)
+	Sign(req, testKey, "wrong", DefaultRegion, time.Now())
+	if res, err = http.DefaultClient.Do(req); err != nil {
+		t.Fatal(err)
+	}
+	body, _ = ioutil.ReadAll(res.Body)
+	res.Body.Close()
+	expect(t, res, body, http.StatusForbidden, "SignatureDoesNotMatch")
+
+	// signature is checked before body is read
+	req, _ = http.NewRequest(http.MethodPut, ts.URL+"/bucket/key", bytes.NewReader(make([]byte, 2048)))
+	Sign(req, testKey, "wrong", DefaultRegion, time.Now())
+	if res, err = http.DefaultClient.Do(req); err != nil {
+		t.Fatal(err)
+	}
+	body, _ = ioutil.ReadAll(res.Body)
+	res.Body.Close()
+	expect(t, res, body, http.StatusForbidden, "SignatureDoesNotMatch")
+
+	res, body = do(t, http.MethodPut, ts.URL+"/bucket", nil)
+	expect(t, res, body, http.StatusOK, "")
+	req, _ = http.NewRequest(http.MethodPut, ts.URL+"/bucket/key", strings.NewReader("body"))
+	req.Header.Set("X-Amz-Content-Sha256", EmptySHA256)
+	Sign(req, testKey, testSecret, DefaultRegion, time.Now())
+	if res, err = http.DefaultClient.Do(req); err != nil {
+		t.Fatal(err)
+	}
+	body, _ = ioutil.ReadAll(res.Body)
+	res.Body.Close()
+	expect(t, res, body, http.StatusBadRequest, "XAmzContentSHA256Mismatch")
+	res, body = do(t, http.MethodHead, ts.URL+"/bucket/key", nil)
+	expect(t, res, body, http.StatusNotFound, "")
+}
+
+func list(t testing.TB, bucket string, query url.Values) ListObjectsResult {
+	query.Set("list-type", "2")
+	res, body := do(t, http.MethodGet, bucket+"?"+query.Encode(), nil)
+	expect(t, res, body, http.StatusOK, "")
+	var result ListObjectsResult
+	if err := xml.Unmarshal(body, &result); err != nil {
+		t.Fatal(err)
+	}
+	return result
+}
+
+func TestGateway_List(t *testing.T) {
+	ts, clear := newGateway(t)
+	defer clear()
+	bucket := ts.URL + "/bucket"
+	res, body := do(t, http.MethodPut, bucket, nil)
+	expect(t, res, body, http.StatusOK, "")
+	keys := []string{"a", "dir/sub/z", "dir/x", "dir/y", "e"}
+	for _, key := range keys {
+		res, body = do(t, http.MethodPut, bucket+"/"+key, []byte(key))
+		expect(t, res, body, http.StatusOK, "")
+	}
+	// other bucket is not listed
+	do(t, http.MethodPut, ts.URL+"/bucket2", nil)
+	do(t, http.MethodPut, ts.URL+"/bucket2/b", nil)
+
+	names := func(r ListObjectsResult) string {
+		var s []string
+		for _, o := range r.Contents {
+			s = append(s, o.Key)
+		}
+		for _, p := range r.CommonPrefixes {
+			s = append(s, p.Prefix)
+		}
+		return strings.Join(s, ",")
+	}
+	for _, tt := range []struct {
+		prefix, delimiter, startAfter, expected string
+	}{
+		{"", "", "", "a,dir/sub/z,dir/x,dir/y,e"},
+		{"", "/", "", "a,e,dir/"},
+		{"dir/", "/", "", "dir/x,dir/y,dir/sub/"},
+		{"dir/s", "", "", "dir/sub/z"},
+		{"", "", "dir/x", "dir/y,e"},
+		{"", "/", "dir/x", "e,dir/"},
+		{"missing", "", "", ""},
+	} {
+		r := list(t, bucket, url.Values{
+			"prefix":      {tt.prefix},
+			"delimiter":   {tt.delimiter},
+			"start-after": {tt.startAfter},
+		})
+		if s := names(r); s != tt.expected {
+			t.Errorf("%+v: %s", tt, s)
+		}
+	}
+
+	var (
+		token string
+		pages []string
+	)
+	for {
+		query := url.Values{"max-keys": {"2"}, "delimiter": {"/"}}
+		if token != "" {
+			query.Set("continuation-token", token)
+		}
+		r := list(t, bucket, query)
+		pages = append(pages, names(r))
+		if !r.IsTruncated {
+			break
+		}
+		token = r.NextContinuationToken
+	}
+	if s := strings.Join(pages, "|"); s != "a,dir/|e" {
+		t.Error("bad pages", s)
+	}
+
+	res, body = do(t, http.MethodPut, bucket+"/with space+plus", nil)
+	expect(t, res, body, http.StatusOK, "")
+	r := list(t, bucket, url.Values{"prefix": {"with"}, "encoding-type": {"url"}})
+	if s := names(r); s != "with%20space%2Bplus" {
+		t.Error("bad encoding", s)
+	}
+}
+
+func TestGateway_Multipart(t *testing.T) {
+	ts, clear := newGateway(t)
+	defer clear()
+	var (
+		bucket = ts.URL + "/bucket"
+		object = bucket + "/big"
+	)
+	res, body := do(t, http.MethodPut, bucket, nil)
+	expect(t, res, body, http.StatusOK, "")
+	initiate := func() string {
+		res, body := do(t, http.MethodPost, object+"?uploads", nil, "Content-Type", "text/plain")
+		expect(t, res, body, http.StatusOK, "")
+		var result InitiateMultipartUploadResult
+		if err := xml.Unmarshal(body, &result); err != nil {
+			t.Fatal(err)
+		}
+		return result.UploadID
+	}
+	id := initiate()
+	parts := [][]byte{
+		bytes.Repeat([]byte{'a'}, 1000),
+		bytes.Repeat([]byte{'b'}, 1000),
+		bytes.Repeat([]byte{'c'}, 500),
+	}
+	etags := make([]string, len(parts))
+	for i, data := range parts {
+		u := object + "?partNumber=" + string('1'+rune(i)) + "&uploadId=" + id
+		res, body = do(t, http.MethodPut, u, data)
+		expect(t, res, body, http.StatusOK, "")
+		etags[i] = res.Header.Get("ETag")
+	}
+	complete := func(id string, numbers ...int) (*http.Response, []byte) {
+		var req CompleteMultipartUpload
+		for _, n := range numbers {
+			req.Parts = append(req.Parts, CompletedPart{PartNumber: n, ETag: etags[n-1]})
+		}
+		data, err := xml.Marshal(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return do(t, http.MethodPost, object+"?uploadId="+id, data)
+	}
+	res, body = complete(id, 3, 1)
+	expect(t, res, body, http.StatusBadRequest, "InvalidPartOrder")
+	res, body = complete(id, 1, 3)
+	expect(t, res, body, http.StatusOK, "")
+	var result CompleteMultipartUploadResult
+	if err := xml.Unmarshal(body, &result); err != nil {
+		t.Fatal(err)
+	}
+	if !strings.HasSuffix(result.ETag, `-2"`) {
+		t.Error("bad etag", result.ETag)
+	}
+	res, body = complete(id, 1, 3)
+	expect(t, res, body, http.StatusNotFound, "NoSuchUpload")
+
+	expected := append(append([]byte(nil), parts[0]...), parts[2]...)
+	res, body = do(t, http.MethodGet, object, nil)
+	expect(t, res, body, http.StatusOK, "")
+	if !bytes.Equal(body, expected) || res.Header.Get("ETag") != result.ETag {
+		t.Error("bad object", len(body), res.Header.Get("ETag"))
+	}
+	res, body = do(t, http.MethodGet, object, nil, "Range", "bytes=990-1009")
+	expect(t, res, body, http.StatusPartialContent, "")
+	if !bytes.Equal(body, expected[990:1010]) {
+		t.Errorf("bad range %q", body)
+	}
+	r := list(t, bucket, url.Values{})
+	if len(r.Contents) != 1 || r.Contents[0].Size != int64(len(expected)) {
+		t.Error("bad list", r.Contents)
+	}
+
+	// aborted upload can't be completed
+	id = initiate()
+	res, body = do(t, http.MethodPut, object+"?partNumber=1&uploadId="+id, parts[0])
+	expect(t, res, body, http.StatusOK, "")
+	res, body = do(t, http.MethodDelete, object+"?uploadId="+id, nil)
+	expect(t, res, body, http.StatusNoContent, "")
+	res, body = complete(id, 1)
+	expect(t, res, body, http.StatusNotFound, "NoSuchUpload")
+
+	res, body = do(t, http.MethodDelete, object, nil)
+	expect(t, res, body, http.StatusNoContent, "")
+	res, body = do(t, http.MethodGet, object, nil)
+	expect(t, res, body, http.StatusNotFound, "NoSuchKey")
+}
@@ -0,0 +1,75 @@
+// Command stok-s3 serves volume over S3 API.
+package main
+
+import (
+	"flag"
+	"log"
+	"net/http"
+	"os"
+	"os/signal"
+
+	"github.com/cydev/stok/index/btree"
+	"github.com/cydev/stok/s3"
+	"github.com/cydev/stok/volume"
+)
+
+var (
+	addr        = flag.String("addr", ":8333", "address to listen")
+	path        = flag.String("volume", "volume.blob", "path to volume blob file")
+	meta        = flag.String("meta", "", "path to metadata tree, volume path with .meta suffix if empty")
+	credentials = flag.String("credentials", "", "path to AWS credentials file, authentication is disabled if empty")
+	region      = flag.String("region", s3.DefaultRegion, "region of gateway")
+	maxSize     = flag.Int64("max-size", s3.DefaultMaxSize, "max size of object or part in request")
+)
+
+func main() {
+	flag.Parse()
+	g := &s3.Gateway{
+		Region:  *region,
+		MaxSize: *maxSize,
+	}
+	if *credentials != "" {
+		c, err := s3.LoadCredentials(*credentials)
+		if err != nil {
+			log.Fatalln("failed to load credentials:", err)
+		}
+		g.Credentials = c
+	} else {
+		log.Println("authentication is disabled")
+	}
+	v, err := volume.Open(*path, nil)
+	if err != nil {
+		log.Fatalln("failed to open volume:", err)
+	}
+	g.Volume = v
+	if *meta == "" {
+		*meta = *path + ".meta"
+	}
+	f, err := os.OpenFile(*meta, os.O_RDWR|os.O_CREATE, 0644)
+	if err != nil {
+		log.Fatalln("failed to open metadata:", err)
+	}
+	if g.Tree, err = btree.Open(f); err != nil {
+		log.Fatalln("failed to open metadata:", err)
+	}
+	s := &http.Server{
+		Addr:    *addr,
+		Handler: g,
+	}
+	go func() {
+		c := make(chan os.Signal, 1)
+		signal.Notify(c, os.Interrupt)
+		<-c
+		s.Close()
+	}()
+	log.Println("serving", *path, "over S3 API on", *addr)
+	if err = s.ListenAndServe(); err != http.ErrServerClosed {
+		log.Println("failed to serve:", err)
+	}
+	if err = g.Tree.Close(); err != nil {
+		log.Println("failed to close metadata:", err)
+	}
+	if err = v.Close(); err != nil {
+		log.Fatalln("failed to close volume:", err)
+	}
+}
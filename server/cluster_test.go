@@ -0,0 +1,125 @@
+package server
+
+import (
+	"context"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/cydev/stok/master"
+)
+
+func TestCluster(t *testing.T) {
+	m := httptest.NewServer(&master.Master{Secret: "secret"})
+	defer m.Close()
+	c := master.Client{URL: m.URL, Secret: "secret"}
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	servers := make(map[string]*Server)
+	for i := uint32(1); i <= 3; i++ {
+		s, ts, clear := newServer(t)
+		defer clear()
+		s.VolumeID = i
+		s.Assigned = true
+		s.Limit = int64(i) * 1024 * 1024
+		servers[ts.URL] = s
+		if err := c.Heartbeat(ctx, master.Heartbeat{
+			URL:     ts.URL,
+			Volumes: []master.VolumeInfo{s.Info()},
+		}); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	seen := make(map[string]bool)
+	for i := 0; i < 10; i++ {
+		a, err := c.Assign(ctx)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if seen[a.FileID] {
+			t.Fatal("duplicate", a.FileID)
+		}
+		seen[a.FileID] = true
+		fid, err := master.ParseFileID(a.FileID)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if servers[a.URL].VolumeID != fid.Volume {
+			t.Fatal("volume", fid.Volume, "assigned on", a.URL)
+		}
+		data := []byte(a.FileID)
+		if res := do(t, http.MethodPut, a.URL+"/"+a.FileID, data); res.StatusCode != http.StatusCreated {
+			t.Fatal("status", res.Status)
+		}
+
+		l, err := c.Lookup(ctx, fid.Volume)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(l.Locations) != 1 || l.Locations[0].URL != a.URL || !l.Locations[0].Primary {
+			t.Fatal("unexpected", l)
+		}
+		res := do(t, http.MethodGet, l.Locations[0].URL+"/"+a.FileID, nil)
+		got, _ := ioutil.ReadAll(res.Body)
+		res.Body.Close()
+		if string(got) != a.FileID {
+			t.Fatalf("%q != %q", got, a.FileID)
+		}
+	}
+	if _, err := c.Lookup(ctx, 42); err == nil {
+		t.Fatal("expected error")
+	}
+	for url := range servers {
+		// keys are assigned only by master
+		res := do(t, http.MethodPost, url+"/", []byte("x"))
+		res.Body.Close()
+		if res.StatusCode != http.StatusForbidden {
+			t.Error("upload without file id", res.Status)
+		}
+	}
+}
+
+func TestClusterHeartbeat(t *testing.T) {
+	m := httptest.NewServer(&master.Master{Secret: "secret"})
+	defer m.Close()
+	c := master.Client{URL: m.URL, Secret: "secret"}
+	s, ts, clear := newServer(t)
+	defer clear()
+	s.VolumeID = 7
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		s.Heartbeat(ctx, c, ts.URL, time.Millisecond, func(err error) {
+			t.Error(err)
+		})
+		close(done)
+	}()
+	for i := 0; ; i++ {
+		a, err := c.Assign(context.Background())
+		if err == nil {
+			if a.URL != ts.URL {
+				t.Fatal("unexpected", a)
+			}
+			break
+		}
+		if i > 1000 {
+			t.Fatal(err)
+		}
+		time.Sleep(time.Millisecond)
+	}
+	cancel()
+	<-done
+
+	// keys from other volume are rejected
+	if res := do(t, http.MethodPut, ts.URL+"/8,1,0", []byte("x")); res.StatusCode != http.StatusNotFound {
+		t.Fatal("status", res.Status)
+	}
+	s.ReadOnly = true
+	if res := do(t, http.MethodPut, ts.URL+"/7,1,0", []byte("x")); res.StatusCode != http.StatusForbidden {
+		t.Fatal("status", res.Status)
+	}
+}
@@ -0,0 +1,58 @@
+package server
+
+import (
+	"context"
+	"sync/atomic"
+	"time"
+
+	"github.com/cydev/stok/master"
+)
+
+// DefaultHeartbeatInterval is default interval between heartbeats.
+const DefaultHeartbeatInterval = 5 * time.Second
+
+func (s *Server) limit() int64 {
+	if s.Limit == 0 {
+		return DefaultLimit
+	}
+	return s.Limit
+}
+
+// Info returns state of volume for master.
+func (s *Server) Info() master.VolumeInfo {
+	free := s.limit() - atomic.LoadInt64(&s.Volume.Blob.Size)
+	if free < 0 {
+		free = 0
+	}
+	return master.VolumeInfo{
+		ID:       s.VolumeID,
+		Free:     free,
+		ReadOnly: s.ReadOnly,
+		NextKey:  s.Volume.Next(),
+		Replica:  s.Replica,
+	}
+}
+
+// Heartbeat reports volume to master every interval until ctx is done.
+// Url is public url of server. Errors are passed to onError, that can be nil.
+func (s *Server) Heartbeat(ctx context.Context, c master.Client, url string, interval time.Duration, onError func(error)) {
+	if interval == 0 {
+		interval = DefaultHeartbeatInterval
+	}
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		h := master.Heartbeat{
+			URL:     url,
+			Volumes: []master.VolumeInfo{s.Info()},
+		}
+		if err := c.Heartbeat(ctx, h); err != nil && onError != nil && ctx.Err() == nil {
+			onError(err)
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
@@ -0,0 +1,155 @@
+package server
+
+import (
+	"bytes"
+	"crypto/subtle"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/cydev/stok/master"
+	"github.com/cydev/stok/storage"
+	"github.com/cydev/stok/volume"
+	"github.com/pkg/errors"
+)
+
+// ReplicatePath is path of replication endpoint of server, that is
+// served only by replicas.
+//
+//	GET /replicate              end of blob in X-Stok-End
+//	POST /replicate?offset=N    apply records at offset, 409 if offset is not the end
+const ReplicatePath = "/replicate"
+
+// EndHeader is header with offset of the end of blob.
+const EndHeader = "X-Stok-End"
+
+// SecretHeader is header with secret, that is shared by primary and
+// replicas, and by master and volume servers.
+const SecretHeader = master.SecretHeader
+
+// Replica is volume.Replica that is served by remote Server.
+type Replica struct {
+	URL    string // base url of server
+	Secret string // secret of replica server
+	HTTP   *http.Client
+}
+
+func (r Replica) http() *http.Client {
+	if r.HTTP == nil {
+		return http.DefaultClient
+	}
+	return r.HTTP
+}
+
+func (r Replica) url() string {
+	return strings.TrimSuffix(r.URL, "/") + ReplicatePath
+}
+
+func (r Replica) do(method, url string, body []byte) (*http.Response, error) {
+	req, err := http.NewRequest(method, url, bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	if r.Secret != "" {
+		req.Header.Set(SecretHeader, r.Secret)
+	}
+	return r.http().Do(req)
+}
+
+// End implements volume.Replica.
+func (r Replica) End() (int64, error) {
+	res, err := r.do(http.MethodGet, r.url(), nil)
+	if err != nil {
+		return 0, err
+	}
+	res.Body.Close()
+	if res.StatusCode != http.StatusOK {
+		return 0, fmt.Errorf("replica: %s", res.Status)
+	}
+	end, err := strconv.ParseInt(res.Header.Get(EndHeader), 10, 64)
+	return end, errors.Wrap(err, "bad end")
+}
+
+// Apply implements volume.Replica.
+func (r Replica) Apply(offset int64, data []byte) error {
+	u := r.url() + "?offset=" + strconv.FormatInt(offset, 10)
+	res, err := r.do(http.MethodPost, u, data)
+	if err != nil {
+		return err
+	}
+	res.Body.Close()
+	switch res.StatusCode {
+	case http.StatusNoContent:
+		return nil
+	case http.StatusConflict:
+		return storage.ErrBadOffset
+	default:
+		return fmt.Errorf("replica: %s", res.Status)
+	}
+}
+
+// replicateLimit returns max size of records in single Apply.
+func (s *Server) replicateLimit() int64 {
+	if limit := s.maxSize() + storage.RecordHeaderSize; limit > volume.ReplicaChunk {
+		return limit
+	}
+	return volume.ReplicaChunk
+}
+
+// replicate serves ReplicatePath, if server is replica.
+func (s *Server) replicate(w http.ResponseWriter, r *http.Request) {
+	if !s.Replica {
+		http.Error(w, "server is not replica", http.StatusForbidden)
+		return
+	}
+	// empty secrets are equal, so replica without secret is not writable
+	secret := r.Header.Get(SecretHeader)
+	if s.Secret == "" || subtle.ConstantTimeCompare([]byte(secret), []byte(s.Secret)) != 1 {
+		http.Error(w, "bad secret", http.StatusForbidden)
+		return
+	}
+	switch r.Method {
+	case http.MethodGet, http.MethodHead:
+		end, _ := s.Volume.End()
+		w.Header().Set(EndHeader, strconv.FormatInt(end, 10))
+		w.WriteHeader(http.StatusOK)
+	case http.MethodPost:
+		offset, err := strconv.ParseInt(r.URL.Query().Get("offset"), 10, 64)
+		if err != nil {
+			http.Error(w, "bad offset", http.StatusBadRequest)
+			return
+		}
+		if s.ReadOnly {
+			http.Error(w, "volume is read-only", http.StatusForbidden)
+			return
+		}
+		b := storage.AcquireByteBuffer()
+		defer storage.ReleaseByteBuffer(b)
+		if b.B, err = readBody(r, b.B, s.replicateLimit()); err != nil {
+			status := http.StatusBadRequest
+			if err == errTooLarge {
+				status = http.StatusRequestEntityTooLarge
+			}
+			http.Error(w, err.Error(), status)
+			return
+		}
+		err = s.Volume.Apply(offset, b.B)
+		if errors.Cause(err) == storage.ErrBadOffset {
+			end, _ := s.Volume.End()
+			w.Header().Set(EndHeader, strconv.FormatInt(end, 10))
+			http.Error(w, err.Error(), http.StatusConflict)
+			return
+		}
+		if err != nil {
+			internalError(w, err)
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+	default:
+		w.Header().Set("Allow", "GET, HEAD, POST")
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+	}
+}
+
+var _ volume.Replica = Replica{}
@@ -0,0 +1,109 @@
+package server
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+)
+
+func TestReplication(t *testing.T) {
+	primary, ts, clear := newServer(t)
+	defer clear()
+	var (
+		replicas []*httptest.Server
+		down     int32
+	)
+	for i := 0; i < 2; i++ {
+		s, replica, clear := newServer(t)
+		defer clear()
+		s.Replica = true
+		s.Secret = "secret"
+		// first replica can be turned off
+		handler := http.Handler(s)
+		if i == 0 {
+			handler = http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				if atomic.LoadInt32(&down) == 1 {
+					http.Error(w, "down", http.StatusServiceUnavailable)
+					return
+				}
+				s.ServeHTTP(w, r)
+			})
+		}
+		replica.Config.Handler = handler
+		replicas = append(replicas, replica)
+		primary.Volume.Replicate(Replica{URL: replica.URL, Secret: "secret"})
+	}
+
+	put := func(data string) (*http.Response, string) {
+		res := do(t, http.MethodPost, ts.URL+"/", []byte(data))
+		defer res.Body.Close()
+		var result PutResult
+		json.NewDecoder(res.Body).Decode(&result)
+		return res, result.FileID
+	}
+	res, fid := put("replicated")
+	if res.StatusCode != http.StatusCreated {
+		t.Fatal("status", res.Status)
+	}
+	atomic.StoreInt32(&down, 1)
+	if res, _ = put("not replicated"); res.StatusCode != http.StatusInternalServerError {
+		t.Error("put with replica down", res.Status)
+	}
+	atomic.StoreInt32(&down, 0)
+	res, last := put("catch up")
+	if res.StatusCode != http.StatusCreated {
+		t.Fatal("status", res.Status)
+	}
+
+	for _, replica := range replicas {
+		for _, f := range []string{fid, last} {
+			res = do(t, http.MethodGet, replica.URL+"/"+f, nil)
+			got, _ := ioutil.ReadAll(res.Body)
+			res.Body.Close()
+			if res.StatusCode != http.StatusOK {
+				t.Error(replica.URL, f, res.Status, string(got))
+			}
+		}
+		// replicas do not accept writes from clients
+		for _, method := range []string{http.MethodPost, http.MethodDelete} {
+			res = do(t, method, replica.URL+"/"+fid, []byte("direct"))
+			res.Body.Close()
+			if res.StatusCode != http.StatusForbidden {
+				t.Error("direct", method, res.Status)
+			}
+		}
+		// and records without secret
+		if err := (Replica{URL: replica.URL}).Apply(0, nil); err == nil {
+			t.Error("applied without secret")
+		}
+	}
+
+	// replica without secret is not writable
+	s, replica, clear := newServer(t)
+	defer clear()
+	s.Replica = true
+	if err := (Replica{URL: replica.URL}).Apply(0, nil); err == nil {
+		t.Error("applied to replica without secret")
+	}
+
+	// primary is not replica
+	if _, err := (Replica{URL: ts.URL, Secret: "secret"}).End(); err == nil {
+		t.Error("primary serves replication")
+	}
+
+	res = do(t, http.MethodDelete, ts.URL+"/"+fid, nil)
+	res.Body.Close()
+	if res.StatusCode != http.StatusNoContent {
+		t.Fatal("delete", res.Status)
+	}
+	for _, replica := range replicas {
+		res = do(t, http.MethodGet, replica.URL+"/"+fid, nil)
+		res.Body.Close()
+		if res.StatusCode != http.StatusNotFound {
+			t.Error("get after delete", res.Status)
+		}
+	}
+}
@@ -0,0 +1,288 @@
+// Package server implements HTTP server for volume.
+//
+//	POST /, PUT /     upload request body as new file, responds with JSON {"fid": "1,1,3b9ac9ff", "size": 5},
+//	                  unless keys are assigned by master
+//	POST /{fid}, PUT  upload request body as file with id assigned by master
+//	GET /{fid}        download file
+//	HEAD /{fid}       file size in Content-Length and crc32 in X-Stok-Checksum
+//	DELETE /{fid}     delete file
+//
+// Files are addressed by file id "volumeID,key,cookie", where cookie is
+// random, so ids of other files can't be guessed. Requests with wrong
+// cookie are answered as if file does not exist, and uploads with wrong
+// cookie to existing file are rejected with 409.
+//
+// GET and HEAD support single and multiple byte ranges, ETag that is
+// derived from file checksum, Last-Modified from time of write, and
+// conditional requests with If-Match, If-None-Match, If-Modified-Since,
+// If-Unmodified-Since and If-Range. Only requested bytes are read from blob.
+//
+// Replicas of volume receive records from primary on ReplicatePath and
+// reject writes of clients.
+//
+// Metrics of storage are exposed on MetricsPath in Prometheus text format.
+package server
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+
+	"github.com/cydev/stok/master"
+	"github.com/cydev/stok/metrics"
+	"github.com/cydev/stok/storage"
+	"github.com/cydev/stok/volume"
+	"github.com/pkg/errors"
+	"github.com/valyala/bytebufferpool"
+)
+
+// DefaultMaxSize is default max size of uploaded file.
+const DefaultMaxSize = 64 * 1024 * 1024
+
+// ChecksumHeader is header with hex-encoded crc32 of file.
+const ChecksumHeader = "X-Stok-Checksum"
+
+// MetricsPath is path of metrics endpoint of server.
+const MetricsPath = "/metrics"
+
+// Server serves Volume over HTTP.
+type Server struct {
+	Volume *volume.Volume
+	// MaxSize is max size of uploaded file, DefaultMaxSize if zero.
+	MaxSize int64
+
+	// VolumeID is id of volume in cluster.
+	VolumeID uint32
+	// ReadOnly disables writes to volume.
+	ReadOnly bool
+	// Assigned means that keys are assigned by master, so uploads
+	// without file id are rejected.
+	Assigned bool
+	// Replica means that server is replica of volume, that is written
+	// only by primary on ReplicatePath.
+	Replica bool
+	// Secret is shared by primary and replicas, that is required on
+	// ReplicatePath. Replica rejects all records if it is empty.
+	Secret string
+	// Limit is max size of volume blob, that is reported to master.
+	// DefaultLimit if zero.
+	Limit int64
+	// Metrics is registry that is served on MetricsPath,
+	// metrics.Default if nil.
+	Metrics *metrics.Registry
+}
+
+// DefaultLimit is default max size of volume blob.
+const DefaultLimit = 32 * 1024 * 1024 * 1024
+
+// PutResult is response of upload.
+type PutResult struct {
+	FileID string `json:"fid"`
+	Size   int64  `json:"size"`
+}
+
+func (s *Server) maxSize() int64 {
+	if s.MaxSize == 0 {
+		return DefaultMaxSize
+	}
+	return s.MaxSize
+}
+
+func (s *Server) metrics() *metrics.Registry {
+	if s.Metrics == nil {
+		return metrics.Default
+	}
+	return s.Metrics
+}
+
+// ServeHTTP implements http.Handler.
+func (s *Server) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	switch r.URL.Path {
+	case ReplicatePath:
+		s.replicate(w, r)
+		return
+	case MetricsPath:
+		s.metrics().ServeHTTP(w, r)
+		return
+	}
+	path := strings.TrimPrefix(r.URL.Path, "/")
+	if path == "" {
+		switch r.Method {
+		case http.MethodPost, http.MethodPut:
+			s.put(w, r)
+		default:
+			w.Header().Set("Allow", "POST, PUT")
+			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		}
+		return
+	}
+	fid, err := master.ParseFileID(path)
+	if err != nil {
+		http.Error(w, "bad file id", http.StatusBadRequest)
+		return
+	}
+	if fid.Volume != s.VolumeID {
+		http.Error(w, "volume not found", http.StatusNotFound)
+		return
+	}
+	switch r.Method {
+	case http.MethodPost, http.MethodPut:
+		s.set(w, r, fid)
+	case http.MethodGet, http.MethodHead:
+		s.get(w, r, fid)
+	case http.MethodDelete:
+		s.delete(w, fid)
+	default:
+		w.Header().Set("Allow", "POST, PUT, GET, HEAD, DELETE")
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+	}
+}
+
+// readBody reads request body to buf, returning errTooLarge if body
+// is larger than max.
+func readBody(r *http.Request, buf []byte, max int64) ([]byte, error) {
+	if r.ContentLength > max {
+		return buf, errTooLarge
+	}
+	if r.ContentLength > 0 {
+		start := len(buf)
+		buf = append(buf, make([]byte, r.ContentLength)...)
+		_, err := io.ReadFull(r.Body, buf[start:])
+		return buf, err
+	}
+	b := storage.AcquireByteBuffer()
+	defer storage.ReleaseByteBuffer(b)
+	n, err := b.ReadFrom(io.LimitReader(r.Body, max+1))
+	if err != nil {
+		return buf, err
+	}
+	if n > max {
+		return buf, errTooLarge
+	}
+	return append(buf, b.B...), nil
+}
+
+var errTooLarge = errors.New("body is too large")
+
+// writable writes error response if clients can't write to volume.
+func (s *Server) writable(w http.ResponseWriter) bool {
+	switch {
+	case s.ReadOnly:
+		http.Error(w, "volume is read-only", http.StatusForbidden)
+	case s.Replica:
+		http.Error(w, "replica is written only by primary", http.StatusForbidden)
+	default:
+		return true
+	}
+	return false
+}
+
+// body reads request body to pooled buffer and writes error response if
+// it fails or volume is read-only.
+func (s *Server) body(w http.ResponseWriter, r *http.Request) (*bytebufferpool.ByteBuffer, bool) {
+	if !s.writable(w) {
+		return nil, false
+	}
+	b := storage.AcquireByteBuffer()
+	var err error
+	if b.B, err = readBody(r, b.B, s.maxSize()); err != nil {
+		storage.ReleaseByteBuffer(b)
+		status := http.StatusBadRequest
+		if err == errTooLarge {
+			status = http.StatusRequestEntityTooLarge
+		}
+		http.Error(w, err.Error(), status)
+		return nil, false
+	}
+	return b, true
+}
+
+func (s *Server) put(w http.ResponseWriter, r *http.Request) {
+	if s.Assigned {
+		http.Error(w, "file id is assigned by master", http.StatusForbidden)
+		return
+	}
+	b, ok := s.body(w, r)
+	if !ok {
+		return
+	}
+	defer storage.ReleaseByteBuffer(b)
+	id, cookie, err := s.Volume.Put(b.B)
+	if err != nil {
+		internalError(w, err)
+		return
+	}
+	created(w, master.FileID{Volume: s.VolumeID, Key: id, Cookie: cookie}, len(b.B))
+}
+
+func (s *Server) set(w http.ResponseWriter, r *http.Request, fid master.FileID) {
+	if fid.Key >= s.Volume.Next()+master.KeyWindow {
+		http.Error(w, "key is out of range", http.StatusBadRequest)
+		return
+	}
+	b, ok := s.body(w, r)
+	if !ok {
+		return
+	}
+	defer storage.ReleaseByteBuffer(b)
+	err := s.Volume.Set(fid.Key, fid.Cookie, b.B)
+	if errors.Cause(err) == volume.ErrCookieMismatch {
+		http.Error(w, "file exists", http.StatusConflict)
+		return
+	}
+	if err != nil {
+		internalError(w, err)
+		return
+	}
+	created(w, fid, len(b.B))
+}
+
+func created(w http.ResponseWriter, fid master.FileID, size int) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(PutResult{FileID: fid.String(), Size: int64(size)})
+}
+
+func internalError(w http.ResponseWriter, err error) {
+	http.Error(w, err.Error(), http.StatusInternalServerError)
+}
+
+// notFound writes 404 if err is volume.ErrNotFound and 500 otherwise.
+func notFound(w http.ResponseWriter, err error) {
+	if errors.Cause(err) == volume.ErrNotFound {
+		http.Error(w, "not found", http.StatusNotFound)
+		return
+	}
+	internalError(w, err)
+}
+
+// ETag returns entity tag for record.
+func ETag(h storage.RecordHeader) string {
+	return fmt.Sprintf(`"%08x"`, h.Checksum)
+}
+
+func (s *Server) get(w http.ResponseWriter, r *http.Request, fid master.FileID) {
+	h, reader, err := s.Volume.Reader(fid.Key, fid.Cookie)
+	if err != nil {
+		notFound(w, err)
+		return
+	}
+	header := w.Header()
+	header.Set("Content-Type", "application/octet-stream")
+	header.Set("ETag", ETag(h))
+	header.Set(ChecksumHeader, fmt.Sprintf("%08x", h.Checksum))
+	http.ServeContent(w, r, "", h.Time(), reader)
+}
+
+func (s *Server) delete(w http.ResponseWriter, fid master.FileID) {
+	if !s.writable(w) {
+		return
+	}
+	if err := s.Volume.Delete(fid.Key, fid.Cookie); err != nil {
+		notFound(w, err)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
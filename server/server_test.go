@@ -0,0 +1,260 @@
+package server
+
+import (
+	"bytes"
+	"encoding/json"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/cydev/stok/master"
+	"github.com/cydev/stok/stokutils"
+	"github.com/cydev/stok/volume"
+)
+
+func newServer(t testing.TB) (*Server, *httptest.Server, func()) {
+	dir, err := ioutil.TempDir("", "server")
+	if err != nil {
+		t.Fatal(err)
+	}
+	v, err := volume.Open(filepath.Join(dir, "volume"), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	s := &Server{Volume: v, MaxSize: 1024}
+	ts := httptest.NewServer(s)
+	return s, ts, func() {
+		ts.Close()
+		stokutils.MustClose(t, v)
+		os.RemoveAll(dir)
+	}
+}
+
+func do(t testing.TB, method, url string, body []byte) *http.Response {
+	req, err := http.NewRequest(method, url, bytes.NewReader(body))
+	if err != nil {
+		t.Fatal(err)
+	}
+	res, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return res
+}
+
+func TestServer(t *testing.T) {
+	_, ts, clear := newServer(t)
+	defer clear()
+	data := []byte("hello, stok")
+	res := do(t, http.MethodPost, ts.URL+"/", data)
+	if res.StatusCode != http.StatusCreated {
+		t.Fatal("status", res.Status)
+	}
+	var result PutResult
+	if err := json.NewDecoder(res.Body).Decode(&result); err != nil {
+		t.Fatal(err)
+	}
+	res.Body.Close()
+	if result.Size != int64(len(data)) {
+		t.Error("size", result.Size)
+	}
+	fid, err := master.ParseFileID(result.FileID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	url := ts.URL + "/" + result.FileID
+
+	res = do(t, http.MethodGet, url, nil)
+	got, _ := ioutil.ReadAll(res.Body)
+	res.Body.Close()
+	if res.StatusCode != http.StatusOK || !bytes.Equal(got, data) {
+		t.Error("get", res.Status, string(got))
+	}
+
+	res = do(t, http.MethodHead, url, nil)
+	res.Body.Close()
+	if res.ContentLength != int64(len(data)) || res.Header.Get(ChecksumHeader) == "" {
+		t.Error("head", res.ContentLength, res.Header)
+	}
+
+	// wrong cookie is same as missing file
+	fid.Cookie++
+	res = do(t, http.MethodGet, ts.URL+"/"+fid.String(), nil)
+	res.Body.Close()
+	if res.StatusCode != http.StatusNotFound {
+		t.Error("get with wrong cookie", res.Status)
+	}
+	res = do(t, http.MethodDelete, ts.URL+"/"+fid.String(), nil)
+	res.Body.Close()
+	if res.StatusCode != http.StatusNotFound {
+		t.Error("delete with wrong cookie", res.Status)
+	}
+	res = do(t, http.MethodPut, ts.URL+"/"+fid.String(), []byte("replaced"))
+	res.Body.Close()
+	if res.StatusCode != http.StatusConflict {
+		t.Error("put with wrong cookie", res.Status)
+	}
+	res = do(t, http.MethodGet, url, nil)
+	got, _ = ioutil.ReadAll(res.Body)
+	res.Body.Close()
+	if !bytes.Equal(got, data) {
+		t.Errorf("replaced with wrong cookie: %q", got)
+	}
+	res = do(t, http.MethodPut, url, []byte("replaced"))
+	res.Body.Close()
+	if res.StatusCode != http.StatusCreated {
+		t.Error("put with cookie", res.Status)
+	}
+
+	res = do(t, http.MethodDelete, url, nil)
+	res.Body.Close()
+	if res.StatusCode != http.StatusNoContent {
+		t.Error("delete", res.Status)
+	}
+	for _, method := range []string{http.MethodGet, http.MethodDelete} {
+		res = do(t, method, url, nil)
+		res.Body.Close()
+		if res.StatusCode != http.StatusNotFound {
+			t.Error(method, "after delete", res.Status)
+		}
+	}
+}
+
+func TestServerErrors(t *testing.T) {
+	_, ts, clear := newServer(t)
+	defer clear()
+	for _, tt := range []struct {
+		method, path string
+		body         []byte
+		status       int
+	}{
+		{http.MethodPut, "/", bytes.Repeat([]byte{1}, 2048), http.StatusRequestEntityTooLarge},
+		{http.MethodGet, "/", nil, http.StatusMethodNotAllowed},
+		{http.MethodGet, "/abc", nil, http.StatusBadRequest},
+		{http.MethodGet, "/1", nil, http.StatusBadRequest},
+		{http.MethodGet, "/0,1,0", nil, http.StatusNotFound},
+		{http.MethodGet, "/1,1,0", nil, http.StatusNotFound},
+		{http.MethodPatch, "/0,1,0", nil, http.StatusMethodNotAllowed},
+		{http.MethodPut, "/0,7fffffffffffffff,0", []byte("x"), http.StatusBadRequest},
+		{http.MethodPut, "/0,100000,0", []byte("x"), http.StatusBadRequest},
+	} {
+		res := do(t, tt.method, ts.URL+tt.path, tt.body)
+		res.Body.Close()
+		if res.StatusCode != tt.status {
+			t.Error(tt.method, tt.path, res.StatusCode, "!=", tt.status)
+		}
+	}
+	// chunked body without Content-Length
+	body := io.MultiReader(strings.NewReader(strings.Repeat("x", 2048)))
+	res, err := http.Post(ts.URL, "text/plain", body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	res.Body.Close()
+	if res.StatusCode != http.StatusRequestEntityTooLarge {
+		t.Error("chunked", res.Status)
+	}
+}
+
+func TestServerRange(t *testing.T) {
+	s, ts, clear := newServer(t)
+	defer clear()
+	data := []byte("0123456789abcdefghij")
+	id, cookie, err := s.Volume.Put(data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	url := ts.URL + "/" + master.FileID{Key: id, Cookie: cookie}.String()
+	get := func(headers ...string) (*http.Response, string) {
+		req, err := http.NewRequest(http.MethodGet, url, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		for i := 0; i < len(headers); i += 2 {
+			req.Header.Set(headers[i], headers[i+1])
+		}
+		res, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer res.Body.Close()
+		body, err := ioutil.ReadAll(res.Body)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return res, string(body)
+	}
+
+	res, body := get()
+	etag := res.Header.Get("ETag")
+	lastModified := res.Header.Get("Last-Modified")
+	if etag == "" || lastModified == "" || body != string(data) {
+		t.Fatal("bad response", res.Header, body)
+	}
+
+	res, body = get("Range", "bytes=2-5")
+	if res.StatusCode != http.StatusPartialContent || body != "2345" {
+		t.Error("single range", res.Status, body)
+	}
+	if cr := res.Header.Get("Content-Range"); cr != "bytes 2-5/20" {
+		t.Error("Content-Range", cr)
+	}
+
+	res, body = get("Range", "bytes=0-1,-2")
+	if res.StatusCode != http.StatusPartialContent ||
+		!strings.HasPrefix(res.Header.Get("Content-Type"), "multipart/byteranges") ||
+		!strings.Contains(body, "01") || !strings.Contains(body, "ij") {
+		t.Error("multi range", res.Status, body)
+	}
+
+	res, _ = get("Range", "bytes=30-40")
+	if res.StatusCode != http.StatusRequestedRangeNotSatisfiable {
+		t.Error("unsatisfiable range", res.Status)
+	}
+
+	res, _ = get("If-None-Match", etag)
+	if res.StatusCode != http.StatusNotModified {
+		t.Error("If-None-Match", res.Status)
+	}
+	res, _ = get("If-Modified-Since", lastModified)
+	if res.StatusCode != http.StatusNotModified {
+		t.Error("If-Modified-Since", res.Status)
+	}
+	res, body = get("Range", "bytes=0-3", "If-Range", etag)
+	if res.StatusCode != http.StatusPartialContent || body != "0123" {
+		t.Error("If-Range match", res.Status, body)
+	}
+	res, body = get("Range", "bytes=0-3", "If-Range", `"other"`)
+	if res.StatusCode != http.StatusOK || body != string(data) {
+		t.Error("If-Range mismatch", res.Status, body)
+	}
+	res, _ = get("If-Match", `"other"`)
+	if res.StatusCode != http.StatusPreconditionFailed {
+		t.Error("If-Match", res.Status)
+	}
+}
+
+func TestServerMetrics(t *testing.T) {
+	_, ts, clear := newServer(t)
+	defer clear()
+	if res := do(t, http.MethodPost, ts.URL+"/", []byte("data")); res.StatusCode != http.StatusCreated {
+		t.Fatal("status", res.Status)
+	}
+	res := do(t, http.MethodGet, ts.URL+MetricsPath, nil)
+	body, _ := ioutil.ReadAll(res.Body)
+	res.Body.Close()
+	for _, name := range []string{
+		"stok_blob_written_bytes_total",
+		"stok_blob_capacity_bytes",
+		"stok_index_write_seconds_count",
+	} {
+		if !bytes.Contains(body, []byte("\n"+name+" ")) {
+			t.Error("no", name)
+		}
+	}
+}
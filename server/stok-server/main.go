@@ -0,0 +1,132 @@
+// Command stok-server serves volume over HTTP.
+package main
+
+import (
+	"context"
+	"flag"
+	"log"
+	"net"
+	"net/http"
+	"os"
+	"os/signal"
+	"strings"
+
+	"github.com/cydev/stok/erasure"
+	"github.com/cydev/stok/master"
+	"github.com/cydev/stok/server"
+	"github.com/cydev/stok/volume"
+	"github.com/cydev/stok/wire"
+)
+
+var (
+	addr      = flag.String("addr", ":8080", "address to listen")
+	path      = flag.String("volume", "volume.blob", "path to volume blob file")
+	maxSize   = flag.Int64("max-size", server.DefaultMaxSize, "max size of uploaded file")
+	id        = flag.Uint("id", 0, "volume id in cluster")
+	readOnly  = flag.Bool("read-only", false, "disable writes")
+	limit     = flag.Int64("limit", server.DefaultLimit, "max size of volume reported to master")
+	masterURL = flag.String("master", "", "url of master, that assigns file ids, heartbeats are disabled if empty")
+	publicURL = flag.String("public-url", "", "url of server that is reported to master")
+	interval  = flag.Duration("heartbeat", server.DefaultHeartbeatInterval, "interval between heartbeats")
+	replicas  = flag.String("replicas", "", "comma-separated urls of replica servers or paths of replica volumes")
+	replica   = flag.Bool("replica", false, "serve as replica, that is written only by primary")
+	secret    = flag.String("secret", "", "secret, that is shared by primary, replica and master servers, required for replication over HTTP and heartbeats")
+	wireAddr  = flag.String("wire", "", "address to serve binary wire protocol, disabled if empty")
+	shards    = flag.String("shards", "", "comma-separated paths of erasure-coded shards of sealed volume, that is served read-only")
+)
+
+func main() {
+	flag.Parse()
+	if *secret == "" && (*replica || strings.Contains(*replicas, "://")) {
+		// replica without secret accepts records from anyone
+		log.Fatalln("secret is required for replication over HTTP")
+	}
+	if *secret == "" && *masterURL != "" {
+		log.Fatalln("secret is required for heartbeats to master")
+	}
+	var (
+		v   *volume.Volume
+		err error
+	)
+	if *shards != "" {
+		v, err = erasure.OpenVolume(strings.Split(*shards, ","), *path+volume.IndexSuffix)
+		*readOnly = true
+	} else {
+		v, err = volume.Open(*path, nil)
+	}
+	if err != nil {
+		log.Fatalln("failed to open volume:", err)
+	}
+	for _, r := range strings.Split(*replicas, ",") {
+		switch {
+		case r == "":
+			continue
+		case strings.HasPrefix(r, "http://") || strings.HasPrefix(r, "https://"):
+			v.Replicate(server.Replica{URL: r, Secret: *secret})
+		default:
+			replica, err := volume.Open(r, nil)
+			if err != nil {
+				log.Fatalln("failed to open replica:", err)
+			}
+			defer replica.Close()
+			v.Replicate(replica)
+		}
+	}
+	srv := &server.Server{
+		Volume:   v,
+		MaxSize:  *maxSize,
+		VolumeID: uint32(*id),
+		ReadOnly: *readOnly,
+		Assigned: *masterURL != "",
+		Replica:  *replica,
+		Secret:   *secret,
+		Limit:    *limit,
+	}
+	s := &http.Server{
+		Addr:    *addr,
+		Handler: srv,
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	if *masterURL != "" {
+		url := *publicURL
+		if url == "" {
+			url = "http://localhost" + *addr
+		}
+		go srv.Heartbeat(ctx, master.Client{URL: *masterURL, Secret: *secret}, url, *interval, func(err error) {
+			log.Println("heartbeat failed:", err)
+		})
+	}
+	var ws *wire.Server
+	if *wireAddr != "" {
+		l, err := net.Listen("tcp", *wireAddr)
+		if err != nil {
+			log.Fatalln("failed to listen:", err)
+		}
+		ws = &wire.Server{
+			Volume:   v,
+			ReadOnly: *readOnly || *replica,
+			Assigned: *masterURL != "",
+			MaxSize:  *maxSize,
+		}
+		log.Println("serving wire protocol on", *wireAddr)
+		go ws.Serve(l)
+	}
+	go func() {
+		c := make(chan os.Signal, 1)
+		signal.Notify(c, os.Interrupt)
+		<-c
+		cancel()
+		if ws != nil {
+			ws.Close()
+		}
+		s.Close()
+	}()
+	log.Println("serving", *path, "on", *addr)
+	if err = s.ListenAndServe(); err != http.ErrServerClosed {
+		log.Println("failed to serve:", err)
+	}
+	cancel()
+	if err = v.Close(); err != nil {
+		log.Fatalln("failed to close volume:", err)
+	}
+}
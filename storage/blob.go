@@ -8,6 +8,9 @@ import (
 	"runtime"
 	"sync"
 	"sync/atomic"
+	"time"
+
+	"github.com/cydev/stok"
 )
 
 // Allocator wraps Allocate method for allocating slices. Should be goroutine-safe.
@@ -42,29 +45,75 @@ type Blob struct {
 	Backend    BlobBackend
 	Size       int64
 	Capacity   int64
+	ReadOnly   bool          // disables writes and header updates
+	Observer   stok.Observer // receives events of blob operations, Metrics if nil
 	headerBuff [blobHeaderSize]byte
+	open       int32 // blob is reported to observer as open
+}
+
+func (b *Blob) observer() stok.Observer {
+	if b.Observer == nil {
+		return Metrics
+	}
+	return b.Observer
+}
+
+// opened reports b to observer as open, so it is reported as closed
+// on Close.
+func (b *Blob) opened() {
+	atomic.StoreInt32(&b.open, 1)
+	b.observer().OnOpen(atomic.LoadInt64(&b.Size), b.Capacity)
+}
+
+// failed reports err of op to observer if it is not nil and returns it.
+func (b *Blob) failed(op stok.Op, err error) error {
+	if err != nil {
+		b.observer().OnError(op, err)
+	}
+	return err
 }
 
 // Sync commits the current state of blob.
 func (b *Blob) Sync() (err error) {
-	b.Lock()
-	if err = b.writeHeader(); err == nil {
+	start := time.Now()
+	if b.ReadOnly {
 		err = b.Backend.Sync()
+	} else {
+		b.Lock()
+		if err = b.writeHeader(); err == nil {
+			err = b.Backend.Sync()
+		}
+		b.Unlock()
 	}
-	b.Unlock()
-	return err
+	if err != nil {
+		return b.failed(stok.OpSync, err)
+	}
+	b.observer().OnSync(time.Since(start))
+	return nil
 }
 
 // Truncate changes the capacity of the blob.
 func (b *Blob) Truncate(size int64) (err error) {
 	b.Lock()
-	if err = b.Backend.Truncate(size); err == nil {
-		b.Capacity = size
+	if err = b.truncate(size); err == nil {
 		// rendering capacity changes to header
 		err = b.writeHeader()
 	}
 	b.Unlock()
-	return err
+	return b.failed(stok.OpTruncate, err)
+}
+
+// truncate truncates backend and sets capacity. Should be called with
+// b locked.
+func (b *Blob) truncate(size int64) error {
+	start := time.Now()
+	if err := b.Backend.Truncate(size); err != nil {
+		return err
+	}
+	old := b.Capacity
+	b.Capacity = size
+	b.observer().OnTruncate(old, size, time.Since(start))
+	return nil
 }
 
 // Allocate returns offset to atomically allocated slice of provided size and error if any.
@@ -76,6 +125,7 @@ func (b *Blob) Truncate(size int64) (err error) {
 //     b.WriteAt(data, offset)
 func (b *Blob) Allocate(size int64) (int64, error) {
 	newSize := atomic.AddInt64(&b.Size, size)
+	b.observer().OnAllocate(newSize-size, size)
 	return newSize - size, nil
 }
 
@@ -84,13 +134,13 @@ func (b *Blob) Allocate(size int64) (int64, error) {
 // Uses b.headerBuff as write buffer.
 func (b *Blob) writeHeader() error {
 	header := BlobHeader{
-		Size:     b.Size,
+		Size:     atomic.LoadInt64(&b.Size), // can be changed by Allocate
 		Capacity: b.Capacity,
 	}
 	header.Put(b.headerBuff[:])
 	_, err := b.Backend.WriteAt(b.headerBuff[:], 0)
-	if b.Size == 0 {
-		b.Size = blobHeaderSize
+	if atomic.CompareAndSwapInt64(&b.Size, 0, blobHeaderSize) {
+		b.observer().OnAllocate(0, blobHeaderSize)
 	}
 	return err
 }
@@ -122,6 +172,8 @@ const (
 // BlobConfig is configuration for blob processing.
 type BlobConfig struct {
 	InitialSize int64
+	// Observer receives events of blob operations, Metrics if nil.
+	Observer stok.Observer
 }
 
 // GetInitialSize returns initial size for the blob used upon creation.
@@ -153,25 +205,56 @@ func OpenBlob(path string, cfg *BlobConfig) (*Blob, error) {
 		Size:     0,
 		Backend:  f,
 	}
+	if cfg != nil {
+		b.Observer = cfg.Observer
+	}
 	if b.Capacity == 0 {
+		b.opened()
 		err = b.Truncate(cfg.GetInitialSize())
-	} else {
-		err = b.readHeader()
+	} else if err = b.readHeader(); err == nil {
+		b.opened()
 	}
 	runtime.SetFinalizer(b, (*Blob).Close)
 	return b, err
 }
 
+// OpenReadOnlyBlob returns read-only Blob on top of backend, that
+// contains sealed blob. Capacity of blob is equal to its size.
+func OpenReadOnlyBlob(backend BlobBackend) (*Blob, error) {
+	b := &Blob{
+		Backend:  backend,
+		ReadOnly: true,
+	}
+	if _, err := backend.ReadAt(b.headerBuff[:], 0); err != nil {
+		return nil, err
+	}
+	h := BlobHeader{}
+	if err := h.Read(b.headerBuff[:]); err != nil {
+		return nil, err
+	}
+	b.Size, b.Capacity = h.Size, h.Size
+	b.opened()
+	return b, nil
+}
+
 // Close closes the Blob, rendering it unusable for changes.
 // It returns an error, if any.
 func (b *Blob) Close() error {
 	if err := b.Sync(); err != nil {
 		return err
 	}
+	if atomic.CompareAndSwapInt32(&b.open, 1, 0) {
+		b.observer().OnClose(atomic.LoadInt64(&b.Size), b.Capacity)
+	}
 	return b.Backend.Close()
 }
 
 // BlobHeader contains info about Blob size and capacity.
+//
+// Unlike other headers, it is encoded by hand and not by stok-binarygen:
+// its layout of varints in 8-byte slots predates generator, and
+// BlobHeaderSize is offset of first record in every existing blob, so
+// changing it would break blobs and backups written before.
 type BlobHeader struct {
 	Size     int64
 	Capacity int64
@@ -183,6 +266,10 @@ const s64 = 8
 // blobHeaderSize = magic + size + capacity + crc.
 const blobHeaderSize = s64 + s64 + s64 + s64
 
+// BlobHeaderSize is size of encoded BlobHeader, that is offset of
+// first record in blob.
+const BlobHeaderSize = blobHeaderSize
+
 // blobHeaderMagic are magic bytes at start of blob header.
 var blobHeaderMagic = [...]byte{
 	0xbb,
@@ -2,9 +2,13 @@ package storage
 
 import (
 	"math/rand"
+	"reflect"
 	"sync"
 	"testing"
+	"time"
 
+	"github.com/cydev/stok"
+	"github.com/cydev/stok/metrics"
 	. "github.com/cydev/stok/stokutils"
 )
 
@@ -273,3 +277,97 @@ func BenchmarkBlobHeader_Append(b *testing.B) {
 		buf = buf[:0]
 	}
 }
+
+// recorder is stok.Observer that records operations.
+type recorder struct {
+	stok.NopObserver
+	mux sync.Mutex
+	ops []stok.Op
+}
+
+func (r *recorder) record(op stok.Op) {
+	r.mux.Lock()
+	r.ops = append(r.ops, op)
+	r.mux.Unlock()
+}
+
+func (r *recorder) OnAllocate(offset, size int64)               { r.record(stok.OpAllocate) }
+func (r *recorder) OnWrite(n int, d time.Duration)              { r.record(stok.OpWrite) }
+func (r *recorder) OnRead(n int, d time.Duration)               { r.record(stok.OpRead) }
+func (r *recorder) OnTruncate(old, size int64, d time.Duration) { r.record(stok.OpTruncate) }
+func (r *recorder) OnSync(d time.Duration)                      { r.record(stok.OpSync) }
+func (r *recorder) OnError(op stok.Op, err error)               { r.record("error " + op) }
+func (r *recorder) OnOpen(size, capacity int64)                 { r.record("open") }
+func (r *recorder) OnClose(size, capacity int64)                { r.record("close") }
+
+func TestBlob_Observer(t *testing.T) {
+	f, fClose := TempFileClose(t)
+	name := f.Name()
+	fClose()
+	r := new(recorder)
+	b, err := OpenBlob(name, &BlobConfig{InitialSize: 64, Observer: r})
+	if err != nil {
+		t.Fatal(err)
+	}
+	offset, err := b.WriteRecord(RecordHeader{ID: 1}, []byte("data"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, _, err = b.ReadRecord(offset, nil); err != nil {
+		t.Fatal(err)
+	}
+	if err = b.Close(); err != nil {
+		t.Fatal(err)
+	}
+	b.Sync()
+	expected := []stok.Op{
+		"open",
+		stok.OpTruncate, stok.OpAllocate, // initial size and header
+		stok.OpAllocate, stok.OpTruncate, // growing for record
+		stok.OpWrite,
+		stok.OpRead, stok.OpRead, // header and data
+		stok.OpSync,
+		"close",
+		"error " + stok.OpSync,
+	}
+	if !reflect.DeepEqual(r.ops, expected) {
+		t.Error(r.ops, "!=", expected)
+	}
+}
+
+func TestBlob_Metrics(t *testing.T) {
+	o := metrics.NewObserver(metrics.NewRegistry(), "stok_blob", "blobs")
+	open := func() *Blob {
+		f, fClose := TempFileClose(t)
+		name := f.Name()
+		fClose()
+		b, err := OpenBlob(name, &BlobConfig{InitialSize: 64, Observer: o})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err = b.WriteRecord(RecordHeader{ID: 1}, make([]byte, 100)); err != nil {
+			t.Fatal(err)
+		}
+		return b
+	}
+	first, second := open(), open()
+	if _, err := second.WriteRecord(RecordHeader{ID: 2}, []byte("data")); err != nil {
+		t.Fatal(err)
+	}
+	check := func(size, capacity int64) {
+		t.Helper()
+		if o.Size.Value() != size || o.Capacity.Value() != capacity {
+			t.Errorf("size %d, capacity %d != %d, %d", o.Size.Value(), o.Capacity.Value(), size, capacity)
+		}
+	}
+	check(first.Size+second.Size, first.Capacity+second.Capacity)
+	if err := first.Close(); err != nil {
+		t.Fatal(err)
+	}
+	first.Close()
+	check(second.Size, second.Capacity)
+	if err := second.Close(); err != nil {
+		t.Fatal(err)
+	}
+	check(0, 0)
+}
@@ -15,4 +15,14 @@ const (
 	ErrBadHeaderCapacity sError = "Capacity in header is less than actual file size, file can be corrupted"
 	// ErrBadHeaderCRC means that header crc check failed.
 	ErrBadHeaderCRC sError = "Header CRC missmatch"
+	// ErrBadRecord means that record header has bad magic bytes or size.
+	ErrBadRecord sError = "Bad record header"
+	// ErrBadRecordCRC means that record header crc check failed.
+	ErrBadRecordCRC sError = "Record header CRC missmatch"
+	// ErrBadRecordChecksum means that record data checksum check failed.
+	ErrBadRecordChecksum sError = "Record data checksum missmatch"
+	// ErrBadOffset means that write is not at the end of blob.
+	ErrBadOffset sError = "Offset is not at the end of blob"
+	// ErrReadOnly means that blob is read-only.
+	ErrReadOnly sError = "Blob is read-only"
 )
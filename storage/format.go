@@ -0,0 +1,19 @@
+package storage
+
+import "github.com/cydev/stok/binary"
+
+// BlobFormatVersion is version of blob format.
+// Version 2 adds cookie to record header.
+const BlobFormatVersion = 2
+
+func init() {
+	binary.Register(binary.Format{
+		Name:       "blob",
+		Magic:      blobHeaderMagic,
+		Version:    BlobFormatVersion,
+		HeaderSize: blobHeaderSize,
+		Check: func(header []byte) error {
+			return new(BlobHeader).Read(header)
+		},
+	})
+}
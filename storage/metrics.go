@@ -0,0 +1,7 @@
+package storage
+
+import "github.com/cydev/stok/metrics"
+
+// Metrics is observer of blobs without Observer, that exposes metrics
+// with stok_blob prefix in metrics.Default.
+var Metrics = metrics.NewObserver(metrics.Default, "stok_blob", "blobs")
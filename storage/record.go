@@ -0,0 +1,346 @@
+package storage
+
+import (
+	"bytes"
+	"encoding/binary"
+	"hash/crc32"
+	"io"
+	"sync/atomic"
+	"time"
+
+	"github.com/cydev/stok"
+)
+
+// RecordHeader precedes data of every record in Blob.
+type RecordHeader struct {
+	ID        int64
+	Size      int64  // length of record data
+	Timestamp int64  // unix time of write in nanoseconds
+	Flags     uint32 // bitmask of Flag* constants
+	Checksum  uint32 // crc32 of record data
+	Cookie    uint32 // random secret that is part of public record id
+}
+
+const (
+	// FlagDeleted marks record as tombstone of deleted ID.
+	FlagDeleted uint32 = 1 << iota
+)
+
+// Time returns time of record write.
+func (h RecordHeader) Time() time.Time {
+	return time.Unix(0, h.Timestamp)
+}
+
+// Deleted reports whether record is tombstone.
+func (h RecordHeader) Deleted() bool {
+	return h.Flags&FlagDeleted != 0
+}
+
+// s32 is size of int32 in bytes.
+const s32 = 4
+
+// RecordHeaderSize = magic + id + size + timestamp + flags + checksum + cookie + crc.
+const RecordHeaderSize = s64 + s64 + s64 + s64 + s32 + s32 + s32 + s32
+
+// recordMagic are magic bytes at start of record header.
+var recordMagic = [...]byte{
+	0xbb,
+	0xba,
+	0x7e,
+	0xc0,
+	0x4d,
+	0x13,
+	0x20,
+	0x16,
+}
+
+// Put encodes RecordHeader into buf and returns the number of bytes written.
+// If the buffer is too small, Put will panic.
+func (h RecordHeader) Put(buf []byte) int {
+	var offset = s64
+	copy(buf[:offset], recordMagic[:])
+	binary.BigEndian.PutUint64(buf[offset:], uint64(h.ID))
+	offset += s64
+	binary.BigEndian.PutUint64(buf[offset:], uint64(h.Size))
+	offset += s64
+	binary.BigEndian.PutUint64(buf[offset:], uint64(h.Timestamp))
+	offset += s64
+	binary.BigEndian.PutUint32(buf[offset:], h.Flags)
+	offset += s32
+	binary.BigEndian.PutUint32(buf[offset:], h.Checksum)
+	offset += s32
+	binary.BigEndian.PutUint32(buf[offset:], h.Cookie)
+	offset += s32
+	binary.BigEndian.PutUint32(buf[offset:], crc32.ChecksumIEEE(buf[:offset]))
+	offset += s32
+	return offset
+}
+
+// Read decodes RecordHeader from buf and returns ErrBadRecord or
+// ErrBadRecordCRC if it fails.
+func (h *RecordHeader) Read(buf []byte) error {
+	if len(buf) < RecordHeaderSize || !bytes.HasPrefix(buf, recordMagic[:]) {
+		return ErrBadRecord
+	}
+	offset := s64
+	h.ID = int64(binary.BigEndian.Uint64(buf[offset:]))
+	offset += s64
+	h.Size = int64(binary.BigEndian.Uint64(buf[offset:]))
+	offset += s64
+	h.Timestamp = int64(binary.BigEndian.Uint64(buf[offset:]))
+	offset += s64
+	h.Flags = binary.BigEndian.Uint32(buf[offset:])
+	offset += s32
+	h.Checksum = binary.BigEndian.Uint32(buf[offset:])
+	offset += s32
+	h.Cookie = binary.BigEndian.Uint32(buf[offset:])
+	offset += s32
+	if crc := binary.BigEndian.Uint32(buf[offset:]); crc != crc32.ChecksumIEEE(buf[:offset]) {
+		return ErrBadRecordCRC
+	}
+	if h.Size < 0 {
+		return ErrBadRecord
+	}
+	return nil
+}
+
+// ReadAt implements io.ReaderAt.
+func (b *Blob) ReadAt(p []byte, off int64) (int, error) {
+	start := time.Now()
+	n, err := b.Backend.ReadAt(p, off)
+	if err != nil && err != io.EOF {
+		return n, b.failed(stok.OpRead, err)
+	}
+	b.observer().OnRead(n, time.Since(start))
+	return n, err
+}
+
+// grow truncates backend to fit size bytes if capacity is not enough.
+func (b *Blob) grow(size int64) (err error) {
+	b.Lock()
+	if size > b.Capacity {
+		newCap := b.Capacity * 2
+		if newCap < size {
+			newCap = size
+		}
+		if err = b.truncate(newCap); err == nil {
+			err = b.writeHeader()
+		}
+	}
+	b.Unlock()
+	return b.failed(stok.OpTruncate, err)
+}
+
+// WriteRecord allocates space for record, writes header with data
+// checksum and data, and returns offset of record.
+// If h.Timestamp is zero, current time is used.
+// Blob capacity is extended if needed.
+func (b *Blob) WriteRecord(h RecordHeader, data []byte) (int64, error) {
+	if b.ReadOnly {
+		return 0, ErrReadOnly
+	}
+	if h.Timestamp == 0 {
+		h.Timestamp = time.Now().UnixNano()
+	}
+	h.Size = int64(len(data))
+	h.Checksum = crc32.ChecksumIEEE(data)
+	size := RecordHeaderSize + h.Size
+	offset, err := b.Allocate(size)
+	if err != nil {
+		return 0, err
+	}
+	if err = b.grow(offset + size); err != nil {
+		return 0, err
+	}
+	buf := AcquireByteBuffer()
+	defer ReleaseByteBuffer(buf)
+	buf.B = append(buf.B, make([]byte, RecordHeaderSize)...)
+	h.Put(buf.B)
+	buf.B = append(buf.B, data...)
+	start := time.Now()
+	n, err := b.Backend.WriteAt(buf.B, offset)
+	if err != nil {
+		return offset, b.failed(stok.OpWrite, err)
+	}
+	b.observer().OnWrite(n, time.Since(start))
+	return offset, nil
+}
+
+// WriteTail writes p at offset, that should be the end of blob, and
+// extends blob by len(p). Returns ErrBadOffset if offset is not the end.
+func (b *Blob) WriteTail(offset int64, p []byte) error {
+	if b.ReadOnly {
+		return ErrReadOnly
+	}
+	end := offset + int64(len(p))
+	if err := b.grow(end); err != nil {
+		return err
+	}
+	if !atomic.CompareAndSwapInt64(&b.Size, offset, end) {
+		return ErrBadOffset
+	}
+	b.observer().OnAllocate(offset, int64(len(p)))
+	start := time.Now()
+	n, err := b.Backend.WriteAt(p, offset)
+	if err != nil {
+		return b.failed(stok.OpWrite, err)
+	}
+	b.observer().OnWrite(n, time.Since(start))
+	return nil
+}
+
+// ReadRecordHeader reads and decodes header of record at offset.
+func (b *Blob) ReadRecordHeader(offset int64) (RecordHeader, error) {
+	var (
+		h   RecordHeader
+		buf [RecordHeaderSize]byte
+	)
+	if _, err := b.ReadAt(buf[:], offset); err != nil {
+		return h, err
+	}
+	return h, h.Read(buf[:])
+}
+
+// ReadRecord reads record at offset, appends its data to buf and
+// verifies data checksum, returning ErrBadRecordChecksum on mismatch.
+// Record should end before size of blob.
+func (b *Blob) ReadRecord(offset int64, buf []byte) (RecordHeader, []byte, error) {
+	return b.ReadRecordBefore(offset, atomic.LoadInt64(&b.Size), buf)
+}
+
+// ReadRecordBefore is ReadRecord of record that ends before end, like
+// record written after synced size of blob. Size in header is checked
+// before data is read, so corrupted header does not allocate more than
+// blob has, and io.ErrUnexpectedEOF is returned if record ends after end.
+func (b *Blob) ReadRecordBefore(offset, end int64, buf []byte) (RecordHeader, []byte, error) {
+	h, err := b.ReadRecordHeader(offset)
+	if err != nil {
+		return h, buf, err
+	}
+	if h.Size > end-offset-RecordHeaderSize {
+		return h, buf, io.ErrUnexpectedEOF
+	}
+	start := len(buf)
+	buf = append(buf, make([]byte, h.Size)...)
+	if _, err = b.ReadAt(buf[start:], offset+RecordHeaderSize); err != nil {
+		return h, buf[:start], err
+	}
+	if crc32.ChecksumIEEE(buf[start:]) != h.Checksum {
+		return h, buf, ErrBadRecordChecksum
+	}
+	return h, buf, nil
+}
+
+// DecodeRecords calls fn for every record in buf, that should contain
+// only whole records, and returns error if any record is invalid.
+// Offsets of records are relative to start of buf.
+func DecodeRecords(buf []byte, fn func(offset int64, h RecordHeader, data []byte) error) error {
+	for offset := 0; offset < len(buf); {
+		var h RecordHeader
+		if err := h.Read(buf[offset:]); err != nil {
+			return err
+		}
+		start := offset + RecordHeaderSize
+		if h.Size > int64(len(buf)-start) {
+			return io.ErrUnexpectedEOF
+		}
+		data := buf[start : start+int(h.Size)]
+		if crc32.ChecksumIEEE(data) != h.Checksum {
+			return ErrBadRecordChecksum
+		}
+		if err := fn(int64(offset), h, data); err != nil {
+			return err
+		}
+		offset = start + int(h.Size)
+	}
+	return nil
+}
+
+// Scanner walks records of Blob in order of offsets.
+type Scanner struct {
+	Blob *Blob
+	// Verify enables checking of data checksums.
+	Verify bool
+	// Corrupted is called for every region [start, end) of blob
+	// that can't be decoded as valid record. Can be nil.
+	Corrupted func(start, end int64, err error)
+}
+
+// scanChunk is size of chunk that is read while searching for record magic.
+const scanChunk = 64 * 1024
+
+// Scan calls fn for every valid record. After corrupted region scanning is
+// resumed from next record magic.
+func (s Scanner) Scan(fn func(offset int64, h RecordHeader) error) error {
+	var (
+		end    = s.Blob.Size
+		offset = int64(blobHeaderSize)
+		data   []byte
+	)
+	for offset < end {
+		h, err := s.Blob.ReadRecordHeader(offset)
+		if err == io.EOF {
+			err = io.ErrUnexpectedEOF
+		}
+		if err == nil && h.Size > end-offset-RecordHeaderSize {
+			err = io.ErrUnexpectedEOF
+		}
+		if err == nil && s.Verify {
+			_, data, err = s.Blob.ReadRecord(offset, data[:0])
+		}
+		if err == ErrBadRecordChecksum {
+			// header is valid, so record boundaries can be trusted
+			next := offset + RecordHeaderSize + h.Size
+			s.corrupted(offset, next, err)
+			offset = next
+			continue
+		}
+		if err == ErrBadRecord || err == ErrBadRecordCRC || err == io.ErrUnexpectedEOF {
+			next, searchErr := s.next(offset+1, end)
+			if searchErr != nil {
+				return searchErr
+			}
+			s.corrupted(offset, next, err)
+			offset = next
+			continue
+		}
+		if err != nil {
+			return err
+		}
+		if err = fn(offset, h); err != nil {
+			return err
+		}
+		offset += RecordHeaderSize + h.Size
+	}
+	return nil
+}
+
+func (s Scanner) corrupted(start, end int64, err error) {
+	if s.Corrupted != nil {
+		s.Corrupted(start, end, err)
+	}
+}
+
+// next returns offset of next record magic in [offset, end) or end.
+func (s Scanner) next(offset, end int64) (int64, error) {
+	buf := make([]byte, scanChunk+len(recordMagic))
+	for end-offset >= int64(len(recordMagic)) {
+		chunk := buf
+		if rest := end - offset; rest < int64(len(chunk)) {
+			chunk = chunk[:rest]
+		}
+		n, err := s.Blob.Backend.ReadAt(chunk, offset)
+		if err != nil && err != io.EOF {
+			return 0, err
+		}
+		if i := bytes.Index(chunk[:n], recordMagic[:]); i >= 0 {
+			return offset + int64(i), nil
+		}
+		if n < len(chunk) || int64(n) == end-offset {
+			break
+		}
+		// overlapping chunks so magic on the boundary is not missed
+		offset += int64(n - len(recordMagic) + 1)
+	}
+	return end, nil
+}
@@ -0,0 +1,136 @@
+package storage
+
+import (
+	"bytes"
+	"io"
+	"math"
+	"testing"
+
+	. "github.com/cydev/stok/stokutils"
+)
+
+func TestRecordHeader_ReadPut(t *testing.T) {
+	buf := make([]byte, RecordHeaderSize)
+	header := RecordHeader{
+		ID:        1234,
+		Size:      5125,
+		Timestamp: 1476873600000000000,
+		Flags:     FlagDeleted,
+		Checksum:  0xdeadbeef,
+		Cookie:    0xcafebabe,
+	}
+	header.Put(buf)
+	newHeader := RecordHeader{}
+	if err := newHeader.Read(buf); err != nil {
+		t.Error(err)
+	}
+	if header != newHeader {
+		t.Error(header, "!=", newHeader)
+	}
+	buf[s64+2]++
+	if err := newHeader.Read(buf); err != ErrBadRecordCRC {
+		t.Error("Expected", ErrBadRecordCRC, "but got", err)
+	}
+	buf[0]++
+	if err := newHeader.Read(buf); err != ErrBadRecord {
+		t.Error("Expected", ErrBadRecord, "but got", err)
+	}
+}
+
+func TestBlob_WriteRecord(t *testing.T) {
+	f := TempFile(t)
+	name := f.Name()
+	MustClose(t, f)
+	b := MustBlob(t, name)
+	defer MustClose(t, b)
+
+	var (
+		offsets []int64
+		records = [][]byte{
+			[]byte("first"),
+			bytes.Repeat([]byte("large"), DefaultBlobSize),
+			[]byte("third"),
+			{},
+		}
+	)
+	for i, data := range records {
+		offset, err := b.WriteRecord(RecordHeader{ID: int64(i)}, data)
+		if err != nil {
+			t.Fatal(err)
+		}
+		offsets = append(offsets, offset)
+	}
+	if b.Capacity < b.Size {
+		t.Error("capacity", b.Capacity, "is less than size", b.Size)
+	}
+	for i, offset := range offsets {
+		h, data, err := b.ReadRecord(offset, nil)
+		if err != nil {
+			t.Error(err)
+		}
+		if h.ID != int64(i) || !bytes.Equal(data, records[i]) {
+			t.Error("record", i, "mismatch")
+		}
+	}
+
+	// corrupting data of first record and header of third one
+	if _, err := b.Backend.WriteAt([]byte("F"), offsets[0]+RecordHeaderSize); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := b.Backend.WriteAt([]byte{0}, offsets[2]+s64+7); err != nil {
+		t.Fatal(err)
+	}
+	var (
+		found     []int64
+		corrupted [][2]int64
+	)
+	s := Scanner{
+		Blob:   b,
+		Verify: true,
+		Corrupted: func(start, end int64, err error) {
+			corrupted = append(corrupted, [2]int64{start, end})
+		},
+	}
+	if err := s.Scan(func(offset int64, h RecordHeader) error {
+		found = append(found, h.ID)
+		return nil
+	}); err != nil {
+		t.Fatal(err)
+	}
+	if len(found) != 2 || found[0] != 1 || found[1] != 3 {
+		t.Error("found", found)
+	}
+	if len(corrupted) != 2 {
+		t.Fatal("corrupted", corrupted)
+	}
+	if corrupted[0] != [2]int64{offsets[0], offsets[1]} {
+		t.Error("corrupted", corrupted[0])
+	}
+	if corrupted[1] != [2]int64{offsets[2], offsets[3]} {
+		t.Error("corrupted", corrupted[1])
+	}
+
+	// size in valid header is larger than blob
+	buf := make([]byte, RecordHeaderSize)
+	RecordHeader{ID: 3, Size: 1 << 62}.Put(buf)
+	if _, err := b.Backend.WriteAt(buf, offsets[3]); err != nil {
+		t.Fatal(err)
+	}
+	if _, _, err := b.ReadRecord(offsets[3], nil); err != io.ErrUnexpectedEOF {
+		t.Error(err, "!=", io.ErrUnexpectedEOF)
+	}
+	// and end of record overflows
+	RecordHeader{ID: 3, Size: math.MaxInt64 - 10}.Put(buf)
+	if _, err := b.Backend.WriteAt(buf, offsets[3]); err != nil {
+		t.Fatal(err)
+	}
+	s.Verify = false
+	if err := s.Scan(func(offset int64, h RecordHeader) error {
+		if h.ID == 3 {
+			t.Error("record with overflowing size is scanned")
+		}
+		return nil
+	}); err != nil {
+		t.Fatal(err)
+	}
+}
@@ -0,0 +1,114 @@
+package volume
+
+import (
+	"bufio"
+	"bytes"
+	"hash/crc32"
+	"io"
+
+	"github.com/cydev/stok/index"
+	"github.com/cydev/stok/storage"
+	"github.com/pkg/errors"
+)
+
+// Walk calls fn for every live record of volume in order of IDs with
+// record header and location.
+func (v *Volume) Walk(fn func(h storage.RecordHeader, l Location) error) error {
+	return index.Iterator{Index: v.Index, Size: LocationSize}.All(func(id int64, buf []byte) error {
+		var l Location
+		if err := l.Read(buf); err != nil {
+			return err
+		}
+		h, err := v.Blob.ReadRecordHeader(l.Offset)
+		if err != nil {
+			return errors.Wrapf(err, "failed to read record %d", id)
+		}
+		return fn(h, l)
+	})
+}
+
+// Export writes every live record of volume to w as stream of record
+// headers followed by data, that is the format of blob without its
+// header, and returns count of written records. Checksums of records
+// are verified.
+func (v *Volume) Export(w io.Writer) (int, error) {
+	var (
+		n    int
+		data []byte
+		hbuf [storage.RecordHeaderSize]byte
+	)
+	bw := bufio.NewWriter(w)
+	err := v.Walk(func(_ storage.RecordHeader, l Location) error {
+		h, buf, err := v.Blob.ReadRecord(l.Offset, data[:0])
+		if err != nil {
+			return errors.Wrapf(err, "failed to read record %d", h.ID)
+		}
+		data = buf
+		h.Put(hbuf[:])
+		if _, err = bw.Write(hbuf[:]); err != nil {
+			return err
+		}
+		if _, err = bw.Write(data); err != nil {
+			return err
+		}
+		n++
+		return nil
+	})
+	if err != nil {
+		return n, err
+	}
+	return n, bw.Flush()
+}
+
+// Import writes records from stream in format of Export to volume,
+// preserving their IDs, cookies and timestamps, and returns count of
+// imported records. Tombstones in stream delete records, so content
+// of blob after its header can be imported too.
+func (v *Volume) Import(r io.Reader) (int, error) {
+	var (
+		n    int
+		data bytes.Buffer
+		hbuf [storage.RecordHeaderSize]byte
+	)
+	br := bufio.NewReader(r)
+	for {
+		_, err := io.ReadFull(br, hbuf[:])
+		if err == io.EOF {
+			return n, nil
+		}
+		if err != nil {
+			return n, errors.Wrap(err, "failed to read header")
+		}
+		var h storage.RecordHeader
+		if err = h.Read(hbuf[:]); err != nil {
+			return n, err
+		}
+		if h.ID < index.StartID {
+			return n, index.ErrBadKey
+		}
+		if h.Deleted() {
+			if err = v.Delete(h.ID, h.Cookie); err != nil && err != ErrNotFound {
+				return n, errors.Wrapf(err, "failed to delete record %d", h.ID)
+			}
+			continue
+		}
+		// size from stream can't be trusted, so buffer grows as data
+		// is read instead of being allocated by size
+		data.Reset()
+		read, err := data.ReadFrom(io.LimitReader(br, h.Size))
+		if err == nil && read < h.Size {
+			err = io.ErrUnexpectedEOF
+		}
+		if err != nil {
+			return n, errors.Wrapf(err, "failed to read record %d", h.ID)
+		}
+		if crc32.ChecksumIEEE(data.Bytes()) != h.Checksum {
+			return n, storage.ErrBadRecordChecksum
+		}
+		v.reserve(h.ID)
+		if err = v.put(h, data.Bytes()); err != nil {
+			return n, err
+		}
+		n++
+	}
+}
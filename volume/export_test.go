@@ -0,0 +1,97 @@
+package volume
+
+import (
+	"bytes"
+	"io"
+	"path/filepath"
+	"testing"
+
+	"github.com/cydev/stok/stokutils"
+	"github.com/cydev/stok/storage"
+	"github.com/pkg/errors"
+)
+
+func TestVolume_Export(t *testing.T) {
+	dir, clear := tempDir(t)
+	defer clear()
+	src, err := Open(filepath.Join(dir, "src"), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer stokutils.MustClose(t, src)
+	var (
+		ids     []int64
+		cookies []uint32
+	)
+	for _, data := range []string{"first", "second", "third"} {
+		id, cookie, err := src.Put([]byte(data))
+		if err != nil {
+			t.Fatal(err)
+		}
+		ids = append(ids, id)
+		cookies = append(cookies, cookie)
+	}
+	if err = src.Delete(ids[1], cookies[1]); err != nil {
+		t.Fatal(err)
+	}
+	var buf bytes.Buffer
+	n, err := src.Export(&buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n != 2 {
+		t.Error("exported", n, "!= 2")
+	}
+
+	dst, err := Open(filepath.Join(dir, "dst"), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer stokutils.MustClose(t, dst)
+	if n, err = dst.Import(bytes.NewReader(buf.Bytes())); err != nil {
+		t.Fatal(err)
+	}
+	if n != 2 {
+		t.Error("imported", n, "!= 2")
+	}
+	err = src.Walk(func(h storage.RecordHeader, l Location) error {
+		data, err := dst.Get(h.ID, h.Cookie, nil)
+		if err != nil {
+			return err
+		}
+		expected, err := src.Get(h.ID, h.Cookie, nil)
+		if err != nil {
+			return err
+		}
+		if !bytes.Equal(data, expected) {
+			t.Errorf("%d: %q != %q", h.ID, data, expected)
+		}
+		got, err := dst.Stat(h.ID, h.Cookie)
+		if err != nil {
+			return err
+		}
+		if got.Timestamp != h.Timestamp {
+			t.Error("timestamp is not preserved")
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if dst.Next() <= ids[2] {
+		t.Error("next id", dst.Next(), "is not after imported ids")
+	}
+
+	// corrupted stream is rejected
+	corrupted := buf.Bytes()
+	corrupted[len(corrupted)-1]++
+	if _, err = dst.Import(bytes.NewReader(corrupted)); err != storage.ErrBadRecordChecksum {
+		t.Error(err, "!=", storage.ErrBadRecordChecksum)
+	}
+	// size in header is not allocated before data is read
+	huge := make([]byte, storage.RecordHeaderSize)
+	storage.RecordHeader{ID: ids[0], Size: 1 << 62}.Put(huge)
+	if _, err = dst.Import(bytes.NewReader(huge)); errors.Cause(err) != io.ErrUnexpectedEOF {
+		t.Error(err, "!=", io.ErrUnexpectedEOF)
+	}
+}
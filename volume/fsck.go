@@ -0,0 +1,288 @@
+package volume
+
+import (
+	"encoding/json"
+	"os"
+	"sort"
+
+	"github.com/cydev/stok/index"
+	"github.com/cydev/stok/storage"
+	"github.com/pkg/errors"
+)
+
+// MarshalJSON implements json.Marshaler, encoding Err as string.
+func (r Region) MarshalJSON() ([]byte, error) {
+	v := struct {
+		Start int64  `json:"start"`
+		End   int64  `json:"end"`
+		Err   string `json:"error,omitempty"`
+	}{Start: r.Start, End: r.End}
+	if r.Err != nil {
+		v.Err = r.Err.Error()
+	}
+	return json.Marshal(v)
+}
+
+// Mismatch is index entry, that does not point to the last valid
+// record with its ID in blob.
+type Mismatch struct {
+	ID     int64 `json:"id"`
+	Offset int64 `json:"offset"` // offset in index entry, zero if entry is missing
+	Record int64 `json:"record"` // offset of last valid record, zero if ID is deleted or not found
+}
+
+// Dangling reports whether entry points to record, that is deleted,
+// corrupted or does not exist.
+func (m Mismatch) Dangling() bool {
+	return m.Record == 0
+}
+
+// FsckReport is result of Fsck.
+type FsckReport struct {
+	Blob     string `json:"blob"`
+	Index    string `json:"index"`
+	FileSize int64  `json:"fileSize"`
+	Size     int64  `json:"size"`     // size in header
+	Capacity int64  `json:"capacity"` // capacity in header
+	// HeaderErr is error of header check, like ErrBadHeaderCRC.
+	HeaderErr string `json:"headerError,omitempty"`
+	// IndexErr is error of index check, like ErrBadBitmap, that
+	// prevents comparing index with blob.
+	IndexErr string `json:"indexError,omitempty"`
+	Records  int64  `json:"records"` // count of valid records, including tombstones
+	Deleted  int64  `json:"deleted"` // count of tombstones
+	// Unsynced is count of valid records after size in header, that
+	// are written before blob header was synced.
+	Unsynced   int64      `json:"unsynced"`
+	Corrupted  []Region   `json:"corrupted"`
+	TornTail   *Region    `json:"tornTail,omitempty"` // partially written records at the end
+	NoIndex    bool       `json:"noIndex"`            // index does not exist and is not checked
+	Mismatches []Mismatch `json:"mismatches"`
+	OK         bool       `json:"ok"`       // no problems are found
+	Repaired   bool       `json:"repaired"` // problems are repaired
+}
+
+// Fsck checks blob at path and volume index alongside it. Header is
+// checked for magic, CRC and capacity, checksums of all records are
+// verified, and index entries are compared with the last valid records
+// of blob.
+//
+// If repair is true, torn tail and everything after the last valid
+// record are truncated, records written after size in header are kept,
+// header is rewritten keeping its capacity if it is valid, and index
+// entries are updated to point to the last valid records, so dangling
+// entries are deleted. Corrupted records in the middle of blob are left
+// as is.
+//
+// Volume should not be opened during Fsck.
+func Fsck(path string, repair bool) (*FsckReport, error) {
+	r := &FsckReport{Blob: path, Index: path + IndexSuffix}
+	flag := os.O_RDONLY
+	if repair {
+		flag = os.O_RDWR
+	}
+	f, err := os.OpenFile(path, flag, 0)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	info, err := f.Stat()
+	if err != nil {
+		return nil, err
+	}
+	r.FileSize = info.Size()
+	headerErr := readHeader(f, r)
+
+	// header size can't be trusted if header is corrupted
+	b := &storage.Blob{Backend: f, Size: r.Size, Capacity: r.FileSize, ReadOnly: true}
+	switch {
+	case headerErr != nil || r.Size > r.FileSize:
+		b.Size = r.FileSize
+	case r.Size < storage.BlobHeaderSize:
+		// header of new blob is written before size is set
+		b.Size = storage.BlobHeaderSize
+	}
+	latest := make(map[int64]Location)
+	s := storage.Scanner{
+		Blob:   b,
+		Verify: true,
+		Corrupted: func(start, end int64, err error) {
+			r.Corrupted = append(r.Corrupted, Region{Start: start, End: end, Err: err})
+		},
+	}
+	record := func(offset int64, h storage.RecordHeader) {
+		r.Records++
+		if h.Deleted() {
+			r.Deleted++
+			latest[h.ID] = Location{}
+			return
+		}
+		latest[h.ID] = Location{Offset: offset, Size: h.Size}
+	}
+	err = s.Scan(func(offset int64, h storage.RecordHeader) error {
+		record(offset, h)
+		return nil
+	})
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to scan")
+	}
+	end := b.Size
+	if headerErr == nil {
+		// records, that are written after last header update
+		var data []byte
+		for end+storage.RecordHeaderSize <= r.FileSize {
+			h, buf, err := b.ReadRecordBefore(end, r.FileSize, data[:0])
+			if err != nil {
+				break
+			}
+			data = buf
+			record(end, h)
+			r.Unsynced++
+			end += storage.RecordHeaderSize + h.Size
+		}
+	}
+	if n := len(r.Corrupted); n > 0 && r.Corrupted[n-1].End >= end {
+		tail := r.Corrupted[n-1]
+		r.TornTail = &tail
+		r.Corrupted = r.Corrupted[:n-1]
+		end = tail.Start
+	}
+	if headerErr == storage.ErrBadHeader && r.Records == 0 {
+		// not a blob, so nothing to repair
+		r.HeaderErr = headerErr.Error()
+		return r, nil
+	}
+
+	_, statErr := os.Stat(r.Index)
+	r.NoIndex = os.IsNotExist(statErr)
+	var idx *index.RWAtIndex
+	if !r.NoIndex || repair {
+		idx, err = index.Open(r.Index, LocationSize)
+		if errors.Cause(err) == index.ErrBadBitmap {
+			r.IndexErr = index.ErrBadBitmap.Error()
+			err = nil
+			if repair {
+				// entries without bitmap can't be trusted, so index
+				// is created again and filled from blob
+				if err = removeIndex(r.Index); err == nil {
+					idx, err = index.Open(r.Index, LocationSize)
+				}
+			}
+		}
+		if err != nil {
+			return nil, err
+		}
+	}
+	if idx != nil {
+		defer idx.Close()
+		if r.Mismatches, err = mismatches(idx, latest); err != nil {
+			return nil, err
+		}
+	}
+	if headerErr != nil {
+		r.HeaderErr = headerErr.Error()
+	}
+	r.OK = headerErr == nil && r.IndexErr == "" && r.Unsynced == 0 &&
+		len(r.Corrupted) == 0 && r.TornTail == nil && len(r.Mismatches) == 0
+	if !repair || r.OK {
+		return r, nil
+	}
+
+	// capacity in header is kept, unless it can't be trusted
+	capacity := r.FileSize
+	if headerErr == nil {
+		capacity = r.Capacity
+	}
+	if capacity < end {
+		capacity = end
+	}
+	if err = truncate(f, end, capacity); err != nil {
+		return r, errors.Wrap(err, "failed to truncate torn tail")
+	}
+	b.ReadOnly = false
+	b.Size, b.Capacity = end, capacity
+	if err = b.Sync(); err != nil {
+		return r, errors.Wrap(err, "failed to write header")
+	}
+	var buf [LocationSize]byte
+	for _, m := range r.Mismatches {
+		if m.Dangling() {
+			err = idx.Delete(m.ID)
+			if errors.Cause(err) == index.ErrNotFound {
+				err = nil
+			}
+		} else {
+			latest[m.ID].Put(buf[:])
+			err = idx.Set(m.ID, buf[:])
+		}
+		if err != nil {
+			return r, errors.Wrapf(err, "failed to repair index entry %d", m.ID)
+		}
+	}
+	r.Repaired = true
+	return r, nil
+}
+
+// truncate discards bytes of f after end, like torn tail, and keeps
+// file capacity bytes long, so discarded bytes are zeroes. File is
+// synced, so header is rewritten after tail is discarded.
+func truncate(f *os.File, end, capacity int64) error {
+	if err := f.Truncate(end); err != nil {
+		return err
+	}
+	if capacity > end {
+		if err := f.Truncate(capacity); err != nil {
+			return err
+		}
+	}
+	return f.Sync()
+}
+
+// readHeader reads blob header to r and returns error of its check.
+func readHeader(f *os.File, r *FsckReport) error {
+	var buf [storage.BlobHeaderSize]byte
+	if _, err := f.ReadAt(buf[:], 0); err != nil {
+		return storage.ErrBadHeader
+	}
+	var h storage.BlobHeader
+	if err := h.Read(buf[:]); err != nil {
+		return err
+	}
+	r.Size, r.Capacity = h.Size, h.Capacity
+	if h.Capacity > r.FileSize {
+		return storage.ErrBadHeaderCapacity
+	}
+	return nil
+}
+
+// mismatches returns entries of idx, that do not match latest
+// locations, sorted by ID.
+func mismatches(idx index.Index, latest map[int64]Location) ([]Mismatch, error) {
+	var (
+		list    []Mismatch
+		indexed = make(map[int64]bool)
+	)
+	err := index.Iterator{Index: idx, Size: LocationSize}.All(func(id int64, buf []byte) error {
+		var l Location
+		if err := l.Read(buf); err != nil {
+			return err
+		}
+		indexed[id] = true
+		if want := latest[id]; want != l {
+			list = append(list, Mismatch{ID: id, Offset: l.Offset, Record: want.Offset})
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to walk index")
+	}
+	for id, l := range latest {
+		if l.Offset != 0 && !indexed[id] {
+			list = append(list, Mismatch{ID: id, Record: l.Offset})
+		}
+	}
+	sort.Slice(list, func(i, j int) bool {
+		return list[i].ID < list[j].ID
+	})
+	return list, nil
+}
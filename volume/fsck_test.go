@@ -0,0 +1,165 @@
+package volume
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/cydev/stok/index"
+	"github.com/cydev/stok/stokutils"
+	"github.com/cydev/stok/storage"
+)
+
+func writeHeader(t testing.TB, path string, h storage.BlobHeader) {
+	f, err := os.OpenFile(path, os.O_WRONLY, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer stokutils.MustClose(t, f)
+	buf := make([]byte, storage.BlobHeaderSize)
+	h.Put(buf)
+	if _, err = f.WriteAt(buf, 0); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func writeAt(t testing.TB, path string, offset int64, data []byte) {
+	f, err := os.OpenFile(path, os.O_WRONLY, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer stokutils.MustClose(t, f)
+	if _, err = f.WriteAt(data, offset); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func readAt(t testing.TB, path string, offset int64, n int) []byte {
+	f, err := os.Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer stokutils.MustClose(t, f)
+	buf := make([]byte, n)
+	if _, err = f.ReadAt(buf, offset); err != nil {
+		t.Fatal(err)
+	}
+	return buf
+}
+
+func mustFsck(t testing.TB, path string, repair bool) *FsckReport {
+	r, err := Fsck(path, repair)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return r
+}
+
+func TestFsck(t *testing.T) {
+	dir, clear := tempDir(t)
+	defer clear()
+	path := filepath.Join(dir, "volume")
+	v, err := Open(path, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var (
+		ids       []int64
+		cookies   []uint32
+		locations []Location
+	)
+	for _, data := range []string{"first", "second", "third"} {
+		id, cookie, err := v.Put([]byte(data))
+		if err != nil {
+			t.Fatal(err)
+		}
+		l, err := v.Locate(id)
+		if err != nil {
+			t.Fatal(err)
+		}
+		ids = append(ids, id)
+		cookies = append(cookies, cookie)
+		locations = append(locations, l)
+	}
+	if err = v.Delete(ids[1], cookies[1]); err != nil {
+		t.Fatal(err)
+	}
+	size, capacity := v.Blob.Size, v.Blob.Capacity
+	// dangling entry of deleted record and missing entry of third
+	var buf [LocationSize]byte
+	locations[1].Put(buf[:])
+	if err = v.Index.Set(ids[1], buf[:]); err != nil {
+		t.Fatal(err)
+	}
+	if err = v.Index.Delete(ids[2]); err != nil {
+		t.Fatal(err)
+	}
+	stokutils.MustClose(t, v)
+
+	// header is not updated after third record is written
+	writeHeader(t, path, storage.BlobHeader{Size: locations[2].Offset, Capacity: capacity})
+	r := mustFsck(t, path, false)
+	if r.OK || r.Unsynced != 2 || r.Records != 4 || r.Deleted != 1 {
+		t.Errorf("bad report %+v", r)
+	}
+	if len(r.Mismatches) != 2 || !r.Mismatches[0].Dangling() || r.Mismatches[1].Record != locations[2].Offset {
+		t.Errorf("bad mismatches %+v", r.Mismatches)
+	}
+	if r = mustFsck(t, path, true); !r.Repaired {
+		t.Error("not repaired")
+	}
+	if r = mustFsck(t, path, false); !r.OK || r.Size != size || r.Capacity != capacity {
+		t.Errorf("bad report after repair %+v", r)
+	}
+
+	// torn tail
+	writeHeader(t, path, storage.BlobHeader{Size: size + 100, Capacity: capacity})
+	writeAt(t, path, size, []byte("torn"))
+	r = mustFsck(t, path, false)
+	if r.OK || r.TornTail == nil || r.TornTail.Start != size || len(r.Corrupted) != 0 {
+		t.Errorf("bad report %+v", r)
+	}
+	mustFsck(t, path, true)
+	if r = mustFsck(t, path, false); !r.OK || r.Size != size || r.Capacity != capacity || r.FileSize != capacity {
+		t.Errorf("bad report after repair %+v", r)
+	}
+	if tail := readAt(t, path, size, 4); !bytes.Equal(tail, make([]byte, 4)) {
+		t.Errorf("torn tail %q is not truncated", tail)
+	}
+
+	// corrupted header
+	writeHeader(t, path, storage.BlobHeader{Size: size, Capacity: capacity * 2})
+	r = mustFsck(t, path, false)
+	if r.OK || r.HeaderErr != storage.ErrBadHeaderCapacity.Error() {
+		t.Errorf("bad report %+v", r)
+	}
+	mustFsck(t, path, true)
+	if r = mustFsck(t, path, false); !r.OK || r.Size != size || r.Capacity != capacity {
+		t.Errorf("bad report after repair %+v", r)
+	}
+
+	// lost bitmap
+	if err = os.Remove(path + IndexSuffix + index.BitmapSuffix); err != nil {
+		t.Fatal(err)
+	}
+	if r = mustFsck(t, path, false); r.OK || r.IndexErr != index.ErrBadBitmap.Error() {
+		t.Errorf("bad report %+v", r)
+	}
+	mustFsck(t, path, true)
+	if r = mustFsck(t, path, false); !r.OK {
+		t.Errorf("bad report after repair %+v", r)
+	}
+
+	v, err = Open(path, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer stokutils.MustClose(t, v)
+	if data, err := v.Get(ids[2], cookies[2], nil); err != nil || string(data) != "third" {
+		t.Error(string(data), err)
+	}
+	if _, err := v.Get(ids[1], cookies[1], nil); err != ErrNotFound {
+		t.Error(err, "!=", ErrNotFound)
+	}
+}
@@ -0,0 +1,111 @@
+package volume
+
+import (
+	"sync/atomic"
+
+	"github.com/cydev/stok/index"
+	"github.com/cydev/stok/storage"
+	"github.com/pkg/errors"
+)
+
+// Duplicate is record with ID that is already written before.
+type Duplicate struct {
+	ID       int64
+	Offset   int64 // offset of overridden record
+	Override int64 // offset of record that is indexed
+}
+
+// Region is part of blob that can't be read as valid record.
+type Region struct {
+	Start int64
+	End   int64
+	Err   error
+}
+
+// RebuildReport is result of Rebuild.
+type RebuildReport struct {
+	Records int64 // count of valid records, including tombstones
+	Deleted int64 // count of tombstones
+	// Unsynced is count of valid records after size in header, that
+	// are written before blob header was synced.
+	Unsynced   int64
+	Duplicates []Duplicate
+	Corrupted  []Region
+}
+
+// Rebuild scans all records of b, verifying checksums, and writes their
+// locations to idx, that should be empty. If some ID is written
+// multiple times, the last record is indexed. Tombstones delete
+// previous records with the same ID.
+//
+// Valid records after size in blob header are indexed too, until the
+// first invalid one, and size of blob is advanced to their end, so they
+// are not overwritten.
+func Rebuild(b *storage.Blob, idx index.Index) (*RebuildReport, error) {
+	var (
+		report = new(RebuildReport)
+		buf    = make([]byte, LocationSize)
+		prev   Location
+	)
+	s := storage.Scanner{
+		Blob:   b,
+		Verify: true,
+		Corrupted: func(start, end int64, err error) {
+			report.Corrupted = append(report.Corrupted, Region{
+				Start: start,
+				End:   end,
+				Err:   err,
+			})
+		},
+	}
+	record := func(offset int64, h storage.RecordHeader) error {
+		report.Records++
+		if h.Deleted() {
+			report.Deleted++
+			err := idx.Delete(h.ID)
+			if errors.Cause(err) == index.ErrNotFound {
+				return nil
+			}
+			return errors.Wrap(err, "failed to delete")
+		}
+		err := idx.Get(h.ID, buf)
+		if err == nil && prev.Read(buf) == nil && prev.Offset != 0 {
+			report.Duplicates = append(report.Duplicates, Duplicate{
+				ID:       h.ID,
+				Offset:   prev.Offset,
+				Override: offset,
+			})
+		}
+		Location{Offset: offset, Size: h.Size}.Put(buf)
+		return errors.Wrap(idx.Set(h.ID, buf), "failed to set")
+	}
+	if err := s.Scan(record); err != nil {
+		return report, errors.Wrap(err, "failed to scan")
+	}
+	end := atomic.LoadInt64(&b.Size)
+	if end < storage.BlobHeaderSize {
+		// header of new blob is written before size is set
+		end = storage.BlobHeaderSize
+	}
+	var data []byte
+	for end+storage.RecordHeaderSize <= b.Capacity {
+		h, buf, err := b.ReadRecordBefore(end, b.Capacity, data[:0])
+		if err != nil {
+			break
+		}
+		data = buf
+		if err = record(end, h); err != nil {
+			return report, err
+		}
+		report.Unsynced++
+		end += storage.RecordHeaderSize + h.Size
+	}
+	if report.Unsynced == 0 || b.ReadOnly {
+		return report, nil
+	}
+	// allocating, so observer of blob sees new size
+	if _, err := b.Allocate(end - atomic.LoadInt64(&b.Size)); err != nil {
+		return report, errors.Wrap(err, "failed to allocate")
+	}
+	return report, errors.Wrap(b.Sync(), "failed to sync header")
+}
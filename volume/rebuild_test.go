@@ -0,0 +1,140 @@
+package volume
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/cydev/stok/index"
+	"github.com/cydev/stok/stokutils"
+	"github.com/cydev/stok/storage"
+)
+
+func tempDir(t testing.TB) (string, func()) {
+	dir, err := ioutil.TempDir("", "volume")
+	if err != nil {
+		t.Fatal(err)
+	}
+	return dir, func() {
+		os.RemoveAll(dir)
+	}
+}
+
+func TestRebuild(t *testing.T) {
+	dir, clear := tempDir(t)
+	defer clear()
+	b, err := storage.OpenBlob(filepath.Join(dir, "blob"), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer stokutils.MustClose(t, b)
+	write := func(id int64, flags uint32, data string) int64 {
+		offset, err := b.WriteRecord(storage.RecordHeader{ID: id, Flags: flags}, []byte(data))
+		if err != nil {
+			t.Fatal(err)
+		}
+		return offset
+	}
+	write(1, 0, "one")
+	first := write(2, 0, "two")
+	write(3, 0, "three")
+	second := write(2, 0, "two again")
+	write(3, storage.FlagDeleted, "")
+	bad := write(4, 0, "four")
+	if _, err := b.Backend.WriteAt([]byte("F"), bad+storage.RecordHeaderSize); err != nil {
+		t.Fatal(err)
+	}
+
+	idx, err := index.Open(filepath.Join(dir, "blob"+IndexSuffix), LocationSize)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer stokutils.MustClose(t, idx)
+	report, err := Rebuild(b, idx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if report.Records != 5 || report.Deleted != 1 {
+		t.Error("bad report", report)
+	}
+	if len(report.Duplicates) != 1 || report.Duplicates[0] != (Duplicate{2, first, second}) {
+		t.Error("bad duplicates", report.Duplicates)
+	}
+	if len(report.Corrupted) != 1 || report.Corrupted[0].Start != bad {
+		t.Error("bad corrupted", report.Corrupted)
+	}
+	if n, _ := idx.Count(); n != 2 {
+		t.Error("count", n, "!=", 2)
+	}
+	var (
+		buf = make([]byte, LocationSize)
+		l   Location
+	)
+	if err := idx.Get(2, buf); err != nil {
+		t.Fatal(err)
+	}
+	if err := l.Read(buf); err != nil {
+		t.Fatal(err)
+	}
+	if l != (Location{Offset: second, Size: int64(len("two again"))}) {
+		t.Error("bad location", l)
+	}
+	if err := idx.Get(3, buf); err != index.ErrNotFound {
+		t.Error(err, "!=", index.ErrNotFound)
+	}
+}
+
+func TestRebuildUnsynced(t *testing.T) {
+	dir, clear := tempDir(t)
+	defer clear()
+	path := filepath.Join(dir, "blob")
+	b, err := storage.OpenBlob(path, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer stokutils.MustClose(t, b)
+	write := func(id int64, data string) int64 {
+		offset, err := b.WriteRecord(storage.RecordHeader{ID: id}, []byte(data))
+		if err != nil {
+			t.Fatal(err)
+		}
+		return offset
+	}
+	write(1, "synced")
+	if err = b.Sync(); err != nil {
+		t.Fatal(err)
+	}
+	synced := b.Size
+	write(2, "unsynced")
+	write(1, "unsynced again")
+	end := b.Size
+	torn := write(3, "torn")
+	if _, err = b.Backend.WriteAt([]byte("T"), torn+storage.RecordHeaderSize); err != nil {
+		t.Fatal(err)
+	}
+	// size in header before records are written
+	b.Size = synced
+
+	idx, err := index.Open(path+IndexSuffix, LocationSize)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer stokutils.MustClose(t, idx)
+	report, err := Rebuild(b, idx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if report.Records != 3 || report.Unsynced != 2 || len(report.Duplicates) != 1 {
+		t.Error("bad report", report)
+	}
+	if b.Size != end {
+		t.Error("size", b.Size, "!=", end)
+	}
+	if n, _ := idx.Count(); n != 2 {
+		t.Error("count", n, "!=", 2)
+	}
+	if err = idx.Get(3, make([]byte, LocationSize)); err != index.ErrNotFound {
+		t.Error(err, "!=", index.ErrNotFound)
+	}
+}
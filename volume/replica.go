@@ -0,0 +1,201 @@
+package volume
+
+import (
+	"sync"
+	"sync/atomic"
+
+	"github.com/cydev/stok"
+	"github.com/cydev/stok/index"
+	"github.com/cydev/stok/storage"
+	"github.com/pkg/errors"
+)
+
+// ErrDiverged means that replica blob is larger than blob of primary.
+const ErrDiverged stok.Error = "Replica diverged from primary"
+
+// ReplicaChunk is size of data that is sent to replica in single Apply,
+// unless single record is larger.
+const ReplicaChunk = 1024 * 1024
+
+// Replica is copy of volume that receives blob contents from primary.
+// Blob of replica is byte-to-byte copy of primary blob, so records are
+// located at the same offsets.
+type Replica interface {
+	// End returns offset of the end of replica blob.
+	End() (int64, error)
+	// Apply writes whole records from data to blob at offset and persists
+	// them. Returns storage.ErrBadOffset if offset is not the end of blob.
+	Apply(offset int64, data []byte) error
+}
+
+type replica struct {
+	Replica
+	end int64 // acknowledged end of replica blob, 0 if unknown
+}
+
+// Replicate adds replicas to volume. Every following write returns only
+// after all replicas persist it, and missing records are sent to replicas
+// that fall behind. Should be called before any write.
+func (v *Volume) Replicate(replicas ...Replica) {
+	v.wmux.Lock()
+	for _, r := range replicas {
+		v.replicas = append(v.replicas, &replica{Replica: r})
+	}
+	v.wmux.Unlock()
+}
+
+// write calls fn and replicates its result. Writes are serialized if
+// volume has replicas, so blob always ends with whole records.
+// If fn fails, space allocated by it is released, so it is not sent to
+// replicas as hole of zeros.
+func (v *Volume) write(fn func() error) error {
+	if len(v.replicas) == 0 {
+		v.tail.RLock()
+		defer v.tail.RUnlock()
+		return fn()
+	}
+	v.wmux.Lock()
+	defer v.wmux.Unlock()
+	v.tail.RLock()
+	end := atomic.LoadInt64(&v.Blob.Size)
+	err := fn()
+	if err != nil {
+		atomic.StoreInt64(&v.Blob.Size, end)
+	}
+	v.tail.RUnlock()
+	if err != nil {
+		return err
+	}
+	return errors.Wrap(v.replicate(), "failed to replicate")
+}
+
+// replicate sends blob contents to all replicas in parallel.
+// Should be called with v.wmux held.
+func (v *Volume) replicate() error {
+	var (
+		end  = atomic.LoadInt64(&v.Blob.Size)
+		wg   sync.WaitGroup
+		errs = make([]error, len(v.replicas))
+	)
+	for i, r := range v.replicas {
+		wg.Add(1)
+		go func(i int, r *replica) {
+			defer wg.Done()
+			errs[i] = v.catchUp(r, end)
+		}(i, r)
+	}
+	wg.Wait()
+	for _, err := range errs {
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// catchUp sends records of blob to replica until its end is reached.
+func (v *Volume) catchUp(r *replica, end int64) error {
+	var buf []byte
+	for retry := true; ; {
+		if r.end == 0 {
+			e, err := r.End()
+			if err != nil {
+				return err
+			}
+			r.end = e
+		}
+		if r.end > end {
+			r.end = 0
+			return ErrDiverged
+		}
+		err := v.send(r, end, buf)
+		if errors.Cause(err) == storage.ErrBadOffset && retry {
+			// replica state changed, requesting its end again
+			r.end, retry = 0, false
+			continue
+		}
+		if err != nil {
+			r.end = 0
+		}
+		return err
+	}
+}
+
+func (v *Volume) send(r *replica, end int64, buf []byte) error {
+	for r.end < end {
+		next, err := v.chunk(r.end, end)
+		if err != nil {
+			return err
+		}
+		buf = append(buf[:0], make([]byte, next-r.end)...)
+		if _, err = v.Blob.ReadAt(buf, r.end); err != nil {
+			return errors.Wrap(err, "failed to read")
+		}
+		if err = r.Apply(r.end, buf); err != nil {
+			return err
+		}
+		r.end = next
+	}
+	return nil
+}
+
+// chunk returns end of last whole record in [start, end) that fits
+// to ReplicaChunk after start, but at least end of first record.
+func (v *Volume) chunk(start, end int64) (int64, error) {
+	offset := start
+	for offset < end {
+		h, err := v.Blob.ReadRecordHeader(offset)
+		if err != nil {
+			return 0, errors.Wrap(err, "failed to read record header")
+		}
+		next := offset + storage.RecordHeaderSize + h.Size
+		if next-start > ReplicaChunk && offset > start {
+			break
+		}
+		offset = next
+	}
+	return offset, nil
+}
+
+// End returns offset of the end of blob, waiting for records that are
+// being written, so blob contains whole records before it.
+func (v *Volume) End() (int64, error) {
+	v.tail.Lock()
+	defer v.tail.Unlock()
+	return atomic.LoadInt64(&v.Blob.Size), nil
+}
+
+// Apply implements Replica.
+func (v *Volume) Apply(offset int64, data []byte) error {
+	v.wmux.Lock()
+	defer v.wmux.Unlock()
+	v.tail.RLock()
+	defer v.tail.RUnlock()
+	if offset != atomic.LoadInt64(&v.Blob.Size) {
+		return storage.ErrBadOffset
+	}
+	noop := func(int64, storage.RecordHeader, []byte) error { return nil }
+	if err := storage.DecodeRecords(data, noop); err != nil {
+		return errors.Wrap(err, "bad records")
+	}
+	if err := v.Blob.WriteTail(offset, data); err != nil {
+		return errors.Wrap(err, "failed to write")
+	}
+	err := storage.DecodeRecords(data, func(pos int64, h storage.RecordHeader, _ []byte) error {
+		if h.Deleted() {
+			err := v.Index.Delete(h.ID)
+			if errors.Cause(err) == index.ErrNotFound {
+				return nil
+			}
+			return errors.Wrap(err, "failed to delete")
+		}
+		v.reserve(h.ID)
+		var buf [LocationSize]byte
+		Location{Offset: offset + pos, Size: h.Size}.Put(buf[:])
+		return errors.Wrap(v.Index.Set(h.ID, buf[:]), "failed to set index")
+	})
+	if err != nil {
+		return errors.Wrap(err, "failed to index")
+	}
+	return v.Blob.Sync()
+}
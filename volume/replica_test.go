@@ -0,0 +1,237 @@
+package volume
+
+import (
+	"bytes"
+	"path/filepath"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/cydev/stok/stokutils"
+	"github.com/cydev/stok/storage"
+	"github.com/pkg/errors"
+)
+
+type failingReplica struct {
+	Replica
+	fail bool
+}
+
+func (r *failingReplica) Apply(offset int64, data []byte) error {
+	if r.fail {
+		return errors.New("replica is down")
+	}
+	return r.Replica.Apply(offset, data)
+}
+
+type failingBlobBackend struct {
+	storage.BlobBackend
+	fail bool
+}
+
+func (b *failingBlobBackend) WriteAt(p []byte, off int64) (int, error) {
+	if b.fail {
+		return 0, errors.New("disk is full")
+	}
+	return b.BlobBackend.WriteAt(p, off)
+}
+
+// blockingBlobBackend blocks writes until release is closed.
+type blockingBlobBackend struct {
+	storage.BlobBackend
+	writing chan struct{}
+	release chan struct{}
+}
+
+func (b *blockingBlobBackend) WriteAt(p []byte, off int64) (int, error) {
+	close(b.writing)
+	<-b.release
+	return b.BlobBackend.WriteAt(p, off)
+}
+
+func TestReplicate(t *testing.T) {
+	dir, clear := tempDir(t)
+	defer clear()
+	open := func(name string) *Volume {
+		v, err := Open(filepath.Join(dir, name), nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return v
+	}
+	primary := open("primary")
+	defer stokutils.MustClose(t, primary)
+
+	// records written before replication are shipped on first write
+	first, firstCookie, err := primary.Put([]byte("before replication"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	healthy := open("healthy")
+	defer stokutils.MustClose(t, healthy)
+	behind := open("behind")
+	defer stokutils.MustClose(t, behind)
+	flaky := &failingReplica{Replica: behind}
+	primary.Replicate(healthy, flaky)
+
+	big := bytes.Repeat([]byte{'x'}, ReplicaChunk)
+	type record struct {
+		id     int64
+		cookie uint32
+		data   []byte
+	}
+	records := []record{{first, firstCookie, []byte("before replication")}}
+	for _, data := range [][]byte{[]byte("one"), big, []byte("two")} {
+		id, cookie, err := primary.Put(data)
+		if err != nil {
+			t.Fatal(err)
+		}
+		records = append(records, record{id, cookie, data})
+	}
+	if err = primary.Delete(records[1].id, records[1].cookie); err != nil {
+		t.Fatal(err)
+	}
+
+	// failed replica fails write, but catches up on next one
+	flaky.fail = true
+	id, cookie, err := primary.Put([]byte("three"))
+	if err == nil {
+		t.Fatal("expected error")
+	}
+	records = append(records, record{id, cookie, []byte("three")})
+	flaky.fail = false
+	id, cookie, err = primary.Put([]byte("four"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	records = append(records, record{id, cookie, []byte("four")})
+
+	end, _ := primary.End()
+	for _, v := range []*Volume{healthy, behind} {
+		if e, _ := v.End(); e != end {
+			t.Error("end", e, "!=", end)
+		}
+		for i, r := range records {
+			data, err := v.Get(r.id, r.cookie, nil)
+			if i == 1 {
+				if err != ErrNotFound {
+					t.Error("deleted record", err)
+				}
+				continue
+			}
+			if err != nil || !bytes.Equal(data, r.data) {
+				t.Error("record", r.id, err)
+			}
+		}
+		if v.Next() != primary.Next() {
+			t.Error("next", v.Next(), "!=", primary.Next())
+		}
+	}
+
+	if err = healthy.Apply(end, []byte("garbage")); err == nil {
+		t.Error("expected error")
+	}
+	if err = healthy.Apply(end+100, nil); err != storage.ErrBadOffset {
+		t.Error(err, "!=", storage.ErrBadOffset)
+	}
+
+	// replica that is ahead of primary can't be used
+	if _, _, err = healthy.Put([]byte("direct write")); err != nil {
+		t.Fatal(err)
+	}
+	if _, _, err = primary.Put([]byte("five")); errors.Cause(err) != ErrDiverged {
+		t.Error(err, "!=", ErrDiverged)
+	}
+}
+
+func TestReplicate_FailedWrite(t *testing.T) {
+	dir, clear := tempDir(t)
+	defer clear()
+	primary, err := Open(filepath.Join(dir, "primary"), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer stokutils.MustClose(t, primary)
+	replica, err := Open(filepath.Join(dir, "replica"), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer stokutils.MustClose(t, replica)
+	primary.Replicate(replica)
+
+	backend := &failingBlobBackend{BlobBackend: primary.Blob.Backend}
+	primary.Blob.Backend = backend
+	if _, _, err = primary.Put([]byte("one")); err != nil {
+		t.Fatal(err)
+	}
+	end, _ := primary.End()
+	backend.fail = true
+	if _, _, err = primary.Put([]byte("failed")); err == nil {
+		t.Fatal("expected error")
+	}
+	// space of failed record is not sent to replica
+	if e, _ := primary.End(); e != end {
+		t.Error("end", e, "!=", end)
+	}
+	backend.fail = false
+	id, cookie, err := primary.Put([]byte("two"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	end, _ = primary.End()
+	if e, _ := replica.End(); e != end {
+		t.Error("end", e, "!=", end)
+	}
+	if data, err := replica.Get(id, cookie, nil); err != nil || string(data) != "two" {
+		t.Error(string(data), err)
+	}
+}
+
+func TestVolume_EndWaitsForWrite(t *testing.T) {
+	dir, clear := tempDir(t)
+	defer clear()
+	v, err := Open(filepath.Join(dir, "volume"), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer stokutils.MustClose(t, v)
+	if _, _, err = v.Put([]byte("one")); err != nil {
+		t.Fatal(err)
+	}
+	backend := &blockingBlobBackend{
+		BlobBackend: v.Blob.Backend,
+		writing:     make(chan struct{}),
+		release:     make(chan struct{}),
+	}
+	v.Blob.Backend = backend
+	written := make(chan error)
+	go func() {
+		_, _, err := v.Put([]byte("two"))
+		written <- err
+	}()
+	<-backend.writing
+	ends := make(chan int64)
+	go func() {
+		end, _ := v.End()
+		ends <- end
+	}()
+	end := int64(-1)
+	select {
+	case end = <-ends:
+		t.Error("end of incomplete record", end)
+	case <-time.After(50 * time.Millisecond):
+	}
+	close(backend.release)
+	err = <-written
+	v.Blob.Backend = backend.BlobBackend
+	if err != nil {
+		t.Fatal(err)
+	}
+	if end < 0 {
+		end = <-ends
+	}
+	if end != atomic.LoadInt64(&v.Blob.Size) {
+		t.Error("end", end, "!=", v.Blob.Size)
+	}
+}
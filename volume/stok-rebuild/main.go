@@ -0,0 +1,75 @@
+// Command stok-rebuild regenerates volume index by scanning blob records.
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+
+	"github.com/cydev/stok/index"
+	"github.com/cydev/stok/storage"
+	"github.com/cydev/stok/volume"
+)
+
+var (
+	blobPath  = flag.String("blob", "", "path to blob file")
+	indexPath = flag.String("index", "", "path to index file (default is blob path with .idx suffix)")
+	force     = flag.Bool("f", false, "overwrite existing index")
+)
+
+func fatal(args ...interface{}) {
+	fmt.Fprintln(os.Stderr, args...)
+	os.Exit(1)
+}
+
+func main() {
+	flag.Parse()
+	if *blobPath == "" {
+		flag.Usage()
+		os.Exit(2)
+	}
+	if *indexPath == "" {
+		*indexPath = *blobPath + volume.IndexSuffix
+	}
+	if _, err := os.Stat(*blobPath); err != nil {
+		fatal("blob:", err)
+	}
+	if _, err := os.Stat(*indexPath); err == nil {
+		if !*force {
+			fatal("index", *indexPath, "exists, use -f to overwrite")
+		}
+		for _, name := range []string{*indexPath, *indexPath + index.BitmapSuffix} {
+			if err := os.Remove(name); err != nil && !os.IsNotExist(err) {
+				fatal("index:", err)
+			}
+		}
+	}
+	b, err := storage.OpenBlob(*blobPath, nil)
+	if err != nil {
+		fatal("blob:", err)
+	}
+	idx, err := index.Open(*indexPath, volume.LocationSize)
+	if err != nil {
+		b.Close()
+		fatal("index:", err)
+	}
+	report, err := volume.Rebuild(b, idx)
+	// closing before exit, so index and blob header are written
+	idxErr, blobErr := idx.Close(), b.Close()
+	for _, d := range report.Duplicates {
+		fmt.Printf("duplicate id=%d offset=%d override=%d\n", d.ID, d.Offset, d.Override)
+	}
+	for _, r := range report.Corrupted {
+		fmt.Printf("corrupted start=%d end=%d err=%q\n", r.Start, r.End, r.Err)
+	}
+	fmt.Printf("records=%d deleted=%d unsynced=%d duplicates=%d corrupted=%d\n",
+		report.Records, report.Deleted, report.Unsynced, len(report.Duplicates), len(report.Corrupted))
+	switch {
+	case err != nil:
+		fatal("rebuild:", err)
+	case idxErr != nil:
+		fatal("index:", idxErr)
+	case blobErr != nil:
+		fatal("blob:", blobErr)
+	}
+}
@@ -0,0 +1,296 @@
+package volume
+
+import (
+	"archive/tar"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/cydev/stok/index"
+	"github.com/cydev/stok/storage"
+	"github.com/pkg/errors"
+)
+
+// PAX records of tar entries with record metadata.
+const (
+	paxChecksum = "STOK.checksum"
+)
+
+// TarName returns name of tar entry of record with ID and cookie.
+func TarName(id int64, cookie uint32) string {
+	return fmt.Sprintf("%x,%08x", id, cookie)
+}
+
+// ParseTarName parses ID and cookie from name of tar entry.
+func ParseTarName(name string) (int64, uint32, bool) {
+	var (
+		id     int64
+		cookie uint32
+	)
+	if n, err := fmt.Sscanf(name, "%x,%08x", &id, &cookie); n != 2 || err != nil {
+		return 0, 0, false
+	}
+	if TarName(id, cookie) != name || id < index.StartID {
+		return 0, 0, false
+	}
+	return id, cookie, true
+}
+
+// ExportTar writes every live record of volume to w as tar entry named
+// by TarName, with modification time of record and its checksum in PAX
+// record, and returns count of written records.
+func (v *Volume) ExportTar(w io.Writer) (int, error) {
+	var (
+		n    int
+		data []byte
+	)
+	tw := tar.NewWriter(w)
+	err := v.Walk(func(rh storage.RecordHeader, l Location) error {
+		h, buf, err := v.Blob.ReadRecord(l.Offset, data[:0])
+		if err != nil {
+			return errors.Wrapf(err, "failed to read record %d", rh.ID)
+		}
+		data = buf
+		err = tw.WriteHeader(&tar.Header{
+			Typeflag: tar.TypeReg,
+			Name:     TarName(h.ID, h.Cookie),
+			Size:     h.Size,
+			Mode:     0644,
+			ModTime:  h.Time(),
+			Format:   tar.FormatPAX,
+			PAXRecords: map[string]string{
+				paxChecksum: strconv.FormatUint(uint64(h.Checksum), 16),
+			},
+		})
+		if err != nil {
+			return err
+		}
+		if _, err = tw.Write(data); err != nil {
+			return err
+		}
+		n++
+		return nil
+	})
+	if err != nil {
+		return n, err
+	}
+	return n, tw.Close()
+}
+
+// DefaultMaxImportSize is default max size of imported file.
+const DefaultMaxImportSize = 64 * 1024 * 1024
+
+// errStopped stops producer of files after failure of worker.
+var errStopped = errors.New("import is stopped")
+
+// Importer writes files from tar streams or directories to volume
+// using concurrent workers.
+type Importer struct {
+	Volume *Volume
+	// Workers is count of concurrent writes, runtime.NumCPU() if zero.
+	Workers int
+	// Imported is called concurrently for every imported file with
+	// its name, ID and cookie. Can be nil.
+	Imported func(name string, id int64, cookie uint32)
+	// MaxSize is max size of imported file, DefaultMaxImportSize if zero.
+	// Import fails on larger files.
+	MaxSize int64
+}
+
+func (i *Importer) maxSize() int64 {
+	if i.MaxSize == 0 {
+		return DefaultMaxImportSize
+	}
+	return i.MaxSize
+}
+
+// tooLarge returns error if file with name and size is larger than
+// max size.
+func (i *Importer) tooLarge(name string, size int64) error {
+	if size > i.maxSize() {
+		return errors.Errorf("%s: size %d is larger than %d", name, size, i.maxSize())
+	}
+	return nil
+}
+
+// file is file to import. If id is negative, new ID and cookie
+// are assigned.
+type file struct {
+	name     string
+	id       int64
+	cookie   uint32
+	modTime  time.Time
+	checksum string // hex crc32 of data, can be empty
+	data     []byte
+	path     string // path to read data from if data is nil
+}
+
+// pipeline runs workers, that import files sent by produce. It returns
+// count of imported files and first error of produce or workers.
+func (i *Importer) pipeline(produce func(files chan<- file, done <-chan struct{}) error) (int, error) {
+	workers := i.Workers
+	if workers < 1 {
+		workers = runtime.NumCPU()
+	}
+	var (
+		files = make(chan file, workers)
+		done  = make(chan struct{})
+		once  sync.Once
+		first error
+		mux   sync.Mutex
+		n     int
+		wg    sync.WaitGroup
+	)
+	fail := func(err error) {
+		once.Do(func() {
+			first = err
+			close(done)
+		})
+	}
+	for w := 0; w < workers; w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for f := range files {
+				if err := i.write(f); err != nil {
+					fail(err)
+					continue
+				}
+				mux.Lock()
+				n++
+				mux.Unlock()
+			}
+		}()
+	}
+	err := produce(files, done)
+	close(files)
+	wg.Wait()
+	if err != nil {
+		fail(err)
+	}
+	return n, first
+}
+
+// read reads data of f from its path, that can grow after it is listed.
+func (i *Importer) read(f file) ([]byte, error) {
+	r, err := os.Open(f.path)
+	if err != nil {
+		return nil, err
+	}
+	defer r.Close()
+	data, err := ioutil.ReadAll(io.LimitReader(r, i.maxSize()+1))
+	if err != nil {
+		return nil, err
+	}
+	return data, i.tooLarge(f.name, int64(len(data)))
+}
+
+// write writes f to volume.
+func (i *Importer) write(f file) error {
+	data := f.data
+	if data == nil && f.path != "" {
+		var err error
+		if data, err = i.read(f); err != nil {
+			return err
+		}
+	}
+	if f.checksum != "" {
+		if strconv.FormatUint(uint64(crc32.ChecksumIEEE(data)), 16) != f.checksum {
+			return errors.Wrap(storage.ErrBadRecordChecksum, f.name)
+		}
+	}
+	h := storage.RecordHeader{ID: f.id, Cookie: f.cookie}
+	if !f.modTime.IsZero() {
+		h.Timestamp = f.modTime.UnixNano()
+	}
+	v := i.Volume
+	if h.ID < 0 {
+		h.ID = v.nextID()
+		h.Cookie = NewCookie()
+	} else {
+		v.reserve(h.ID)
+	}
+	if err := v.put(h, data); err != nil {
+		return errors.Wrap(err, f.name)
+	}
+	if i.Imported != nil {
+		i.Imported(f.name, h.ID, h.Cookie)
+	}
+	return nil
+}
+
+// Tar imports regular files of tar stream and returns count of imported
+// files. Entries named by TarName keep their IDs and cookies, and
+// other files get new ones. Modification times are kept as record
+// timestamps.
+func (i *Importer) Tar(r io.Reader) (int, error) {
+	return i.pipeline(func(files chan<- file, done <-chan struct{}) error {
+		tr := tar.NewReader(r)
+		for {
+			hdr, err := tr.Next()
+			if err == io.EOF {
+				return nil
+			}
+			if err != nil {
+				return errors.Wrap(err, "failed to read tar")
+			}
+			if hdr.Typeflag != tar.TypeReg {
+				continue
+			}
+			if err = i.tooLarge(hdr.Name, hdr.Size); err != nil {
+				return err
+			}
+			data := make([]byte, hdr.Size)
+			if _, err = io.ReadFull(tr, data); err != nil {
+				return errors.Wrapf(err, "failed to read %s", hdr.Name)
+			}
+			f := file{name: hdr.Name, id: -1, modTime: hdr.ModTime, data: data}
+			if id, cookie, ok := ParseTarName(hdr.Name); ok {
+				f.id, f.cookie = id, cookie
+				f.checksum = hdr.PAXRecords[paxChecksum]
+			}
+			select {
+			case files <- f:
+			case <-done:
+				return nil
+			}
+		}
+	})
+}
+
+// Dir imports every regular file in directory tree with root as new
+// record and returns count of imported files. Names of files are
+// slash-separated paths relative to root.
+func (i *Importer) Dir(root string) (int, error) {
+	return i.pipeline(func(files chan<- file, done <-chan struct{}) error {
+		return filepath.Walk(root, func(path string, info os.FileInfo, err error) error {
+			if err != nil {
+				return err
+			}
+			if !info.Mode().IsRegular() {
+				return nil
+			}
+			name, err := filepath.Rel(root, path)
+			if err != nil {
+				return err
+			}
+			if err = i.tooLarge(name, info.Size()); err != nil {
+				return err
+			}
+			f := file{name: filepath.ToSlash(name), id: -1, modTime: info.ModTime(), path: path}
+			select {
+			case files <- f:
+				return nil
+			case <-done:
+				return errStopped
+			}
+		})
+	})
+}
@@ -0,0 +1,161 @@
+package volume
+
+import (
+	"archive/tar"
+	"bytes"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+
+	"github.com/cydev/stok/stokutils"
+	"github.com/cydev/stok/storage"
+)
+
+func TestTarName(t *testing.T) {
+	id, cookie, ok := ParseTarName(TarName(0x1f, 0xbeef))
+	if !ok || id != 0x1f || cookie != 0xbeef {
+		t.Error("bad name", id, cookie, ok)
+	}
+	for _, name := range []string{"", "photo.jpg", "1f,beef", "1f,0000beef,1", "-1,0000beef"} {
+		if _, _, ok := ParseTarName(name); ok {
+			t.Error(name, "is parsed")
+		}
+	}
+}
+
+func TestVolume_ExportTar(t *testing.T) {
+	dir, clear := tempDir(t)
+	defer clear()
+	src, err := Open(filepath.Join(dir, "src"), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer stokutils.MustClose(t, src)
+	for _, data := range []string{"first", "second", ""} {
+		if _, _, err := src.Put([]byte(data)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	var buf bytes.Buffer
+	n, err := src.ExportTar(&buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n != 3 {
+		t.Error("exported", n, "!= 3")
+	}
+	// foreign file gets new ID
+	tw := tar.NewWriter(&buf)
+	if err = tw.WriteHeader(&tar.Header{Name: "photo.jpg", Size: 5, Mode: 0644}); err != nil {
+		t.Fatal(err)
+	}
+	tw.Write([]byte("photo"))
+	tw.Close()
+
+	dst, err := Open(filepath.Join(dir, "dst"), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer stokutils.MustClose(t, dst)
+	var (
+		mux      sync.Mutex
+		imported = make(map[string]int64)
+	)
+	i := &Importer{Volume: dst, Workers: 2, Imported: func(name string, id int64, cookie uint32) {
+		mux.Lock()
+		imported[name] = id
+		mux.Unlock()
+	}}
+	// tar writer of export is closed, so second stream is not read
+	if n, err = i.Tar(bytes.NewReader(buf.Bytes())); err != nil {
+		t.Fatal(err)
+	}
+	if n != 3 || len(imported) != 3 {
+		t.Error("imported", n, "!= 3")
+	}
+	// size in header is checked before data is allocated
+	i.MaxSize = 5
+	if _, err = i.Tar(bytes.NewReader(buf.Bytes())); err == nil {
+		t.Error("imported file larger than max size")
+	}
+	err = src.Walk(func(h storage.RecordHeader, l Location) error {
+		data, err := dst.Get(h.ID, h.Cookie, nil)
+		if err != nil {
+			return err
+		}
+		expected, _ := src.Get(h.ID, h.Cookie, nil)
+		if !bytes.Equal(data, expected) {
+			t.Errorf("%d: %q != %q", h.ID, data, expected)
+		}
+		got, _ := dst.Stat(h.ID, h.Cookie)
+		if got.Time().Unix() != h.Time().Unix() {
+			t.Error("time is not preserved")
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestImporter_Dir(t *testing.T) {
+	dir, clear := tempDir(t)
+	defer clear()
+	root := filepath.Join(dir, "root")
+	files := map[string]string{
+		"a.txt":         "a",
+		"sub/b.txt":     "b",
+		"sub/sub/c.txt": "c",
+	}
+	for name, data := range files {
+		path := filepath.Join(root, filepath.FromSlash(name))
+		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := ioutil.WriteFile(path, []byte(data), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	v, err := Open(filepath.Join(dir, "volume"), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer stokutils.MustClose(t, v)
+	var (
+		mux  sync.Mutex
+		read = make(map[string]string)
+	)
+	i := &Importer{Volume: v, Imported: func(name string, id int64, cookie uint32) {
+		data, err := v.Get(id, cookie, nil)
+		if err != nil {
+			t.Error(err)
+		}
+		mux.Lock()
+		read[name] = string(data)
+		mux.Unlock()
+	}}
+	n, err := i.Dir(root)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n != len(files) {
+		t.Error("imported", n, "!=", len(files))
+	}
+	for name, data := range files {
+		if read[name] != data {
+			t.Errorf("%s: %q != %q", name, read[name], data)
+		}
+	}
+	if _, err = i.Dir(filepath.Join(dir, "missing")); err == nil {
+		t.Error("expected error")
+	}
+	i.MaxSize = 1
+	if err = ioutil.WriteFile(filepath.Join(root, "large.txt"), []byte("large"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if _, err = i.Dir(root); err == nil {
+		t.Error("imported file larger than max size")
+	}
+}
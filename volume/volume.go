@@ -0,0 +1,349 @@
+// Package volume implements storage of records, where every record is
+// written to storage.Blob and its location is saved to index.Index under
+// record ID.
+//
+// Every record has random cookie, that is verified on read and delete,
+// so records can't be accessed by guessing sequential IDs.
+package volume
+
+import (
+	"crypto/rand"
+	"encoding/binary"
+	"io"
+	"os"
+	"sync"
+	"sync/atomic"
+
+	"github.com/cydev/stok"
+	"github.com/cydev/stok/index"
+	"github.com/cydev/stok/storage"
+	"github.com/pkg/errors"
+)
+
+const (
+	// ErrBadLocation means that location can't be decoded.
+	ErrBadLocation stok.Error = "Bad location"
+	// ErrNotFound means that record with ID is not found or deleted.
+	ErrNotFound stok.Error = "Record not found"
+	// ErrCookieMismatch means that record with ID exists and has other
+	// cookie, so it can't be replaced.
+	ErrCookieMismatch stok.Error = "Cookie mismatch"
+)
+
+// LocationSize is size of encoded Location, the value size of volume index.
+const LocationSize = 8 + 8
+
+// IndexSuffix is appended to blob file name to get name of index file.
+const IndexSuffix = ".idx"
+
+// Location is position of record in blob.
+type Location struct {
+	Offset int64 // offset of record header
+	Size   int64 // size of record data
+}
+
+// Put encodes Location into buf and returns the number of bytes written.
+// If the buffer is too small, Put will panic.
+func (l Location) Put(buf []byte) int {
+	binary.BigEndian.PutUint64(buf, uint64(l.Offset))
+	binary.BigEndian.PutUint64(buf[8:], uint64(l.Size))
+	return LocationSize
+}
+
+// Read decodes Location from buf.
+func (l *Location) Read(buf []byte) error {
+	if len(buf) < LocationSize {
+		return ErrBadLocation
+	}
+	l.Offset = int64(binary.BigEndian.Uint64(buf))
+	l.Size = int64(binary.BigEndian.Uint64(buf[8:]))
+	return nil
+}
+
+// Volume is set of records, addressed by ID.
+type Volume struct {
+	Blob  *storage.Blob
+	Index index.Index
+	next  int64 // next ID to assign
+
+	smux     sync.Mutex   // serializes Set, so cookie check is atomic
+	wmux     sync.Mutex   // serializes writes if volume is replicated
+	tail     sync.RWMutex // held for reading while records are written
+	replicas []*replica
+}
+
+// Config is configuration for volume.
+type Config struct {
+	Blob *storage.BlobConfig
+}
+
+func (c *Config) blob() *storage.BlobConfig {
+	if c == nil {
+		return nil
+	}
+	return c.Blob
+}
+
+// New returns Volume on top of blob and index.
+func New(b *storage.Blob, idx index.Index) (*Volume, error) {
+	n, err := idx.Len()
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to get index length")
+	}
+	if n < index.StartID {
+		n = index.StartID
+	}
+	return &Volume{Blob: b, Index: idx, next: n}, nil
+}
+
+// Open opens or creates volume with blob at path and index
+// alongside it. If index file does not exist or its bitmap is lost, it
+// is rebuilt from blob.
+func Open(path string, cfg *Config) (*Volume, error) {
+	b, err := storage.OpenBlob(path, cfg.blob())
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to open blob")
+	}
+	v, err := Load(b, path+IndexSuffix)
+	if err != nil {
+		b.Close()
+		return nil, err
+	}
+	return v, nil
+}
+
+// Load returns Volume on top of b with index at indexPath. If index file
+// does not exist or its live-entry bitmap is lost, index is rebuilt
+// from b.
+func Load(b *storage.Blob, indexPath string) (*Volume, error) {
+	idx, rebuild, err := openIndex(indexPath)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to open index")
+	}
+	if rebuild {
+		if _, err = Rebuild(b, idx); err != nil {
+			idx.Close()
+			return nil, errors.Wrap(err, "failed to rebuild index")
+		}
+	}
+	v, err := New(b, idx)
+	if err != nil {
+		idx.Close()
+		return nil, err
+	}
+	return v, nil
+}
+
+// openIndex opens index at path and reports whether it should be
+// rebuilt, that is when it is new or its bitmap does not match it. In
+// the latter case index is removed and created again, because entries
+// without bitmap can't be trusted.
+func openIndex(path string) (*index.RWAtIndex, bool, error) {
+	_, statErr := os.Stat(path)
+	idx, err := index.Open(path, LocationSize)
+	if errors.Cause(err) == index.ErrBadBitmap {
+		if err = removeIndex(path); err != nil {
+			return nil, false, err
+		}
+		idx, err = index.Open(path, LocationSize)
+		return idx, true, err
+	}
+	return idx, os.IsNotExist(statErr), err
+}
+
+// removeIndex removes index at path and its bitmap.
+func removeIndex(path string) error {
+	for _, name := range []string{path, path + index.BitmapSuffix} {
+		if err := os.Remove(name); err != nil && !os.IsNotExist(err) {
+			return err
+		}
+	}
+	return nil
+}
+
+// NewCookie returns random cookie.
+func NewCookie() uint32 {
+	var b [4]byte
+	if _, err := rand.Read(b[:]); err != nil {
+		panic(err)
+	}
+	return binary.BigEndian.Uint32(b[:])
+}
+
+// Put writes data as new record with random cookie and returns its ID
+// and cookie.
+func (v *Volume) Put(data []byte) (int64, uint32, error) {
+	id := v.nextID()
+	cookie := NewCookie()
+	return id, cookie, v.put(storage.RecordHeader{ID: id, Cookie: cookie}, data)
+}
+
+// nextID assigns new ID.
+func (v *Volume) nextID() int64 {
+	return atomic.AddInt64(&v.next, 1) - 1
+}
+
+// Set writes data as record with ID and cookie, replacing previous record
+// if any. Returns ErrCookieMismatch if previous record has other cookie.
+// IDs assigned by Put are always larger than id.
+func (v *Volume) Set(id int64, cookie uint32, data []byte) error {
+	if id < index.StartID {
+		return index.ErrBadKey
+	}
+	v.smux.Lock()
+	defer v.smux.Unlock()
+	l, err := v.Locate(id)
+	if err == nil {
+		var h storage.RecordHeader
+		if h, err = v.Blob.ReadRecordHeader(l.Offset); err != nil {
+			return err
+		}
+		if h.Cookie != cookie {
+			return ErrCookieMismatch
+		}
+	} else if err != ErrNotFound {
+		return err
+	}
+	v.reserve(id)
+	return v.put(storage.RecordHeader{ID: id, Cookie: cookie}, data)
+}
+
+// reserve ensures that IDs assigned by Put are larger than id.
+func (v *Volume) reserve(id int64) {
+	for {
+		next := atomic.LoadInt64(&v.next)
+		if next > id || atomic.CompareAndSwapInt64(&v.next, next, id+1) {
+			return
+		}
+	}
+}
+
+// Next returns ID that will be assigned by next Put.
+func (v *Volume) Next() int64 {
+	return atomic.LoadInt64(&v.next)
+}
+
+// put writes data as record with ID, cookie and timestamp of h.
+func (v *Volume) put(h storage.RecordHeader, data []byte) error {
+	return v.write(func() error {
+		offset, err := v.Blob.WriteRecord(h, data)
+		if err != nil {
+			return errors.Wrap(err, "failed to write record")
+		}
+		var buf [LocationSize]byte
+		Location{Offset: offset, Size: int64(len(data))}.Put(buf[:])
+		return errors.Wrap(v.Index.Set(h.ID, buf[:]), "failed to set index")
+	})
+}
+
+// Locate returns location of record with ID.
+func (v *Volume) Locate(id int64) (Location, error) {
+	var (
+		buf [LocationSize]byte
+		l   Location
+	)
+	if id < index.StartID {
+		return l, ErrNotFound
+	}
+	if n, _ := v.Index.Len(); id >= n {
+		return l, ErrNotFound
+	}
+	err := v.Index.Get(id, buf[:])
+	if errors.Cause(err) == index.ErrNotFound {
+		return l, ErrNotFound
+	}
+	if err != nil {
+		return l, err
+	}
+	if err = l.Read(buf[:]); err != nil {
+		return l, err
+	}
+	if l.Offset == 0 {
+		return l, ErrNotFound
+	}
+	return l, nil
+}
+
+// Stat returns header of record with ID, returning ErrNotFound
+// if cookie does not match.
+func (v *Volume) Stat(id int64, cookie uint32) (storage.RecordHeader, error) {
+	l, err := v.Locate(id)
+	if err != nil {
+		return storage.RecordHeader{}, err
+	}
+	return v.header(l, cookie)
+}
+
+// header reads header of record at l and verifies its cookie.
+func (v *Volume) header(l Location, cookie uint32) (storage.RecordHeader, error) {
+	h, err := v.Blob.ReadRecordHeader(l.Offset)
+	if err != nil {
+		return h, err
+	}
+	if h.Cookie != cookie {
+		return storage.RecordHeader{}, ErrNotFound
+	}
+	return h, nil
+}
+
+// Reader returns header of record with ID and reader of its data, that
+// reads directly from blob without checksum verification.
+func (v *Volume) Reader(id int64, cookie uint32) (storage.RecordHeader, *io.SectionReader, error) {
+	l, err := v.Locate(id)
+	if err != nil {
+		return storage.RecordHeader{}, nil, err
+	}
+	h, err := v.header(l, cookie)
+	if err != nil {
+		return h, nil, err
+	}
+	return h, io.NewSectionReader(v.Blob, l.Offset+storage.RecordHeaderSize, h.Size), nil
+}
+
+// Get appends data of record with ID to buf and returns it.
+func (v *Volume) Get(id int64, cookie uint32, buf []byte) ([]byte, error) {
+	l, err := v.Locate(id)
+	if err != nil {
+		return buf, err
+	}
+	h, data, err := v.Blob.ReadRecord(l.Offset, buf)
+	if err == nil && h.Cookie != cookie {
+		return buf, ErrNotFound
+	}
+	return data, err
+}
+
+// Delete writes tombstone of record with ID and removes it from index.
+func (v *Volume) Delete(id int64, cookie uint32) error {
+	l, err := v.Locate(id)
+	if err != nil {
+		return err
+	}
+	if _, err = v.header(l, cookie); err != nil {
+		return err
+	}
+	return v.write(func() error {
+		h := storage.RecordHeader{ID: id, Cookie: cookie, Flags: storage.FlagDeleted}
+		if _, err := v.Blob.WriteRecord(h, nil); err != nil {
+			return errors.Wrap(err, "failed to write tombstone")
+		}
+		err := v.Index.Delete(id)
+		if errors.Cause(err) == index.ErrNotFound {
+			return ErrNotFound
+		}
+		return err
+	})
+}
+
+// Sync commits blob to stable storage.
+func (v *Volume) Sync() error {
+	return v.Blob.Sync()
+}
+
+// Close closes index and blob.
+func (v *Volume) Close() error {
+	if err := v.Index.Close(); err != nil {
+		return errors.Wrap(err, "failed to close index")
+	}
+	return v.Blob.Close()
+}
@@ -0,0 +1,112 @@
+package volume
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/cydev/stok/index"
+	"github.com/cydev/stok/stokutils"
+)
+
+func TestVolume(t *testing.T) {
+	dir, clear := tempDir(t)
+	defer clear()
+	path := filepath.Join(dir, "volume")
+	v, err := Open(path, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var (
+		ids     []int64
+		cookies []uint32
+	)
+	for _, data := range []string{"first", "second", "third"} {
+		id, cookie, err := v.Put([]byte(data))
+		if err != nil {
+			t.Fatal(err)
+		}
+		ids = append(ids, id)
+		cookies = append(cookies, cookie)
+	}
+	if err := v.Delete(ids[1], cookies[1]+1); err != ErrNotFound {
+		t.Error(err, "!=", ErrNotFound)
+	}
+	if err := v.Delete(ids[1], cookies[1]); err != nil {
+		t.Fatal(err)
+	}
+	if err := v.Delete(ids[1], cookies[1]); err != ErrNotFound {
+		t.Error(err, "!=", ErrNotFound)
+	}
+	if _, err := v.Get(100, 0, nil); err != ErrNotFound {
+		t.Error(err, "!=", ErrNotFound)
+	}
+	if _, err := v.Stat(ids[2], cookies[2]+1); err != ErrNotFound {
+		t.Error(err, "!=", ErrNotFound)
+	}
+	if _, _, err := v.Reader(ids[2], cookies[2]+1); err != ErrNotFound {
+		t.Error(err, "!=", ErrNotFound)
+	}
+	if err := v.Set(ids[2], cookies[2]+1, []byte("replaced")); err != ErrCookieMismatch {
+		t.Error(err, "!=", ErrCookieMismatch)
+	}
+	// deleted record can be written again
+	if err := v.Set(ids[1], cookies[1]+1, []byte("second")); err != nil {
+		t.Fatal(err)
+	}
+	if err := v.Delete(ids[1], cookies[1]+1); err != nil {
+		t.Fatal(err)
+	}
+	h, err := v.Stat(ids[2], cookies[2])
+	if err != nil {
+		t.Fatal(err)
+	}
+	if h.ID != ids[2] || h.Size != int64(len("third")) {
+		t.Error("bad header", h)
+	}
+	stokutils.MustClose(t, v)
+
+	// index should be rebuilt if lost
+	for _, name := range []string{path + IndexSuffix, path + IndexSuffix + index.BitmapSuffix} {
+		if err := os.Remove(name); err != nil {
+			t.Fatal(err)
+		}
+	}
+	v, err = Open(path, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() { stokutils.MustClose(t, v) }()
+	data, err := v.Get(ids[0], cookies[0], nil)
+	if err != nil || string(data) != "first" {
+		t.Error(string(data), err)
+	}
+	if data, err = v.Get(ids[0], cookies[0]+1, nil); err != ErrNotFound || len(data) != 0 {
+		t.Error(err, "!=", ErrNotFound)
+	}
+	if _, err := v.Get(ids[1], cookies[1], nil); err != ErrNotFound {
+		t.Error(err, "!=", ErrNotFound)
+	}
+	id, _, err := v.Put([]byte("fourth"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if id != ids[2]+1 {
+		t.Error("id", id, "!=", ids[2]+1)
+	}
+	stokutils.MustClose(t, v)
+
+	// index should be rebuilt if bitmap is lost
+	if err = os.Remove(path + IndexSuffix + index.BitmapSuffix); err != nil {
+		t.Fatal(err)
+	}
+	if v, err = Open(path, nil); err != nil {
+		t.Fatal(err)
+	}
+	if data, err = v.Get(ids[2], cookies[2], nil); err != nil || string(data) != "third" {
+		t.Error(string(data), err)
+	}
+	if _, err := v.Get(ids[1], cookies[1], nil); err != ErrNotFound {
+		t.Error(err, "!=", ErrNotFound)
+	}
+}
@@ -0,0 +1,200 @@
+package wire
+
+import (
+	"net"
+	"sync"
+	"time"
+
+	"github.com/cydev/stok"
+	"github.com/cydev/stok/storage"
+	"github.com/cydev/stok/volume"
+	"github.com/pkg/errors"
+)
+
+// ErrClosed means that connection of client is closed.
+const ErrClosed stok.Error = "Connection closed"
+
+// call is pending request.
+type call struct {
+	res  *Response
+	err  error
+	done chan struct{}
+}
+
+// Client sends requests over single connection. It is goroutine-safe,
+// and requests of concurrent callers are pipelined.
+type Client struct {
+	conn  net.Conn
+	codec *Codec
+
+	wmux sync.Mutex // guards writes and id
+	id   uint64
+
+	mux     sync.Mutex // guards pending and err
+	pending map[uint64]*call
+	err     error
+
+	calls sync.Pool
+}
+
+// Dial connects to server at addr.
+func Dial(addr string) (*Client, error) {
+	conn, err := net.DialTimeout("tcp", addr, 10*time.Second)
+	if err != nil {
+		return nil, err
+	}
+	return NewClient(conn), nil
+}
+
+// NewClient returns Client on top of conn.
+func NewClient(conn net.Conn) *Client {
+	c := &Client{
+		conn:    conn,
+		codec:   NewCodec(conn),
+		pending: make(map[uint64]*call),
+	}
+	c.calls.New = func() interface{} {
+		return &call{done: make(chan struct{}, 1)}
+	}
+	go c.read()
+	return c
+}
+
+// read dispatches responses to pending calls until connection fails.
+func (c *Client) read() {
+	var res Response
+	for {
+		err := c.codec.Read(&res)
+		c.mux.Lock()
+		if err != nil {
+			c.err = err
+			for id, call := range c.pending {
+				delete(c.pending, id)
+				call.err = ErrClosed
+				call.done <- struct{}{}
+			}
+			c.mux.Unlock()
+			return
+		}
+		call, ok := c.pending[res.ID]
+		delete(c.pending, res.ID)
+		c.mux.Unlock()
+		if !ok {
+			continue
+		}
+		data := call.res.Data[:0]
+		*call.res = res
+		call.res.Data = append(data, res.Data...)
+		call.err = nil
+		call.done <- struct{}{}
+	}
+}
+
+// Do sends req and waits for response, that is decoded to res.
+// ID of req is set by Do. Returns error only if request is not completed.
+func (c *Client) Do(req *Request, res *Response) error {
+	call := c.calls.Get().(*call)
+	call.res, res.Data = res, res.Data[:0]
+	c.wmux.Lock()
+	c.id++
+	req.ID = c.id
+	c.mux.Lock()
+	if c.err != nil {
+		c.mux.Unlock()
+		c.wmux.Unlock()
+		c.calls.Put(call)
+		return ErrClosed
+	}
+	c.pending[req.ID] = call
+	c.mux.Unlock()
+	err := c.codec.Write(*req)
+	if err == nil {
+		err = c.codec.Flush()
+	}
+	c.wmux.Unlock()
+	if err != nil {
+		c.conn.Close()
+	}
+	<-call.done
+	err = call.err
+	c.calls.Put(call)
+	return err
+}
+
+// result converts status of response to error.
+func result(res *Response) error {
+	switch res.Status {
+	case StatusOK:
+		return nil
+	case StatusNotFound:
+		return volume.ErrNotFound
+	case StatusReadOnly:
+		return storage.ErrReadOnly
+	case StatusCookieMismatch:
+		return volume.ErrCookieMismatch
+	default:
+		return errors.Errorf("remote: %s", res.Error)
+	}
+}
+
+func (c *Client) do(req Request) (*Response, error) {
+	res := new(Response)
+	if err := c.Do(&req, res); err != nil {
+		return nil, err
+	}
+	return res, result(res)
+}
+
+// Put writes data as new record and returns its key and cookie.
+func (c *Client) Put(data []byte) (int64, uint32, error) {
+	res, err := c.do(Request{Op: OpAssign, Data: data})
+	if err != nil {
+		return 0, 0, err
+	}
+	return res.Key, res.Cookie, nil
+}
+
+// Set writes data as record with key and cookie. Returns
+// volume.ErrCookieMismatch if record exists with different cookie.
+func (c *Client) Set(key int64, cookie uint32, data []byte) error {
+	_, err := c.do(Request{Op: OpPut, Key: key, Cookie: cookie, Data: data})
+	return err
+}
+
+// Get appends data of record to buf and returns it.
+func (c *Client) Get(key int64, cookie uint32, buf []byte) ([]byte, error) {
+	res := &Response{Data: buf[len(buf):]}
+	if err := c.Do(&Request{Op: OpGet, Key: key, Cookie: cookie}, res); err != nil {
+		return buf, err
+	}
+	if err := result(res); err != nil {
+		return buf, err
+	}
+	return append(buf, res.Data...), nil
+}
+
+// Delete deletes record.
+func (c *Client) Delete(key int64, cookie uint32) error {
+	_, err := c.do(Request{Op: OpDelete, Key: key, Cookie: cookie})
+	return err
+}
+
+// Stat returns header of record.
+func (c *Client) Stat(key int64, cookie uint32) (storage.RecordHeader, error) {
+	res, err := c.do(Request{Op: OpStat, Key: key, Cookie: cookie})
+	if err != nil {
+		return storage.RecordHeader{}, err
+	}
+	return storage.RecordHeader{
+		ID:        res.Key,
+		Size:      res.Size,
+		Timestamp: res.Timestamp,
+		Checksum:  res.Checksum,
+		Cookie:    res.Cookie,
+	}, nil
+}
+
+// Close closes connection.
+func (c *Client) Close() error {
+	return c.conn.Close()
+}
@@ -0,0 +1,73 @@
+// Package wire implements compact binary protocol for volume operations,
+// that is pipelined over persistent connections.
+//
+// Every message is Request or Response encoded with binary package and
+// wrapped into binary frame. Client can send many requests without waiting
+// for responses, and matches responses to requests by ID.
+package wire
+
+import (
+	"bufio"
+	"io"
+
+	"github.com/cydev/stok/binary"
+)
+
+// bufferSize is size of read and write buffers of connection.
+const bufferSize = 32 * 1024
+
+// Codec reads and writes framed messages. It is not goroutine-safe,
+// but reading and writing can be done concurrently.
+type Codec struct {
+	// MaxSize is max length of read message, binary.MaxFrameSize if zero.
+	MaxSize int
+
+	r       *bufio.Reader
+	w       *bufio.Writer
+	frame   []byte // read buffer
+	payload []byte // write buffers
+	out     []byte
+}
+
+// NewCodec returns Codec on top of rw.
+func NewCodec(rw io.ReadWriter) *Codec {
+	return &Codec{
+		r: bufio.NewReaderSize(rw, bufferSize),
+		w: bufio.NewWriterSize(rw, bufferSize),
+	}
+}
+
+// Read reads next message to m. Byte slices of m are copied, so
+// they are valid after next Read.
+func (c *Codec) Read(m binary.Decoder) error {
+	limit := c.MaxSize
+	if limit == 0 {
+		limit = binary.MaxFrameSize
+	}
+	payload, frame, err := binary.ReadFrameLimit(c.r, c.frame, limit)
+	c.frame = frame
+	if err != nil {
+		return err
+	}
+	_, err = m.Decode(payload)
+	return err
+}
+
+// Buffered reports whether next message can be read without blocking
+// on connection.
+func (c *Codec) Buffered() bool {
+	return c.r.Buffered() > 0
+}
+
+// Write writes message to buffer, that is sent on Flush or when it is full.
+func (c *Codec) Write(m binary.Appender) error {
+	c.payload = m.Append(c.payload[:0])
+	c.out = binary.AppendFrame(c.out[:0], c.payload)
+	_, err := c.w.Write(c.out)
+	return err
+}
+
+// Flush sends buffered messages.
+func (c *Codec) Flush() error {
+	return c.w.Flush()
+}
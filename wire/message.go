@@ -0,0 +1,59 @@
+package wire
+
+//go:generate stok-binarygen -type Request,Response -output message_binary.go
+
+// Operations of Request.
+const (
+	// OpPut writes Data as record with Key and Cookie.
+	OpPut = iota + 1
+	// OpGet reads record with Key and Cookie.
+	OpGet
+	// OpDelete deletes record with Key and Cookie.
+	OpDelete
+	// OpStat reads header of record with Key and Cookie.
+	OpStat
+	// OpAssign writes Data as new record, Key and Cookie of response
+	// are assigned by server.
+	OpAssign
+)
+
+// Statuses of Response.
+const (
+	StatusOK = iota
+	StatusNotFound
+	StatusReadOnly
+	StatusError
+	// StatusCookieMismatch means that record with Key exists and has
+	// different cookie.
+	StatusCookieMismatch
+)
+
+// requestHeaderSize = ID + op + key + cookie + length of data.
+const requestHeaderSize = 8 + 1 + 8 + 4 + 4
+
+// Request is message from client to server.
+type Request struct {
+	ID     uint64 // response has the same ID
+	Op     uint8
+	Key    int64
+	Cookie uint32
+	Data   []byte
+}
+
+// Response is message from server to client.
+type Response struct {
+	ID        uint64
+	Status    uint8
+	Key       int64
+	Cookie    uint32
+	Size      int64
+	Timestamp int64
+	Checksum  uint32
+	Error     string // message of StatusError
+	Data      []byte
+}
+
+// Reset resets response to zero value, keeping capacity of Data.
+func (r *Response) Reset() {
+	*r = Response{Data: r.Data[:0]}
+}
@@ -0,0 +1,85 @@
+// Code generated by "stok-binarygen -type Request,Response -output message_binary.go"; DO NOT EDIT.
+
+package wire
+
+import (
+	"github.com/cydev/stok/binary"
+)
+
+// Append encodes Request to buf and returns it, implementing binary.Appender.
+func (r Request) Append(buf []byte) []byte {
+	buf = binary.AppendUint64(buf, r.ID)
+	buf = binary.AppendUint8(buf, r.Op)
+	buf = binary.AppendInt64(buf, r.Key)
+	buf = binary.AppendUint32(buf, r.Cookie)
+	buf = binary.AppendBytes(buf, r.Data)
+	return buf
+}
+
+// Decode decodes Request from buf and returns rest of buf, implementing binary.Decoder.
+func (r *Request) Decode(buf []byte) ([]byte, error) {
+	var err error
+	if buf, err = binary.DecodeUint64(buf, &r.ID); err != nil {
+		return buf, err
+	}
+	if buf, err = binary.DecodeUint8(buf, &r.Op); err != nil {
+		return buf, err
+	}
+	if buf, err = binary.DecodeInt64(buf, &r.Key); err != nil {
+		return buf, err
+	}
+	if buf, err = binary.DecodeUint32(buf, &r.Cookie); err != nil {
+		return buf, err
+	}
+	if buf, err = binary.DecodeBytes(buf, &r.Data); err != nil {
+		return buf, err
+	}
+	return buf, nil
+}
+
+// Append encodes Response to buf and returns it, implementing binary.Appender.
+func (r Response) Append(buf []byte) []byte {
+	buf = binary.AppendUint64(buf, r.ID)
+	buf = binary.AppendUint8(buf, r.Status)
+	buf = binary.AppendInt64(buf, r.Key)
+	buf = binary.AppendUint32(buf, r.Cookie)
+	buf = binary.AppendInt64(buf, r.Size)
+	buf = binary.AppendInt64(buf, r.Timestamp)
+	buf = binary.AppendUint32(buf, r.Checksum)
+	buf = binary.AppendString(buf, r.Error)
+	buf = binary.AppendBytes(buf, r.Data)
+	return buf
+}
+
+// Decode decodes Response from buf and returns rest of buf, implementing binary.Decoder.
+func (r *Response) Decode(buf []byte) ([]byte, error) {
+	var err error
+	if buf, err = binary.DecodeUint64(buf, &r.ID); err != nil {
+		return buf, err
+	}
+	if buf, err = binary.DecodeUint8(buf, &r.Status); err != nil {
+		return buf, err
+	}
+	if buf, err = binary.DecodeInt64(buf, &r.Key); err != nil {
+		return buf, err
+	}
+	if buf, err = binary.DecodeUint32(buf, &r.Cookie); err != nil {
+		return buf, err
+	}
+	if buf, err = binary.DecodeInt64(buf, &r.Size); err != nil {
+		return buf, err
+	}
+	if buf, err = binary.DecodeInt64(buf, &r.Timestamp); err != nil {
+		return buf, err
+	}
+	if buf, err = binary.DecodeUint32(buf, &r.Checksum); err != nil {
+		return buf, err
+	}
+	if buf, err = binary.DecodeString(buf, &r.Error); err != nil {
+		return buf, err
+	}
+	if buf, err = binary.DecodeBytes(buf, &r.Data); err != nil {
+		return buf, err
+	}
+	return buf, nil
+}
@@ -0,0 +1,187 @@
+package wire
+
+import (
+	"hash/crc32"
+	"io"
+	"net"
+	"sync"
+
+	"github.com/cydev/stok/master"
+	"github.com/cydev/stok/storage"
+	"github.com/cydev/stok/volume"
+	"github.com/pkg/errors"
+)
+
+// DefaultMaxSize is default max size of written record.
+const DefaultMaxSize = 64 * 1024 * 1024
+
+// Server serves Volume over wire protocol.
+type Server struct {
+	Volume *volume.Volume
+	// ReadOnly disables writes to volume.
+	ReadOnly bool
+	// Assigned disables OpAssign, because keys are assigned by master.
+	Assigned bool
+	// MaxSize is max size of written record, DefaultMaxSize if zero.
+	// Connections with larger requests are closed.
+	MaxSize int64
+
+	mux   sync.Mutex
+	conns map[net.Conn]struct{}
+	ls    []net.Listener
+}
+
+// Serve accepts connections on l and serves them until l is closed.
+func (s *Server) Serve(l net.Listener) error {
+	s.mux.Lock()
+	s.ls = append(s.ls, l)
+	s.mux.Unlock()
+	for {
+		conn, err := l.Accept()
+		if err != nil {
+			return err
+		}
+		go s.ServeConn(conn)
+	}
+}
+
+// Close closes listeners and connections.
+func (s *Server) Close() error {
+	s.mux.Lock()
+	defer s.mux.Unlock()
+	for _, l := range s.ls {
+		l.Close()
+	}
+	for conn := range s.conns {
+		conn.Close()
+	}
+	s.ls, s.conns = nil, nil
+	return nil
+}
+
+func (s *Server) track(conn net.Conn, add bool) {
+	s.mux.Lock()
+	if add {
+		if s.conns == nil {
+			s.conns = make(map[net.Conn]struct{})
+		}
+		s.conns[conn] = struct{}{}
+	} else {
+		delete(s.conns, conn)
+	}
+	s.mux.Unlock()
+}
+
+// ServeConn serves requests from conn until it is closed. Responses are
+// flushed when there are no more buffered requests, so pipelined
+// requests are answered in batches.
+func (s *Server) ServeConn(conn net.Conn) error {
+	s.track(conn, true)
+	defer s.track(conn, false)
+	defer conn.Close()
+	var (
+		c   = NewCodec(conn)
+		req Request
+		res Response
+	)
+	c.MaxSize = int(s.maxSize()) + requestHeaderSize
+	for {
+		if err := c.Read(&req); err != nil {
+			if err == io.EOF {
+				return nil
+			}
+			return err
+		}
+		s.handle(&req, &res)
+		if err := c.Write(res); err != nil {
+			return err
+		}
+		if c.Buffered() {
+			continue
+		}
+		if err := c.Flush(); err != nil {
+			return err
+		}
+	}
+}
+
+func (s *Server) handle(req *Request, res *Response) {
+	res.Reset()
+	res.ID, res.Key, res.Cookie = req.ID, req.Key, req.Cookie
+	var err error
+	switch req.Op {
+	case OpPut, OpAssign:
+		switch {
+		case s.ReadOnly:
+			err = storage.ErrReadOnly
+		case int64(len(req.Data)) > s.maxSize():
+			err = errors.New("data is too large")
+		case req.Op == OpAssign && s.Assigned:
+			err = errors.New("key is assigned by master")
+		case req.Op == OpAssign:
+			res.Key, res.Cookie, err = s.Volume.Put(req.Data)
+		case req.Key >= s.Volume.Next()+master.KeyWindow:
+			err = errors.New("key is out of range")
+		default:
+			err = s.Volume.Set(req.Key, req.Cookie, req.Data)
+		}
+		res.Size = int64(len(req.Data))
+	case OpGet:
+		err = s.get(req, res)
+	case OpDelete:
+		if s.ReadOnly {
+			err = storage.ErrReadOnly
+		} else {
+			err = s.Volume.Delete(req.Key, req.Cookie)
+		}
+	case OpStat:
+		var h storage.RecordHeader
+		if h, err = s.Volume.Stat(req.Key, req.Cookie); err == nil {
+			res.stat(h)
+		}
+	default:
+		err = errors.Errorf("unknown op %d", req.Op)
+	}
+	switch errors.Cause(err) {
+	case nil:
+		res.Status = StatusOK
+	case volume.ErrNotFound:
+		res.Status = StatusNotFound
+	case storage.ErrReadOnly:
+		res.Status = StatusReadOnly
+	case volume.ErrCookieMismatch:
+		res.Status = StatusCookieMismatch
+	default:
+		res.Status, res.Error = StatusError, err.Error()
+	}
+}
+
+func (s *Server) maxSize() int64 {
+	if s.MaxSize == 0 {
+		return DefaultMaxSize
+	}
+	return s.MaxSize
+}
+
+func (s *Server) get(req *Request, res *Response) error {
+	h, r, err := s.Volume.Reader(req.Key, req.Cookie)
+	if err != nil {
+		return err
+	}
+	res.stat(h)
+	if int64(cap(res.Data)) < h.Size {
+		res.Data = make([]byte, h.Size)
+	}
+	res.Data = res.Data[:h.Size]
+	if _, err = io.ReadFull(r, res.Data); err != nil {
+		return err
+	}
+	if crc32.ChecksumIEEE(res.Data) != h.Checksum {
+		return storage.ErrBadRecordChecksum
+	}
+	return nil
+}
+
+func (r *Response) stat(h storage.RecordHeader) {
+	r.Size, r.Timestamp, r.Checksum = h.Size, h.Timestamp, h.Checksum
+}
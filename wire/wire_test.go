@@ -0,0 +1,191 @@
+package wire
+
+import (
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+
+	"github.com/cydev/stok/master"
+	"github.com/cydev/stok/stokutils"
+	"github.com/cydev/stok/storage"
+	"github.com/cydev/stok/volume"
+)
+
+func newServer(t testing.TB) (*Server, *Client, func()) {
+	dir, err := ioutil.TempDir("", "wire")
+	if err != nil {
+		t.Fatal(err)
+	}
+	v, err := volume.Open(filepath.Join(dir, "volume"), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	s := &Server{Volume: v, MaxSize: 4096}
+	go s.Serve(l)
+	c, err := Dial(l.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	return s, c, func() {
+		c.Close()
+		s.Close()
+		stokutils.MustClose(t, v)
+		os.RemoveAll(dir)
+	}
+}
+
+func TestMessage(t *testing.T) {
+	req := Request{ID: 1, Op: OpPut, Key: 2, Cookie: 3, Data: []byte("data")}
+	var decoded Request
+	if _, err := decoded.Decode(req.Append(nil)); err != nil {
+		t.Fatal(err)
+	}
+	if decoded.ID != 1 || decoded.Op != OpPut || decoded.Key != 2 ||
+		decoded.Cookie != 3 || string(decoded.Data) != "data" {
+		t.Error("bad request", decoded)
+	}
+	res := Response{ID: 1, Status: StatusError, Error: "failed", Data: []byte("x")}
+	var decodedRes Response
+	if _, err := decodedRes.Decode(res.Append(nil)); err != nil {
+		t.Fatal(err)
+	}
+	if decodedRes.Error != "failed" || decodedRes.Status != StatusError {
+		t.Error("bad response", decodedRes)
+	}
+}
+
+func TestClient(t *testing.T) {
+	s, c, clear := newServer(t)
+	defer clear()
+	data := []byte("hello, wire")
+	key, cookie, err := c.Put(data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	got, err := c.Get(key, cookie, []byte("prefix:"))
+	if err != nil || string(got) != "prefix:hello, wire" {
+		t.Fatal(string(got), err)
+	}
+	h, err := c.Stat(key, cookie)
+	if err != nil || h.Size != int64(len(data)) || h.Timestamp == 0 {
+		t.Error(h, err)
+	}
+	if _, err = c.Get(key, cookie+1, nil); err != volume.ErrNotFound {
+		t.Error(err, "!=", volume.ErrNotFound)
+	}
+	if err = c.Set(key+10, 42, []byte("set")); err != nil {
+		t.Fatal(err)
+	}
+	if got, err = c.Get(key+10, 42, nil); err != nil || string(got) != "set" {
+		t.Error(string(got), err)
+	}
+	if err = c.Set(key, cookie+1, []byte("overwritten")); err != volume.ErrCookieMismatch {
+		t.Error(err, "!=", volume.ErrCookieMismatch)
+	}
+	if err = c.Set(s.Volume.Next()+master.KeyWindow, 42, []byte("far")); err == nil {
+		t.Error("set key out of range")
+	}
+	if err = c.Delete(key, cookie); err != nil {
+		t.Fatal(err)
+	}
+	if err = c.Delete(key, cookie); err != volume.ErrNotFound {
+		t.Error(err, "!=", volume.ErrNotFound)
+	}
+	res := new(Response)
+	if err = c.Do(&Request{Op: 100}, res); err != nil {
+		t.Fatal(err)
+	}
+	if res.Status != StatusError || res.Error == "" {
+		t.Error("unknown op", res)
+	}
+
+	s.ReadOnly = true
+	if _, _, err = c.Put(data); err != storage.ErrReadOnly {
+		t.Error(err, "!=", storage.ErrReadOnly)
+	}
+	s.Close()
+	if _, _, err = c.Put(data); err != ErrClosed {
+		t.Error(err, "!=", ErrClosed)
+	}
+}
+
+func TestServerLimits(t *testing.T) {
+	s, c, clear := newServer(t)
+	defer clear()
+	s.Assigned = true
+	if _, _, err := c.Put([]byte("assigned")); err == nil {
+		t.Error("put with key assigned by master")
+	}
+	if err := c.Set(0, 1, []byte("key 0")); err != nil {
+		t.Fatal(err)
+	}
+	if got, err := c.Get(0, 1, nil); err != nil || string(got) != "key 0" {
+		t.Error(string(got), err)
+	}
+	if err := c.Set(1, 1, make([]byte, 4097)); err == nil {
+		t.Error("set data larger than max size")
+	}
+	if err := c.Set(1, 1, make([]byte, 8192)); err != ErrClosed {
+		t.Error(err, "!=", ErrClosed)
+	}
+}
+
+func TestClientPipelining(t *testing.T) {
+	_, c, clear := newServer(t)
+	defer clear()
+	var wg sync.WaitGroup
+	for i := 0; i < 8; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			var buf []byte
+			for j := 0; j < 100; j++ {
+				data := []byte(fmt.Sprint(i, "-", j))
+				key, cookie, err := c.Put(data)
+				if err != nil {
+					t.Error(err)
+					return
+				}
+				if buf, err = c.Get(key, cookie, buf[:0]); err != nil || !bytes.Equal(buf, data) {
+					t.Error(string(buf), err)
+					return
+				}
+			}
+		}(i)
+	}
+	wg.Wait()
+}
+
+func BenchmarkClient_Get(b *testing.B) {
+	_, c, clear := newServer(b)
+	defer clear()
+	data := make([]byte, 2048)
+	key, cookie, err := c.Put(data)
+	if err != nil {
+		b.Fatal(err)
+	}
+	b.ReportAllocs()
+	b.SetBytes(int64(len(data)))
+	b.ResetTimer()
+	b.RunParallel(func(pb *testing.PB) {
+		var (
+			buf []byte
+			err error
+		)
+		for pb.Next() {
+			if buf, err = c.Get(key, cookie, buf[:0]); err != nil {
+				b.Error(err)
+				return
+			}
+		}
+	})
+}